@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// isAdmin reports whether the member behind an interaction may run admin commands:
+// listed in admin_user_ids, holding a role in admin_role_ids, or a server administrator
+func isAdmin(i *discordgo.InteractionCreate) bool {
+	if slices.Contains(AppConfig.AdminUserIDs, interactionUserID(i)) {
+		return true
+	}
+	if i.Member == nil {
+		return false
+	}
+	for _, roleID := range i.Member.Roles {
+		if slices.Contains(AppConfig.AdminRoleIDs, roleID) {
+			return true
+		}
+	}
+	return i.Member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
+// requireAdmin answers the deferred interaction with a refusal when the caller is not an admin
+func requireAdmin(s *discordgo.Session, i *discordgo.InteractionCreate) bool {
+	if isAdmin(i) {
+		return true
+	}
+	respondOrFallback(s, i, "This command is restricted to bot admins.")
+	return false
+}
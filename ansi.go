@@ -0,0 +1,16 @@
+package main
+
+import "regexp"
+
+// ansiEscapePattern matches terminal escape sequences: CSI (colors, cursor movement), OSC (titles,
+// hyperlinks) terminated by BEL or ST, and the remaining two-byte escapes
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI removes terminal escape sequences from tool output when strip_ansi is enabled,
+// leaving the text itself intact
+func stripANSI(text string) string {
+	if !AppConfig.stripANSI() {
+		return text
+	}
+	return ansiEscapePattern.ReplaceAllString(text, "")
+}
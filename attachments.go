@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sst/opencode-sdk-go"
+)
+
+// Limits for images attached to a prompt
+const (
+	maxPromptImages     = 4
+	maxPromptImageBytes = 5 << 20 // 5 MiB per image
+)
+
+// imageDownloadClient fetches attachments from Discord's CDN
+var imageDownloadClient = &http.Client{Timeout: 30 * time.Second}
+
+// promptImage is an image attachment forwarded to a vision model
+type promptImage struct {
+	Filename string
+	Mime     string
+	Data     []byte
+}
+
+// modelSupportsVision reports whether the configured model accepts image input
+func modelSupportsVision(model Model) bool {
+	configured := findModel(model.ProviderID, model.ModelID)
+	return configured != nil && configured.SupportsVision
+}
+
+// imageAttachments returns the attachments of a message that are images
+func imageAttachments(attachments []*discordgo.MessageAttachment) []*discordgo.MessageAttachment {
+	var images []*discordgo.MessageAttachment
+	for _, attachment := range attachments {
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			images = append(images, attachment)
+		}
+	}
+	return images
+}
+
+// downloadPromptImages downloads image attachments within the count and size limits.
+// Skipped images are described in the returned notes so the user knows what the model didn't see.
+func downloadPromptImages(attachments []*discordgo.MessageAttachment) ([]promptImage, []string) {
+	var images []promptImage
+	var notes []string
+	for idx, attachment := range attachments {
+		if idx >= maxPromptImages {
+			notes = append(notes, fmt.Sprintf("Only the first %d images are sent, %d ignored.", maxPromptImages, len(attachments)-maxPromptImages))
+			break
+		}
+		if attachment.Size > maxPromptImageBytes {
+			notes = append(notes, fmt.Sprintf("`%s` is larger than %d MiB and was ignored.", attachment.Filename, maxPromptImageBytes>>20))
+			continue
+		}
+		data, err := downloadAttachment(attachment.URL)
+		if err != nil {
+			slog.Error("failed to download image attachment", "filename", attachment.Filename, "error", err)
+			notes = append(notes, fmt.Sprintf("`%s` could not be downloaded and was ignored.", attachment.Filename))
+			continue
+		}
+		images = append(images, promptImage{Filename: attachment.Filename, Mime: attachment.ContentType, Data: data})
+	}
+	return images, notes
+}
+
+// downloadAttachment fetches an attachment, refusing bodies over maxPromptImageBytes
+func downloadAttachment(url string) ([]byte, error) {
+	resp, err := imageDownloadClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPromptImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPromptImageBytes {
+		return nil, fmt.Errorf("attachment exceeds %d bytes", maxPromptImageBytes)
+	}
+	return data, nil
+}
+
+// imagePart converts an image into a file part carrying the image as a data URL
+func imagePart(image promptImage) opencode.SessionPromptParamsPartUnion {
+	return &opencode.FilePartInputParam{
+		Type:     opencode.F(opencode.FilePartInputTypeFile),
+		Mime:     opencode.F(image.Mime),
+		Filename: opencode.F(image.Filename),
+		URL:      opencode.F(fmt.Sprintf("data:%s;base64,%s", image.Mime, base64.StdEncoding.EncodeToString(image.Data))),
+	}
+}
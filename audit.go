@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// AuditEvent describes a user action recorded in the audit channel
+type AuditEvent struct {
+	UserID     string
+	Action     string
+	Repository string
+	ThreadID   string
+	Detail     string
+	Timestamp  time.Time
+}
+
+var auditQueue = make(chan AuditEvent, 100)
+var auditOnce sync.Once
+
+// format renders the audit event as a single structured line
+func (e AuditEvent) format() string {
+	fields := []string{
+		fmt.Sprintf("`%s`", e.Timestamp.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("user=<@%s>", e.UserID),
+		fmt.Sprintf("action=%s", e.Action),
+	}
+	if e.Repository != "" {
+		fields = append(fields, fmt.Sprintf("repo=%s", e.Repository))
+	}
+	if e.ThreadID != "" {
+		fields = append(fields, fmt.Sprintf("thread=<#%s>", e.ThreadID))
+	}
+	if e.Detail != "" {
+		fields = append(fields, fmt.Sprintf("detail=%q", e.Detail))
+	}
+	return strings.Join(fields, " ")
+}
+
+// auditLog queues an audit event for the configured audit channel without blocking the caller
+func auditLog(event AuditEvent) {
+	if AppConfig.AuditChannelID == "" {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	auditOnce.Do(func() {
+		go auditWorker()
+	})
+
+	select {
+	case auditQueue <- event:
+	default:
+		slog.Warn("audit queue full, dropping event", "action", event.Action, "user_id", event.UserID)
+	}
+}
+
+// auditWorker posts queued audit events in order
+func auditWorker() {
+	for event := range auditQueue {
+		if discord == nil {
+			slog.Error("discord session not available for audit log", "action", event.Action)
+			continue
+		}
+		if _, err := discord.ChannelMessageSend(AppConfig.AuditChannelID, event.format()); err != nil {
+			slog.Error("failed to post audit event", "action", event.Action, "error", err)
+		}
+	}
+}
+
+// auditInteraction records a slash command invocation
+func auditInteraction(i *discordgo.InteractionCreate) {
+	if AppConfig.AuditChannelID == "" {
+		return
+	}
+	data := i.ApplicationCommandData()
+	event := AuditEvent{
+		UserID:   interactionUserID(i),
+		Action:   "/" + data.Name,
+		ThreadID: i.ChannelID,
+	}
+
+	if data.Name == "codesession" {
+		for _, option := range data.Options {
+			if option.Name == "repository" {
+				if index := int(option.IntValue()); index >= 0 && index < len(AppConfig.Repositories) {
+					event.Repository = AppConfig.Repositories[index].Name
+				}
+			}
+		}
+	} else {
+		event.Repository = cachedRepositoryName(i.ChannelID)
+	}
+
+	auditLog(event)
+}
+
+// auditPrompt records a prompt sent to a session, redacting the content unless enabled in config
+func auditPrompt(userID, threadID, content string) {
+	detail := fmt.Sprintf("%d chars (redacted)", len(content))
+	if AppConfig.AuditIncludePrompts {
+		detail = content
+		detail = truncateText(detail, 200, "...")
+	}
+	auditLog(AuditEvent{
+		UserID:     userID,
+		Action:     "prompt",
+		Repository: cachedRepositoryName(threadID),
+		ThreadID:   threadID,
+		Detail:     detail,
+	})
+}
+
+// cachedRepositoryName returns the repository of a cached session, or "" when unknown
+func cachedRepositoryName(threadID string) string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		return sessionData.RepositoryName
+	}
+	return ""
+}
+
+// interactionUserID returns the invoking user for guild and DM interactions
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
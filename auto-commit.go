@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Checkpoint commit timers per thread, reset on every session activity
+var autoCommitTimers = make(map[string]*time.Timer)
+var autoCommitMutex sync.Mutex
+
+// autoCommitInterval returns the effective checkpoint interval for a session, 0 when disabled
+func autoCommitInterval(sessionData *SessionData) time.Duration {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	// Review and scratch sessions never produce changes to checkpoint
+	if sessionData.ReviewMode || sessionData.Scratch {
+		return 0
+	}
+	if sessionData.AutoCommitInterval != nil {
+		return *sessionData.AutoCommitInterval
+	}
+	return AppConfig.AutoCommitInterval
+}
+
+// resetAutoCommitTimer (re)starts the checkpoint timer for a thread after activity
+func resetAutoCommitTimer(threadID string) {
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	sessionMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	interval := autoCommitInterval(sessionData)
+
+	autoCommitMutex.Lock()
+	defer autoCommitMutex.Unlock()
+
+	if timer, exists := autoCommitTimers[threadID]; exists {
+		timer.Stop()
+		delete(autoCommitTimers, threadID)
+	}
+	if interval <= 0 {
+		return
+	}
+	autoCommitTimers[threadID] = time.AfterFunc(interval, func() {
+		runCheckpointCommit(threadID)
+	})
+	slog.Debug("auto-commit timer reset", "thread_id", threadID, "interval", interval)
+}
+
+// stopAutoCommitTimer cancels the checkpoint timer for a thread
+func stopAutoCommitTimer(threadID string) {
+	autoCommitMutex.Lock()
+	defer autoCommitMutex.Unlock()
+
+	if timer, exists := autoCommitTimers[threadID]; exists {
+		timer.Stop()
+		delete(autoCommitTimers, threadID)
+		slog.Debug("auto-commit timer stopped", "thread_id", threadID)
+	}
+}
+
+// runCheckpointCommit commits a dirty worktree with a generated checkpoint message. It goes through
+// the same checks and staging as /commit and never fires mid-turn or during another commit: the timer
+// is rescheduled instead.
+func runCheckpointCommit(threadID string) {
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	var isStreaming bool
+	var worktreePath string
+	if exists {
+		isStreaming = sessionData.IsStreaming
+		worktreePath = sessionData.WorktreePath
+	}
+	sessionMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	if isStreaming {
+		slog.Debug("session is streaming, postponing checkpoint commit", "thread_id", threadID)
+		resetAutoCommitTimer(threadID)
+		return
+	}
+	unlock, locked := tryLockCommits(threadID)
+	if !locked {
+		slog.Debug("commit in progress, postponing checkpoint commit", "thread_id", threadID)
+		resetAutoCommitTimer(threadID)
+		return
+	}
+	defer unlock()
+
+	includeUntracked := !AppConfig.CommitExcludeUntracked
+	gitStatus, refusal, err := stageSessionChanges(sessionData, worktreePath, includeUntracked, false)
+	if err != nil {
+		slog.Error("failed to prepare checkpoint", "thread_id", threadID, "error", err)
+		return
+	}
+	if refusal != nil {
+		if refusal.noChanges {
+			slog.Debug("nothing to checkpoint", "thread_id", threadID)
+			return
+		}
+		sendToDiscord(threadID, "💾 Checkpoint commit skipped. "+refusal.message)
+		return
+	}
+
+	fileCount := gitStatus.TotalCount
+	if !includeUntracked {
+		fileCount -= gitStatus.UntrackedCount
+	}
+	message := fmt.Sprintf("chore(checkpoint): auto-commit %d file(s)", fileCount)
+	branch, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		slog.Warn("failed to get branch for checkpoint", "thread_id", threadID, "error", err)
+	}
+	commitRecord := &CommitRecord{
+		Summary:   message,
+		Timestamp: time.Now(),
+		Status:    "pending",
+		Branch:    branch,
+	}
+	sessionMutex.Lock()
+	sessionData.Commits = append(sessionData.Commits, commitRecord)
+	sessionMutex.Unlock()
+
+	commitHash, err := gitOps.Commit(worktreePath, message, commitTrailers(threadID, "", ""))
+	if err != nil {
+		slog.Error("failed to create checkpoint commit", "thread_id", threadID, "error", err)
+		updateCommitRecord(commitRecord, "failed", "")
+		saveSessionData(sessionData)
+		return
+	}
+
+	status := "committed"
+	switch {
+	case !AppConfig.AutoCommitPush:
+		// Checkpoints stay local unless auto_commit_push is set
+	case branch == "":
+		slog.Error("not pushing checkpoint commit without a branch", "thread_id", threadID)
+	case commitConfirmationWarning(sessionData, time.Now()) != "":
+		// Old sessions need an explicit /commit confirm:true before anything is pushed
+		slog.Info("session needs commit confirmation, not pushing checkpoint", "thread_id", threadID)
+	default:
+		if err := gitOps.Push(worktreePath, branch); err != nil {
+			slog.Error("failed to push checkpoint commit", "thread_id", threadID, "error", err)
+		} else {
+			status = "success"
+			// Pushing may have rebased the commit onto the remote branch, giving it a new hash
+			if head, err := gitOps.GetCommitHash(worktreePath); err == nil {
+				commitHash = head
+			}
+		}
+	}
+	updateCommitRecord(commitRecord, status, commitHash)
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after checkpoint", "thread_id", threadID, "error", err)
+	}
+
+	slog.Info("checkpoint commit created", "thread_id", threadID, "commit_hash", commitHash, "status", status)
+	sendToDiscord(threadID, fmt.Sprintf("💾 Checkpoint commit `%s` created (%d file(s), %s).", commitHash[:min(len(commitHash), 7)], fileCount, status))
+}
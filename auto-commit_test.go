@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointCommitOnTimer(t *testing.T) {
+	repo := newTestRepo(t)
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	useTestConfig(t, func(config *Config) { config.AutoCommitPush = false })
+
+	interval := 10 * time.Millisecond
+	sessionData := &SessionData{ThreadID: "checkpoint-thread", WorktreePath: repo, AutoCommitInterval: &interval}
+	useTestSession(t, sessionData)
+	t.Cleanup(func() { stopAutoCommitTimer("checkpoint-thread") })
+
+	writeTestFile(t, filepath.Join(repo, "README.md"), "changed\n")
+	writeTestFile(t, filepath.Join(repo, "notes.txt"), "new\n")
+	resetAutoCommitTimer("checkpoint-thread")
+
+	// The announcement is the checkpoint's last step
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.calls(http.MethodPost, "/channels/checkpoint-thread/messages")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint commit was announced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if subject := runGit(t, repo, "log", "-1", "--format=%s"); subject != "chore(checkpoint): auto-commit 2 file(s)" {
+		t.Errorf("HEAD subject = %q, want the checkpoint message", subject)
+	}
+	if status := runGit(t, repo, "status", "--porcelain"); status != "" {
+		t.Errorf("worktree still dirty after checkpoint:\n%s", status)
+	}
+	head := runGit(t, repo, "rev-parse", "HEAD")
+
+	sessionMutex.RLock()
+	commits := sessionData.Commits
+	sessionMutex.RUnlock()
+	if len(commits) != 1 || commits[0].Status != "committed" || commits[0].Hash != head || commits[0].Branch != "main" {
+		t.Fatalf("commit records = %+v, want one local checkpoint at %s", commits, head)
+	}
+	announced := fake.calls(http.MethodPost, "/channels/checkpoint-thread/messages")[0].Body
+	if !strings.Contains(announced, head[:7]) || !strings.Contains(announced, "committed") {
+		t.Errorf("announcement %q does not name the checkpoint", announced)
+	}
+}
+
+func TestCheckpointPushRecordsRebasedHash(t *testing.T) {
+	repo := newTestRepo(t)
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	useTestConfig(t, func(config *Config) {
+		config.AutoCommitPush = true
+		config.CommitConfirmAfter = 0
+	})
+
+	// Someone else pushes to the branch after the session started
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--bare", "--initial-branch=main")
+	runGit(t, repo, "remote", "add", "origin", remote)
+	runGit(t, repo, "push", "--quiet", "origin", "main")
+	other := t.TempDir()
+	runGit(t, other, "clone", "--quiet", remote, ".")
+	runGit(t, other, "config", "user.name", "Other")
+	runGit(t, other, "config", "user.email", "other@example.com")
+	writeTestFile(t, filepath.Join(other, "other.txt"), "other\n")
+	runGit(t, other, "add", "-A")
+	runGit(t, other, "commit", "--quiet", "-m", "other change")
+	runGit(t, other, "push", "--quiet", "origin", "main")
+	otherHead := runGit(t, other, "rev-parse", "HEAD")
+
+	sessionData := &SessionData{ThreadID: "rebased-checkpoint", WorktreePath: repo}
+	useTestSession(t, sessionData)
+	writeTestFile(t, filepath.Join(repo, "README.md"), "changed\n")
+
+	runCheckpointCommit("rebased-checkpoint")
+
+	head := runGit(t, repo, "rev-parse", "HEAD")
+	if parent := runGit(t, repo, "rev-parse", "HEAD~1"); parent != otherHead {
+		t.Fatalf("checkpoint parent = %s, want it rebased onto %s", parent, otherHead)
+	}
+	if pushed := runGit(t, remote, "rev-parse", "main"); pushed != head {
+		t.Errorf("remote main = %s, want the checkpoint %s", pushed, head)
+	}
+	sessionMutex.RLock()
+	commits := sessionData.Commits
+	sessionMutex.RUnlock()
+	if len(commits) != 1 || commits[0].Status != "success" || commits[0].Hash != head {
+		t.Fatalf("commit records = %+v, want the pushed checkpoint at %s", commits, head)
+	}
+	announced := fake.calls(http.MethodPost, "/channels/rebased-checkpoint/messages")
+	if len(announced) != 1 || !strings.Contains(announced[0].Body, head[:7]) {
+		t.Errorf("announcement %v does not name the rebased hash %s", announced, head[:7])
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Generated branch names are cut to this many characters at a word boundary
+const maxBranchNameLength = 50
+
+// Asks the model for a branch name describing the first prompt of a session
+const branchNameInstruction = "Propose a short, descriptive git branch name for the request below, such as fix-login-redirect or add-csv-export. Reply with ONLY the branch name: lowercase words separated by hyphens, no explanation.\n\nRequest:\n"
+
+// sanitizeBranchName turns model output into a branch name: the first line, lowercased, with every
+// run of characters other than letters, digits and "/" collapsed into a hyphen. Returns "" when
+// nothing usable is left.
+func sanitizeBranchName(raw string) string {
+	line := strings.TrimSpace(raw)
+	line, _, _ = strings.Cut(line, "\n")
+	line = strings.ToLower(strings.Trim(strings.TrimSpace(line), "`'\"*"))
+
+	var b strings.Builder
+	hyphen := false
+	for _, r := range line {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '/':
+			b.WriteRune(r)
+			hyphen = false
+		case !hyphen:
+			b.WriteRune('-')
+			hyphen = true
+		}
+	}
+
+	// Drop empty path components and hyphens at their edges, e.g. "/feat/-x-" becomes "feat/x"
+	var components []string
+	for _, component := range strings.Split(b.String(), "/") {
+		if component = strings.Trim(component, "-"); component != "" {
+			components = append(components, component)
+		}
+	}
+	name := strings.Join(components, "/")
+
+	if len(name) > maxBranchNameLength {
+		name = name[:maxBranchNameLength]
+		if idx := strings.LastIndexAny(name, "-/"); idx > maxBranchNameLength/2 {
+			name = name[:idx]
+		}
+		name = strings.TrimRight(name, "-/")
+	}
+	if name == "main" || name == "master" {
+		return ""
+	}
+	return name
+}
+
+// branchHasCommits reports whether a session's branch already carries work that a rename could
+// disconnect from a pushed branch or open PR: recorded commits, or commits over the base branch
+func branchHasCommits(session *SessionData, commitCount int) bool {
+	if commitCount > 0 {
+		return true
+	}
+	baseBranch, err := sessionBaseBranch(session)
+	if err != nil {
+		slog.Debug("could not resolve base branch, relying on recorded commits", "thread_id", session.ThreadID, "error", err)
+		return false
+	}
+	ahead, _, err := gitOps.AheadBehind(session.WorktreePath, baseBranch)
+	return err != nil || ahead > 0
+}
+
+// autoNameBranch replaces the thread ID branch name of a fresh session with a descriptive one the
+// model derives from the first prompt. It runs once per session when auto_branch_name is enabled,
+// in a throwaway OpenCode session so the session's own history stays clean.
+func autoNameBranch(sessionData *SessionData, prompt string) {
+	if !AppConfig.AutoBranchName {
+		return
+	}
+
+	sessionMutex.Lock()
+	if sessionData.BranchNamed || sessionData.Scratch || sessionData.ReviewMode || sessionData.Worktrees != nil {
+		sessionMutex.Unlock()
+		return
+	}
+	sessionData.BranchNamed = true
+	threadID := sessionData.ThreadID
+	worktreePath := sessionData.WorktreePath
+	repoPath := sessionData.RepositoryPath
+	model := sessionData.Model
+	commitCount := len(sessionData.Commits)
+	sessionMutex.Unlock()
+	scheduleSessionFlush(threadID)
+
+	if branchHasCommits(sessionData, commitCount) {
+		slog.Debug("branch already has commits, keeping its name", "thread_id", threadID)
+		return
+	}
+
+	answer, err := promptThrowawaySession(worktreePath, model, branchNameInstruction+prompt)
+	if err != nil {
+		slog.Warn("failed to generate branch name", "thread_id", threadID, "error", err)
+		return
+	}
+	name := sanitizeBranchName(answer)
+	if name == "" {
+		slog.Warn("generated branch name is unusable", "thread_id", threadID, "answer", answer)
+		return
+	}
+	if gitOps.BranchExists(repoPath, name) {
+		name = fmt.Sprintf("%s-%s", name, threadID[max(0, len(threadID)-6):])
+	}
+	if err := gitOps.checkBranchName(repoPath, name); err != nil {
+		slog.Warn("generated branch name is invalid", "thread_id", threadID, "branch", name, "error", err)
+		return
+	}
+
+	// The model may have committed while the name was being generated
+	sessionMutex.RLock()
+	commitCount = len(sessionData.Commits)
+	sessionMutex.RUnlock()
+	if branchHasCommits(sessionData, commitCount) {
+		slog.Debug("branch gained commits, keeping its name", "thread_id", threadID)
+		return
+	}
+
+	current, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		slog.Warn("failed to resolve session branch", "thread_id", threadID, "error", err)
+		return
+	}
+	if err := gitOps.RenameBranch(worktreePath, current, name); err != nil {
+		slog.Warn("failed to rename session branch", "thread_id", threadID, "error", err)
+		return
+	}
+	slog.Info("renamed session branch", "thread_id", threadID, "from", current, "to", name)
+	sendToDiscord(threadID, fmt.Sprintf("🌿 Session branch renamed to `%s`.", name))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/sst/opencode-sdk-go"
@@ -13,34 +14,57 @@ import (
 var opencodeClient *opencode.Client
 var opencodeOnce sync.Once
 var sessionsDirectory string
+var worktreesDirectory string
+var dataDirsMutex sync.Mutex
 
-func ensureSessionDir() (string, error) {
-	if sessionsDirectory != "" {
-		return sessionsDirectory, nil
+// ensureDataDirs resolves the sessions and worktrees directories from a single base
+// (the bot's working directory) so every caller agrees on where data lives
+func ensureDataDirs() error {
+	dataDirsMutex.Lock()
+	defer dataDirsMutex.Unlock()
+
+	if sessionsDirectory != "" && worktreesDirectory != "" {
+		return nil
 	}
 	cwd, err := os.Getwd()
 	if err != nil {
+		return err
+	}
+	sessionsDir := filepath.Join(cwd, ".sessions")
+	worktreesDir := filepath.Join(cwd, ".worktrees")
+	for _, dir := range []string{sessionsDir, worktreesDir} {
+		if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
+			return mkErr
+		}
+	}
+	sessionsDirectory = sessionsDir
+	worktreesDirectory = worktreesDir
+	return nil
+}
+
+func ensureSessionDir() (string, error) {
+	if err := ensureDataDirs(); err != nil {
 		return "", err
 	}
-	dir := fmt.Sprintf("%s/.sessions", cwd)
-	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
-		return "", mkErr
+	return sessionsDirectory, nil
+}
+
+func ensureWorktreeDir() (string, error) {
+	if err := ensureDataDirs(); err != nil {
+		return "", err
 	}
-	sessionsDirectory = dir
-	return dir, nil
+	return worktreesDirectory, nil
 }
 
 // setup opencode singleton
 func Opencode() *opencode.Client {
 	opencodeOnce.Do(func() {
-		// Use ensureSessionDir helper
-		_, err := ensureSessionDir()
-		if err != nil {
-			slog.Error("failed to ensure sessions directory", "error", err)
+		if err := ensureDataDirs(); err != nil {
+			slog.Error("failed to ensure data directories", "error", err)
 			return
 		}
 
-		slog.Debug("sessions directory", "sessions_directory", sessionsDirectory)
+		slog.Debug("data directories", "sessions_directory", sessionsDirectory, "worktrees_directory", worktreesDirectory)
 
 		opencodeClient = opencode.NewClient(
 			option.WithBaseURL(fmt.Sprintf("http://127.0.0.1:%d", AppConfig.OpencodePort)),
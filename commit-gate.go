@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Per-thread locks serializing /commit, /amend and checkpoint commits in a session's worktree
+var commitLocks = make(map[string]*sync.Mutex)
+var commitLocksMutex sync.Mutex
+
+// Reply when a commit is requested while another one is still running in the thread
+const commitInProgressMessage = "Another commit is still running in this thread. Try again once it has finished."
+
+// Protected files listed in a refused commit before the rest are summarized as a count
+const maxListedProtectedFiles = 20
+
+// tryLockCommits takes a thread's commit lock without waiting. It returns the unlock function, or
+// false when another commit holds the lock.
+func tryLockCommits(threadID string) (func(), bool) {
+	commitLocksMutex.Lock()
+	lock, exists := commitLocks[threadID]
+	if !exists {
+		lock = &sync.Mutex{}
+		commitLocks[threadID] = lock
+	}
+	commitLocksMutex.Unlock()
+
+	if !lock.TryLock() {
+		return nil, false
+	}
+	return lock.Unlock, true
+}
+
+// commitRefusal explains why stageSessionChanges staged nothing. noChanges marks a worktree with
+// nothing to commit, as opposed to changes the repository's safety checks refused.
+type commitRefusal struct {
+	message   string
+	noChanges bool
+}
+
+// stageSessionChanges runs the checks every session commit goes through, then stages the changes.
+// There must be something to commit (tracked changes only without includeUntracked), and unless
+// forced no protected path or file with a disallowed extension may be touched. Nothing is staged
+// when it returns a refusal or an error.
+func stageSessionChanges(session *SessionData, worktreePath string, includeUntracked, force bool) (*GitStatus, *commitRefusal, error) {
+	sessionMutex.RLock()
+	threadID := session.ThreadID
+	repository := findRepository(session.RepositoryName)
+	sessionMutex.RUnlock()
+
+	gitStatus, err := gitOps.GetStatus(worktreePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check git status: %w", err)
+	}
+	if gitStatus.IsClean {
+		return gitStatus, &commitRefusal{message: "No changes to commit. The worktree is clean.", noChanges: true}, nil
+	}
+	if !includeUntracked && gitStatus.ModifiedCount == 0 && gitStatus.StagedCount == 0 {
+		message := fmt.Sprintf("No tracked changes to commit. %d untracked file(s) were left out; use `include_untracked:true` to add them.", gitStatus.UntrackedCount)
+		return gitStatus, &commitRefusal{message: message, noChanges: true}, nil
+	}
+
+	// Refuse to commit changes to protected paths unless forced
+	if repository != nil && len(repository.ProtectedPaths) > 0 {
+		touched, err := gitOps.ProtectedFilesTouched(worktreePath, repository.ProtectedPaths)
+		if err != nil {
+			return gitStatus, nil, fmt.Errorf("failed to check protected paths: %w", err)
+		}
+		if len(touched) > 0 {
+			if !force {
+				slog.Warn("commit touches protected paths", "thread_id", threadID, "files", touched)
+				message := fmt.Sprintf("Refusing to commit: protected files were changed:\n```\n%s\n```\nRun `/commit force:true` to commit anyway.", formatFileList(touched[:min(len(touched), maxListedProtectedFiles)], len(touched)))
+				return gitStatus, &commitRefusal{message: message}, nil
+			}
+			slog.Warn("committing protected paths with force", "thread_id", threadID, "files", touched)
+		}
+	}
+
+	// Refuse to commit files whose extension the repository does not allow, unless forced
+	if repository != nil && repository.hasEditRules() {
+		disallowed, err := gitOps.ChangedFilesMatching(worktreePath, func(file string) bool {
+			return !repository.editAllowed(file)
+		})
+		if err != nil {
+			return gitStatus, nil, fmt.Errorf("failed to check changed file extensions: %w", err)
+		}
+		if len(disallowed) > 0 {
+			if !force {
+				slog.Warn("commit touches disallowed file extensions", "thread_id", threadID, "files", disallowed)
+				message := fmt.Sprintf("Refusing to commit: files with disallowed extensions were changed:\n```\n%s\n```\nRun `/commit force:true` to commit anyway.", formatFileList(disallowed[:min(len(disallowed), maxListedProtectedFiles)], len(disallowed)))
+				return gitStatus, &commitRefusal{message: message}, nil
+			}
+			slog.Warn("committing disallowed file extensions with force", "thread_id", threadID, "files", disallowed)
+		}
+	}
+
+	slog.Debug("staging changes", "thread_id", threadID, "include_untracked", includeUntracked)
+	if includeUntracked {
+		err = gitOps.AddAll(worktreePath)
+	} else {
+		err = gitOps.AddTracked(worktreePath)
+	}
+	if err != nil {
+		return gitStatus, nil, fmt.Errorf("failed to stage changes: %w", err)
+	}
+	return gitStatus, nil, nil
+}
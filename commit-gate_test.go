@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStageSessionChangesUntracked(t *testing.T) {
+	tests := []struct {
+		name             string
+		modifyTracked    bool
+		addUntracked     bool
+		includeUntracked bool
+		wantRefusal      string
+		wantStaged       []string
+	}{
+		{name: "clean", includeUntracked: true, wantRefusal: "No changes to commit. The worktree is clean."},
+		{name: "untracked only, excluded", addUntracked: true, wantRefusal: "No tracked changes to commit. 1 untracked file(s) were left out; use `include_untracked:true` to add them."},
+		{name: "untracked only, included", addUntracked: true, includeUntracked: true, wantStaged: []string{"new.txt"}},
+		{name: "tracked only, excluded", modifyTracked: true, wantStaged: []string{"README.md"}},
+		{name: "tracked and untracked, excluded", modifyTracked: true, addUntracked: true, wantStaged: []string{"README.md"}},
+		{name: "tracked and untracked, included", modifyTracked: true, addUntracked: true, includeUntracked: true, wantStaged: []string{"README.md", "new.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestRepo(t)
+			if tt.modifyTracked {
+				writeTestFile(t, filepath.Join(dir, "README.md"), "changed\n")
+			}
+			if tt.addUntracked {
+				writeTestFile(t, filepath.Join(dir, "new.txt"), "new\n")
+			}
+
+			session := &SessionData{ThreadID: "stage-thread"}
+			_, refusal, err := stageSessionChanges(session, dir, tt.includeUntracked, false)
+			if err != nil {
+				t.Fatalf("stageSessionChanges() error = %v", err)
+			}
+			if tt.wantRefusal != "" {
+				if refusal == nil || refusal.message != tt.wantRefusal || !refusal.noChanges {
+					t.Fatalf("refusal = %+v, want no-changes refusal %q", refusal, tt.wantRefusal)
+				}
+			} else if refusal != nil {
+				t.Fatalf("unexpected refusal %q", refusal.message)
+			}
+
+			var staged []string
+			if output := runGit(t, dir, "diff", "--cached", "--name-only"); output != "" {
+				staged = strings.Split(output, "\n")
+			}
+			if strings.Join(staged, ",") != strings.Join(tt.wantStaged, ",") {
+				t.Errorf("staged %v, want %v", staged, tt.wantStaged)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CommitIndexEntry is one pushed commit in the central commit index
+type CommitIndexEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Repository string    `json:"repository"`
+	Branch     string    `json:"branch"`
+	Hash       string    `json:"hash"`
+	Summary    string    `json:"summary"`
+	UserID     string    `json:"user_id,omitempty"`
+	ThreadID   string    `json:"thread_id"`
+}
+
+// indexMutex serializes index file access so concurrent appends never interleave lines
+var indexMutex sync.Mutex
+
+// commitIndexPath returns the location of the append-only commit index next to the data directories
+func commitIndexPath() (string, error) {
+	sessionDir, err := ensureSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(sessionDir), "commits-index.jsonl"), nil
+}
+
+// appendCommitIndex appends an entry to the commit index when commit_index is enabled
+func appendCommitIndex(entry CommitIndexEntry) error {
+	if !AppConfig.CommitIndex {
+		return nil
+	}
+	indexPath, err := commitIndexPath()
+	if err != nil {
+		return err
+	}
+	return appendIndexEntry(indexPath, entry)
+}
+
+// readCommitIndex returns the index entries recorded at or after since, oldest first
+func readCommitIndex(since time.Time) ([]CommitIndexEntry, error) {
+	indexPath, err := commitIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	return readIndexEntries(indexPath, since, func(entry CommitIndexEntry) time.Time {
+		return entry.Timestamp
+	})
+}
+
+// appendIndexEntry appends entry as one JSON line to an append-only index file
+func appendIndexEntry(indexPath string, entry any) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index entry: %w", err)
+	}
+
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	file, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(indexPath), err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", filepath.Base(indexPath), err)
+	}
+	return nil
+}
+
+// readIndexEntries returns the entries of an index file whose timestamp is at or after since, oldest first.
+// A missing file yields no entries.
+func readIndexEntries[T any](indexPath string, since time.Time, timestamp func(T) time.Time) ([]T, error) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	file, err := os.Open(indexPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(indexPath), err)
+	}
+	defer file.Close()
+
+	var entries []T
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry T
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("skipping malformed index line", "file", filepath.Base(indexPath), "error", err)
+			continue
+		}
+		if !timestamp(entry).Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(indexPath), err)
+	}
+	return entries, nil
+}
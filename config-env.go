@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// envReferencePattern matches ${VAR} and ${VAR:-default} in config string values
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandConfigEnv replaces environment variable references in every string value of the config,
+// so secrets such as bot_token can stay out of the file. Only strings are expanded: numbers,
+// booleans and durations are decoded before expansion runs.
+func expandConfigEnv(config *Config) error {
+	return expandEnvValue(reflect.ValueOf(config).Elem(), "", os.LookupEnv)
+}
+
+// expandEnvValue walks a decoded config value and expands the strings it contains. path names the
+// value in errors, using the config keys.
+func expandEnvValue(value reflect.Value, path string, lookup func(string) (string, bool)) error {
+	switch value.Kind() {
+	case reflect.String:
+		expanded, err := expandEnv(value.String(), lookup)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if value.CanSet() {
+			value.SetString(expanded)
+		}
+	case reflect.Pointer:
+		if !value.IsNil() {
+			return expandEnvValue(value.Elem(), path, lookup)
+		}
+	case reflect.Struct:
+		for idx := 0; idx < value.NumField(); idx++ {
+			field := value.Type().Field(idx)
+			if !field.IsExported() {
+				continue
+			}
+			if err := expandEnvValue(value.Field(idx), joinConfigPath(path, configKey(field)), lookup); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for idx := 0; idx < value.Len(); idx++ {
+			if err := expandEnvValue(value.Index(idx), fmt.Sprintf("%s[%d]", path, idx), lookup); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map values are not addressable, so each one is expanded in a copy and stored back
+		iter := value.MapRange()
+		for iter.Next() {
+			entry := reflect.New(iter.Value().Type()).Elem()
+			entry.Set(iter.Value())
+			if err := expandEnvValue(entry, joinConfigPath(path, fmt.Sprint(iter.Key().Interface())), lookup); err != nil {
+				return err
+			}
+			value.SetMapIndex(iter.Key(), entry)
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${VAR} with the variable's value and ${VAR:-default} with the value or, when
+// the variable is unset or empty, the default. A referenced variable that is unset without a
+// default is an error.
+func expandEnv(text string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	expanded := envReferencePattern.ReplaceAllStringFunc(text, func(reference string) string {
+		match := envReferencePattern.FindStringSubmatch(reference)
+		name, hasDefault, fallback := match[1], match[2] != "", match[3]
+		if value, ok := lookup(name); ok && (value != "" || !hasDefault) {
+			return value
+		}
+		if hasDefault {
+			return fallback
+		}
+		missing = append(missing, name)
+		return reference
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// configKey returns the config file key of a struct field
+func configKey(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("toml"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// joinConfigPath appends a key to a dotted config path
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
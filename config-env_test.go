@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{
+		"TOKEN": "secret",
+		"EMPTY": "",
+		"HOST":  "example.com",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "no reference", text: "plain", want: "plain"},
+		{name: "set", text: "${TOKEN}", want: "secret"},
+		{name: "embedded", text: "https://${HOST}/api", want: "https://example.com/api"},
+		{name: "several", text: "${HOST}:${TOKEN}", want: "example.com:secret"},
+		{name: "set empty without default", text: "[${EMPTY}]", want: "[]"},
+		{name: "default when empty", text: "${EMPTY:-fallback}", want: "fallback"},
+		{name: "default when unset", text: "${MISSING:-fallback}", want: "fallback"},
+		{name: "empty default", text: "${MISSING:-}", want: ""},
+		{name: "default ignored when set", text: "${TOKEN:-fallback}", want: "secret"},
+		{name: "unset", text: "${MISSING}", wantErr: true},
+		{name: "bare dollar untouched", text: "$TOKEN", want: "$TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.text, lookup)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expandEnv(%q) = %q, want an error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandEnv(%q) returned error: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("expandEnv(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,46 +1,365 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	BotToken              string       `toml:"bot_token"`
-	OpencodePort          int          `toml:"opencode_port"`
-	LogLevel              string       `toml:"log_level"`
-	SummarizerInstruction string       `toml:"summarizer_instruction"`
-	Repositories          []Repository `toml:"repositories"`
-	Models                []Model      `toml:"models"`
+	BotToken                   string                  `toml:"bot_token" yaml:"bot_token"`
+	OpencodePort               int                     `toml:"opencode_port" yaml:"opencode_port"`
+	OpencodePath               string                  `toml:"opencode_path" yaml:"opencode_path"`
+	OpencodeArgs               []string                `toml:"opencode_args" yaml:"opencode_args"`
+	ReuseExistingServer        bool                    `toml:"reuse_existing_server" yaml:"reuse_existing_server"`
+	OpencodeEnv                map[string]string       `toml:"opencode_env" yaml:"opencode_env"`
+	LogLevel                   string                  `toml:"log_level" yaml:"log_level"`
+	SummarizerInstruction      string                  `toml:"summarizer_instruction" yaml:"summarizer_instruction"`
+	PRDescriptionInstruction   string                  `toml:"pr_description_instruction" yaml:"pr_description_instruction"`
+	CleanupStatusOnComplete    bool                    `toml:"cleanup_status_on_complete" yaml:"cleanup_status_on_complete"`
+	CommitMessageTemplate      string                  `toml:"commit_message_template" yaml:"commit_message_template"`
+	EnforceConventionalCommits bool                    `toml:"enforce_conventional_commits" yaml:"enforce_conventional_commits"`
+	CommitExcludeUntracked     bool                    `toml:"commit_exclude_untracked" yaml:"commit_exclude_untracked"`
+	CommitThreadTrailer        bool                    `toml:"commit_thread_trailer" yaml:"commit_thread_trailer"`
+	CommitRequesterTrailer     bool                    `toml:"commit_requester_trailer" yaml:"commit_requester_trailer"`
+	ResponseMode               string                  `toml:"response_mode" yaml:"response_mode"`
+	UseEmbeds                  bool                    `toml:"use_embeds" yaml:"use_embeds"`
+	AuditChannelID             string                  `toml:"audit_channel_id" yaml:"audit_channel_id"`
+	AuditIncludePrompts        bool                    `toml:"audit_include_prompts" yaml:"audit_include_prompts"`
+	AllowedBotIDs              []string                `toml:"allowed_bot_ids" yaml:"allowed_bot_ids"`
+	CommitConfirmAfter         time.Duration           `toml:"commit_confirm_after" yaml:"commit_confirm_after"`
+	AutoCommitInterval         time.Duration           `toml:"auto_commit_interval" yaml:"auto_commit_interval"`
+	AutoCommitPush             bool                    `toml:"auto_commit_push" yaml:"auto_commit_push"`
+	ShowPresence               bool                    `toml:"show_presence" yaml:"show_presence"`
+	UpdateThreadTitle          bool                    `toml:"update_thread_title" yaml:"update_thread_title"`
+	ThreadNameTemplate         string                  `toml:"thread_name_template" yaml:"thread_name_template"`
+	MessageCoalesceWindow      time.Duration           `toml:"message_coalesce_window" yaml:"message_coalesce_window"`
+	ContextTokenBudget         int                     `toml:"context_token_budget" yaml:"context_token_budget"`
+	ToolProgress               bool                    `toml:"tool_progress" yaml:"tool_progress"`
+	StatusFlushInterval        time.Duration           `toml:"status_flush_interval" yaml:"status_flush_interval"`
+	StuckSessionTimeout        time.Duration           `toml:"stuck_session_timeout" yaml:"stuck_session_timeout"`
+	PromptTimeout              time.Duration           `toml:"prompt_timeout" yaml:"prompt_timeout"`
+	InteractionMode            string                  `toml:"interaction_mode" yaml:"interaction_mode"`
+	InteractionsListen         string                  `toml:"interactions_listen" yaml:"interactions_listen"`
+	ApplicationPublicKey       string                  `toml:"application_public_key" yaml:"application_public_key"`
+	MaxConcurrentGitOps        int                     `toml:"max_concurrent_git_ops" yaml:"max_concurrent_git_ops"`
+	AllowAnnouncementThreads   bool                    `toml:"allow_announcement_threads" yaml:"allow_announcement_threads"`
+	ConfirmSessionStart        bool                    `toml:"confirm_session_start" yaml:"confirm_session_start"`
+	EnableAutoRespond          bool                    `toml:"enable_auto_respond" yaml:"enable_auto_respond"`
+	EnableModelComparison      bool                    `toml:"enable_model_comparison" yaml:"enable_model_comparison"`
+	AllowChannelSessions       bool                    `toml:"allow_channel_sessions" yaml:"allow_channel_sessions"`
+	AutoBranchName             bool                    `toml:"auto_branch_name" yaml:"auto_branch_name"`
+	SummaryFallback            bool                    `toml:"summary_fallback" yaml:"summary_fallback"`
+	RerunOnEdit                bool                    `toml:"rerun_on_edit" yaml:"rerun_on_edit"`
+	ThreadMessageLimit         int                     `toml:"thread_message_limit" yaml:"thread_message_limit"`
+	ThreadMaxAge               time.Duration           `toml:"thread_max_age" yaml:"thread_max_age"`
+	CommitSubjectMax           int                     `toml:"commit_subject_max" yaml:"commit_subject_max"`
+	CommitBodyWrap             int                     `toml:"commit_body_wrap" yaml:"commit_body_wrap"`
+	InactivityNudgeAfter       time.Duration           `toml:"inactivity_nudge_after" yaml:"inactivity_nudge_after"`
+	InactivityCloseAfter       time.Duration           `toml:"inactivity_close_after" yaml:"inactivity_close_after"`
+	StatusMaxChars             int                     `toml:"status_max_chars" yaml:"status_max_chars"`
+	StatusToolChars            int                     `toml:"status_tool_chars" yaml:"status_tool_chars"`
+	StatusResponseChars        int                     `toml:"status_response_chars" yaml:"status_response_chars"`
+	StatusTrimFirst            string                  `toml:"status_trim_first" yaml:"status_trim_first"`
+	ResponseAsEmbed            bool                    `toml:"response_as_embed" yaml:"response_as_embed"`
+	FinalResponse              string                  `toml:"final_response" yaml:"final_response"`
+	StripANSI                  *bool                   `toml:"strip_ansi" yaml:"strip_ansi"`
+	OnComplete                 string                  `toml:"on_complete" yaml:"on_complete"`
+	ReasoningOnlyResponse      string                  `toml:"reasoning_only_response" yaml:"reasoning_only_response"`
+	CommandPermissions         map[string][]string     `toml:"command_permissions" yaml:"command_permissions"`
+	UsageIndex                 bool                    `toml:"usage_index" yaml:"usage_index"`
+	CommitIndex                bool                    `toml:"commit_index" yaml:"commit_index"`
+	AdminUserIDs               []string                `toml:"admin_user_ids" yaml:"admin_user_ids"`
+	Pricing                    map[string]ModelPricing `toml:"pricing" yaml:"pricing"`
+	ToolPresets                map[string]ToolPreset   `toml:"tool_presets" yaml:"tool_presets"`
+	CostConfirmThreshold       float64                 `toml:"cost_confirm_threshold" yaml:"cost_confirm_threshold"`
+	AdminRoleIDs               []string                `toml:"admin_role_ids" yaml:"admin_role_ids"`
+	Repositories               []Repository            `toml:"repositories" yaml:"repositories"`
+	Models                     []Model                 `toml:"models" yaml:"models"`
 }
 
 type Repository struct {
-	Path string `toml:"path"`
-	Name string `toml:"name"`
+	Path           string   `toml:"path" yaml:"path"`
+	Name           string   `toml:"name" yaml:"name"`
+	ProtectedPaths []string `toml:"protected_paths" yaml:"protected_paths"`
+	// File extensions /commit accepts changes to; empty allows all. Blocked extensions win over allowed ones.
+	AllowedEditExtensions []string `toml:"allowed_edit_extensions" yaml:"allowed_edit_extensions"`
+	BlockedEditExtensions []string `toml:"blocked_edit_extensions" yaml:"blocked_edit_extensions"`
+	SparsePaths           []string `toml:"sparse_paths" yaml:"sparse_paths"`
+	ContextFiles          []string `toml:"context_files" yaml:"context_files"`
+	// Absolute paths of directories the model may read but not modify, e.g. a shared library
+	ExtraContextDirs []string `toml:"extra_context_dirs" yaml:"extra_context_dirs"`
+	// Pull the reference repository before creating a session worktree; nil means true
+	PullBeforeWorktree *bool `toml:"pull_before_worktree" yaml:"pull_before_worktree"`
+	// Name of the entry in tool_presets that sets the OpenCode tools prompts may use; empty keeps the server defaults
+	ToolsPreset string `toml:"tools_preset" yaml:"tools_preset"`
+	// Let /gitconfig enable extensions.worktreeConfig, which is written to the repository's shared config
+	AllowWorktreeConfig bool `toml:"allow_worktree_config" yaml:"allow_worktree_config"`
+}
+
+// ToolPreset enables (true) or disables (false) OpenCode tools by name, e.g. {"bash": false}
+type ToolPreset map[string]bool
+
+// pullBeforeWorktree reports whether new session worktrees start from freshly pulled changes
+func (r Repository) pullBeforeWorktree() bool {
+	return r.PullBeforeWorktree == nil || *r.PullBeforeWorktree
+}
+
+// stripANSI reports whether terminal escape sequences are removed from tool output
+func (c *Config) stripANSI() bool {
+	return c.StripANSI == nil || *c.StripANSI
+}
+
+// editAllowed reports whether changes to file pass the repository's extension rules.
+// Extensions are compared case-insensitively with or without the leading dot; "" stands for files without one.
+func (r Repository) editAllowed(file string) bool {
+	extension := strings.TrimPrefix(strings.ToLower(path.Ext(file)), ".")
+	matches := func(extensions []string) bool {
+		for _, candidate := range extensions {
+			if strings.TrimPrefix(strings.ToLower(candidate), ".") == extension {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(r.BlockedEditExtensions) {
+		return false
+	}
+	return len(r.AllowedEditExtensions) == 0 || matches(r.AllowedEditExtensions)
+}
+
+// hasEditRules reports whether the repository restricts which file extensions may change
+func (r Repository) hasEditRules() bool {
+	return len(r.AllowedEditExtensions) > 0 || len(r.BlockedEditExtensions) > 0
 }
 
 type Model struct {
-	ProviderID string `toml:"provider_id"`
-	ModelID    string `toml:"model_id"`
+	ProviderID     string        `toml:"provider_id" yaml:"provider_id"`
+	ModelID        string        `toml:"model_id" yaml:"model_id"`
+	PromptTimeout  time.Duration `toml:"prompt_timeout" yaml:"prompt_timeout" json:"-"`
+	SupportsVision bool          `toml:"supports_vision" yaml:"supports_vision" json:"-"`
+}
+
+// Prompts time out after this long unless prompt_timeout is configured
+const defaultPromptTimeout = 60 * time.Second
+
+// promptTimeoutFor returns the prompt timeout for a model: its own, then the global one, then the default
+func promptTimeoutFor(model Model) time.Duration {
+	if configured := findModel(model.ProviderID, model.ModelID); configured != nil && configured.PromptTimeout > 0 {
+		return configured.PromptTimeout
+	}
+	if AppConfig.PromptTimeout > 0 {
+		return AppConfig.PromptTimeout
+	}
+	return defaultPromptTimeout
+}
+
+// findModel returns the configured model matching provider and model ID, or nil
+func findModel(providerID, modelID string) *Model {
+	for idx := range AppConfig.Models {
+		if AppConfig.Models[idx].ProviderID == providerID && AppConfig.Models[idx].ModelID == modelID {
+			return &AppConfig.Models[idx]
+		}
+	}
+	return nil
 }
 
+// Response rendering modes
+const (
+	ResponseModeEdit       = "edit"        // Edit the response into the status message in place
+	ResponseModeReplyChain = "reply-chain" // Post each completed text part as a new message
+)
+
+// Handling of turns that end with reasoning but no text response
+const (
+	ReasoningOnlyPromote = "promote" // Post the last reasoning as the response
+	ReasoningOnlyNotice  = "notice"  // Post a notice that no answer was produced
+	ReasoningOnlyIgnore  = "ignore"  // Do nothing
+)
+
+// What happens to a session thread when a turn completes
+const (
+	OnCompleteKeep    = "keep"    // Leave the thread open
+	OnCompleteArchive = "archive" // Archive the thread; a new message reopens it
+	OnCompleteLock    = "lock"    // Archive and lock the thread; only moderators can reopen it
+)
+
+// Which part of an oversized status message status_max_chars trims first
+const (
+	StatusTrimTools    = "tools"    // Drop the oldest tool status lines, keeping the response
+	StatusTrimResponse = "response" // Cut the start of the response, keeping the tool history
+)
+
+// How the final response of a turn is repeated once the turn completes
+const (
+	FinalResponseNone   = "none"   // Only the status message shows the response
+	FinalResponseEmbed  = "embed"  // Post the response as a standalone result embed
+	FinalResponsePinned = "pinned" // Post the result embed and pin it
+)
+
+// Slash command delivery modes
+const (
+	InteractionModeGateway = "gateway" // Receive interactions over the gateway websocket
+	InteractionModeHTTP    = "http"    // Receive interactions on an HTTP endpoint
+)
+
+// Default listen address for the HTTP interactions endpoint
+const defaultInteractionsListen = ":8080"
+
 var AppConfig Config
 
-func LoadConfig() error {
-	configFile := "config.toml"
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		slog.Error("config.toml not found", "error", err)
+// findRepository returns the configured repository with the given name, or nil
+func findRepository(name string) *Repository {
+	for idx := range AppConfig.Repositories {
+		if AppConfig.Repositories[idx].Name == name {
+			return &AppConfig.Repositories[idx]
+		}
+	}
+	return nil
+}
+
+// Config files looked up in the working directory; exactly one of them must exist
+var configFiles = []string{"config.toml", "config.yaml", "config.yml"}
+
+// findConfigFile returns the single config file present, erroring when none or several exist
+func findConfigFile() (string, error) {
+	var found []string
+	for _, name := range configFiles {
+		if _, err := os.Stat(name); err == nil {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no config file found, expected one of %s", strings.Join(configFiles, ", "))
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("multiple config files found (%s), keep only one", strings.Join(found, ", "))
+	}
+}
+
+// decodeConfigFile decodes a TOML or YAML config file into config based on its extension
+func decodeConfigFile(configFile string, config *Config) error {
+	if filepath.Ext(configFile) == ".toml" {
+		_, err := toml.DecodeFile(configFile, config)
 		return err
 	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, config)
+}
 
-	_, err := toml.DecodeFile(configFile, &AppConfig)
+func LoadConfig() error {
+	configFile, err := findConfigFile()
 	if err != nil {
-		slog.Error("failed to decode config.toml", "error", err)
+		slog.Error("config file not found", "error", err)
+		return err
+	}
+
+	if err := decodeConfigFile(configFile, &AppConfig); err != nil {
+		slog.Error("failed to decode config", "file", configFile, "error", err)
+		return err
+	}
+
+	if err := expandConfigEnv(&AppConfig); err != nil {
+		err = fmt.Errorf("failed to expand environment variables in config: %w", err)
+		slog.Error("invalid config", "file", configFile, "error", err)
+		return err
+	}
+
+	switch AppConfig.ResponseMode {
+	case "":
+		AppConfig.ResponseMode = ResponseModeEdit
+	case ResponseModeEdit, ResponseModeReplyChain:
+	default:
+		err := fmt.Errorf("invalid response_mode %q, expected %q or %q", AppConfig.ResponseMode, ResponseModeEdit, ResponseModeReplyChain)
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+
+	switch AppConfig.ReasoningOnlyResponse {
+	case "":
+		AppConfig.ReasoningOnlyResponse = ReasoningOnlyPromote
+	case ReasoningOnlyPromote, ReasoningOnlyNotice, ReasoningOnlyIgnore:
+	default:
+		err := fmt.Errorf("invalid reasoning_only_response %q, expected %q, %q or %q", AppConfig.ReasoningOnlyResponse, ReasoningOnlyPromote, ReasoningOnlyNotice, ReasoningOnlyIgnore)
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+
+	switch AppConfig.OnComplete {
+	case "":
+		AppConfig.OnComplete = OnCompleteKeep
+	case OnCompleteKeep, OnCompleteArchive, OnCompleteLock:
+	default:
+		err := fmt.Errorf("invalid on_complete %q, expected %q, %q or %q", AppConfig.OnComplete, OnCompleteKeep, OnCompleteArchive, OnCompleteLock)
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+
+	switch AppConfig.StatusTrimFirst {
+	case "":
+		AppConfig.StatusTrimFirst = StatusTrimTools
+	case StatusTrimTools, StatusTrimResponse:
+	default:
+		err := fmt.Errorf("invalid status_trim_first %q, expected %q or %q", AppConfig.StatusTrimFirst, StatusTrimTools, StatusTrimResponse)
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+
+	switch AppConfig.FinalResponse {
+	case "":
+		AppConfig.FinalResponse = FinalResponseNone
+	case FinalResponseNone, FinalResponseEmbed, FinalResponsePinned:
+	default:
+		err := fmt.Errorf("invalid final_response %q, expected %q, %q or %q", AppConfig.FinalResponse, FinalResponseNone, FinalResponseEmbed, FinalResponsePinned)
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+
+	for _, repository := range AppConfig.Repositories {
+		if _, exists := AppConfig.ToolPresets[repository.ToolsPreset]; repository.ToolsPreset != "" && !exists {
+			err := fmt.Errorf("repository %q uses unknown tools_preset %q", repository.Name, repository.ToolsPreset)
+			slog.Error("invalid config", "error", err)
+			return err
+		}
+	}
+
+	if AppConfig.CommitMessageTemplate != "" {
+		if err := validateCommitTemplate(AppConfig.CommitMessageTemplate); err != nil {
+			slog.Error("invalid config", "error", err)
+			return err
+		}
+	}
+
+	switch AppConfig.InteractionMode {
+	case "":
+		AppConfig.InteractionMode = InteractionModeGateway
+	case InteractionModeGateway:
+	case InteractionModeHTTP:
+		if _, err := parsePublicKey(AppConfig.ApplicationPublicKey); err != nil {
+			slog.Error("invalid config", "error", err)
+			return err
+		}
+		if AppConfig.InteractionsListen == "" {
+			AppConfig.InteractionsListen = defaultInteractionsListen
+		}
+	default:
+		err := fmt.Errorf("invalid interaction_mode %q, expected %q or %q", AppConfig.InteractionMode, InteractionModeGateway, InteractionModeHTTP)
+		slog.Error("invalid config", "error", err)
 		return err
 	}
 
-	slog.Info("config loaded successfully")
+	slog.Info("config loaded successfully", "file", configFile)
 	return nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Correlation IDs tie a user-facing error to the log records behind it: the ID shown to the user
+// is attached to every record logged through the matching logger, so operators can grep for it.
+
+// interactionCorrelationID derives a short ID from an interaction, stable for its whole lifetime
+func interactionCorrelationID(i *discordgo.InteractionCreate) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(i.ID))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// newCorrelationID returns a random short ID for failures outside an interaction, e.g. prompts
+func newCorrelationID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// interactionLogger returns a logger that tags records with the interaction's thread and correlation ID
+func interactionLogger(i *discordgo.InteractionCreate) *slog.Logger {
+	return slog.With("thread_id", i.ChannelID, "correlation_id", interactionCorrelationID(i))
+}
+
+// withCorrelationID appends the reference users can quote when reporting an error
+func withCorrelationID(message, correlationID string) string {
+	return fmt.Sprintf("%s (ref: `%s`)", message, correlationID)
+}
+
+// respondError answers a deferred interaction with an error message carrying its correlation ID
+func respondError(s *discordgo.Session, i *discordgo.InteractionCreate, message string) {
+	respondOrFallback(s, i, withCorrelationID(message, interactionCorrelationID(i)))
+}
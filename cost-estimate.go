@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ModelPricing is a model's price in USD per million input and output tokens
+type ModelPricing struct {
+	InputPerMillion  float64 `toml:"input_per_million" yaml:"input_per_million"`
+	OutputPerMillion float64 `toml:"output_per_million" yaml:"output_per_million"`
+}
+
+// Rough heuristics for estimates: ~4 characters per token and a typical reply length
+const (
+	charsPerToken         = 4
+	estimatedOutputTokens = 1000
+)
+
+// Button ID prefixes for confirming a prompt above cost_confirm_threshold, followed by ":<nonce>"
+const (
+	promptCostConfirmID = "prompt_cost:confirm"
+	promptCostCancelID  = "prompt_cost:cancel"
+)
+
+// How long a held prompt waits for confirmation before it is dropped
+const pendingPromptTTL = 15 * time.Minute
+
+// pendingPrompt is a prompt held back until its author confirms the cost
+type pendingPrompt struct {
+	threadID string
+	authorID string
+	content  string
+	images   []promptImage
+}
+
+// Prompts awaiting cost confirmation, keyed by the nonce in their buttons' custom IDs
+var pendingPrompts = make(map[string]pendingPrompt)
+var pendingPromptsMutex sync.Mutex
+
+// pricingFor returns the configured pricing of a model, keyed as "provider_id/model_id"
+func pricingFor(model Model) (ModelPricing, bool) {
+	pricing, ok := AppConfig.Pricing[model.ProviderID+"/"+model.ModelID]
+	return pricing, ok
+}
+
+// estimateTokens approximates the token count of text
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// estimatePromptCost returns the rough USD cost of sending prompt, assuming a typical reply length.
+// Conversation history already in the session is not counted.
+func estimatePromptCost(prompt string, pricing ModelPricing) float64 {
+	input := float64(estimateTokens(prompt)) * pricing.InputPerMillion / 1_000_000
+	output := float64(estimatedOutputTokens) * pricing.OutputPerMillion / 1_000_000
+	return input + output
+}
+
+// formatCostEstimate describes an estimate for a prompt sent to model
+func formatCostEstimate(prompt string, model Model, pricing ModelPricing) string {
+	return fmt.Sprintf("Estimated cost for %s/%s: ~$%.4f (~%d input tokens, assuming ~%d output tokens; session history not included).",
+		model.ProviderID, model.ModelID, estimatePromptCost(prompt, pricing), estimateTokens(prompt), estimatedOutputTokens)
+}
+
+// holdExpensivePrompt asks for confirmation when a prompt's estimate exceeds cost_confirm_threshold.
+// It returns true when the prompt was held back and must not be sent yet.
+func holdExpensivePrompt(s *discordgo.Session, threadID, authorID, content string, images []promptImage, model Model) bool {
+	if AppConfig.CostConfirmThreshold <= 0 {
+		return false
+	}
+	pricing, ok := pricingFor(model)
+	if !ok {
+		return false
+	}
+	cost := estimatePromptCost(content, pricing)
+	if cost <= AppConfig.CostConfirmThreshold {
+		return false
+	}
+
+	pendingPromptsMutex.Lock()
+	nonce := newCorrelationID()
+	for _, taken := pendingPrompts[nonce]; taken; _, taken = pendingPrompts[nonce] {
+		nonce = newCorrelationID()
+	}
+	pendingPrompts[nonce] = pendingPrompt{threadID: threadID, authorID: authorID, content: content, images: images}
+	pendingPromptsMutex.Unlock()
+
+	message, err := s.ChannelMessageSendComplex(threadID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("%s\nThis is above the $%.2f threshold. Send it anyway?", formatCostEstimate(content, model, pricing), AppConfig.CostConfirmThreshold),
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Send prompt",
+						Style:    discordgo.PrimaryButton,
+						CustomID: promptCostConfirmID + ":" + nonce,
+					},
+					discordgo.Button{
+						Label:    "Cancel",
+						Style:    discordgo.SecondaryButton,
+						CustomID: promptCostCancelID + ":" + nonce,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		takePendingPrompt(nonce)
+		s.ChannelMessageSend(threadID, "Failed to ask for cost confirmation, the prompt was not sent.")
+		return true
+	}
+	time.AfterFunc(pendingPromptTTL, func() {
+		expirePendingPrompt(s, nonce, message)
+	})
+	return true
+}
+
+// takePendingPrompt removes and returns the held prompt with the given nonce
+func takePendingPrompt(nonce string) (pendingPrompt, bool) {
+	pendingPromptsMutex.Lock()
+	defer pendingPromptsMutex.Unlock()
+
+	prompt, ok := pendingPrompts[nonce]
+	delete(pendingPrompts, nonce)
+	return prompt, ok
+}
+
+// claimPendingPrompt takes the held prompt with the given nonce for its author. A prompt held for
+// another user stays pending and is reported with owned false.
+func claimPendingPrompt(nonce, userID string) (prompt pendingPrompt, found, owned bool) {
+	pendingPromptsMutex.Lock()
+	defer pendingPromptsMutex.Unlock()
+
+	prompt, found = pendingPrompts[nonce]
+	if !found || prompt.authorID != userID {
+		return prompt, found, false
+	}
+	delete(pendingPrompts, nonce)
+	return prompt, true, true
+}
+
+// expirePendingPrompt drops a prompt that was not confirmed within pendingPromptTTL and removes its buttons
+func expirePendingPrompt(s *discordgo.Session, nonce string, message *discordgo.Message) {
+	prompt, ok := takePendingPrompt(nonce)
+	if !ok {
+		return
+	}
+	content := "Prompt expired without confirmation and was not sent."
+	components := []discordgo.MessageComponent{}
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         message.ID,
+		Channel:    message.ChannelID,
+		Content:    &content,
+		Components: &components,
+	})
+	if err != nil {
+		slog.Warn("failed to mark held prompt as expired", "thread_id", prompt.threadID, "error", err)
+	}
+	slog.Debug("held prompt expired", "thread_id", prompt.threadID)
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -35,11 +37,18 @@ func RunDiscordBot(ctx context.Context, wg *sync.WaitGroup) {
 	}
 	discord = discordSession
 
-	discord.AddHandler(InteractionHandlers)
+	// In HTTP mode slash commands arrive on the interactions endpoint; the gateway
+	// is still used for mention-based chat
+	if AppConfig.InteractionMode != InteractionModeHTTP {
+		discord.AddHandler(InteractionHandlers)
+	}
 	discord.AddHandler(MessageHandler)
+	discord.AddHandler(MessageEditHandler)
+	discord.AddHandler(handleGatewayReady)
+	discord.AddHandler(handleGatewayResumed)
+	discord.AddHandler(handleGatewayDisconnect)
 
-	// We need both message events and application commands
-	discord.Identify.Intents = discordgo.IntentsGuildMessages
+	discord.Identify.Intents = gatewayIntents()
 
 	// Open a websocket connection to Discord and begin listening.
 	err = discord.Open()
@@ -57,21 +66,140 @@ func RunDiscordBot(ctx context.Context, wg *sync.WaitGroup) {
 		return
 	}
 
+	if AppConfig.InteractionMode == InteractionModeHTTP {
+		server, err := startInteractionsServer(discord)
+		if err != nil {
+			slog.Error("error starting interactions endpoint", "error", err)
+			return
+		}
+		defer stopInteractionsServer(server)
+	}
+
+	// Tell threads whose task was interrupted by the previous shutdown
+	go announceResumedSessions()
+	go runStuckSessionWatchdog(ctx)
+	go runPresenceUpdater(ctx, discord)
+
 	// wait for ctx to be canceled
 	<-ctx.Done()
 
+	// Let running sessions know before their listeners go away
+	notifyInterruptedSessions()
+
 	// Stop all active listeners before closing discord
 	stopAllActiveListeners()
 	discord.Close()
 	slog.Info("discord bot stopped")
 }
 
+// Upper bound on the time spent posting interruption notices during shutdown
+const shutdownNoticeTimeout = 5 * time.Second
+
+// notifyInterruptedSessions posts a restart notice to every thread with an active listener and
+// marks its session as interrupted so the next startup can pick it up
+func notifyInterruptedSessions() {
+	listenersMutex.RLock()
+	threadIDs := make([]string, 0, len(activeListeners))
+	for threadID := range activeListeners {
+		threadIDs = append(threadIDs, threadID)
+	}
+	listenersMutex.RUnlock()
+	if len(threadIDs) == 0 {
+		return
+	}
+	slog.Info("notifying interrupted sessions", "count", len(threadIDs))
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, threadID := range threadIDs {
+			wg.Add(1)
+			go func(threadID string) {
+				defer wg.Done()
+				markSessionInterrupted(threadID)
+				sendToDiscord(threadID, "⚠️ Bot restarting, the current task was interrupted. Your session will resume once the bot is back; send a message to continue.")
+			}(threadID)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownNoticeTimeout):
+		slog.Warn("timed out notifying interrupted sessions")
+	}
+}
+
+// markSessionInterrupted persists WasStreaming for a thread's session
+func markSessionInterrupted(threadID string) {
+	sessionMutex.Lock()
+	sessionData, exists := sessionCache[threadID]
+	if exists {
+		sessionData.WasStreaming = true
+	}
+	sessionMutex.Unlock()
+	if !exists {
+		return
+	}
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save interrupted session", "thread_id", threadID, "error", err)
+	}
+}
+
+// announceResumedSessions loads sessions interrupted by the previous shutdown and tells their threads
+func announceResumedSessions() {
+	sessions, err := listStoredSessions()
+	if err != nil {
+		slog.Error("failed to list sessions for resume", "error", err)
+		return
+	}
+	for _, stored := range sessions {
+		sessionMutex.RLock()
+		wasStreaming := stored.WasStreaming
+		sessionMutex.RUnlock()
+		if !wasStreaming {
+			continue
+		}
+		sessionData := lazyLoadSession(stored.ThreadID)
+		if sessionData == nil {
+			continue
+		}
+		sessionMutex.Lock()
+		sessionData.WasStreaming = false
+		sessionMutex.Unlock()
+		if err := saveSessionData(sessionData); err != nil {
+			slog.Error("failed to clear interrupted flag", "thread_id", stored.ThreadID, "error", err)
+		}
+		slog.Info("resumed interrupted session", "thread_id", stored.ThreadID)
+		sendToDiscord(stored.ThreadID, "✅ Bot is back. The previous task was interrupted; send a message to continue where you left off.")
+	}
+}
+
 func registerCommands(s *discordgo.Session) error {
-	repositoryList, err := repositoryList()
+	commands, err := buildCommands()
 	if err != nil {
 		return err
 	}
 
+	for _, command := range commands {
+		_, err := s.ApplicationCommandCreate(s.State.User.ID, "", command)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("slash commands registered successfully")
+	return nil
+}
+
+// buildCommands returns the slash commands to register for the current config
+func buildCommands() ([]*discordgo.ApplicationCommand, error) {
+	repositoryList, err := repositoryList()
+	if err != nil {
+		return nil, err
+	}
+
 	// choices
 	var repositoryChoices []*discordgo.ApplicationCommandOptionChoice
 	var modelChoices []*discordgo.ApplicationCommandOptionChoice
@@ -96,10 +224,412 @@ func registerCommands(s *discordgo.Session) error {
 		{
 			Name:        "commit",
 			Description: "Generate commit message push changes",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "include_untracked",
+					Description: "Include new untracked files in the commit",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "confirm",
+					Description: "Confirm committing an old session",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "force",
+					Description: "Commit even if protected paths or disallowed file types were changed",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "template",
+					Description: "Message template with {summary} for the generated message and \\n for line breaks",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "show_diff",
+					Description: "Post the committed diff after a successful commit",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
 		},
 		{
 			Name:        "diff",
 			Description: "Show diff of changes in current worktree",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "staged",
+					Description: "Show only staged changes (git diff --cached)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "base",
+					Description: "Show committed changes against the base branch",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "filediff",
+			Description: "Show the diff of a single file in the current worktree",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "path",
+					Description: "File path relative to the repository root",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        "staged",
+					Description: "Show only staged changes (git diff --cached)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "review",
+			Description: "Show staged, unstaged and committed-vs-base diffs of this session together",
+		},
+		{
+			Name:        "status",
+			Description: "Show the status of the session in this thread",
+		},
+		{
+			Name:        "prdescription",
+			Description: "Generate a pull request title and description for the session branch",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "target",
+					Description: "Branch the PR would merge into (defaults to the repository's base branch)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "logs",
+			Description: "Show the captured bot logs for the session in this thread",
+		},
+		{
+			Name:        "keep",
+			Description: "Keep this thread open after tasks complete, overriding on_complete",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "enabled",
+					Description: "Set to false to follow on_complete again (default true)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "retrypush",
+			Description: "Push the latest commit again after a failed push",
+		},
+		{
+			Name:        "amend",
+			Description: "Rewrite the message of the session's last commit",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "message",
+					Description: "New commit message (\\n for line breaks); generated again when empty",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "force",
+					Description: "Amend even if the commit was pushed, then force-push the branch",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "transfer",
+			Description: "Hand this session over to another user (owner or admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "user",
+					Description: "New session owner",
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "autorespond",
+			Description: "Show or set whether every message in this thread is sent to the model",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "enabled",
+					Description: "On to respond without a mention, off to require one",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "queue",
+			Description: "List the prompts queued behind the running one, or clear them",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "clear",
+					Description: "Drop every queued prompt (session owner only)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "fork",
+			Description: "Fork the session into a new named worktree and switch to it",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "Name of the new worktree (lowercase letters, digits and dashes)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "worktree",
+			Description: "List the session's worktrees, or switch to or remove one",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "Worktree to switch to; omit to list worktrees",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "remove",
+					Description: "Remove the named worktree and its branch instead of switching",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "modelinfo",
+			Description: "Show this session's model and whether the OpenCode server offers it",
+		},
+		{
+			Name:        "estimate",
+			Description: "Estimate the cost of sending a prompt to this session's model",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "prompt",
+					Description: "Prompt to estimate",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "reloadsession",
+			Description: "Reload this thread's session from its file on disk (admin only)",
+		},
+		{
+			Name:        "disk",
+			Description: "Show the disk usage of worktrees and sessions, or prune orphans (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "action",
+					Description: "What to do (defaults to report)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "report", Value: "report"},
+						{Name: "prune-orphans", Value: "prune-orphans"},
+					},
+				},
+			},
+		},
+		{
+			Name:        "loglevel",
+			Description: "Show or change the bot's log level until the next restart (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "level",
+					Description: "New log level",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "debug", Value: "debug"},
+						{Name: "info", Value: "info"},
+						{Name: "warn", Value: "warn"},
+						{Name: "error", Value: "error"},
+					},
+				},
+			},
+		},
+		{
+			Name:        "costreport",
+			Description: "Summarize model cost per user, repository and model (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "days",
+					Description: "How many days back to include (default 30)",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "shipped",
+			Description: "Summarize recently pushed commits across all sessions (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "days",
+					Description: "How many days back to include (default 7)",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "dumpsession",
+			Description: "Show a thread's stored session JSON with secrets redacted (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "thread",
+					Description: "Session thread (defaults to this one)",
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "cleanuprepo",
+			Description: "Remove every session and worktree of a repository (admin only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "repository",
+					Description: "Repository name as stored in the sessions",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "pause",
+			Description: "Stop live updates in this thread while the task keeps running",
+		},
+		{
+			Name:        "unpause",
+			Description: "Resume live updates and catch up on what happened while paused",
+		},
+		{
+			Name:        "exportmd",
+			Description: "Export the session's conversation, tool usage and commits as a Markdown file",
+		},
+		{
+			Name:        "setbase",
+			Description: "Show or set the branch this session targets for compare and pull requests",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "branch",
+					Description: "Base branch, e.g. release or origin/release",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "reset",
+					Description: "Go back to the remote's default branch",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "compare",
+			Description: "Compare the session branch against another branch",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "target",
+					Description: "Branch to compare against (defaults to the repository's base branch)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "drift",
+			Description: "Show how far the base branch moved since the session forked",
+		},
+		{
+			Name:        "agent",
+			Description: "Show or switch the OpenCode agent for this session",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "Agent to switch to (e.g. build, plan)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "last",
+			Description: "Jump back into your most recently active session",
+		},
+		{
+			Name:        "list",
+			Description: "List the repositories and models available for sessions",
+		},
+		{
+			Name:        "approve",
+			Description: "Approve the plan of a plan-first session and let the model execute it",
+		},
+		{
+			Name:        "reject",
+			Description: "Discard the plan of a plan-first session",
+		},
+		{
+			Name:        "autocommit",
+			Description: "Show or set the checkpoint auto-commit interval for this session",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "interval",
+					Description: "Inactivity before a checkpoint commit (e.g. 30m), or off",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "context",
+			Description: "Show the repository, worktree and branch of this session",
+		},
+		{
+			Name:        "remote",
+			Description: "Show the git remotes, current branch and its upstream tracking",
+		},
+		{
+			Name:        "gitconfig",
+			Description: "Show or set worktree git config (allow-listed keys only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "key",
+					Description: "Config key (e.g. user.email)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "value",
+					Description: "New value; omit to show the current value",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+			},
 		},
 		{
 			Name:        "codesession",
@@ -120,18 +650,173 @@ func registerCommands(s *discordgo.Session) error {
 					Required:    true,
 					Choices:     modelChoices,
 				},
+				{
+					Name:        "agent",
+					Description: "OpenCode agent to use (defaults to the server default)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "from",
+					Description: "Commit, tag or branch to start the session from (defaults to the repository's current branch)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "review",
+					Description: "Read-only Q&A session: the model cannot modify files",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "auto_respond",
+					Description: "Send every message in the thread to the model without needing a mention",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "plan",
+					Description: "Have the model post a plan to /approve before it changes any files",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
 			},
 		},
 	}
 
+	// Discord rejects choice options without choices, so /codesession needs at least one repository and model
+	if len(repositoryChoices) == 0 || len(modelChoices) == 0 {
+		slog.Warn("no repositories or models configured, /codesession will not be registered", "repositories", len(repositoryChoices), "models", len(modelChoices))
+		commands = slices.DeleteFunc(commands, func(command *discordgo.ApplicationCommand) bool {
+			return command.Name == "codesession"
+		})
+	}
+
+	// Scratch sessions and model switching only need a model
+	if len(modelChoices) > 0 {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        "scratch",
+			Description: "Start a Q&A session without a repository",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "model",
+					Description: "Select model",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    true,
+					Choices:     modelChoices,
+				},
+				{
+					Name:        "agent",
+					Description: "OpenCode agent to use (defaults to the server default)",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "auto_respond",
+					Description: "Send every message in the thread to the model without needing a mention",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		})
+
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        "model",
+			Description: "Show or switch the model for this session",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "model",
+					Description: "Model to switch to",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    false,
+					Choices:     modelChoices,
+				},
+			},
+		})
+	}
+
+	// Comparison runs every prompt on several models, so it is only offered when enabled
+	if AppConfig.EnableModelComparison && len(modelChoices) == 0 {
+		slog.Warn("no models configured, /comparemodels will not be registered")
+	} else if AppConfig.EnableModelComparison {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        "comparemodels",
+			Description: "Send the same prompt to two models and post both answers",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "prompt",
+					Description: "Prompt to send to both models",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        "model_a",
+					Description: "First model",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    true,
+					Choices:     modelChoices,
+				},
+				{
+					Name:        "model_b",
+					Description: "Second model",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    true,
+					Choices:     modelChoices,
+				},
+			},
+		})
+	}
+
+	if err := applyCommandPermissions(commands, AppConfig.CommandPermissions); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// commandPermissionFlags maps the permission names accepted in command_permissions to Discord permission bits
+var commandPermissionFlags = map[string]int64{
+	"administrator":            discordgo.PermissionAdministrator,
+	"manage_guild":             discordgo.PermissionManageGuild,
+	"manage_channels":          discordgo.PermissionManageChannels,
+	"manage_roles":             discordgo.PermissionManageRoles,
+	"manage_threads":           discordgo.PermissionManageThreads,
+	"manage_messages":          discordgo.PermissionManageMessages,
+	"moderate_members":         discordgo.PermissionModerateMembers,
+	"create_public_threads":    discordgo.PermissionCreatePublicThreads,
+	"send_messages":            discordgo.PermissionSendMessages,
+	"send_messages_in_threads": discordgo.PermissionSendMessagesInThreads,
+}
+
+// applyCommandPermissions sets DefaultMemberPermissions on each command listed in permissions.
+// Members need all listed permissions to see and use the command; server admins can refine
+// access per role or channel under Server Settings > Integrations, which bots cannot set themselves.
+func applyCommandPermissions(commands []*discordgo.ApplicationCommand, permissions map[string][]string) error {
+	known := make(map[string]bool, len(commands))
 	for _, command := range commands {
-		_, err := s.ApplicationCommandCreate(s.State.User.ID, "", command)
-		if err != nil {
-			return err
+		known[command.Name] = true
+	}
+	for name := range permissions {
+		if !known[name] {
+			return fmt.Errorf("command_permissions: unknown command %q", name)
 		}
 	}
 
-	slog.Info("slash commands registered successfully")
+	for _, command := range commands {
+		names, exists := permissions[command.Name]
+		if !exists {
+			continue
+		}
+		var bits int64
+		for _, permission := range names {
+			flag, ok := commandPermissionFlags[strings.ToLower(permission)]
+			if !ok {
+				return fmt.Errorf("command_permissions: unknown permission %q for command %q", permission, command.Name)
+			}
+			bits |= flag
+		}
+		command.DefaultMemberPermissions = &bits
+		slog.Debug("restricted command", "command", command.Name, "permissions", names)
+	}
 	return nil
 }
 
@@ -192,20 +877,44 @@ func SendDiscordDiffMessage(threadID string, diffOutput string) {
 	}
 }
 
+// Diffs longer than this are uploaded as a file instead of being chunked into messages
+const diffAttachmentThreshold = 8000
+
+// Diffs are capped at this size before uploading
+const maxDiffAttachmentSize = 1 << 20
+
+// SendDiscordDiff posts a diff as chunked code blocks, or as a file attachment when it is large
+func SendDiscordDiff(threadID, filename, diffOutput string) {
+	if len(diffOutput) <= diffAttachmentThreshold {
+		SendDiscordDiffMessage(threadID, diffOutput)
+		return
+	}
+
+	content := fmt.Sprintf("Diff is %d characters, attached as a file.", len(diffOutput))
+	if len(diffOutput) > maxDiffAttachmentSize {
+		diffOutput = truncateText(diffOutput, maxDiffAttachmentSize, "") + "\n... (truncated)"
+		content = fmt.Sprintf("Diff is too large, attached the first %d bytes.", maxDiffAttachmentSize)
+	}
+
+	_, err := discord.ChannelMessageSendComplex(threadID, &discordgo.MessageSend{
+		Content: content,
+		Files: []*discordgo.File{
+			{
+				Name:        filename,
+				ContentType: "text/x-diff",
+				Reader:      strings.NewReader(diffOutput),
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("failed to send diff attachment to discord", "thread_id", threadID, "error", err)
+		return
+	}
+	slog.Debug("sent diff attachment to discord", "thread_id", threadID, "diff_length", len(diffOutput))
+}
+
 func SendDiscordMessage(threadID string, message string) {
-	remaining := message
-	for len(remaining) > 0 {
-		chunk := remaining
-		if len(chunk) > messageLimit {
-			split := strings.LastIndex(chunk[:messageLimit], "\n")
-			if split <= 0 {
-				split = messageLimit
-			}
-			chunk = remaining[:split]
-			remaining = strings.TrimPrefix(remaining[split:], "\n")
-		} else {
-			remaining = ""
-		}
+	for _, chunk := range splitMessage(message, messageLimit) {
 		if _, err := discord.ChannelMessageSend(threadID, chunk); err != nil {
 			slog.Error("failed to send message to discord", "thread_id", threadID, "error", err)
 			break
@@ -248,11 +957,11 @@ func updateToolStatus(threadID, toolUpdate string) {
 	}
 
 	// Format as blockquote and append to tool status history
-	formattedUpdate := formatBlockquote(toolUpdate)
+	formattedUpdate := formatBlockquote(stripANSI(toolUpdate))
 	sessionData.ToolStatusHistory = appendToContentHistory(sessionData.ToolStatusHistory, formattedUpdate)
 
-	// Rebuild and update the complete message
-	rebuildStatusMessage(threadID, sessionData)
+	// Rebuild and update the complete message, coalesced with other updates
+	scheduleStatusFlush(threadID, sessionData)
 }
 
 // updateTextResponse replaces the current response content
@@ -269,90 +978,220 @@ func updateTextResponse(threadID, textResponse string) {
 	// Replace the current response content (not append, replace for new responses)
 	sessionData.CurrentResponse = textResponse
 
-	// Rebuild and update the complete message
-	rebuildStatusMessage(threadID, sessionData)
+	// Rebuild and update the complete message, coalesced with other updates
+	scheduleStatusFlush(threadID, sessionData)
+}
+
+// Status message headers; the first page header is replaced once the turn reaches a terminal state
+const (
+	statusHeaderWorking   = "```fix\n✨codesession is working...\n```"
+	statusHeaderContinued = "```fix\n✨codesession is working (continued...)\n```"
+)
+
+// Terminal outcomes of a turn shown in the status message header
+const (
+	statusOutcomeCompleted   = "✅ codesession finished"
+	statusOutcomeFailed      = "❌ codesession stopped with an error"
+	statusOutcomeInterrupted = "⚠️ codesession was interrupted"
+)
+
+// finalizeStatusMessage replaces the "working" header of the turn's status messages with the
+// outcome so no message is left claiming work is in progress
+func finalizeStatusMessage(threadID, outcome string) {
+	// Render any coalesced update first so the final state is what gets finalized
+	flushStatus(threadID)
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	sessionData, exists := sessionCache[threadID]
+	if !exists {
+		return
+	}
+	finalHeader := "```fix\n" + outcome + "\n```"
+	for idx, messageID := range sessionData.StatusMessageIDs {
+		if idx >= len(sessionData.StatusMessageContents) {
+			break
+		}
+		content := sessionData.StatusMessageContents[idx]
+		var finalized string
+		switch {
+		case strings.HasPrefix(content, statusHeaderWorking):
+			finalized = finalHeader + strings.TrimPrefix(content, statusHeaderWorking)
+		case strings.HasPrefix(content, statusHeaderContinued):
+			// continuation pages just drop the header
+			finalized = strings.TrimPrefix(strings.TrimPrefix(content, statusHeaderContinued), "\n")
+		default:
+			continue
+		}
+		if finalized == "" {
+			finalized = finalHeader
+		}
+		if err := editDiscordMessage(threadID, messageID, finalized); err != nil {
+			slog.Error("failed to finalize status message", "thread_id", threadID, "message_id", messageID, "error", err)
+			continue
+		}
+		sessionData.StatusMessageContents[idx] = finalized
+	}
+	if last := len(sessionData.StatusMessageContents) - 1; last >= 0 {
+		sessionData.StatusMessageContent = sessionData.StatusMessageContents[last]
+	}
 }
 
-// rebuildStatusMessage combines content history and updates Discord message
+// rebuildStatusMessage renders the status content across an ordered list of messages,
+// filling each one to capacity and only creating a new message once the last is full
 func rebuildStatusMessage(threadID string, sessionData *SessionData) {
 	const maxMessageLength = 1800 // Leave buffer before Discord's 2000 limit
 
-	// Build the complete message content
-	header := "```fix\n✨codesession is working...\n```"
+	header := statusHeaderWorking
+	continueHeader := statusHeaderContinued
 	var parts []string
 
+	// Fit both parts into their character budgets, trimming the less important one first
+	toolHistory, response := allocateStatusBudget(sessionData.ToolStatusHistory, sessionData.CurrentResponse, configuredStatusBudget())
+
 	// Add tool status history if present
-	if sessionData.ToolStatusHistory != "" {
-		parts = append(parts, sessionData.ToolStatusHistory)
+	if toolHistory != "" {
+		parts = append(parts, toolHistory)
 	}
 
 	// Add current response if present
-	if sessionData.CurrentResponse != "" {
-		parts = append(parts, sessionData.CurrentResponse)
+	if response != "" {
+		parts = append(parts, response)
 	}
 
-	// Combine all parts
-	newContent := header
-	if len(parts) > 0 {
-		newContent += "\n" + strings.Join(parts, "\n")
+	// Split greedily from the start so earlier pages stay stable as content grows
+	pages := splitMessage(strings.Join(parts, "\n"), maxMessageLength-len(continueHeader)-1)
+	if len(pages) == 0 {
+		pages = []string{""}
+	}
+	// Trimming to the status budgets can leave fewer pages than before; blank the ones left over
+	for len(pages) < len(sessionData.StatusMessageIDs) {
+		pages = append(pages, "")
 	}
 
-	// Check if we need to create a continuation message
-	if len(newContent) > maxMessageLength {
-		// Mark current message as continued
-		if sessionData.LastStatusMessageID != "" {
-			continuedContent := sessionData.StatusMessageContent + "\n...continued below..."
-			editDiscordMessage(threadID, sessionData.LastStatusMessageID, continuedContent)
+	for idx, page := range pages {
+		pageHeader := header
+		if idx > 0 {
+			pageHeader = continueHeader
+		}
+		content := pageHeader
+		if page != "" {
+			content += "\n" + page
 		}
 
-		// Calculate how much content we can fit in continuation message
-		continueHeader := "```fix\n✨codesession is working (continued...)\n```\n"
-		maxContentForContinuation := maxMessageLength - len(continueHeader)
-
-		// Combine parts and truncate if needed
-		combinedContent := strings.Join(parts, "\n")
-		truncatedContent := combinedContent
-		if len(truncatedContent) > maxContentForContinuation {
-			truncatedContent = truncatedContent[len(truncatedContent)-maxContentForContinuation:]
-			// Try to start from a newline to avoid cutting mid-line
-			if newlineIndex := strings.Index(truncatedContent, "\n"); newlineIndex != -1 {
-				truncatedContent = truncatedContent[newlineIndex+1:]
+		// Edit an existing page only when its content changed
+		if idx < len(sessionData.StatusMessageIDs) {
+			if idx < len(sessionData.StatusMessageContents) && sessionData.StatusMessageContents[idx] == content {
+				continue
 			}
+			if err := editDiscordMessage(threadID, sessionData.StatusMessageIDs[idx], content); err != nil {
+				slog.Error("failed to update status message", "thread_id", threadID, "page", idx, "error", err)
+				continue
+			}
+			for len(sessionData.StatusMessageContents) <= idx {
+				sessionData.StatusMessageContents = append(sessionData.StatusMessageContents, "")
+			}
+			sessionData.StatusMessageContents[idx] = content
+			continue
 		}
 
-		// Create new continuation message
-		newStatusContent := continueHeader + truncatedContent
-		msg, err := discord.ChannelMessageSend(threadID, newStatusContent)
+		msg, err := discord.ChannelMessageSend(threadID, content)
 		if err != nil {
-			slog.Error("failed to create continuation status message", "thread_id", threadID, "error", err)
+			slog.Error("failed to create status message", "thread_id", threadID, "page", idx, "error", err)
 			return
 		}
+		sessionData.StatusMessageIDs = append(sessionData.StatusMessageIDs, msg.ID)
+		sessionData.StatusMessageContents = append(sessionData.StatusMessageContents, content)
+		scheduleSessionFlush(threadID)
+		slog.Debug("created status message", "thread_id", threadID, "message_id", msg.ID, "page", idx)
+	}
+
+	last := len(sessionData.StatusMessageIDs) - 1
+	if last >= 0 {
+		sessionData.LastStatusMessageID = sessionData.StatusMessageIDs[last]
+		sessionData.StatusMessageContent = sessionData.StatusMessageContents[last]
+	}
+}
 
-		sessionData.LastStatusMessageID = msg.ID
-		sessionData.StatusMessageContent = newStatusContent
-		slog.Debug("created continuation status message", "thread_id", threadID, "message_id", msg.ID)
+// cleanupStatusMessages deletes all status messages of the current turn and reposts only the final
+// response, unless final_response is about to post it as a result embed
+func cleanupStatusMessages(threadID string) {
+	cancelStatusFlush(threadID)
+
+	sessionMutex.Lock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists {
+		sessionMutex.Unlock()
+		slog.Error("session not found for status cleanup", "thread_id", threadID)
 		return
 	}
+	messageIDs := sessionData.StatusMessageIDs
+	response := strings.TrimPrefix(sessionData.CurrentResponse, "Response:\n")
+	sessionData.StatusMessageIDs = nil
+	sessionData.StatusMessageContents = nil
+	sessionData.LastStatusMessageID = ""
+	sessionData.StatusMessageContent = ""
+	sessionMutex.Unlock()
 
-	// Update existing message or create new one
-	if sessionData.LastStatusMessageID == "" {
-		// Create initial status message
-		msg, err := discord.ChannelMessageSend(threadID, newContent)
-		if err != nil {
-			slog.Error("failed to create initial status message", "thread_id", threadID, "error", err)
-			return
+	for _, messageID := range messageIDs {
+		if err := discord.ChannelMessageDelete(threadID, messageID); err != nil {
+			slog.Error("failed to delete status message", "thread_id", threadID, "message_id", messageID, "error", err)
 		}
-		sessionData.LastStatusMessageID = msg.ID
-		sessionData.StatusMessageContent = newContent
-		slog.Debug("created initial status message", "thread_id", threadID, "message_id", msg.ID)
-	} else {
-		// Edit existing message
-		sessionData.StatusMessageContent = newContent
-		err := editDiscordMessage(threadID, sessionData.LastStatusMessageID, newContent)
-		if err != nil {
-			slog.Error("failed to update status message", "thread_id", threadID, "error", err)
+	}
+	slog.Debug("deleted status messages", "thread_id", threadID, "count", len(messageIDs))
+
+	if response != "" && AppConfig.FinalResponse == FinalResponseNone {
+		sendModelResponse(threadID, response)
+	}
+}
+
+// Embed sidebar colors
+const (
+	embedColorSuccess = 0x2ecc71
+	embedColorFailure = 0xe74c3c
+)
+
+// buildCommitEmbed renders a commit record as an embed, green for success and red otherwise
+func buildCommitEmbed(record *CommitRecord, filesChanged int, commitURL string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     "Commit & Push Successful",
+		Color:     embedColorSuccess,
+		Timestamp: record.Timestamp.Format(time.RFC3339),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Git hooks are skipped (if any)",
+		},
+	}
+	if record.Status != "success" {
+		embed.Title = "Commit Failed"
+		if record.Hash != "" {
+			embed.Title = "Push Failed"
+		}
+		embed.Color = embedColorFailure
+	}
+
+	summary := record.Summary
+	summary = truncateText(summary, 1024, "...")
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Summary", Value: summary})
+
+	if record.Hash != "" {
+		shortHash := record.Hash
+		if len(shortHash) > 7 {
+			shortHash = shortHash[:7]
 		}
+		hashValue := fmt.Sprintf("`%s`", shortHash)
+		if commitURL != "" {
+			hashValue = fmt.Sprintf("[`%s`](%s)", shortHash, commitURL)
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Hash", Value: hashValue, Inline: true})
+	}
+	if record.Branch != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Branch", Value: record.Branch, Inline: true})
+	}
+	if filesChanged > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Files Changed", Value: fmt.Sprintf("%d", filesChanged), Inline: true})
 	}
+	return embed
 }
 
 // sendToDiscord sends a message to the Discord channel
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordRequest is one REST call captured by fakeDiscord
+type discordRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// fakeDiscord serves the Discord REST API from an httptest server and records every call
+type fakeDiscord struct {
+	mu       sync.Mutex
+	requests []discordRequest
+	nextID   int
+	// respond, when set, may answer a request itself; returning false falls through to the default reply
+	respond func(w http.ResponseWriter, r *http.Request, body string) bool
+}
+
+// redirectTransport sends every request to the test server, keeping the Discord API path
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	r.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// useFakeDiscord installs a fake Discord session as the global client for the test's duration
+func useFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+	fake := &fakeDiscord{}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := discordgo.New("Bot test-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Client = &http.Client{Transport: redirectTransport{target: target}}
+	session.MaxRestRetries = 0
+	session.State.User = &discordgo.User{ID: "bot-user"}
+
+	previous := discord
+	discord = session
+	t.Cleanup(func() { discord = previous })
+	return fake
+}
+
+func (f *fakeDiscord) serve(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	body := string(data)
+	f.mu.Lock()
+	f.requests = append(f.requests, discordRequest{Method: r.Method, Path: strings.TrimPrefix(r.URL.Path, "/api/v9"), Body: body})
+	f.nextID++
+	id := f.nextID
+	respond := f.respond
+	f.mu.Unlock()
+
+	if respond != nil && respond(w, r, body) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasSuffix(r.URL.Path, "/messages") || strings.Contains(r.URL.Path, "/messages/"):
+		json.NewEncoder(w).Encode(map[string]any{"id": fmt.Sprintf("msg-%d", id), "content": ""})
+	default:
+		w.Write([]byte("{}"))
+	}
+}
+
+// calls returns the recorded requests matching method whose path contains fragment
+func (f *fakeDiscord) calls(method, fragment string) []discordRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []discordRequest
+	for _, req := range f.requests {
+		if req.Method == method && strings.Contains(req.Path, fragment) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// useTestSession registers sessionData in the session cache for the test's duration
+func useTestSession(t *testing.T, sessionData *SessionData) {
+	t.Helper()
+	sessionMutex.Lock()
+	sessionCache[sessionData.ThreadID] = sessionData
+	sessionMutex.Unlock()
+	t.Cleanup(func() {
+		sessionMutex.Lock()
+		delete(sessionCache, sessionData.ThreadID)
+		sessionMutex.Unlock()
+	})
+}
+
+// useTestConfig applies configure to AppConfig for the test's duration
+func useTestConfig(t *testing.T, configure func(*Config)) {
+	t.Helper()
+	previous := AppConfig
+	configure(&AppConfig)
+	t.Cleanup(func() { AppConfig = previous })
+}
+
+func TestCleanupStatusMessagesOnComplete(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	useTestConfig(t, func(config *Config) {
+		config.CleanupStatusOnComplete = true
+		config.FinalResponse = FinalResponseNone
+	})
+	sessionData := &SessionData{
+		ThreadID:              "cleanup-thread",
+		StatusMessageIDs:      []string{"status-1", "status-2"},
+		StatusMessageContents: []string{"tools", "Response:\nDone."},
+		LastStatusMessageID:   "status-2",
+		StatusMessageContent:  "Response:\nDone.",
+		CurrentResponse:       "Response:\nDone.",
+	}
+	useTestSession(t, sessionData)
+
+	finishTurn("cleanup-thread")
+
+	deleted := fake.calls(http.MethodDelete, "/channels/cleanup-thread/messages/")
+	if len(deleted) != 2 || !strings.HasSuffix(deleted[0].Path, "/status-1") || !strings.HasSuffix(deleted[1].Path, "/status-2") {
+		t.Errorf("deleted %v, want status-1 and status-2", deleted)
+	}
+	posted := fake.calls(http.MethodPost, "/channels/cleanup-thread/messages")
+	if len(posted) == 0 || !strings.Contains(posted[0].Body, "Done.") || strings.Contains(posted[0].Body, "Response:") {
+		t.Errorf("posted %v, want the final response without its prefix", posted)
+	}
+	if sessionData.StatusMessageIDs != nil || sessionData.LastStatusMessageID != "" || sessionData.StatusMessageContent != "" {
+		t.Errorf("status fields not cleared: %+v", sessionData)
+	}
+}
+
+func TestBuildCommitEmbed(t *testing.T) {
+	timestamp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name         string
+		record       CommitRecord
+		filesChanged int
+		commitURL    string
+		wantTitle    string
+		wantColor    int
+		wantFields   []string // name=value pairs, in order
+	}{
+		{
+			name:         "pushed with link",
+			record:       CommitRecord{Hash: "0123456789abcdef", Summary: "feat: add x", Status: "success", Branch: "thread-1"},
+			filesChanged: 3,
+			commitURL:    "https://example.com/commit/0123456789abcdef",
+			wantTitle:    "Commit & Push Successful",
+			wantColor:    embedColorSuccess,
+			wantFields:   []string{"Summary=feat: add x", "Hash=[`0123456`](https://example.com/commit/0123456789abcdef)", "Branch=thread-1", "Files Changed=3"},
+		},
+		{
+			name:       "push failed",
+			record:     CommitRecord{Hash: "abc", Summary: "fix: y", Status: "failed"},
+			wantTitle:  "Push Failed",
+			wantColor:  embedColorFailure,
+			wantFields: []string{"Summary=fix: y", "Hash=`abc`"},
+		},
+		{
+			name:       "commit failed",
+			record:     CommitRecord{Summary: "fix: z", Status: "failed"},
+			wantTitle:  "Commit Failed",
+			wantColor:  embedColorFailure,
+			wantFields: []string{"Summary=fix: z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record
+			record.Timestamp = timestamp
+			embed := buildCommitEmbed(&record, tt.filesChanged, tt.commitURL)
+			if embed.Title != tt.wantTitle || embed.Color != tt.wantColor {
+				t.Errorf("title, color = %q, %#x, want %q, %#x", embed.Title, embed.Color, tt.wantTitle, tt.wantColor)
+			}
+			if embed.Timestamp != "2026-01-02T03:04:05Z" {
+				t.Errorf("timestamp = %q", embed.Timestamp)
+			}
+			var fields []string
+			for _, field := range embed.Fields {
+				fields = append(fields, field.Name+"="+field.Value)
+			}
+			if strings.Join(fields, "\n") != strings.Join(tt.wantFields, "\n") {
+				t.Errorf("fields = %q, want %q", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestNotifyInterruptedSessions(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	threadIDs := []string{"shutdown-a", "shutdown-b", "shutdown-c"}
+	for _, threadID := range threadIDs {
+		useTestSession(t, &SessionData{ThreadID: threadID, IsStreaming: true})
+	}
+	listenersMutex.Lock()
+	for _, threadID := range threadIDs {
+		activeListeners[threadID] = &activeListener{cancel: func() {}}
+	}
+	listenersMutex.Unlock()
+	t.Cleanup(func() {
+		listenersMutex.Lock()
+		for _, threadID := range threadIDs {
+			delete(activeListeners, threadID)
+		}
+		listenersMutex.Unlock()
+	})
+
+	notifyInterruptedSessions()
+
+	for _, threadID := range threadIDs {
+		sent := fake.calls(http.MethodPost, "/channels/"+threadID+"/messages")
+		if len(sent) != 1 || !strings.Contains(sent[0].Body, "Bot restarting") {
+			t.Errorf("%s received %v, want one restart notice", threadID, sent)
+		}
+		data, err := os.ReadFile(filepath.Join(sessionsDirectory, threadID+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored SessionData
+		if err := json.Unmarshal(data, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if !stored.WasStreaming {
+			t.Errorf("%s was not saved as interrupted", threadID)
+		}
+	}
+}
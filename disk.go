@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Number of worktrees listed by size in /disk
+const largestWorktreesShown = 5
+
+// worktreeUsage is the disk footprint of one directory under the worktrees directory
+type worktreeUsage struct {
+	Name     string
+	Path     string
+	Size     int64
+	ThreadID string // Owning session; empty for orphans
+}
+
+// diskUsage summarizes the bot's worktrees and session files
+type diskUsage struct {
+	Worktrees       []worktreeUsage // Largest first
+	WorktreesSize   int64
+	SessionFiles    int
+	SessionsSize    int64
+	OrphanWorktrees []worktreeUsage
+	OrphanSessions  []string // Threads whose session file points at a worktree that no longer exists
+}
+
+// dirSize sums the sizes of the regular files below path without following symlinks
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Count what is readable
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// computeDiskUsage measures the worktrees and sessions directories and matches worktree directories
+// against the stored sessions: a directory no session refers to is an orphan, and so is a session
+// whose worktree is gone. Scratch sessions live in the temp dir and are never orphans, but scratch
+// directories in tempDir that no session refers to are.
+func computeDiskUsage(worktreesDir, sessionsDir, tempDir string, sessions []*SessionData) (diskUsage, error) {
+	var usage diskUsage
+
+	owners := make(map[string]string)
+	scratchDirs := make(map[string]bool)
+	sessionMutex.RLock()
+	for _, sessionData := range sessions {
+		if sessionData.Scratch {
+			scratchDirs[filepath.Clean(sessionData.WorktreePath)] = true
+			continue
+		}
+		owners[filepath.Clean(sessionData.WorktreePath)] = sessionData.ThreadID
+		for _, sub := range sessionData.Worktrees {
+			owners[filepath.Clean(sub.Path)] = sessionData.ThreadID
+		}
+		if _, err := os.Stat(sessionData.WorktreePath); os.IsNotExist(err) && !sessionData.Active {
+			usage.OrphanSessions = append(usage.OrphanSessions, sessionData.ThreadID)
+		}
+	}
+	sessionMutex.RUnlock()
+
+	entries, err := os.ReadDir(worktreesDir)
+	if err != nil {
+		return usage, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(worktreesDir, entry.Name())
+		worktree := worktreeUsage{Name: entry.Name(), Path: path, Size: dirSize(path), ThreadID: owners[filepath.Clean(path)]}
+		usage.Worktrees = append(usage.Worktrees, worktree)
+		usage.WorktreesSize += worktree.Size
+		if worktree.ThreadID == "" {
+			usage.OrphanWorktrees = append(usage.OrphanWorktrees, worktree)
+		}
+	}
+	slices.SortFunc(usage.Worktrees, func(a, b worktreeUsage) int {
+		return cmp.Compare(b.Size, a.Size)
+	})
+
+	orphanScratch, err := orphanScratchDirs(tempDir, scratchDirs)
+	if err != nil {
+		slog.Warn("failed to look for orphaned scratch directories", "temp_dir", tempDir, "error", err)
+	}
+	usage.OrphanWorktrees = append(usage.OrphanWorktrees, orphanScratch...)
+
+	sessionEntries, err := os.ReadDir(sessionsDir)
+	if err != nil {
+		return usage, err
+	}
+	for _, entry := range sessionEntries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		usage.SessionFiles++
+		if info, err := entry.Info(); err == nil {
+			usage.SessionsSize += info.Size()
+		}
+	}
+	return usage, nil
+}
+
+// formatByteSize renders a size with a binary unit, e.g. "1.5 GiB"
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDiskUsage renders the /disk report
+func formatDiskUsage(usage diskUsage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Worktrees:** %d (%s)\n", len(usage.Worktrees), formatByteSize(usage.WorktreesSize))
+	fmt.Fprintf(&b, "**Sessions:** %d files (%s)\n", usage.SessionFiles, formatByteSize(usage.SessionsSize))
+
+	if len(usage.Worktrees) > 0 {
+		b.WriteString("\n**Largest worktrees**\n")
+		for _, worktree := range usage.Worktrees[:min(len(usage.Worktrees), largestWorktreesShown)] {
+			owner := "orphan"
+			if worktree.ThreadID != "" {
+				owner = fmt.Sprintf("<#%s>", worktree.ThreadID)
+			}
+			fmt.Fprintf(&b, "- `%s` %s (%s)\n", worktree.Name, formatByteSize(worktree.Size), owner)
+		}
+	}
+
+	if len(usage.OrphanWorktrees) == 0 && len(usage.OrphanSessions) == 0 {
+		b.WriteString("\nNo orphaned worktrees or sessions.")
+		return b.String()
+	}
+	var orphanSize int64
+	for _, worktree := range usage.OrphanWorktrees {
+		orphanSize += worktree.Size
+	}
+	fmt.Fprintf(&b, "\n%d orphaned worktree(s) (%s) and %d session(s) without a worktree. Remove them with `/disk action:prune-orphans`.",
+		len(usage.OrphanWorktrees), formatByteSize(orphanSize), len(usage.OrphanSessions))
+	return b.String()
+}
+
+// pruneOrphans removes orphaned worktrees and the session files of sessions whose worktree is gone.
+// Git worktrees are removed through git so their repository forgets them; other directories are deleted.
+func pruneOrphans(usage diskUsage) repoCleanupSummary {
+	var summary repoCleanupSummary
+	for _, worktree := range usage.OrphanWorktrees {
+		var err error
+		if isScratchDir(worktree.Path) {
+			err = removeScratchDir(worktree.Path)
+		} else if _, statErr := os.Stat(filepath.Join(worktree.Path, ".git")); statErr == nil {
+			var repoPath string
+			repoPath, err = gitOps.GetRepositoryRoot(worktree.Path)
+			if err == nil {
+				err = gitOps.RemoveWorktree(repoPath, worktree.Path)
+			}
+		} else {
+			err = os.RemoveAll(worktree.Path)
+		}
+		if err != nil {
+			slog.Warn("failed to prune orphaned worktree", "worktree_path", worktree.Path, "error", err)
+			summary.Failures = append(summary.Failures, fmt.Sprintf("%s: %v", worktree.Name, err))
+			continue
+		}
+		summary.Worktrees++
+	}
+	for _, threadID := range usage.OrphanSessions {
+		if err := CleanupSession(threadID); err != nil {
+			slog.Warn("failed to prune orphaned session", "thread_id", threadID, "error", err)
+			summary.Failures = append(summary.Failures, fmt.Sprintf("session %s: %v", threadID, err))
+			continue
+		}
+		summary.Sessions++
+	}
+	slog.Info("pruned orphans", "worktrees", summary.Worktrees, "sessions", summary.Sessions, "failures", len(summary.Failures))
+	return summary
+}
+
+// handleDiskCommand reports the disk footprint of worktrees and sessions, or prunes orphans
+func handleDiskCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	slog.Debug("starting disk command", "channel_id", i.ChannelID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer disk interaction", "channel_id", i.ChannelID, "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+
+	var action string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "action":
+			action = option.StringValue()
+		}
+	}
+
+	usage, err := currentDiskUsage()
+	if err != nil {
+		slog.Error("failed to compute disk usage", "error", err)
+		respondOrFallback(s, i, "Failed to compute disk usage.")
+		return
+	}
+	if action == "prune-orphans" {
+		respondOrFallback(s, i, formatPruneSummary(pruneOrphans(usage)))
+		return
+	}
+	respondOrFallback(s, i, formatDiskUsage(usage))
+}
+
+// currentDiskUsage measures the bot's data directories against the stored sessions
+func currentDiskUsage() (diskUsage, error) {
+	worktreesDir, err := ensureWorktreeDir()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	sessionsDir, err := ensureSessionDir()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	sessions, err := listStoredSessions()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	return computeDiskUsage(worktreesDir, sessionsDir, os.TempDir(), sessions)
+}
+
+// formatPruneSummary renders the result of pruning orphans
+func formatPruneSummary(summary repoCleanupSummary) string {
+	content := fmt.Sprintf("Pruned %d orphaned worktree(s) and %d session(s).", summary.Worktrees, summary.Sessions)
+	if len(summary.Failures) > 0 {
+		shown := summary.Failures[:min(len(summary.Failures), maxCleanupFailuresShown)]
+		content += fmt.Sprintf("\n%d failed:\n- %s", len(summary.Failures), strings.Join(shown, "\n- "))
+	}
+	return content
+}
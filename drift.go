@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Base branch commits since the fork point from which /drift recommends syncing the session branch
+const driftSyncThreshold = 20
+
+// driftTarget prefers the remote-tracking ref of the base branch, which moves as others push
+func driftTarget(worktreePath, base string) string {
+	if strings.HasPrefix(base, "origin/") || !gitOps.RefExists(worktreePath, "origin/"+base) {
+		return base
+	}
+	return "origin/" + base
+}
+
+// driftRecommendation advises whether the session branch should be synced with its base
+func driftRecommendation(advanced int) string {
+	switch {
+	case advanced == 0:
+		return "✅ The base branch has not moved since the session forked."
+	case advanced < driftSyncThreshold:
+		return "No sync needed yet."
+	default:
+		return "⚠️ Consider rebasing or merging the base branch before committing more, to keep conflicts small."
+	}
+}
+
+// Notes explaining a fork point /drift did not take from the session as recorded
+const (
+	forkPointEstimated = "The fork point is estimated from the merge base, because this session predates fork point tracking.\n"
+	forkPointSynced    = "The fork point is the merge base, because the session branch was synced with the base branch since it forked.\n"
+)
+
+// formatDrift renders the /drift report; forkNote explains where the fork point came from, if needed
+func formatDrift(target, forkPoint, forkNote string, forkedAt time.Time, advanced, ahead int) string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "**%s** advanced **%d commit(s)** since the session forked at `%s`", target, advanced, forkPoint[:min(len(forkPoint), 7)])
+	if !forkedAt.IsZero() {
+		fmt.Fprintf(&report, " (%s ago)", time.Since(forkedAt).Round(time.Minute))
+	}
+	report.WriteString(".\n")
+	report.WriteString(forkNote)
+	fmt.Fprintf(&report, "The session branch has %d commit(s) of its own.\n", ahead)
+	report.WriteString(driftRecommendation(advanced))
+	return report.String()
+}
+
+// handleDriftCommand reports how far the base branch moved since the session branch was created
+func handleDriftCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	logger := interactionLogger(i)
+	if err := deferInteraction(s, i, false); err != nil {
+		logger.Error("failed to defer drift interaction", "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireWorktree(s, i, session) {
+		return
+	}
+	worktreePath := session.WorktreePath
+
+	base, err := sessionBaseBranch(session)
+	if err != nil {
+		logger.Error("failed to determine base branch", "error", err)
+		respondOrFallback(s, i, "Could not determine the base branch. Set one with `/setbase`.")
+		return
+	}
+	target := driftTarget(worktreePath, base)
+	if remoteBranch, found := strings.CutPrefix(target, "origin/"); found {
+		if err := gitOps.FetchBranch(worktreePath, remoteBranch); err != nil {
+			logger.Warn("failed to fetch base branch, using the last fetched state", "target", target, "error", err)
+		}
+	}
+	if !gitOps.RefExists(worktreePath, target) {
+		respondOrFallback(s, i, fmt.Sprintf("Branch `%s` was not found.", target))
+		return
+	}
+
+	sessionMutex.RLock()
+	forkPoint := session.ForkPoint
+	forkedAt := session.CreatedAt
+	sessionMutex.RUnlock()
+	// A merge or rebase of the base branch since the session forked moves the merge base past the
+	// recorded fork point, and only commits after the newer of the two are drift
+	mergeBase, err := gitOps.MergeBase(worktreePath, target)
+	var forkNote string
+	if forkPoint == "" || !gitOps.RefExists(worktreePath, forkPoint) {
+		if err != nil {
+			logger.Error("failed to estimate fork point", "target", target, "error", err)
+			respondError(s, i, "Failed to find where the session branch forked from the base branch.")
+			return
+		}
+		forkPoint, forkNote, forkedAt = mergeBase, forkPointEstimated, time.Time{}
+	} else if err != nil {
+		logger.Warn("failed to find merge base, using the recorded fork point", "target", target, "error", err)
+	} else if mergeBase != forkPoint {
+		if newer, err := gitOps.IsAncestor(worktreePath, forkPoint, mergeBase); err != nil {
+			logger.Warn("failed to compare fork point with merge base", "target", target, "error", err)
+		} else if newer {
+			forkPoint, forkNote, forkedAt = mergeBase, forkPointSynced, time.Time{}
+		}
+	}
+
+	advanced, err := gitOps.CountCommits(worktreePath, forkPoint, target)
+	if err == nil {
+		var ahead int
+		if ahead, err = gitOps.CountCommits(worktreePath, forkPoint, "HEAD"); err == nil {
+			slog.Debug("computed branch drift", "thread_id", i.ChannelID, "target", target, "advanced", advanced, "ahead", ahead)
+			respondOrFallback(s, i, formatDrift(target, forkPoint, forkNote, forkedAt, advanced, ahead))
+			return
+		}
+	}
+	logger.Error("failed to count drift commits", "target", target, "error", err)
+	respondError(s, i, "Failed to count commits on the base branch.")
+}
@@ -3,14 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
 
+	"github.com/bwmarrin/discordgo"
 	"github.com/sst/opencode-sdk-go"
 )
 
-func OpencodeEventsListener(ctx context.Context, wg *sync.WaitGroup, threadID string) {
+func OpencodeEventsListener(ctx context.Context, wg *sync.WaitGroup, threadID string, generation uint64) {
 	defer func() {
 		wg.Done()
 		slog.Debug("workgroup for OpencodeEventsListener released", "thread_id", threadID)
@@ -38,6 +40,13 @@ func OpencodeEventsListener(ctx context.Context, wg *sync.WaitGroup, threadID st
 	})
 
 	for stream.Next() {
+		// A listener that was replaced by a newer one exits without posting anything
+		if !isCurrentListener(threadID, generation) {
+			slog.Debug("superseded listener exiting", "thread_id", threadID, "generation", generation)
+			return
+		}
+		touchLastEvent(threadID)
+
 		event := stream.Current()
 		switch event.Type {
 		case opencode.EventListResponseTypeServerConnected:
@@ -50,58 +59,17 @@ func OpencodeEventsListener(ctx context.Context, wg *sync.WaitGroup, threadID st
 			}
 			sessionMutex.Unlock()
 		case opencode.EventListResponseTypeMessagePartUpdated:
-			// Parse the event directly from raw JSON properties
-			eventData := serializeEvent[struct {
-				Part MessagePart `json:"part"`
-			}](&event)
-			if eventData == nil {
-				slog.Error("failed to serialize message part updated event")
+			// While paused the parts are caught up on later from the transcript
+			if updatesPaused(threadID) {
 				continue
 			}
-
-			// for tool parts, only send completed tools to Discord
-			// for other parts (text, reasoning), send them regardless of time
-			part := eventData.Part
-			shouldSendToDiscord := false
-			if part.Type == PartTypeTool {
-				// for tools, check time in the state field (not part.Time)
-				if part.State != nil && part.State.Status == ToolStatusCompleted && part.State.Time != nil && part.State.Time.End != nil {
-					shouldSendToDiscord = true
-				}
-			} else {
-				// for non-tool parts (text, reasoning), send if time.end is present
-				if part.Time != nil && part.Time.End != nil {
-					shouldSendToDiscord = true
-				}
-			}
-
-			if !shouldSendToDiscord {
-				// skip if not ready for discord
+			// Parse the event directly from raw JSON properties, falling back to lenient extraction
+			part := parseMessagePart(&event)
+			if part == nil {
+				slog.Error("failed to serialize message part updated event")
 				continue
 			}
-
-			// format message based on part type
-			switch part.Type {
-			case PartTypeTool:
-				// for tool parts, only send completed tools as status updates
-				if part.Tool != "" && part.State != nil && part.State.Status == ToolStatusCompleted {
-					toolUpdate := fmt.Sprintf("|>> tool: %s", part.Tool)
-					updateToolStatus(threadID, toolUpdate)
-				}
-			case PartTypeReasoning:
-				if part.Text != "" {
-					reasoningUpdate := fmt.Sprintf("|>> thinking: %s", part.Text)
-					updateToolStatus(threadID, reasoningUpdate)
-				}
-			case PartTypeText:
-				// Text responses should be sent as status updates to maintain chronological order
-				if part.Text != "" {
-					cleanText := fmt.Sprintf("Response:\n%s", removeExcessiveNewLine(part.Text))
-					updateTextResponse(threadID, cleanText)
-				}
-			}
-
-			// debug log
+			handleMessagePart(threadID, part)
 			slog.Debug("processing message for Discord", "thread_id", threadID, "session_id", sessionData.SessionID, "part_type", part.Type)
 		case opencode.EventListResponseTypeSessionIdle:
 			eventData := serializeEvent[struct {
@@ -112,72 +80,245 @@ func OpencodeEventsListener(ctx context.Context, wg *sync.WaitGroup, threadID st
 				continue
 			}
 
-			slog.Debug("session idle detected", "thread_id", threadID, "session_id", eventData.SessionID)
-
-			// Mark session as no longer streaming (completed)
-			sessionMutex.Lock()
-			if sessionData, exists := sessionCache[threadID]; exists {
-				sessionData.IsStreaming = false
-				slog.Debug("marked session as not streaming", "thread_id", threadID)
-			} else {
-				slog.Error("session not found when clearing streaming state", "thread_id", threadID)
+			// The stream carries every session in the worktree, e.g. /comparemodels runs
+			if !isThreadSession(threadID, eventData.SessionID) {
+				slog.Debug("ignoring idle event of another session", "thread_id", threadID, "session_id", eventData.SessionID)
+				continue
 			}
-			sessionMutex.Unlock()
+			slog.Debug("session idle detected", "thread_id", threadID, "session_id", eventData.SessionID)
 
-			// Mention the user that the task is completed (keep existing text responses intact)
-			sessionMutex.RLock()
-			if sessionData, exists := sessionCache[threadID]; exists && sessionData.UserID != "" {
-				userID := sessionData.UserID
-				sessionMutex.RUnlock()
-				mentionMessage := fmt.Sprintf("<@%s> task completed", userID)
-				sendToDiscord(threadID, mentionMessage)
-			} else {
-				sessionMutex.RUnlock()
+			// A turn that ended while paused shows what it missed before it is finished
+			if err := resumeUpdates(threadID); err != nil && !errors.Is(err, errUpdatesRunning) {
+				slog.Error("failed to catch up on paused updates", "thread_id", threadID, "error", err)
+				clearUpdatesPause(threadID)
 			}
-
-			// set session inactive and cleanup
-			SetSessionActive(threadID, false)
+			finishTurn(threadID)
 
 			// remove from active listeners and exit
-			removeActiveListener(threadID)
+			removeActiveListener(threadID, generation)
+			continueAfterTurn(threadID)
 			return
+		default:
+			slog.Debug("unhandled event type", "thread_id", threadID, "event_type", event.Type, "raw", event.JSON.Properties.Raw())
 		}
 	}
 
-	if err := stream.Err(); err != nil {
+	if !isCurrentListener(threadID, generation) {
+		slog.Debug("superseded listener stopped", "thread_id", threadID, "generation", generation)
+		return
+	}
+
+	// The stream ended before the session went idle, so the turn will not complete normally
+	outcome := statusOutcomeInterrupted
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
 		slog.Error("error in opencode event stream", "thread_id", threadID, "error", err)
+		outcome = statusOutcomeFailed
 	}
+	finalizeStatusMessage(threadID, outcome)
+	sessionMutex.Lock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		sessionData.IsStreaming = false
+	}
+	sessionMutex.Unlock()
+	refreshPresence()
 
 	// Cleanup on exit
-	removeActiveListener(threadID)
+	removeActiveListener(threadID, generation)
 	slog.Debug("opencode events listener stopped", "thread_id", threadID)
 }
 
+// recordTurnPart tracks whether the current turn produced a text response and its latest reasoning
+func recordTurnPart(threadID string, part *MessagePart) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists {
+		return
+	}
+	switch part.Type {
+	case PartTypeText:
+		sessionData.TurnHadText = true
+	case PartTypeReasoning:
+		sessionData.LastReasoning = part.Text
+	}
+}
+
+// handleReasoningOnlyTurn applies reasoning_only_response when a turn ended without any text part,
+// so the user isn't left with thinking lines and no answer
+func handleReasoningOnlyTurn(threadID string) {
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists || sessionData.TurnHadText || sessionData.LastReasoning == "" {
+		sessionMutex.RUnlock()
+		return
+	}
+	reasoning := sessionData.LastReasoning
+	sessionMutex.RUnlock()
+
+	slog.Debug("turn ended with reasoning only", "thread_id", threadID, "mode", AppConfig.ReasoningOnlyResponse)
+	switch AppConfig.ReasoningOnlyResponse {
+	case ReasoningOnlyPromote:
+		if AppConfig.ResponseMode == ResponseModeReplyChain {
+			SendDiscordMessage(threadID, removeExcessiveNewLine(reasoning))
+		} else {
+			updateTextResponse(threadID, fmt.Sprintf("Response:\n%s", removeExcessiveNewLine(reasoning)))
+		}
+	case ReasoningOnlyNotice:
+		sendToDiscord(threadID, "The model finished with reasoning only and gave no final answer. Try asking again or switching models.")
+	}
+}
+
+// applyOnCompleteAction archives or locks the thread after a completed turn according to on_complete,
+// unless the session asked to keep its thread open with /keep
+func applyOnCompleteAction(threadID string) {
+	if AppConfig.OnComplete == OnCompleteKeep || discord == nil {
+		return
+	}
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	// A channel hosting a session cannot be archived like a thread
+	keep := exists && (sessionData.KeepThread || sessionData.ChannelSession)
+	sessionMutex.RUnlock()
+	if !exists || keep {
+		return
+	}
+
+	flag := true
+	edit := &discordgo.ChannelEdit{Archived: &flag}
+	if AppConfig.OnComplete == OnCompleteLock {
+		edit = &discordgo.ChannelEdit{Archived: &flag, Locked: &flag}
+	}
+	if _, err := discord.ChannelEdit(threadID, edit); err != nil {
+		slog.Error("failed to apply on_complete action", "thread_id", threadID, "action", AppConfig.OnComplete, "error", err)
+		return
+	}
+	slog.Debug("applied on_complete action", "thread_id", threadID, "action", AppConfig.OnComplete)
+}
+
 // serializeEvent deserializes the event's raw JSON properties into a typed struct.
 // The type T should be a struct with appropriate JSON tags matching the event structure.
 func serializeEvent[T any](event *opencode.EventListResponse) *T {
 	var data T
 	err := json.Unmarshal([]byte(event.JSON.Properties.Raw()), &data)
 	if err != nil {
-		slog.Error("failed to serialize event to json", "error", err)
+		slog.Error("failed to serialize event to json", "event_type", event.Type, "error", err)
+		slog.Debug("raw event properties", "event_type", event.Type, "raw", event.JSON.Properties.Raw())
 		return nil
 	}
 	return &data
 }
 
-// removeActiveListener removes the cancel function for a session listener
-func removeActiveListener(threadID string) {
+// parseMessagePart extracts the part of a message.part.updated event. When the strict
+// decode fails (e.g. OpenCode changed a field's shape), the fields needed to render the
+// part are extracted leniently so text still reaches Discord.
+func parseMessagePart(event *opencode.EventListResponse) *MessagePart {
+	raw := event.JSON.Properties.Raw()
+	var data struct {
+		Part MessagePart `json:"part"`
+	}
+	err := json.Unmarshal([]byte(raw), &data)
+	if err == nil {
+		return &data.Part
+	}
+	slog.Debug("strict message part decode failed, trying lenient decode", "error", err, "raw", raw)
+	return lenientMessagePart([]byte(raw))
+}
+
+// lenientMessagePart decodes a message part from loosely typed JSON, keeping only
+// fields whose values have the expected types. Returns nil if there is no part object.
+func lenientMessagePart(raw []byte) *MessagePart {
+	var properties map[string]any
+	if err := json.Unmarshal(raw, &properties); err != nil {
+		slog.Debug("message part is not a json object", "error", err, "raw", string(raw))
+		return nil
+	}
+	fields, ok := properties["part"].(map[string]any)
+	if !ok {
+		slog.Debug("message part event has no part object", "raw", string(raw))
+		return nil
+	}
+
+	part := &MessagePart{
+		ID:        stringField(fields, "id"),
+		MessageID: stringField(fields, "messageID"),
+		SessionID: stringField(fields, "sessionID"),
+		Type:      stringField(fields, "type"),
+		Text:      stringField(fields, "text"),
+		Tool:      stringField(fields, "tool"),
+		CallID:    stringField(fields, "callID"),
+		Time:      lenientTimeRange(fields["time"]),
+	}
+	if state, ok := fields["state"].(map[string]any); ok {
+		part.State = &ToolState{
+			Status: stringField(state, "status"),
+			Title:  stripANSI(stringField(state, "title")),
+			Output: stripANSI(stringField(state, "output")),
+			Time:   lenientTimeRange(state["time"]),
+		}
+		part.State.Input, _ = state["input"].(map[string]any)
+		part.State.Metadata, _ = state["metadata"].(map[string]any)
+	}
+	return part
+}
+
+// lenientTimeRange reads start/end timestamps from a loosely typed time object
+func lenientTimeRange(value any) *TimeRange {
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	timeRange := &TimeRange{}
+	if start, ok := fields["start"].(float64); ok {
+		timeRange.Start = int64(start)
+	}
+	if end, ok := fields["end"].(float64); ok {
+		endValue := int64(end)
+		timeRange.End = &endValue
+	}
+	return timeRange
+}
+
+// stringField returns a string value from a loosely typed object, or "" when missing or not a string
+func stringField(fields map[string]any, key string) string {
+	value, _ := fields[key].(string)
+	return value
+}
+
+// removeActiveListener removes a session listener, unless it has already been replaced by a newer one
+func removeActiveListener(threadID string, generation uint64) {
 	listenersMutex.Lock()
 	defer listenersMutex.Unlock()
-	delete(activeListeners, threadID)
+	if listener, exists := activeListeners[threadID]; exists && listener.generation == generation {
+		delete(activeListeners, threadID)
+	}
+}
+
+// isThreadSession reports whether sessionID is the thread's own OpenCode session. Events without a
+// session ID are attributed to the thread.
+func isThreadSession(threadID, sessionID string) bool {
+	if sessionID == "" {
+		return true
+	}
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	sessionData, exists := sessionCache[threadID]
+	return exists && sessionData.SessionID == sessionID
+}
+
+// isCurrentListener reports whether the listener with this generation is still the registered one for the thread
+func isCurrentListener(threadID string, generation uint64) bool {
+	listenersMutex.RLock()
+	defer listenersMutex.RUnlock()
+	listener, exists := activeListeners[threadID]
+	return exists && listener.generation == generation
 }
 
 // stopActiveListener cancels and removes a listener for a thread
 func stopActiveListener(threadID string) {
 	listenersMutex.Lock()
 	defer listenersMutex.Unlock()
-	if cancelFunc, exists := activeListeners[threadID]; exists {
-		cancelFunc()
+	if listener, exists := activeListeners[threadID]; exists {
+		listener.cancel()
 		delete(activeListeners, threadID)
 		slog.Debug("stopped active listener", "thread_id", threadID)
 	}
@@ -187,12 +328,12 @@ func stopActiveListener(threadID string) {
 func stopAllActiveListeners() {
 	listenersMutex.Lock()
 	defer listenersMutex.Unlock()
-	for threadID, cancelFunc := range activeListeners {
-		cancelFunc()
+	for threadID, listener := range activeListeners {
+		listener.cancel()
 		slog.Debug("stopped active listener", "thread_id", threadID)
 	}
 	// Clear the map
-	activeListeners = make(map[string]context.CancelFunc)
+	activeListeners = make(map[string]*activeListener)
 	slog.Info("stopped all active listeners")
 }
 
@@ -212,11 +353,129 @@ func spawnListenerIfNotExists(ctx context.Context, wg *sync.WaitGroup, threadID
 
 	// Add to waitgroup and register listener
 	wg.Add(1)
-	activeListeners[threadID] = cancel
+	listenerGeneration++
+	generation := listenerGeneration
+	activeListeners[threadID] = &activeListener{cancel: cancel, generation: generation}
 
 	// Start listener
-	go OpencodeEventsListener(listenerCtx, wg, threadID)
+	go OpencodeEventsListener(listenerCtx, wg, threadID, generation)
 	slog.Debug("spawned session event listener", "thread_id", threadID)
 
 	return true // New listener spawned
 }
+
+// handleMessagePart shows a finished message part in the thread: completed tools and reasoning as
+// status lines, text as the response. Parts that are still in progress are skipped.
+func handleMessagePart(threadID string, part *MessagePart) {
+	if part.Type == PartTypeStepFinish {
+		recordStepUsage(threadID, part)
+	}
+
+	// for tool parts, only send completed tools to Discord
+	// for other parts (text, reasoning), send them regardless of time
+	shouldSendToDiscord := false
+	if part.Type == PartTypeTool {
+		// for tools, check time in the state field (not part.Time)
+		if part.State != nil && part.State.Status == ToolStatusCompleted && part.State.Time != nil && part.State.Time.End != nil {
+			shouldSendToDiscord = true
+		}
+	} else {
+		// for non-tool parts (text, reasoning), send if time.end is present
+		if part.Time != nil && part.Time.End != nil {
+			shouldSendToDiscord = true
+		}
+	}
+
+	if !shouldSendToDiscord {
+		// skip if not ready for discord
+		return
+	}
+
+	// format message based on part type
+	switch part.Type {
+	case PartTypeTool:
+		// for tool parts, only send completed tools as status updates
+		if part.Tool != "" && part.State != nil && part.State.Status == ToolStatusCompleted {
+			updateToolStatus(threadID, toolStatusLine(part))
+		}
+	case PartTypeReasoning:
+		if part.Text != "" {
+			recordTurnPart(threadID, part)
+			reasoningUpdate := fmt.Sprintf("|>> thinking: %s", part.Text)
+			updateToolStatus(threadID, reasoningUpdate)
+		}
+	case PartTypeText:
+		// Text responses should be sent as status updates to maintain chronological order
+		if part.Text == "" {
+			break
+		}
+		recordTurnPart(threadID, part)
+		if AppConfig.ResponseMode == ResponseModeReplyChain {
+			// Post each completed text part as its own message instead of editing the status message
+			sendModelResponse(threadID, removeExcessiveNewLine(part.Text))
+		} else {
+			cleanText := fmt.Sprintf("Response:\n%s", removeExcessiveNewLine(part.Text))
+			updateTextResponse(threadID, cleanText)
+		}
+	case PartTypeStepStart, PartTypeStepFinish:
+		// bookkeeping parts, nothing to show
+	default:
+		slog.Debug("unknown message part type", "thread_id", threadID, "part_type", part.Type)
+	}
+}
+
+// finishTurn wraps up a turn once the session went idle: the status message is finalized,
+// the user mentioned and the session marked inactive
+func finishTurn(threadID string) {
+	// Mark session as no longer streaming (completed)
+	sessionMutex.Lock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		sessionData.IsStreaming = false
+		slog.Debug("marked session as not streaming", "thread_id", threadID)
+	} else {
+		slog.Error("session not found when clearing streaming state", "thread_id", threadID)
+	}
+	sessionMutex.Unlock()
+	refreshPresence()
+
+	handleReasoningOnlyTurn(threadID)
+
+	// Optionally replace the status chatter with a clean final response
+	if AppConfig.CleanupStatusOnComplete {
+		cleanupStatusMessages(threadID)
+	} else {
+		finalizeStatusMessage(threadID, statusOutcomeCompleted)
+	}
+	postFinalResponse(threadID)
+	markPlanReady(threadID)
+
+	// Mention the user that the task is completed (keep existing text responses intact)
+	sessionMutex.RLock()
+	if sessionData, exists := sessionCache[threadID]; exists && sessionData.UserID != "" {
+		userID := sessionData.UserID
+		sessionMutex.RUnlock()
+		mentionMessage := fmt.Sprintf("<@%s> task completed", userID)
+		sendToDiscord(threadID, mentionMessage)
+	} else {
+		sessionMutex.RUnlock()
+	}
+
+	// Checkpoint the turn's runtime state now that the session is idle
+	flushSession(threadID)
+	offerThreadContinuation(threadID)
+
+	// set session inactive and cleanup
+	SetSessionActive(threadID, false)
+	resetAutoCommitTimer(threadID)
+}
+
+// continueAfterTurn starts the next queued prompt, if any, on a fresh listener; otherwise it tidies up the thread.
+// The finished turn's listener must already be unregistered.
+func continueAfterTurn(threadID string) {
+	if len(queuedPrompts(threadID)) > 0 {
+		go dispatchQueuedPrompt(threadID)
+	} else {
+		applyOnCompleteAction(threadID)
+		scheduleInactivityNudge(threadID)
+	}
+}
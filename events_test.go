@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serveEvents answers the event stream with the given event JSON objects, then ends it
+func serveEvents(events ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/event" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"server.connected\",\"properties\":{}}\n\n")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}
+}
+
+// idleEvent is the session.idle event of sessionID
+func idleEvent(sessionID string) string {
+	return fmt.Sprintf(`{"type":"session.idle","properties":{"sessionID":%q}}`, sessionID)
+}
+
+// runListener runs a thread's event listener until the stream ends
+func runListener(t *testing.T, threadID string) {
+	t.Helper()
+	var wg sync.WaitGroup
+	if !spawnListenerIfNotExists(context.Background(), &wg, threadID) {
+		t.Fatal("a listener is already running for the thread")
+	}
+	wg.Wait()
+	stopActiveListener(threadID)
+}
+
+func TestListenerIgnoresIdleOfOtherSessions(t *testing.T) {
+	tests := []struct {
+		name         string
+		idleSession  string
+		wantFinished bool
+	}{
+		{name: "own session", idleSession: "ses_thread", wantFinished: true},
+		{name: "comparison session", idleSession: "ses_compare"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDataDirs(t)
+			fake := useFakeDiscord(t)
+			useFakeOpencode(t, serveEvents(idleEvent(tt.idleSession)))
+			sessionData := &SessionData{ThreadID: "idle-thread", SessionID: "ses_thread", UserID: "user", IsStreaming: true}
+			useTestSession(t, sessionData)
+
+			runListener(t, "idle-thread")
+
+			// Only a finished turn mentions the user; a stream that ends mid-turn does not
+			finished := false
+			for _, sent := range fake.calls(http.MethodPost, "/channels/idle-thread/messages") {
+				finished = finished || strings.Contains(sent.Body, "task completed")
+			}
+			if finished != tt.wantFinished {
+				t.Errorf("turn finished = %v, want %v", finished, tt.wantFinished)
+			}
+			sessionMutex.RLock()
+			streaming := sessionData.IsStreaming
+			sessionMutex.RUnlock()
+			if streaming {
+				t.Error("session still marked as streaming after the stream ended")
+			}
+		})
+	}
+}
+
+func TestFailedTurnFinalizesStatus(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "event stream unavailable", http.StatusInternalServerError)
+	}))
+	working := statusHeaderWorking + "\n🔧 read main.go"
+	continued := statusHeaderContinued + "\n🔧 edit main.go"
+	sessionData := &SessionData{
+		ThreadID:              "failed-thread",
+		SessionID:             "ses_failed",
+		IsStreaming:           true,
+		StatusMessageIDs:      []string{"status-1", "status-2"},
+		StatusMessageContents: []string{working, continued},
+		LastStatusMessageID:   "status-2",
+		StatusMessageContent:  continued,
+	}
+	useTestSession(t, sessionData)
+
+	runListener(t, "failed-thread")
+
+	edits := fake.calls(http.MethodPatch, "/channels/failed-thread/messages/")
+	if len(edits) != 2 {
+		t.Fatalf("edited %d status messages, want 2", len(edits))
+	}
+	for _, edit := range edits {
+		if strings.Contains(edit.Body, "is working") {
+			t.Errorf("status message %s still claims work is in progress: %s", edit.Path, edit.Body)
+		}
+	}
+	if !strings.HasSuffix(edits[0].Path, "/status-1") || !strings.Contains(edits[0].Body, statusOutcomeFailed) {
+		t.Errorf("first status message edit = %v, want the failed outcome header", edits[0])
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	for _, content := range sessionData.StatusMessageContents {
+		if strings.Contains(content, "is working") {
+			t.Errorf("stored status content still working: %q", content)
+		}
+	}
+	if sessionData.IsStreaming {
+		t.Error("session still marked as streaming after a failed turn")
+	}
+}
+
+func TestSupersededListenerDoesNotPost(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	connected := make(chan struct{})
+	release := make(chan struct{})
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"server.connected\",\"properties\":{}}\n\n")
+		w.(http.Flusher).Flush()
+		close(connected)
+		<-release
+		fmt.Fprint(w, `data: {"type":"message.part.updated","properties":{"part":{"id":"part","messageID":"msg","sessionID":"ses_old","type":"text","text":"stale answer","time":{"start":1,"end":2}}}}`+"\n\n")
+		fmt.Fprintf(w, "data: %s\n\n", idleEvent("ses_old"))
+	}))
+	sessionData := &SessionData{ThreadID: "superseded-thread", SessionID: "ses_old", UserID: "user"}
+	useTestSession(t, sessionData)
+
+	var wg sync.WaitGroup
+	if !spawnListenerIfNotExists(context.Background(), &wg, "superseded-thread") {
+		t.Fatal("listener was not spawned")
+	}
+	<-connected
+
+	// A respawn registers a newer listener while the old stream is still open
+	replacement := &activeListener{cancel: func() {}}
+	listenersMutex.Lock()
+	listenerGeneration++
+	replacement.generation = listenerGeneration
+	activeListeners["superseded-thread"] = replacement
+	listenersMutex.Unlock()
+	t.Cleanup(func() { stopActiveListener("superseded-thread") })
+
+	close(release)
+	wg.Wait()
+
+	fake.mu.Lock()
+	requests := fake.requests
+	fake.mu.Unlock()
+	if len(requests) != 0 {
+		t.Errorf("superseded listener called Discord: %v", requests)
+	}
+	listenersMutex.RLock()
+	current := activeListeners["superseded-thread"]
+	listenersMutex.RUnlock()
+	if current != replacement {
+		t.Error("superseded listener removed the newer listener's registration")
+	}
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	if sessionData.CurrentResponse != "" {
+		t.Errorf("superseded listener recorded a response: %q", sessionData.CurrentResponse)
+	}
+}
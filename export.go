@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// transcriptMessage is one prompt or response of a session, reduced to what the Markdown export shows
+type transcriptMessage struct {
+	Role      string
+	Created   time.Time
+	Completed time.Time // Zero while an assistant message is still being generated
+	Parts     []MessagePart
+}
+
+// fetchTranscript loads a session's message history from the OpenCode server
+func fetchTranscript(session *SessionData) ([]transcriptMessage, error) {
+	client := Opencode()
+	if client == nil {
+		return nil, errOpencodeUnavailable
+	}
+
+	sessionMutex.RLock()
+	sessionID := session.SessionID
+	worktreePath := session.WorktreePath
+	sessionMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	history, err := client.Session.Messages(ctx, sessionID, opencode.SessionMessagesParams{
+		Directory: opencode.F(worktreePath),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return nil, nil
+	}
+
+	messages := make([]transcriptMessage, 0, len(*history))
+	for _, entry := range *history {
+		// Decode the raw JSON into the bot's own types, as the event listener does
+		var info struct {
+			Role string `json:"role"`
+			Time struct {
+				Created   int64 `json:"created"`
+				Completed int64 `json:"completed"`
+			} `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(entry.Info.JSON.RawJSON()), &info); err != nil {
+			slog.Debug("skipping undecodable message", "session_id", sessionID, "error", err)
+			continue
+		}
+		message := transcriptMessage{Role: info.Role, Created: time.UnixMilli(info.Time.Created)}
+		if info.Time.Completed > 0 {
+			message.Completed = time.UnixMilli(info.Time.Completed)
+		}
+		for _, part := range entry.Parts {
+			var decoded MessagePart
+			if err := json.Unmarshal([]byte(part.JSON.RawJSON()), &decoded); err != nil {
+				slog.Debug("skipping undecodable message part", "session_id", sessionID, "error", err)
+				continue
+			}
+			message.Parts = append(message.Parts, decoded)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// stripInjectedContext removes what the bot adds to user prompts: the repository context preamble
+// and the trailing worktree boundary instruction
+func stripInjectedContext(text string) string {
+	if strings.HasPrefix(text, repositoryContextHeader) {
+		if end := strings.LastIndex(text, "</file>\n"); end >= 0 {
+			text = text[end+len("</file>\n"):]
+		}
+		// Drop the truncation notes that follow the last file
+		for strings.HasPrefix(text, "(") {
+			_, rest, found := strings.Cut(text, "\n")
+			if !found {
+				break
+			}
+			text = rest
+		}
+	}
+	if cut := strings.LastIndex(text, "\n\nImportant: "); cut >= 0 {
+		text = text[:cut]
+	}
+	return strings.TrimSpace(text)
+}
+
+// renderTranscriptMarkdown renders a session's prompts, responses, tool calls and commits as Markdown
+func renderTranscriptMarkdown(session *SessionData, messages []transcriptMessage) string {
+	sessionMutex.RLock()
+	title := session.ThreadName
+	repositoryName := session.RepositoryName
+	model := session.Model
+	createdAt := session.CreatedAt
+	scratch := session.Scratch
+	commits := make([]CommitRecord, 0, len(session.Commits))
+	for _, commit := range session.Commits {
+		commits = append(commits, *commit)
+	}
+	sessionMutex.RUnlock()
+
+	if title == "" {
+		title = "codesession transcript"
+	}
+	if scratch {
+		repositoryName = "(scratch)"
+	}
+
+	var md strings.Builder
+	fmt.Fprintf(&md, "# %s\n\n", title)
+	fmt.Fprintf(&md, "- Repository: %s\n", repositoryName)
+	fmt.Fprintf(&md, "- Model: %s/%s\n", model.ProviderID, model.ModelID)
+	fmt.Fprintf(&md, "- Started: %s\n", createdAt.Format(time.RFC3339))
+	fmt.Fprintf(&md, "- Exported: %s\n\n", time.Now().Format(time.RFC3339))
+
+	md.WriteString("## Conversation\n\n")
+	for _, message := range messages {
+		heading := "Assistant"
+		if message.Role == "user" {
+			heading = "User"
+		}
+		fmt.Fprintf(&md, "### %s (%s)\n\n", heading, message.Created.Format("2006-01-02 15:04"))
+
+		var tools []string
+		for _, part := range message.Parts {
+			switch part.Type {
+			case PartTypeText:
+				text := part.Text
+				if message.Role == "user" {
+					text = stripInjectedContext(text)
+				}
+				if text != "" {
+					md.WriteString(strings.TrimSpace(text) + "\n\n")
+				}
+			case PartTypeTool:
+				line := fmt.Sprintf("- `%s`", part.Tool)
+				if part.State != nil && part.State.Title != "" {
+					line += ": " + part.State.Title
+				}
+				if part.State != nil && part.State.Status != "" && part.State.Status != ToolStatusCompleted {
+					line += fmt.Sprintf(" (%s)", part.State.Status)
+				}
+				tools = append(tools, line)
+			}
+		}
+		if len(tools) > 0 {
+			md.WriteString("Tools used:\n\n" + strings.Join(tools, "\n") + "\n\n")
+		}
+	}
+
+	if len(commits) > 0 {
+		md.WriteString("## Commits\n\n")
+		for _, commit := range commits {
+			hash := commit.Hash[:min(len(commit.Hash), 7)]
+			if hash == "" {
+				hash = "-------"
+			}
+			subject, _, _ := strings.Cut(strings.TrimSpace(commit.Summary), "\n")
+			fmt.Fprintf(&md, "- `%s` %s (%s, %s)\n", hash, subject, commit.Status, commit.Timestamp.Format("2006-01-02 15:04"))
+		}
+	}
+	return md.String()
+}
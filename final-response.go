@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's limit for an embed description
+const maxEmbedDescription = 4096
+
+// buildFinalResponseEmbed renders a turn's final response as a standalone result embed
+func buildFinalResponseEmbed(response string, model Model, finishedAt time.Time) *discordgo.MessageEmbed {
+	response = truncateText(response, maxEmbedDescription, "...")
+	return &discordgo.MessageEmbed{
+		Title:       "Result",
+		Description: response,
+		Color:       embedColorSuccess,
+		Timestamp:   finishedAt.Format(time.RFC3339),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s/%s", model.ProviderID, model.ModelID),
+		},
+	}
+}
+
+// postFinalResponse posts the turn's final response as an embed when final_response asks for it,
+// pinning it in "pinned" mode. Turns without a text response post nothing.
+func postFinalResponse(threadID string) {
+	if AppConfig.FinalResponse == FinalResponseNone {
+		return
+	}
+
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	var response string
+	var model Model
+	if exists {
+		response = strings.TrimSpace(strings.TrimPrefix(sessionData.CurrentResponse, "Response:\n"))
+		model = sessionData.Model
+	}
+	sessionMutex.RUnlock()
+	if response == "" || discord == nil {
+		return
+	}
+
+	message, err := discord.ChannelMessageSendEmbed(threadID, buildFinalResponseEmbed(response, model, time.Now()))
+	if err != nil {
+		slog.Error("failed to post final response embed", "thread_id", threadID, "error", err)
+		return
+	}
+	if AppConfig.FinalResponse == FinalResponsePinned {
+		if err := discord.ChannelMessagePin(threadID, message.ID); err != nil {
+			slog.Warn("failed to pin final response", "thread_id", threadID, "message_id", message.ID, "error", err)
+		}
+	}
+}
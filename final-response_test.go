@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// responseEvent is a finished text part of sessionID carrying text
+func responseEvent(sessionID, text string) string {
+	return fmt.Sprintf(`{"type":"message.part.updated","properties":{"part":{"id":"part","messageID":"msg","sessionID":%q,"type":"text","text":%q,"time":{"start":1,"end":2}}}}`, sessionID, text)
+}
+
+func TestFinalResponseEmbedOnIdle(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		cleanup    bool
+		wantPinned bool
+	}{
+		{name: "embed", mode: FinalResponseEmbed},
+		{name: "pinned", mode: FinalResponsePinned, wantPinned: true},
+		{name: "embed with status cleanup", mode: FinalResponseEmbed, cleanup: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDataDirs(t)
+			fake := useFakeDiscord(t)
+			useFakeOpencode(t, serveEvents(responseEvent("ses_final", "All tests pass."), idleEvent("ses_final")))
+			useTestConfig(t, func(config *Config) {
+				config.FinalResponse = tt.mode
+				config.CleanupStatusOnComplete = tt.cleanup
+				config.StatusFlushInterval = -1
+			})
+			useTestSession(t, &SessionData{
+				ThreadID:    "final-thread",
+				SessionID:   "ses_final",
+				IsStreaming: true,
+				Model:       Model{ProviderID: "provider", ModelID: "model"},
+			})
+
+			runListener(t, "final-thread")
+
+			// Every post of the answer: the status message, the result embed and any cleanup repost
+			var answers, embeds []discordRequest
+			for _, sent := range fake.calls(http.MethodPost, "/channels/final-thread/messages") {
+				if !strings.Contains(sent.Body, "All tests pass.") {
+					continue
+				}
+				answers = append(answers, sent)
+				if strings.Contains(sent.Body, `"title":"Result"`) {
+					embeds = append(embeds, sent)
+				}
+			}
+			if len(embeds) != 1 || !strings.Contains(embeds[0].Body, "provider/model") {
+				t.Fatalf("result embeds = %v, want one naming the model", embeds)
+			}
+			if tt.cleanup {
+				// The status message is deleted and the embed is the only copy of the answer left
+				if deleted := fake.calls(http.MethodDelete, "/channels/final-thread/messages/"); len(deleted) != 1 {
+					t.Errorf("deleted %d status messages, want 1", len(deleted))
+				}
+				if len(answers) != 2 {
+					t.Errorf("answer posted %d times, want the status message and the embed only", len(answers))
+				}
+			}
+			if pins := fake.calls(http.MethodPut, "/channels/final-thread/pins/"); (len(pins) == 1) != tt.wantPinned {
+				t.Errorf("pins = %v, want pinned %v", pins, tt.wantPinned)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Number of Ready events seen; every Ready after the first means the gateway reconnected with a new session
+var gatewayReadyCount atomic.Int32
+
+// gatewayIntents returns the intents the bot needs for the current config
+func gatewayIntents() discordgo.Intent {
+	// We need both message events and application commands
+	intents := discordgo.IntentsGuildMessages
+	// Reading messages that don't mention the bot needs the privileged message content intent
+	if AppConfig.EnableAutoRespond {
+		intents |= discordgo.IntentMessageContent
+	}
+	return intents
+}
+
+// handleGatewayReady re-validates the bot after a full gateway reconnect. The first Ready belongs to
+// startup, where RunDiscordBot registers the commands itself.
+func handleGatewayReady(s *discordgo.Session, r *discordgo.Ready) {
+	if gatewayReadyCount.Add(1) == 1 {
+		slog.Debug("gateway ready", "session_id", r.SessionID)
+		return
+	}
+	slog.Warn("gateway reconnected with a new session", "session_id", r.SessionID)
+
+	if s.Identify.Intents != gatewayIntents() {
+		slog.Warn("gateway intents changed, restoring them for the next connect", "intents", s.Identify.Intents, "expected", gatewayIntents())
+		s.Identify.Intents = gatewayIntents()
+	}
+	go revalidateCommands(s)
+}
+
+// handleGatewayResumed logs a resumed gateway session; Discord replays missed events, so nothing is lost
+func handleGatewayResumed(s *discordgo.Session, r *discordgo.Resumed) {
+	slog.Info("gateway session resumed")
+}
+
+// handleGatewayDisconnect logs a dropped gateway connection; discordgo reconnects on its own
+func handleGatewayDisconnect(s *discordgo.Session, d *discordgo.Disconnect) {
+	slog.Warn("gateway disconnected, waiting for reconnect")
+}
+
+// revalidateCommands registers the slash commands again when any of them is missing
+func revalidateCommands(s *discordgo.Session) {
+	expected, err := buildCommands()
+	if err != nil {
+		slog.Error("failed to build commands for revalidation", "error", err)
+		return
+	}
+	registered, err := s.ApplicationCommands(s.State.User.ID, "")
+	if err != nil {
+		slog.Error("failed to list registered commands", "error", err)
+		return
+	}
+
+	missing := missingCommands(expected, registered)
+	if len(missing) == 0 {
+		slog.Debug("slash commands still registered after reconnect")
+		return
+	}
+	slog.Warn("slash commands missing after reconnect, registering again", "missing", missing)
+	if err := registerCommands(s); err != nil {
+		slog.Error("failed to register commands after reconnect", "error", err)
+	}
+}
+
+// missingCommands returns the names of expected commands that are not registered
+func missingCommands(expected, registered []*discordgo.ApplicationCommand) []string {
+	names := make(map[string]bool, len(registered))
+	for _, command := range registered {
+		names[command.Name] = true
+	}
+	var missing []string
+	for _, command := range expected {
+		if !names[command.Name] {
+			missing = append(missing, command.Name)
+		}
+	}
+	return missing
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // GitStatus represents the status of a Git repository
@@ -15,42 +23,123 @@ type GitStatus struct {
 	ModifiedFiles  []string
 	UntrackedFiles []string
 	StagedFiles    []string
+
+	// Exact totals; the file lists above are capped at maxStatusFiles
+	ModifiedCount  int
+	UntrackedCount int
+	StagedCount    int
+	TotalCount     int // number of status entries
 }
 
 // GitOperations provides a wrapper around go-git operations
-type GitOperations struct{}
+type GitOperations struct {
+	// slots bounds concurrent git subprocesses; nil means unlimited
+	slots     chan struct{}
+	slotsOnce sync.Once
+}
 
 // NewGitOperations creates a new GitOperations instance
 func NewGitOperations() *GitOperations {
 	return &GitOperations{}
 }
 
+// acquire blocks until a git subprocess slot is free and returns the function releasing it.
+// The limit is read from max_concurrent_git_ops on first use, after the config is loaded.
+func (g *GitOperations) acquire() func() {
+	g.slotsOnce.Do(func() {
+		if AppConfig.MaxConcurrentGitOps > 0 {
+			g.slots = make(chan struct{}, AppConfig.MaxConcurrentGitOps)
+		}
+	})
+	if g.slots == nil {
+		return func() {}
+	}
+	g.slots <- struct{}{}
+	return func() { <-g.slots }
+}
+
+// run executes a git command while holding a subprocess slot
+func (g *GitOperations) run(cmd *exec.Cmd) error {
+	defer g.acquire()()
+	return cmd.Run()
+}
+
+// output executes a git command while holding a subprocess slot and returns its stdout
+func (g *GitOperations) output(cmd *exec.Cmd) ([]byte, error) {
+	defer g.acquire()()
+	return cmd.Output()
+}
 
-// CreateWorktree creates a new git worktree at the specified path with a branch
-func (g *GitOperations) CreateWorktree(repoPath, worktreePath, branchName string) error {
-	slog.Debug("creating worktree", "repo_path", repoPath, "worktree_path", worktreePath, "branch", branchName)
+// combinedOutput executes a git command while holding a subprocess slot and returns stdout and stderr
+func (g *GitOperations) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	defer g.acquire()()
+	return cmd.CombinedOutput()
+}
 
-	// Reject empty branch names early
+// validateBranchName checks a branch name against the rules of `git check-ref-format --branch`
+// so validation does not depend on a git binary being available
+func validateBranchName(branchName string) error {
 	if branchName == "" {
 		return fmt.Errorf("branch name cannot be empty")
 	}
+	if branchName == "@" {
+		return fmt.Errorf("invalid branch name %q: cannot be '@'", branchName)
+	}
+	if strings.HasPrefix(branchName, "-") {
+		return fmt.Errorf("invalid branch name %q: cannot start with '-'", branchName)
+	}
+	if strings.HasSuffix(branchName, ".") || strings.HasSuffix(branchName, "/") {
+		return fmt.Errorf("invalid branch name %q: cannot end with '.' or '/'", branchName)
+	}
+	if strings.Contains(branchName, "..") || strings.Contains(branchName, "//") || strings.Contains(branchName, "@{") {
+		return fmt.Errorf("invalid branch name %q: cannot contain '..', '//' or '@{'", branchName)
+	}
+	for _, r := range branchName {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return fmt.Errorf("invalid branch name %q: contains forbidden character %q", branchName, r)
+		}
+	}
+	for _, component := range strings.Split(branchName, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return fmt.Errorf("invalid branch name %q: component %q cannot start with '.' or end with '.lock'", branchName, component)
+		}
+	}
+	return nil
+}
 
-	// Validate branch name
-	validate := exec.Command("git", "check-ref-format", "--branch", branchName)
-	validate.Dir = repoPath
-	if out, err := validate.CombinedOutput(); err != nil {
-		return fmt.Errorf("invalid branch name %q: %s", branchName, strings.TrimSpace(string(out)))
+// CreateWorktree creates a new git worktree at the specified path with a branch.
+// A new branch starts from baseRef, or the repository's HEAD when empty.
+// When sparsePaths is non-empty only those directories are checked out.
+func (g *GitOperations) CreateWorktree(repoPath, worktreePath, branchName, baseRef string, sparsePaths []string, pull bool) error {
+	slog.Debug("creating worktree", "repo_path", repoPath, "worktree_path", worktreePath, "branch", branchName, "base_ref", baseRef, "sparse_paths", sparsePaths, "pull", pull)
+
+	if err := g.checkBranchName(repoPath, branchName); err != nil {
+		return err
+	}
+
+	// Reuse a worktree left behind by a previous attempt for the same branch
+	reuse, err := g.reusableWorktree(worktreePath, branchName)
+	if err != nil {
+		return err
+	}
+	if reuse {
+		slog.Info("reusing existing worktree", "worktree_path", worktreePath, "branch", branchName)
+		return nil
 	}
 
-	// Pull latest changes from current branch
-	pullCmd := exec.Command("git", "pull")
-	pullCmd.Dir = repoPath
-	pullOutput, pullErr := pullCmd.CombinedOutput()
-	if pullErr != nil {
-		slog.Warn("failed to pull latest changes before creating worktree", "error", pullErr, "output", string(pullOutput))
-		// Continue anyway - might be network issues or new repo
+	// Pull latest changes from current branch, unless the caller wants the local state as is
+	if pull {
+		pullCmd := exec.Command("git", "pull")
+		pullCmd.Dir = repoPath
+		pullOutput, pullErr := g.combinedOutput(pullCmd)
+		if pullErr != nil {
+			slog.Warn("failed to pull latest changes before creating worktree", "error", pullErr, "output", string(pullOutput))
+			// Continue anyway - might be network issues or new repo
+		} else {
+			slog.Debug("pulled latest changes from current branch before creating worktree", "repo_path", repoPath)
+		}
 	} else {
-		slog.Debug("pulled latest changes from current branch before creating worktree", "repo_path", repoPath)
+		slog.Debug("skipping pull before creating worktree", "repo_path", repoPath)
 	}
 
 	// Create the worktree directory if it doesn't exist
@@ -58,19 +147,178 @@ func (g *GitOperations) CreateWorktree(repoPath, worktreePath, branchName string
 		return fmt.Errorf("failed to create worktree parent directory: %w", err)
 	}
 
-	// Create git worktree with new branch
-	cmd := exec.Command("git", "worktree", "add", "-b", branchName, worktreePath)
+	if err := g.validateSparsePaths(repoPath, sparsePaths); err != nil {
+		return err
+	}
+
+	// Create git worktree with new branch, or check out the branch if a previous attempt already created it
+	args := []string{"worktree", "add"}
+	if len(sparsePaths) > 0 {
+		// Defer the checkout until the sparse patterns are in place
+		args = append(args, "--no-checkout")
+	}
+	if g.BranchExists(repoPath, branchName) {
+		slog.Info("reusing existing branch for worktree", "branch", branchName)
+		args = append(args, worktreePath, branchName)
+	} else {
+		args = append(args, "-b", branchName, worktreePath)
+		if baseRef != "" {
+			args = append(args, baseRef)
+		}
+	}
+	cmd := exec.Command("git", args...)
 	cmd.Dir = repoPath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to create git worktree: %s", string(output))
 	}
 
+	if len(sparsePaths) > 0 {
+		if err := g.sparseCheckout(worktreePath, sparsePaths); err != nil {
+			return err
+		}
+	}
+
 	slog.Debug("worktree created successfully", "worktree_path", worktreePath, "branch", branchName)
 	return nil
 }
 
+// checkBranchName validates a branch name natively first, then lets git have the final say when available
+func (g *GitOperations) checkBranchName(repoPath, branchName string) error {
+	if err := validateBranchName(branchName); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		slog.Debug("git not found in PATH, skipping check-ref-format", "branch", branchName)
+		return nil
+	}
+	validate := exec.Command("git", "check-ref-format", "--branch", branchName)
+	validate.Dir = repoPath
+	if out, err := g.combinedOutput(validate); err != nil {
+		return fmt.Errorf("invalid branch name %q: %s", branchName, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reusableWorktree reports whether worktreePath already holds a worktree on branchName;
+// a worktree there on another branch is an error
+func (g *GitOperations) reusableWorktree(worktreePath, branchName string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(worktreePath, ".git")); err != nil {
+		return false, nil
+	}
+	if branch, err := g.GetCurrentBranch(worktreePath); err == nil && branch == branchName {
+		return true, nil
+	}
+	return false, fmt.Errorf("worktree path %s already exists and is not on branch %s", worktreePath, branchName)
+}
+
+// WorktreePlan describes what CreateWorktree would do for the same arguments
+type WorktreePlan struct {
+	ReuseWorktree bool   // An existing worktree on the branch would be reused as is
+	ReuseBranch   bool   // The branch already exists and would be checked out instead of created
+	BaseRef       string // Ref a new branch would start from; empty means the repository's HEAD
+}
+
+// PlanWorktree runs CreateWorktree's validation as a dry run: the branch name, the worktree path,
+// the sparse paths and the base ref are checked without pulling, creating or checking out anything
+func (g *GitOperations) PlanWorktree(repoPath, worktreePath, branchName, baseRef string, sparsePaths []string) (*WorktreePlan, error) {
+	slog.Debug("planning worktree", "repo_path", repoPath, "worktree_path", worktreePath, "branch", branchName, "base_ref", baseRef, "sparse_paths", sparsePaths)
+
+	if err := g.checkBranchName(repoPath, branchName); err != nil {
+		return nil, err
+	}
+	reuse, err := g.reusableWorktree(worktreePath, branchName)
+	if err != nil {
+		return nil, err
+	}
+	if reuse {
+		return &WorktreePlan{ReuseWorktree: true, ReuseBranch: true}, nil
+	}
+
+	if entries, err := os.ReadDir(worktreePath); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("worktree path %s already exists and is not empty", worktreePath)
+	}
+	if err := checkWritableAncestor(filepath.Dir(worktreePath)); err != nil {
+		return nil, err
+	}
+	if err := g.validateSparsePaths(repoPath, sparsePaths); err != nil {
+		return nil, err
+	}
+
+	if g.BranchExists(repoPath, branchName) {
+		return &WorktreePlan{ReuseBranch: true}, nil
+	}
+	if baseRef != "" && !g.RefExists(repoPath, baseRef) {
+		return nil, fmt.Errorf("base ref %q was not found in %s", baseRef, repoPath)
+	}
+	return &WorktreePlan{BaseRef: baseRef}, nil
+}
+
+// checkWritableAncestor checks that the closest existing directory on the way to dir accepts new
+// files, since MkdirAll would create the rest below it. The probe file is removed right away.
+func checkWritableAncestor(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	probe, err := os.CreateTemp(dir, ".codesession-probe-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// validateSparsePaths checks that every sparse path is a directory in the repository's HEAD
+func (g *GitOperations) validateSparsePaths(repoPath string, sparsePaths []string) error {
+	for _, sparsePath := range sparsePaths {
+		cleaned := path.Clean(strings.Trim(sparsePath, "/"))
+		if cleaned == "." || strings.HasPrefix(cleaned, "-") || strings.HasPrefix(cleaned, "..") {
+			return fmt.Errorf("invalid sparse path %q", sparsePath)
+		}
+		cmd := exec.Command("git", "cat-file", "-t", "HEAD:"+cleaned)
+		cmd.Dir = repoPath
+		output, err := g.combinedOutput(cmd)
+		if err != nil || strings.TrimSpace(string(output)) != "tree" {
+			return fmt.Errorf("sparse path %q is not a directory in %s", sparsePath, repoPath)
+		}
+	}
+	return nil
+}
+
+// sparseCheckout limits a worktree created with --no-checkout to the given directories and checks it out
+func (g *GitOperations) sparseCheckout(worktreePath string, sparsePaths []string) error {
+	slog.Debug("configuring sparse checkout", "worktree_path", worktreePath, "sparse_paths", sparsePaths)
+
+	setCmd := exec.Command("git", append([]string{"sparse-checkout", "set"}, sparsePaths...)...)
+	setCmd.Dir = worktreePath
+	if output, err := g.combinedOutput(setCmd); err != nil {
+		return fmt.Errorf("failed to configure sparse checkout (requires git 2.25+): %s", string(output))
+	}
+
+	checkoutCmd := exec.Command("git", "checkout")
+	checkoutCmd.Dir = worktreePath
+	if output, err := g.combinedOutput(checkoutCmd); err != nil {
+		return fmt.Errorf("failed to check out sparse worktree: %s", string(output))
+	}
+	return nil
+}
+
 // RemoveWorktree removes a git worktree at the specified path
 func (g *GitOperations) RemoveWorktree(repoPath, worktreePath string) error {
 	slog.Debug("removing worktree", "worktree_path", worktreePath)
@@ -85,7 +333,7 @@ func (g *GitOperations) RemoveWorktree(repoPath, worktreePath string) error {
 	cmd := exec.Command("git", "worktree", "remove", worktreePath, "--force")
 	cmd.Dir = repoPath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		slog.Warn("git worktree remove failed, falling back to manual removal", "error", err, "output", string(output))
 
@@ -99,63 +347,262 @@ func (g *GitOperations) RemoveWorktree(repoPath, worktreePath string) error {
 	return nil
 }
 
-// GetStatus gets the status of a git repository at the specified path
-func (g *GitOperations) GetStatus(worktreePath string) (*GitStatus, error) {
-	slog.Debug("getting git status", "worktree_path", worktreePath)
+// BranchExists reports whether a local branch exists in the repository
+func (g *GitOperations) BranchExists(repoPath, branchName string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/"+branchName)
+	cmd.Dir = repoPath
+	return g.run(cmd) == nil
+}
+
+// RemoteBranchExists checks whether a remote-tracking branch such as "origin/main" exists
+func (g *GitOperations) RemoteBranchExists(repoPath, branchName string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/remotes/"+branchName)
+	cmd.Dir = repoPath
+	return g.run(cmd) == nil
+}
+
+// DeleteBranch force-deletes a local branch in the repository
+func (g *GitOperations) DeleteBranch(repoPath, branchName string) error {
+	slog.Debug("deleting branch", "repo_path", repoPath, "branch", branchName)
+
+	if branchName == "main" || branchName == "master" {
+		return fmt.Errorf("refusing to delete branch %s", branchName)
+	}
+
+	cmd := exec.Command("git", "branch", "-D", branchName)
+	cmd.Dir = repoPath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to delete branch: %s", string(output))
+	}
+	return nil
+}
 
-	cmd := exec.Command("git", "status", "--porcelain=v1 -z")
+// RenameBranch renames the branch checked out in a worktree
+func (g *GitOperations) RenameBranch(worktreePath, oldName, newName string) error {
+	slog.Debug("renaming branch", "worktree_path", worktreePath, "from", oldName, "to", newName)
+
+	cmd := exec.Command("git", "branch", "-m", oldName, newName)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to rename branch %s to %s: %s", oldName, newName, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// GetRepositoryRoot returns the main repository directory that owns a worktree
+func (g *GitOperations) GetRepositoryRoot(worktreePath string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-common-dir")
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get git status: %s", string(output))
+		return "", fmt.Errorf("failed to resolve repository root: %s", string(output))
 	}
+	return filepath.Dir(strings.TrimSpace(string(output))), nil
+}
+
+// GetStatus gets the status of a git repository at the specified path
+func (g *GitOperations) GetStatus(worktreePath string) (*GitStatus, error) {
+	slog.Debug("getting git status", "worktree_path", worktreePath)
 
-	// Parse porcelain output
 	gitStatus := &GitStatus{
 		ModifiedFiles:  make([]string, 0),
 		UntrackedFiles: make([]string, 0),
 		StagedFiles:    make([]string, 0),
 	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if len(line) < 3 {
-			continue
+	err := g.scanStatus(worktreePath, func(stagingStatus, worktreeStatus byte, filename, origFile string) {
+		if origFile != "" {
+			filename = origFile + " -> " + filename
 		}
+		gitStatus.add(stagingStatus, worktreeStatus, filename)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		stagingStatus := line[0]
-		worktreeStatus := line[1]
-		filename := line[3:]
+	gitStatus.IsClean = gitStatus.TotalCount == 0
 
-		if stagingStatus != ' ' && stagingStatus != '?' {
-			gitStatus.StagedFiles = append(gitStatus.StagedFiles, filename)
-		}
-		if worktreeStatus == 'M' || worktreeStatus == 'D' {
-			gitStatus.ModifiedFiles = append(gitStatus.ModifiedFiles, filename)
+	slog.Debug("git status retrieved", "worktree_path", worktreePath, "is_clean", gitStatus.IsClean,
+		"modified_count", gitStatus.ModifiedCount, "untracked_count", gitStatus.UntrackedCount,
+		"staged_count", gitStatus.StagedCount, "truncated", gitStatus.Truncated())
+
+	return gitStatus, nil
+}
+
+// Each GitStatus file list keeps at most this many entries; the counts stay exact
+const maxStatusFiles = 500
+
+// add records one porcelain status entry, counting it even when its list is already full
+func (s *GitStatus) add(stagingStatus, worktreeStatus byte, filename string) {
+	s.TotalCount++
+	if stagingStatus != ' ' && stagingStatus != '?' {
+		s.StagedCount++
+		s.StagedFiles = appendCapped(s.StagedFiles, filename)
+	}
+	if worktreeStatus == 'M' || worktreeStatus == 'D' {
+		s.ModifiedCount++
+		s.ModifiedFiles = appendCapped(s.ModifiedFiles, filename)
+	}
+	if stagingStatus == '?' && worktreeStatus == '?' {
+		s.UntrackedCount++
+		s.UntrackedFiles = appendCapped(s.UntrackedFiles, filename)
+	}
+}
+
+// appendCapped appends to a status file list unless it already holds maxStatusFiles entries
+func appendCapped(files []string, file string) []string {
+	if len(files) >= maxStatusFiles {
+		return files
+	}
+	return append(files, file)
+}
+
+// Truncated reports whether any file list was capped at maxStatusFiles
+func (s *GitStatus) Truncated() bool {
+	return s.StagedCount > len(s.StagedFiles) || s.ModifiedCount > len(s.ModifiedFiles) || s.UntrackedCount > len(s.UntrackedFiles)
+}
+
+// formatFileList renders a file list, noting how many more files exist beyond those listed
+func formatFileList(files []string, total int) string {
+	list := strings.Join(files, "\n")
+	if total > len(files) {
+		list += fmt.Sprintf("\n…and %d more", total-len(files))
+	}
+	return list
+}
+
+// scanStatus streams `git status --porcelain=v1 -z` entry by entry so huge change sets are never held in memory at once.
+// Untracked directories are expanded into their files, and origFile is the source path of a rename or copy.
+func (g *GitOperations) scanStatus(worktreePath string, fn func(stagingStatus, worktreeStatus byte, filename, origFile string)) error {
+	defer g.acquire()()
+
+	cmd := exec.Command("git", "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	cmd.Dir = worktreePath
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get git status: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to get git status: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	scanner.Split(scanNUL)
+	for scanner.Scan() {
+		// The first column may be a significant space, so entries are not trimmed
+		entry := scanner.Text()
+		if len(entry) < 3 {
+			continue
 		}
-		if stagingStatus == '?' && worktreeStatus == '?' {
-			gitStatus.UntrackedFiles = append(gitStatus.UntrackedFiles, filename)
+		stagingStatus, worktreeStatus, filename := entry[0], entry[1], entry[3:]
+		// Renames and copies are followed by their source path as a separate entry
+		var origFile string
+		if isRenameOrCopy(stagingStatus) || isRenameOrCopy(worktreeStatus) {
+			if !scanner.Scan() {
+				break
+			}
+			origFile = scanner.Text()
 		}
+		fn(stagingStatus, worktreeStatus, filename, origFile)
+	}
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// drain so git can exit
+		io.Copy(io.Discard, stdout)
 	}
 
-	gitStatus.IsClean = len(gitStatus.ModifiedFiles) == 0 && len(gitStatus.UntrackedFiles) == 0 && len(gitStatus.StagedFiles) == 0
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to get git status: %s", stderr.String())
+	}
+	if scanErr != nil {
+		return fmt.Errorf("failed to read git status: %w", scanErr)
+	}
+	return nil
+}
 
-	slog.Debug("git status retrieved", "worktree_path", worktreePath, "is_clean", gitStatus.IsClean,
-		"modified_count", len(gitStatus.ModifiedFiles), "untracked_count", len(gitStatus.UntrackedFiles),
-		"staged_count", len(gitStatus.StagedFiles))
+// isRenameOrCopy reports whether a porcelain status column marks a rename or copy
+func isRenameOrCopy(status byte) bool {
+	return status == 'R' || status == 'C'
+}
 
-	return gitStatus, nil
+// scanNUL is a bufio.SplitFunc for NUL-terminated records
+func scanNUL(data []byte, atEOF bool) (int, []byte, error) {
+	if idx := bytes.IndexByte(data, 0); idx >= 0 {
+		return idx + 1, data[:idx], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
 
-// AddAll stages all changes in the repository
+// matchesPathPattern reports whether a repository-relative file matches a pattern.
+// Patterns ending in "/" match everything under that directory, patterns without
+// a "/" match the file name anywhere, and all others are globs against the full path.
+func matchesPathPattern(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(file, pattern)
+	}
+	if matched, _ := path.Match(pattern, file); matched {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(file))
+		return matched
+	}
+	return false
+}
+
+// ProtectedFilesTouched returns the changed files matching any of the protected path patterns.
+// It scans the full status rather than GitStatus's capped lists so no change slips through.
+func (g *GitOperations) ProtectedFilesTouched(worktreePath string, patterns []string) ([]string, error) {
+	return g.ChangedFilesMatching(worktreePath, func(file string) bool {
+		for _, pattern := range patterns {
+			if matchesPathPattern(pattern, file) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ChangedFilesMatching returns every changed file (staged, unstaged or untracked) for which match is true
+func (g *GitOperations) ChangedFilesMatching(worktreePath string, match func(file string) bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var matched []string
+	err := g.scanStatus(worktreePath, func(_, _ byte, filename, origFile string) {
+		for _, file := range []string{filename, origFile} {
+			if file == "" || seen[file] {
+				continue
+			}
+			seen[file] = true
+			if match(file) {
+				matched = append(matched, file)
+			}
+		}
+	})
+	return matched, err
+}
+
+// Pathspecs that keep the bot's own data directories out of every commit, wherever they sit in
+// the worktree and whatever .gitignore says
+var botDataExcludes = []string{":(exclude,glob)**/.worktrees/**", ":(exclude,glob)**/.sessions/**"}
+
+// AddAll stages all changes in the repository except the bot's data directories
 func (g *GitOperations) AddAll(worktreePath string) error {
 	slog.Debug("staging all changes", "worktree_path", worktreePath)
 
-	cmd := exec.Command("git", "add", ".")
+	cmd := exec.Command("git", append([]string{"add", "--", "."}, botDataExcludes...)...)
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to stage changes: %s", string(output))
 	}
@@ -164,14 +611,49 @@ func (g *GitOperations) AddAll(worktreePath string) error {
 	return nil
 }
 
-// Commit creates a commit with the specified message and returns the commit hash
-func (g *GitOperations) Commit(worktreePath, message string) (string, error) {
+// AddTracked stages modifications and deletions of tracked files only, leaving untracked files out
+func (g *GitOperations) AddTracked(worktreePath string) error {
+	slog.Debug("staging tracked changes", "worktree_path", worktreePath)
+
+	cmd := exec.Command("git", append([]string{"add", "-u", "--", "."}, botDataExcludes...)...)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to stage tracked changes: %s", string(output))
+	}
+
+	slog.Debug("tracked changes staged successfully", "worktree_path", worktreePath)
+	return nil
+}
+
+// CommitTrailer is a "Key: value" line appended to the end of a commit message
+type CommitTrailer struct {
+	Key   string
+	Value string
+}
+
+// appendTrailers adds trailers to a commit message as a final paragraph
+func appendTrailers(message string, trailers []CommitTrailer) string {
+	if len(trailers) == 0 {
+		return message
+	}
+	lines := make([]string, 0, len(trailers))
+	for _, trailer := range trailers {
+		lines = append(lines, fmt.Sprintf("%s: %s", trailer.Key, trailer.Value))
+	}
+	return strings.TrimRight(message, "\n") + "\n\n" + strings.Join(lines, "\n")
+}
+
+// Commit creates a commit with the specified message and trailers and returns the commit hash
+func (g *GitOperations) Commit(worktreePath, message string, trailers []CommitTrailer) (string, error) {
+	message = appendTrailers(message, trailers)
 	slog.Debug("creating commit", "worktree_path", worktreePath, "message", message)
 
 	cmd := exec.Command("git", "commit", "-m", message, "--author", "codesessions <bot@codesessions.com>", "--no-verify")
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return "", fmt.Errorf("%s", string(output))
 	}
@@ -180,7 +662,7 @@ func (g *GitOperations) Commit(worktreePath, message string) (string, error) {
 	hashCmd := exec.Command("git", "rev-parse", "HEAD")
 	hashCmd.Dir = worktreePath
 
-	hashOutput, err := hashCmd.CombinedOutput()
+	hashOutput, err := g.combinedOutput(hashCmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to get commit hash: %s", string(hashOutput))
 	}
@@ -190,6 +672,48 @@ func (g *GitOperations) Commit(worktreePath, message string) (string, error) {
 	return commitHash, nil
 }
 
+// Amend replaces the message of the HEAD commit and returns its new hash. Staged changes are left
+// out of the amended commit (--only without paths).
+func (g *GitOperations) Amend(worktreePath, message string, trailers []CommitTrailer) (string, error) {
+	message = appendTrailers(message, trailers)
+	slog.Debug("amending commit", "worktree_path", worktreePath, "message", message)
+
+	cmd := exec.Command("git", "commit", "--amend", "--only", "-m", message, "--author", "codesessions <bot@codesessions.com>", "--no-verify")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("%s", string(output))
+	}
+	return g.GetCommitHash(worktreePath)
+}
+
+// IsCommitPushed reports whether a remote-tracking branch already contains the commit
+func (g *GitOperations) IsCommitPushed(worktreePath, hash string) (bool, error) {
+	cmd := exec.Command("git", "branch", "-r", "--contains", hash)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return false, fmt.Errorf("failed to check remote branches: %s", string(output))
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
+// ForcePush overwrites the remote branch with the local one, unless someone else pushed to it since
+// it was last fetched (--force-with-lease)
+func (g *GitOperations) ForcePush(worktreePath, branch string) error {
+	cmd := exec.Command("git", "push", "--force-with-lease", "origin", branch)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to force push to remote: %s", string(output))
+	}
+	slog.Debug("force pushed to remote", "worktree_path", worktreePath, "branch", branch)
+	return nil
+}
+
 // GetCurrentBranch returns the current branch name
 func (g *GitOperations) GetCurrentBranch(worktreePath string) (string, error) {
 	slog.Debug("getting current branch", "worktree_path", worktreePath)
@@ -197,7 +721,7 @@ func (g *GitOperations) GetCurrentBranch(worktreePath string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to get current branch: %s", string(output))
 	}
@@ -214,30 +738,31 @@ func (g *GitOperations) Push(worktreePath, branch string) error {
 	// Fetch latest remote state
 	fetchCmd := exec.Command("git", "fetch", "origin", branch)
 	fetchCmd.Dir = worktreePath
-	fetchOutput, fetchErr := fetchCmd.CombinedOutput()
+	fetchOutput, fetchErr := g.combinedOutput(fetchCmd)
 	if fetchErr != nil {
 		slog.Warn("failed to fetch before push", "error", fetchErr, "output", string(fetchOutput))
 		// Continue with push - might be a new branch
 	} else {
 		slog.Debug("fetched latest remote state", "worktree_path", worktreePath, "branch", branch)
-		
-		// Reset to remote state to accept remote as source of truth (coding agent philosophy)
-		// This ensures human changes/fixes take precedence over agent changes
-		resetCmd := exec.Command("git", "reset", "--hard", "origin/"+branch)
-		resetCmd.Dir = worktreePath
-		resetOutput, resetErr := resetCmd.CombinedOutput()
-		if resetErr != nil {
-			slog.Warn("failed to reset to remote state", "error", resetErr, "output", string(resetOutput))
-			// Continue with push anyway
-		} else {
-			slog.Debug("reset to remote state successfully", "worktree_path", worktreePath, "branch", branch)
+
+		// Replay local commits on top of changes pushed by others instead of discarding either side
+		rebaseCmd := exec.Command("git", "rebase", "--autostash", "origin/"+branch)
+		rebaseCmd.Dir = worktreePath
+		if rebaseOutput, rebaseErr := g.combinedOutput(rebaseCmd); rebaseErr != nil {
+			abortCmd := exec.Command("git", "rebase", "--abort")
+			abortCmd.Dir = worktreePath
+			if abortOutput, abortErr := g.combinedOutput(abortCmd); abortErr != nil {
+				slog.Warn("failed to abort rebase", "error", abortErr, "output", string(abortOutput))
+			}
+			return fmt.Errorf("remote branch %s has diverged and could not be rebased onto: %s", branch, strings.TrimSpace(string(rebaseOutput)))
 		}
+		slog.Debug("rebased onto remote state", "worktree_path", worktreePath, "branch", branch)
 	}
 
 	cmd := exec.Command("git", "push", "origin", branch)
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		// Check if it's just "already up to date"
 		if strings.Contains(string(output), "up-to-date") {
@@ -251,6 +776,17 @@ func (g *GitOperations) Push(worktreePath, branch string) error {
 	return nil
 }
 
+// FetchBranch updates the remote-tracking ref origin/<branch>
+func (g *GitOperations) FetchBranch(worktreePath, branch string) error {
+	cmd := exec.Command("git", "fetch", "origin", branch)
+	cmd.Dir = worktreePath
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %s", branch, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // GetCommitHash returns the hash of the current HEAD commit
 func (g *GitOperations) GetCommitHash(worktreePath string) (string, error) {
 	slog.Debug("getting commit hash", "worktree_path", worktreePath)
@@ -258,7 +794,7 @@ func (g *GitOperations) GetCommitHash(worktreePath string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "HEAD")
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to get commit hash: %s", string(output))
 	}
@@ -268,15 +804,27 @@ func (g *GitOperations) GetCommitHash(worktreePath string) (string, error) {
 	return commitHash, nil
 }
 
+// GetDiff's output when the working tree has no tracked changes
+const noChangesDiff = "No changes to show."
+
+// Returned by GetStagedDiff when nothing is staged
+const noStagedChangesDiff = "No staged changes to show."
+
 // GetDiff returns the diff of changes in the repository
-func (g *GitOperations) GetDiff(worktreePath string) (string, error) {
-	slog.Debug("getting git diff", "worktree_path", worktreePath)
+func (g *GitOperations) GetDiff(worktreePath string, paths ...string) (string, error) {
+	slog.Debug("getting git diff", "worktree_path", worktreePath, "paths", paths)
 
-	// Execute git diff in the worktree directory
-	cmd := exec.Command("git", "diff", "--minimal", "--ignore-all-space", "--diff-filter=ACMR")
+	// Execute git diff in the worktree directory, limited to paths when given. A diff of specific
+	// paths includes deletions, so a deleted file shows its removal rather than no changes.
+	args := []string{"diff", "--minimal", "--ignore-all-space", "--no-ext-diff", "--diff-filter=ACMR"}
+	if len(paths) > 0 {
+		args[len(args)-1] = "--diff-filter=ACMRD"
+		args = append(append(args, "--"), paths...)
+	}
+	cmd := exec.Command("git", args...)
 	cmd.Dir = worktreePath
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute git diff: %w", err)
 	}
@@ -284,16 +832,533 @@ func (g *GitOperations) GetDiff(worktreePath string) (string, error) {
 	diffOutput := strings.TrimSpace(string(output))
 
 	if diffOutput == "" {
-		return "No changes to show.", nil
+		return noChangesDiff, nil
 	}
 
-	// Return the raw diff output - let the sender handle code block formatting
-	result := diffOutput
+	// Return the diff with binary content omitted - let the sender handle code block formatting
+	result := omitBinaryDiffs(diffOutput)
 
 	slog.Debug("git diff executed successfully", "worktree_path", worktreePath, "diff_length", len(result))
 
 	return result, nil
 }
 
+// GetRemoteURL returns the URL of the origin remote
+func (g *GitOperations) GetRemoteURL(worktreePath string) (string, error) {
+	cmd := exec.Command("git", "remote", "get-url", "origin")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote url: %s", string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ListRemotes returns the output of git remote -v with credentials in the URLs redacted
+func (g *GitOperations) ListRemotes(worktreePath string) (string, error) {
+	cmd := exec.Command("git", "remote", "-v")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to list remotes: %s", string(output))
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		fields[1] = redactRemoteURL(fields[1])
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// redactRemoteURL hides the user info (tokens, passwords) embedded in an http(s) remote URL
+func redactRemoteURL(remoteURL string) string {
+	scheme, rest, found := strings.Cut(remoteURL, "://")
+	if !found {
+		return remoteURL
+	}
+	host, path, hasPath := strings.Cut(rest, "/")
+	at := strings.LastIndex(host, "@")
+	if at == -1 {
+		return remoteURL
+	}
+	redacted := scheme + "://***@" + host[at+1:]
+	if hasPath {
+		redacted += "/" + path
+	}
+	return redacted
+}
+
+// TrackingInfo describes the current branch of a worktree and the upstream it pushes to
+type TrackingInfo struct {
+	Branch   string // Empty on a detached HEAD
+	Upstream string // Empty when the branch tracks nothing
+	Ahead    int
+	Behind   int
+	Gone     bool // The upstream is configured but no longer exists on the remote
+}
+
+// GetTrackingInfo returns the worktree's branch and upstream tracking state
+func (g *GitOperations) GetTrackingInfo(worktreePath string) (*TrackingInfo, error) {
+	cmd := exec.Command("git", "status", "--porcelain=v2", "--branch", "--untracked-files=no")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracking info: %s", string(output))
+	}
+	return parseTrackingInfo(string(output)), nil
+}
+
+// parseTrackingInfo reads the "# branch.*" headers of git status --porcelain=v2 --branch
+func parseTrackingInfo(output string) *TrackingInfo {
+	info := &TrackingInfo{}
+	hasCounts := false
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "# "), " ")
+		if !found || !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		switch key {
+		case "branch.head":
+			if value != "(detached)" {
+				info.Branch = value
+			}
+		case "branch.upstream":
+			info.Upstream = value
+		case "branch.ab":
+			// "+<ahead> -<behind>"
+			if _, err := fmt.Sscanf(value, "+%d -%d", &info.Ahead, &info.Behind); err == nil {
+				hasCounts = true
+			}
+		}
+	}
+	// git omits the counts when the upstream ref is missing
+	info.Gone = info.Upstream != "" && !hasCounts
+	return info
+}
+
+// CountCommitFiles returns the number of files changed by a commit
+func (g *GitOperations) CountCommitFiles(worktreePath, hash string) (int, error) {
+	cmd := exec.Command("git", "diff-tree", "--no-commit-id", "--name-only", "-r", "--root", hash)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list commit files: %s", string(output))
+	}
+	trimmed := strings.TrimSpace(string(output))
+	if trimmed == "" {
+		return 0, nil
+	}
+	return len(strings.Split(trimmed, "\n")), nil
+}
+
+// remoteCommitURL converts a GitHub/GitLab-style remote URL into a web link for a commit.
+// Returns "" when the remote cannot be mapped to a web URL.
+func remoteCommitURL(remoteURL, hash string) string {
+	base := strings.TrimSuffix(strings.TrimSpace(remoteURL), ".git")
+	switch {
+	case strings.HasPrefix(base, "git@"):
+		// git@host:owner/repo -> https://host/owner/repo
+		hostAndPath := strings.TrimPrefix(base, "git@")
+		host, path, found := strings.Cut(hostAndPath, ":")
+		if !found {
+			return ""
+		}
+		base = "https://" + host + "/" + path
+	case strings.HasPrefix(base, "https://"), strings.HasPrefix(base, "http://"):
+		// strip embedded credentials
+		if scheme, rest, found := strings.Cut(base, "://"); found {
+			if at := strings.Index(rest, "@"); at != -1 {
+				rest = rest[at+1:]
+			}
+			base = scheme + "://" + rest
+		}
+	default:
+		return ""
+	}
+	return base + "/commit/" + hash
+}
+
+// allowedGitConfigKeys lists the worktree git config keys users may read and change
+var allowedGitConfigKeys = []string{
+	"user.name",
+	"user.email",
+	"commit.gpgsign",
+	"core.autocrlf",
+	"core.filemode",
+	"core.eol",
+}
+
+// isAllowedGitConfigKey reports whether a git config key is in the allow-list
+func isAllowedGitConfigKey(key string) bool {
+	for _, allowed := range allowedGitConfigKeys {
+		if strings.EqualFold(key, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfig returns the effective value of a git config key in the worktree, or "" when unset
+func (g *GitOperations) GetConfig(worktreePath, key string) (string, error) {
+	if !isAllowedGitConfigKey(key) {
+		return "", fmt.Errorf("git config key %q is not allowed", key)
+	}
+
+	cmd := exec.Command("git", "config", "--get", key)
+	cmd.Dir = worktreePath
+
+	output, err := g.output(cmd)
+	if err != nil {
+		// exit code 1 means the key is not set
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get git config %s: %w", key, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Returned by SetConfig when worktree-scoped config is off and may not be enabled
+var errWorktreeConfigDisabled = errors.New("worktree config is not enabled for this repository")
+
+// SetConfig sets a git config key for the worktree only. Worktree-scoped config
+// (extensions.worktreeConfig) keeps the value from leaking into the shared repository config.
+// Turning the extension on is itself a write to the shared config, so it only happens when
+// enableExtension is set; otherwise SetConfig returns errWorktreeConfigDisabled.
+func (g *GitOperations) SetConfig(worktreePath, key, value string, enableExtension bool) error {
+	if !isAllowedGitConfigKey(key) {
+		return fmt.Errorf("git config key %q is not allowed", key)
+	}
+	slog.Debug("setting worktree git config", "worktree_path", worktreePath, "key", key)
+
+	checkCmd := exec.Command("git", "config", "--bool", "--get", "extensions.worktreeConfig")
+	checkCmd.Dir = worktreePath
+	output, _ := g.output(checkCmd)
+	if strings.TrimSpace(string(output)) != "true" {
+		if !enableExtension {
+			return errWorktreeConfigDisabled
+		}
+		slog.Info("enabling extensions.worktreeConfig in the shared repository config", "worktree_path", worktreePath)
+		enableCmd := exec.Command("git", "config", "extensions.worktreeConfig", "true")
+		enableCmd.Dir = worktreePath
+		if output, err := g.combinedOutput(enableCmd); err != nil {
+			return fmt.Errorf("failed to enable worktree config: %s", string(output))
+		}
+	}
+
+	cmd := exec.Command("git", "config", "--worktree", key, value)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set git config %s: %s", key, string(output))
+	}
+	return nil
+}
+
+// Show returns the patch and file stat of a commit
+func (g *GitOperations) Show(worktreePath, hash string) (string, error) {
+	slog.Debug("showing commit", "worktree_path", worktreePath, "hash", hash)
+
+	cmd := exec.Command("git", "show", "--stat", "--patch", "--no-ext-diff", "--format=commit %H%nAuthor: %an <%ae>%nDate: %ad%n%n%w(0,4,4)%B", hash)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to show commit %s: %s", hash, string(output))
+	}
+	return omitBinaryDiffs(strings.TrimSpace(string(output))), nil
+}
+
+// GetStagedDiff returns the diff of changes staged in the index
+func (g *GitOperations) GetStagedDiff(worktreePath string, paths ...string) (string, error) {
+	slog.Debug("getting staged git diff", "worktree_path", worktreePath, "paths", paths)
+
+	args := []string{"diff", "--cached", "--minimal", "--ignore-all-space", "--no-ext-diff"}
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git diff --cached: %w", err)
+	}
+
+	diffOutput := strings.TrimSpace(string(output))
+	if diffOutput == "" {
+		return noStagedChangesDiff, nil
+	}
+
+	slog.Debug("staged git diff executed successfully", "worktree_path", worktreePath, "diff_length", len(diffOutput))
+	return omitBinaryDiffs(diffOutput), nil
+}
+
+// IsTracked reports whether path is known to git in the worktree's index
+func (g *GitOperations) IsTracked(worktreePath, path string) bool {
+	cmd := exec.Command("git", "ls-files", "--error-unmatch", "--", path)
+	cmd.Dir = worktreePath
+	_, err := g.output(cmd)
+	return err == nil
+}
+
 // Global GitOperations instance
 var gitOps = NewGitOperations()
+
+// GetBaseBranch returns the branch the remote's HEAD points to (e.g. "origin/main"),
+// falling back to a local main or master branch
+func (g *GitOperations) GetBaseBranch(worktreePath string) (string, error) {
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD")
+	cmd.Dir = worktreePath
+	if output, err := g.output(cmd); err == nil {
+		if base := strings.TrimSpace(string(output)); base != "" {
+			return base, nil
+		}
+	}
+
+	for _, candidate := range []string{"main", "master"} {
+		if g.BranchExists(worktreePath, candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("could not determine the base branch")
+}
+
+// RefExists reports whether a ref resolves to a commit
+func (g *GitOperations) RefExists(worktreePath, ref string) bool {
+	if strings.HasPrefix(ref, "-") {
+		return false
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = worktreePath
+	return g.run(cmd) == nil
+}
+
+// AheadBehind returns how many commits HEAD is ahead of and behind the target ref
+func (g *GitOperations) AheadBehind(worktreePath, target string) (int, int, error) {
+	cmd := exec.Command("git", "rev-list", "--left-right", "--count", target+"...HEAD")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count commits against %s: %s", target, string(output))
+	}
+	return parseAheadBehind(string(output))
+}
+
+// CountCommits returns how many commits are reachable from to but not from from (from..to)
+func (g *GitOperations) CountCommits(worktreePath, from, to string) (int, error) {
+	cmd := exec.Command("git", "rev-list", "--count", from+".."+to)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count commits %s..%s: %s", from, to, string(output))
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rev-list output: %q", output)
+	}
+	return count, nil
+}
+
+// MergeBase returns the best common ancestor of HEAD and target
+func (g *GitOperations) MergeBase(worktreePath, target string) (string, error) {
+	cmd := exec.Command("git", "merge-base", "HEAD", target)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to find merge base with %s: %s", target, string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// IsAncestor reports whether ancestor is an ancestor of (or the same commit as) descendant
+func (g *GitOperations) IsAncestor(worktreePath, ancestor, descendant string) (bool, error) {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", ancestor, descendant)
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		// exit code 1 means it is not an ancestor
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check whether %s is an ancestor of %s: %s", ancestor, descendant, string(output))
+	}
+	return true, nil
+}
+
+// parseAheadBehind parses `git rev-list --left-right --count target...HEAD` output ("behind<TAB>ahead")
+func parseAheadBehind(output string) (int, int, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rev-list output: %q", output)
+	}
+	behind, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output: %q", output)
+	}
+	ahead, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output: %q", output)
+	}
+	return ahead, behind, nil
+}
+
+// DiffStat returns the --stat summary of what HEAD adds over the merge base with target
+func (g *GitOperations) DiffStat(worktreePath, target string) (string, error) {
+	cmd := exec.Command("git", "diff", "--stat", target+"...HEAD")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to diff against %s: %s", target, string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// UncommittedLineCounts returns how many lines the uncommitted changes to tracked files add and
+// delete relative to HEAD. Binary files count as no lines.
+func (g *GitOperations) UncommittedLineCounts(worktreePath string) (int, int, error) {
+	cmd := exec.Command("git", "diff", "HEAD", "--numstat", "--no-ext-diff")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count changed lines: %s", string(output))
+	}
+
+	var added, deleted int
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		// Binary files report "-" instead of counts
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			added += n
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			deleted += n
+		}
+	}
+	return added, deleted, nil
+}
+
+// GetBranchDiff returns the diff of what HEAD adds over the merge base with target
+func (g *GitOperations) GetBranchDiff(worktreePath, target string) (string, error) {
+	slog.Debug("getting branch diff", "worktree_path", worktreePath, "target", target)
+
+	cmd := exec.Command("git", "diff", "--minimal", "--ignore-all-space", "--no-ext-diff", target+"...HEAD")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to diff against %s: %s", target, string(output))
+	}
+
+	diffOutput := strings.TrimSpace(string(output))
+	if diffOutput == "" {
+		return noBranchChangesDiff(target), nil
+	}
+	return omitBinaryDiffs(diffOutput), nil
+}
+
+// noBranchChangesDiff is returned by GetBranchDiff when the branch has no commits over target
+func noBranchChangesDiff(target string) string {
+	return fmt.Sprintf("No committed changes over %s.", target)
+}
+
+// omitBinaryDiffs replaces the sections of a diff that concern binary files with a
+// "(binary) path" list at the end, so raw bytes or binary patches never reach Discord
+func omitBinaryDiffs(diff string) string {
+	sections := strings.Split(diff, "\ndiff --git ")
+	var kept, binaries []string
+	for idx, section := range sections {
+		if idx > 0 {
+			section = "diff --git " + section
+		}
+		if !strings.HasPrefix(section, "diff --git ") || !isBinaryDiffSection(section) {
+			kept = append(kept, section)
+			continue
+		}
+		binaries = append(binaries, "(binary) "+diffSectionPath(section))
+	}
+	if len(binaries) == 0 {
+		return diff
+	}
+
+	result := strings.Join(kept, "\n")
+	if result != "" {
+		result += "\n\n"
+	}
+	return result + "Binary files (content omitted):\n" + strings.Join(binaries, "\n")
+}
+
+// isBinaryDiffSection reports whether a single-file diff section is for a binary file
+func isBinaryDiffSection(section string) bool {
+	if !utf8.ValidString(section) || strings.ContainsRune(section, 0) {
+		return true
+	}
+	for _, line := range strings.Split(section, "\n") {
+		if line == "GIT binary patch" || (strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSectionPath extracts the (new) file path from a "diff --git a/<path> b/<path>" header
+func diffSectionPath(section string) string {
+	header, _, _ := strings.Cut(section, "\n")
+	header = strings.TrimPrefix(header, "diff --git ")
+	if idx := strings.LastIndex(header, " b/"); idx >= 0 {
+		return header[idx+len(" b/"):]
+	}
+	return header
+}
+
+// CommitLog returns the one-line log of commits on HEAD that are not on target
+func (g *GitOperations) CommitLog(worktreePath, target string) (string, error) {
+	cmd := exec.Command("git", "log", "--format=%h %s", target+"..HEAD")
+	cmd.Dir = worktreePath
+
+	output, err := g.combinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to list commits since %s: %s", target, string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// EnsureRef checks that a ref resolves to a commit, fetching tags from origin once if it does not
+func (g *GitOperations) EnsureRef(repoPath, ref string) error {
+	if g.RefExists(repoPath, ref) {
+		return nil
+	}
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+
+	slog.Debug("ref not found locally, fetching tags", "repo_path", repoPath, "ref", ref)
+	cmd := exec.Command("git", "fetch", "--tags", "origin")
+	cmd.Dir = repoPath
+	if output, err := g.combinedOutput(cmd); err != nil {
+		slog.Warn("failed to fetch tags", "repo_path", repoPath, "error", err, "output", string(output))
+	}
+	if !g.RefExists(repoPath, ref) {
+		return fmt.Errorf("ref %q not found", ref)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ module codesession
 
 go 1.24
 
-require github.com/BurntSushi/toml v1.5.0
+require (
+	github.com/BurntSushi/toml v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/tidwall/gjson v1.14.4 // indirect
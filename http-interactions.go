@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Interaction payloads larger than this are rejected
+const maxInteractionBodySize = 1 << 20
+
+// How long the endpoint waits for a handler's initial response. Discord fails the interaction
+// when the endpoint has not answered within 3 seconds.
+const interactionResponseWait = 2500 * time.Millisecond
+
+// initialResponse is the body a handler sent to the interaction callback endpoint
+type initialResponse struct {
+	contentType string
+	body        []byte
+}
+
+// Interactions received over HTTP still waiting for their initial response, by interaction ID
+var pendingHTTPInteractions = make(map[string]chan initialResponse)
+var pendingHTTPInteractionsMutex sync.Mutex
+
+// callbackInterceptor hands the initial responses of interactions received over HTTP to the
+// waiting endpoint request, which must carry them in its own response body. Every other
+// request, including late callbacks, goes to Discord's REST API.
+type callbackInterceptor struct {
+	next http.RoundTripper
+}
+
+func (c callbackInterceptor) RoundTrip(req *http.Request) (*http.Response, error) {
+	interactionID, ok := interactionCallbackID(req)
+	if !ok {
+		return c.next.RoundTrip(req)
+	}
+	pendingHTTPInteractionsMutex.Lock()
+	responses, pending := pendingHTTPInteractions[interactionID]
+	delete(pendingHTTPInteractions, interactionID)
+	pendingHTTPInteractionsMutex.Unlock()
+	if !pending {
+		return c.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	responses <- initialResponse{contentType: req.Header.Get("Content-Type"), body: body}
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// interactionCallbackID returns the interaction ID of a request to the interaction callback
+// endpoint, /interactions/<id>/<token>/callback
+func interactionCallbackID(req *http.Request) (string, bool) {
+	if req.Method != http.MethodPost {
+		return "", false
+	}
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(segments) < 4 || segments[len(segments)-1] != "callback" || segments[len(segments)-4] != "interactions" {
+		return "", false
+	}
+	return segments[len(segments)-3], true
+}
+
+// parsePublicKey decodes the hex-encoded Ed25519 application public key from the developer portal
+func parsePublicKey(hexKey string) (ed25519.PublicKey, error) {
+	if hexKey == "" {
+		return nil, fmt.Errorf("application_public_key is required when interaction_mode is %q", InteractionModeHTTP)
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("application_public_key is not valid hex: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("application_public_key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifyInteractionSignature checks Discord's Ed25519 signature over timestamp+body
+func verifyInteractionSignature(publicKey ed25519.PublicKey, signatureHex, timestamp string, body []byte) bool {
+	if signatureHex == "" || timestamp == "" {
+		return false
+	}
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	message := append([]byte(timestamp), body...)
+	return ed25519.Verify(publicKey, message, signature)
+}
+
+// interactionsHTTPHandler serves Discord's interactions endpoint. Pings are answered inline;
+// commands are dispatched to InteractionHandlers, which respond through the REST callback
+// endpoint exactly as they do for gateway interactions.
+func interactionsHTTPHandler(s *discordgo.Session, publicKey ed25519.PublicKey) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxInteractionBodySize))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if !verifyInteractionSignature(publicKey, r.Header.Get("X-Signature-Ed25519"), r.Header.Get("X-Signature-Timestamp"), body) {
+			slog.Warn("rejected interaction with invalid signature", "remote_addr", r.RemoteAddr)
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+
+		var interaction discordgo.Interaction
+		if err := json.Unmarshal(body, &interaction); err != nil {
+			slog.Error("failed to decode interaction", "error", err)
+			http.Error(w, "invalid interaction", http.StatusBadRequest)
+			return
+		}
+
+		switch interaction.Type {
+		case discordgo.InteractionPing:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(discordgo.InteractionResponse{Type: discordgo.InteractionResponsePong})
+		case discordgo.InteractionApplicationCommand, discordgo.InteractionMessageComponent:
+			writeHandlerResponse(w, s, &interaction)
+		default:
+			slog.Debug("ignoring unsupported interaction type", "type", interaction.Type)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+}
+
+// writeHandlerResponse dispatches an interaction to InteractionHandlers and answers the request
+// with the handler's initial response. A handler that has not responded in time gets a deferred
+// response instead; its own response then reaches Discord too late and fails.
+func writeHandlerResponse(w http.ResponseWriter, s *discordgo.Session, interaction *discordgo.Interaction) {
+	responses := make(chan initialResponse, 1)
+	pendingHTTPInteractionsMutex.Lock()
+	pendingHTTPInteractions[interaction.ID] = responses
+	pendingHTTPInteractionsMutex.Unlock()
+
+	go InteractionHandlers(s, &discordgo.InteractionCreate{Interaction: interaction})
+
+	select {
+	case response := <-responses:
+		w.Header().Set("Content-Type", response.contentType)
+		w.Write(response.body)
+		return
+	case <-time.After(interactionResponseWait):
+	}
+
+	pendingHTTPInteractionsMutex.Lock()
+	_, pending := pendingHTTPInteractions[interaction.ID]
+	delete(pendingHTTPInteractions, interaction.ID)
+	pendingHTTPInteractionsMutex.Unlock()
+	if !pending {
+		// The handler responded just as the wait ran out
+		response := <-responses
+		w.Header().Set("Content-Type", response.contentType)
+		w.Write(response.body)
+		return
+	}
+
+	slog.Warn("interaction handler did not respond in time, deferring", "interaction_id", interaction.ID, "type", interaction.Type)
+	responseType := discordgo.InteractionResponseDeferredChannelMessageWithSource
+	if interaction.Type == discordgo.InteractionMessageComponent {
+		responseType = discordgo.InteractionResponseDeferredMessageUpdate
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discordgo.InteractionResponse{Type: responseType})
+}
+
+// startInteractionsServer starts the HTTP interactions endpoint. TLS is expected to be
+// terminated by a reverse proxy in front of the listen address. It fails when the listen
+// address cannot be bound, so a misconfigured endpoint stops startup.
+func startInteractionsServer(s *discordgo.Session) (*http.Server, error) {
+	publicKey, err := parsePublicKey(AppConfig.ApplicationPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", AppConfig.InteractionsListen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s for interactions: %w", AppConfig.InteractionsListen, err)
+	}
+
+	// Initial responses go back in the body of the endpoint's HTTP response
+	client := *s.Client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = callbackInterceptor{next: next}
+	s.Client = &client
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/interactions", interactionsHTTPHandler(s, publicKey))
+	server := &http.Server{
+		Addr:              AppConfig.InteractionsListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("interactions endpoint listening", "addr", listener.Addr().String(), "path", "/interactions")
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("interactions endpoint stopped, slash commands are no longer received", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// stopInteractionsServer gracefully shuts down the HTTP interactions endpoint
+func stopInteractionsServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to shut down interactions endpoint", "error", err)
+	}
+}
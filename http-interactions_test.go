@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestVerifyInteractionSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"type":1}`)
+	timestamp := "1700000000"
+	signature := hex.EncodeToString(ed25519.Sign(privateKey, append([]byte(timestamp), body...)))
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		body      []byte
+		want      bool
+	}{
+		{name: "valid", signature: signature, timestamp: timestamp, body: body, want: true},
+		{name: "tampered body", signature: signature, timestamp: timestamp, body: []byte(`{"type":2}`), want: false},
+		{name: "other timestamp", signature: signature, timestamp: "1700000001", body: body, want: false},
+		{name: "missing signature", signature: "", timestamp: timestamp, body: body, want: false},
+		{name: "missing timestamp", signature: signature, timestamp: "", body: body, want: false},
+		{name: "not hex", signature: "zz", timestamp: timestamp, body: body, want: false},
+		{name: "short signature", signature: signature[:10], timestamp: timestamp, body: body, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyInteractionSignature(publicKey, tt.signature, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("verifyInteractionSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInteractionCallbackID(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		wantID string
+		wantOK bool
+	}{
+		{name: "callback", method: http.MethodPost, url: "https://discord.com/api/v9/interactions/123/token/callback", wantID: "123", wantOK: true},
+		{name: "get", method: http.MethodGet, url: "https://discord.com/api/v9/interactions/123/token/callback"},
+		{name: "webhook edit", method: http.MethodPost, url: "https://discord.com/api/v9/webhooks/app/token/messages/@original"},
+		{name: "channel message", method: http.MethodPost, url: "https://discord.com/api/v9/channels/1/messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			id, ok := interactionCallbackID(req)
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("interactionCallbackID(%s %s) = (%q, %v), want (%q, %v)", tt.method, tt.url, id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCallbackInterceptor(t *testing.T) {
+	var forwarded atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(upstream.Close)
+	client := &http.Client{Transport: callbackInterceptor{next: http.DefaultTransport}}
+
+	responses := make(chan initialResponse, 1)
+	pendingHTTPInteractionsMutex.Lock()
+	pendingHTTPInteractions["42"] = responses
+	pendingHTTPInteractionsMutex.Unlock()
+
+	// The first callback of a pending interaction is captured instead of sent
+	resp, err := client.Post(upstream.URL+"/api/v9/interactions/42/token/callback", "application/json", strings.NewReader(`{"type":5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("captured callback status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	select {
+	case response := <-responses:
+		if string(response.body) != `{"type":5}` || response.contentType != "application/json" {
+			t.Errorf("captured response = (%q, %q)", response.contentType, response.body)
+		}
+	default:
+		t.Fatal("callback was not handed to the waiting endpoint")
+	}
+	if forwarded.Load() != 0 {
+		t.Error("captured callback was also sent upstream")
+	}
+
+	// Later callbacks and other requests go to the API
+	for _, path := range []string{"/api/v9/interactions/42/token/callback", "/api/v9/channels/1/messages"} {
+		resp, err := client.Post(upstream.URL+path, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if forwarded.Load() != 2 {
+		t.Errorf("forwarded %d requests upstream, want 2", forwarded.Load())
+	}
+}
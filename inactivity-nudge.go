@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Inactivity nudge and close timers per thread, started when a turn finishes and stopped by the next prompt
+var inactivityTimers = make(map[string]*time.Timer)
+var inactivityMutex sync.Mutex
+
+// scheduleInactivityNudge starts the inactivity_nudge_after timer for a thread that stays open
+// after its turn finished. Threads archived by on_complete and channel sessions are left alone.
+func scheduleInactivityNudge(threadID string) {
+	if AppConfig.InactivityNudgeAfter <= 0 {
+		return
+	}
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	archivedOnComplete := exists && AppConfig.OnComplete != OnCompleteKeep && !sessionData.KeepThread
+	skip := !exists || sessionData.ChannelSession || archivedOnComplete
+	sessionMutex.RUnlock()
+	if skip {
+		return
+	}
+
+	setInactivityTimer(threadID, AppConfig.InactivityNudgeAfter, func() {
+		nudgeInactiveSession(threadID)
+	})
+	slog.Debug("inactivity nudge scheduled", "thread_id", threadID, "after", AppConfig.InactivityNudgeAfter)
+}
+
+// stopInactivityTimer cancels a thread's pending nudge or close
+func stopInactivityTimer(threadID string) {
+	inactivityMutex.Lock()
+	defer inactivityMutex.Unlock()
+
+	if timer, exists := inactivityTimers[threadID]; exists {
+		timer.Stop()
+		delete(inactivityTimers, threadID)
+		slog.Debug("inactivity timer stopped", "thread_id", threadID)
+	}
+}
+
+// setInactivityTimer replaces a thread's inactivity timer
+func setInactivityTimer(threadID string, after time.Duration, fire func()) {
+	inactivityMutex.Lock()
+	defer inactivityMutex.Unlock()
+
+	if timer, exists := inactivityTimers[threadID]; exists {
+		timer.Stop()
+	}
+	inactivityTimers[threadID] = time.AfterFunc(after, fire)
+}
+
+// sessionIdle reports whether a thread's session still exists and has nothing running or queued
+func sessionIdle(threadID string) bool {
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	streaming := exists && sessionData.IsStreaming
+	sessionMutex.RUnlock()
+	return exists && !streaming && len(queuedPrompts(threadID)) == 0
+}
+
+// nudgeInactiveSession asks whether anything else is needed and, with inactivity_close_after,
+// schedules the thread to be closed if the question goes unanswered
+func nudgeInactiveSession(threadID string) {
+	if !sessionIdle(threadID) {
+		stopInactivityTimer(threadID)
+		return
+	}
+
+	closeAfter := AppConfig.InactivityCloseAfter
+	message := "👋 Anything else? Mention me to keep going."
+	if closeAfter > 0 {
+		message = fmt.Sprintf("👋 Anything else? This thread will be archived in %s if there is no further activity; mentioning me reopens it.", closeAfter)
+	}
+	sendToDiscord(threadID, message)
+	slog.Info("nudged inactive session", "thread_id", threadID)
+
+	if closeAfter <= 0 {
+		stopInactivityTimer(threadID)
+		return
+	}
+	setInactivityTimer(threadID, closeAfter, func() {
+		closeInactiveSession(threadID)
+	})
+}
+
+// closeInactiveSession archives a thread that stayed idle after the nudge. The worktree and session
+// are kept, so a new message resumes the session.
+func closeInactiveSession(threadID string) {
+	stopInactivityTimer(threadID)
+	if !sessionIdle(threadID) || discord == nil {
+		return
+	}
+
+	sendToDiscord(threadID, "💤 Archived after inactivity. Mention me in this thread to pick the session up again.")
+	archived := true
+	if _, err := discord.ChannelEdit(threadID, &discordgo.ChannelEdit{Archived: &archived}); err != nil {
+		slog.Error("failed to archive inactive thread", "thread_id", threadID, "error", err)
+		return
+	}
+	SetSessionActive(threadID, false)
+	slog.Info("archived inactive session thread", "thread_id", threadID)
+}
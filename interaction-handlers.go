@@ -3,128 +3,419 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/goombaio/namegenerator"
-	"github.com/sst/opencode-sdk-go"
 )
 
 var seed = time.Now().UnixNano()
 var generator = namegenerator.NewNameGenerator(seed)
 
+// The generator's random source is not safe for concurrent use, and /codesession can run in parallel
+var generatorMutex sync.Mutex
+
+// generateThreadName returns a random adjective-noun name for a new session thread
+func generateThreadName() string {
+	generatorMutex.Lock()
+	defer generatorMutex.Unlock()
+	return generator.Generate()
+}
+
+// commandHandlers maps each slash command name to its handler
+var commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+	"ping":          handlePingCommand,
+	"codesession":   handleOpencodeCommand,
+	"commit":        handleCommitCommand,
+	"diff":          handleDiffCommand,
+	"filediff":      handleFileDiffCommand,
+	"review":        handleReviewCommand,
+	"agent":         handleAgentCommand,
+	"model":         handleModelCommand,
+	"status":        handleStatusCommand,
+	"last":          handleLastCommand,
+	"approve":       handleApproveCommand,
+	"reject":        handleRejectCommand,
+	"list":          handleListCommand,
+	"autocommit":    handleAutoCommitCommand,
+	"context":       handleContextCommand,
+	"remote":        handleRemoteCommand,
+	"gitconfig":     handleGitConfigCommand,
+	"compare":       handleCompareCommand,
+	"drift":         handleDriftCommand,
+	"setbase":       handleSetBaseCommand,
+	"logs":          handleLogsCommand,
+	"comparemodels": handleCompareModelsCommand,
+	"keep":          handleKeepCommand,
+	"retrypush":     handleRetryPushCommand,
+	"amend":         handleAmendCommand,
+	"transfer":      handleTransferCommand,
+	"autorespond":   handleAutoRespondCommand,
+	"queue":         handleQueueCommand,
+	"fork":          handleForkCommand,
+	"worktree":      handleWorktreeCommand,
+	"modelinfo":     handleModelInfoCommand,
+	"estimate":      handleEstimateCommand,
+	"reloadsession": handleReloadSessionCommand,
+	"loglevel":      handleLogLevelCommand,
+	"dumpsession":   handleDumpSessionCommand,
+	"costreport":    handleCostReportCommand,
+	"shipped":       handleShippedCommand,
+	"cleanuprepo":   handleCleanupRepoCommand,
+	"disk":          handleDiskCommand,
+	"scratch":       handleScratchCommand,
+	"exportmd":      handleExportMarkdownCommand,
+	"pause":         handlePauseCommand,
+	"unpause":       handleUnpauseCommand,
+	"prdescription": handlePRDescriptionCommand,
+}
+
 func InteractionHandlers(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		handleComponentInteraction(s, i)
+		return
+	case discordgo.InteractionApplicationCommand:
+	default:
+		slog.Debug("ignoring unsupported interaction type", "type", i.Type.String(), "channel_id", i.ChannelID)
+		return
+	}
+
 	command := i.ApplicationCommandData().Name
-	if command == "ping" {
+	auditInteraction(i)
+	interactionLogger(i).Debug("handling interaction", "command", command, "interaction_id", i.ID)
+
+	handler, exists := commandHandlers[command]
+	if !exists {
+		// Usually a command that was removed from registerCommands but is still cached by Discord
+		interactionLogger(i).Warn("unknown command", "command", command)
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "Pong!",
+				Content: fmt.Sprintf("Unknown command `/%s`.", command),
+				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
+		return
+	}
+	handler(s, i)
+}
+
+func handlePingCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Pong!",
+		},
+	})
+}
+
+// Interactions deferred as ephemeral, by interaction ID, with when they were deferred. The fallback
+// for an expired token must not post their content to the channel.
+var ephemeralInteractions = make(map[string]time.Time)
+var ephemeralInteractionsMutex sync.Mutex
+
+// How long an ephemeral interaction is remembered; handlers do not run anywhere near this long
+const ephemeralInteractionTTL = 24 * time.Hour
+
+// deferInteraction acknowledges an interaction so it can be answered after a long-running operation
+func deferInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, ephemeral bool) error {
+	response := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}
+	if ephemeral {
+		response.Data = &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		}
+		markEphemeralInteraction(i.ID)
+	}
+	return s.InteractionRespond(i.Interaction, response)
+}
+
+// markEphemeralInteraction remembers that an interaction was deferred as ephemeral and forgets
+// interactions old enough that no handler can still be answering them
+func markEphemeralInteraction(interactionID string) {
+	ephemeralInteractionsMutex.Lock()
+	defer ephemeralInteractionsMutex.Unlock()
+
+	now := time.Now()
+	for id, deferredAt := range ephemeralInteractions {
+		if now.Sub(deferredAt) > ephemeralInteractionTTL {
+			delete(ephemeralInteractions, id)
+		}
+	}
+	ephemeralInteractions[interactionID] = now
+}
+
+// isEphemeralInteraction reports whether an interaction was deferred as ephemeral
+func isEphemeralInteraction(interactionID string) bool {
+	ephemeralInteractionsMutex.Lock()
+	defer ephemeralInteractionsMutex.Unlock()
+	_, ephemeral := ephemeralInteractions[interactionID]
+	return ephemeral
+}
+
+// respondOrFallback replaces the content of a deferred interaction response. Long-running handlers
+// can outlive the 15-minute interaction token, so when the token has expired the content is posted
+// as a regular channel message instead, or sent to the user as a direct message when the response
+// was ephemeral.
+func respondOrFallback(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+	if err == nil {
+		return
+	}
+	if !isExpiredInteractionError(err) {
+		slog.Error("failed to update interaction response", "channel_id", i.ChannelID, "error", err)
+		return
+	}
+
+	if isEphemeralInteraction(i.ID) {
+		slog.Warn("interaction token expired, falling back to direct message", "channel_id", i.ChannelID)
+		dm, err := s.UserChannelCreate(interactionUserID(i))
+		if err != nil {
+			slog.Error("failed to open direct message for fallback", "channel_id", i.ChannelID, "error", err)
+			return
+		}
+		if _, err := s.ChannelMessageSend(dm.ID, content); err != nil {
+			slog.Error("failed to send fallback direct message", "channel_id", i.ChannelID, "error", err)
+		}
+		return
 	}
 
-	if command == "codesession" {
-		handleOpencodeCommand(s, i)
+	slog.Warn("interaction token expired, falling back to channel message", "channel_id", i.ChannelID)
+	if _, err := s.ChannelMessageSend(i.ChannelID, content); err != nil {
+		slog.Error("failed to send fallback message", "channel_id", i.ChannelID, "error", err)
 	}
+}
 
-	if command == "commit" {
-		handleCommitCommand(s, i)
+// isExpiredInteractionError reports whether a REST error means the interaction token is no longer valid
+func isExpiredInteractionError(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	if restErr.Message != nil {
+		switch restErr.Message.Code {
+		case discordgo.ErrCodeInvalidWebhookTokenProvided, discordgo.ErrCodeUnknownWebhook, discordgo.ErrCodeUnknownInteraction:
+			return true
+		}
 	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusUnauthorized
+}
 
-	if command == "diff" {
-		handleDiffCommand(s, i)
+// loadThreadSession loads the session bound to the interaction's thread, answering the interaction when there is none
+func loadThreadSession(s *discordgo.Session, i *discordgo.InteractionCreate) *SessionData {
+	session := lazyLoadSession(i.ChannelID)
+	if session == nil {
+		slog.Error("no session found for thread", "thread_id", i.ChannelID)
+		respondOrFallback(s, i, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
 	}
+	return session
 }
 
 func handleOpencodeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// Respond immediately to prevent timeout
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Flags: discordgo.MessageFlagsEphemeral,
-		},
-	})
+	err := deferInteraction(s, i, true)
 	if err != nil {
 		slog.Error("failed to respond to interaction", "error", err)
 		return
 	}
 
 	// Get command options
-	options := i.ApplicationCommandData().Options
-	var repositoryIndex, modelIndex int
-
-	for _, option := range options {
+	var request sessionStartRequest
+	for _, option := range i.ApplicationCommandData().Options {
 		switch option.Name {
 		case "repository":
-			repositoryIndex = int(option.IntValue())
+			request.RepositoryIndex = int(option.IntValue())
 		case "model":
-			modelIndex = int(option.IntValue())
+			request.ModelIndex = int(option.IntValue())
+		case "agent":
+			request.Agent = strings.TrimSpace(option.StringValue())
+		case "from":
+			request.BaseRef = strings.TrimSpace(option.StringValue())
+		case "review":
+			request.ReviewMode = option.BoolValue()
+		case "auto_respond":
+			request.AutoRespond = option.BoolValue()
+		case "plan":
+			request.PlanFirst = option.BoolValue()
 		}
 	}
 
+	if request.PlanFirst && request.ReviewMode {
+		respondOrFallback(s, i, "Review sessions cannot change files, so `plan` only applies to edit sessions.")
+		return
+	}
+
 	// Get selected repository
-	if repositoryIndex >= len(AppConfig.Repositories) {
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Invalid repository selection"}[0],
-		})
+	if request.RepositoryIndex < 0 || request.RepositoryIndex >= len(AppConfig.Repositories) {
+		respondOrFallback(s, i, "Invalid repository selection")
 		return
 	}
 
-	repository := AppConfig.Repositories[repositoryIndex]
-	model := AppConfig.Models[modelIndex]
+	if request.ModelIndex < 0 || request.ModelIndex >= len(AppConfig.Models) {
+		respondOrFallback(s, i, "Invalid model selection")
+		return
+	}
 
-	// Create a new thread
-	threadName := generator.Generate()
-	slog.Debug("creating thread", "thread_name", threadName, "channel_id", i.ChannelID)
-	thread, err := s.ThreadStart(
-		i.ChannelID,
-		fmt.Sprintf("codesession: %s", threadName),
-		discordgo.ChannelTypeGuildPublicThread,
-		1440, // 24 hours
-	)
-	if err != nil {
-		slog.Error("failed to create thread", "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to create thread"}[0],
-		})
+	if request.AutoRespond && !AppConfig.EnableAutoRespond {
+		respondOrFallback(s, i, autoRespondDisabledMessage)
+		return
+	}
+
+	repository := request.repository()
+
+	// Validate the requested agent against what the server reports
+	if err := validateAgent(repository.Path, request.Agent); err != nil {
+		slog.Error("invalid agent selection", "agent", request.Agent, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("Invalid agent: %v", err))
+		return
+	}
+
+	// Make sure the requested base ref exists, fetching tags when it isn't known locally
+	if request.BaseRef != "" {
+		if err := gitOps.EnsureRef(repository.Path, request.BaseRef); err != nil {
+			slog.Error("invalid base ref", "ref", request.BaseRef, "error", err)
+			respondOrFallback(s, i, fmt.Sprintf("Ref `%s` was not found in %s.", request.BaseRef, repository.Name))
+			return
+		}
+	}
+
+	// Fail before confirmation when a new thread could not be started in this channel
+	if interactionThread(s, i.ChannelID) == nil {
+		if _, refusal := sessionThreadTypeIn(s, i.ChannelID); refusal != "" {
+			respondOrFallback(s, i, refusal)
+			return
+		}
+	}
+
+	if AppConfig.ConfirmSessionStart {
+		askSessionStartConfirmation(s, i, request)
 		return
 	}
-	slog.Debug("thread created successfully", "thread_id", thread.ID, "thread_name", thread.Name)
+	startSession(s, i, request)
+}
+
+// sessionStartRequest holds the validated options of a /codesession invocation
+type sessionStartRequest struct {
+	RepositoryIndex int
+	ModelIndex      int
+	Agent           string
+	BaseRef         string // Commit, tag or branch the session branch starts from; empty means the repository's current HEAD
+	ReviewMode      bool   // Read-only Q&A session: prompts run with file-modifying tools disabled
+	AutoRespond     bool   // Treat every message in the thread as a prompt, without a mention
+	PlanFirst       bool   // Prompts draft a plan that must be approved with /approve before it is executed
+}
+
+func (r sessionStartRequest) repository() Repository {
+	return AppConfig.Repositories[r.RepositoryIndex]
+}
+
+func (r sessionStartRequest) model() Model {
+	return AppConfig.Models[r.ModelIndex]
+}
+
+// startSession creates the thread (unless invoked inside one), worktree and OpenCode session
+// for a /codesession request whose interaction has already been deferred
+func startSession(s *discordgo.Session, i *discordgo.InteractionCreate, request sessionStartRequest) {
+	repository := request.repository()
+	model := request.model()
+	agent := request.Agent
+
+	// Track created resources so a failure further down leaves nothing behind for a retry
+	var rollback rollbackSteps
+	var err error
+
+	// Bind the session to the current thread when invoked inside one, otherwise start a new thread
+	var threadName string
+	var channelSession bool
+	thread := interactionThread(s, i.ChannelID)
+	if thread != nil {
+		if lazyLoadSession(thread.ID) != nil {
+			respondOrFallback(s, i, "This thread already has a codesession session.")
+			return
+		}
+		slog.Debug("binding session to existing thread", "thread_id", thread.ID, "thread_name", thread.Name)
+	} else {
+		threadName = renderThreadName(AppConfig.ThreadNameTemplate, threadNameValues{
+			Repo:   repository.Name,
+			User:   interactionUsername(i),
+			Date:   time.Now(),
+			Random: generateThreadName(),
+		})
+		var refusal string
+		thread, channelSession, refusal = startSessionThread(s, i.ChannelID, threadName)
+		if refusal != "" {
+			respondOrFallback(s, i, refusal)
+			return
+		}
+		if channelSession {
+			threadName = ""
+		} else {
+			slog.Debug("thread created successfully", "thread_id", thread.ID, "thread_name", thread.Name)
+			rollback.add("thread", func() error {
+				_, err := s.ChannelDelete(thread.ID)
+				return err
+			})
+		}
+	}
 
 	// Create worktree directory in bot's current directory (not repository directory)
 	repoPath := repository.Path
-	currentDir, err := os.Getwd()
+	worktreesDir, err := ensureWorktreeDir()
 	if err != nil {
-		slog.Error("failed to get current working directory", "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to get current working directory"}[0],
-		})
+		slog.Error("failed to ensure worktrees directory", "error", err)
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, "Failed to create worktrees directory")
 		return
 	}
-	worktreeDir := filepath.Join(currentDir, ".worktrees", thread.ID)
-	err = os.MkdirAll(filepath.Dir(worktreeDir), 0755)
+	worktreeDir := filepath.Join(worktreesDir, thread.ID)
+
+	// Create git worktree FIRST with branch name as thread ID. Rollback only undoes what this call
+	// creates: a worktree or branch left by an earlier attempt is reused and must survive a failure.
+	_, statErr := os.Stat(worktreeDir)
+	worktreeExisted := statErr == nil
+	branchExisted := gitOps.BranchExists(repoPath, thread.ID)
+	err = gitOps.CreateWorktree(repoPath, worktreeDir, thread.ID, request.BaseRef, repository.SparsePaths, repository.pullBeforeWorktree())
 	if err != nil {
-		slog.Error("failed to create worktrees directory", "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to create worktrees directory"}[0],
-		})
+		slog.Error("failed to create git worktree", "error", err)
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, "Failed to create git worktree")
 		return
 	}
 
-	// Create git worktree FIRST with branch name as thread ID
-	err = gitOps.CreateWorktree(repoPath, worktreeDir, thread.ID)
-	if err != nil {
-		slog.Error("failed to create git worktree", "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to create git worktree"}[0],
+	// A reused worktree may already carry session commits, so /drift estimates its fork point instead
+	var forkPoint string
+	if !worktreeExisted {
+		if forkPoint, err = gitOps.GetCommitHash(worktreeDir); err != nil {
+			slog.Warn("failed to record fork point", "thread_id", thread.ID, "error", err)
+		}
+	}
+
+	if !worktreeExisted {
+		rollback.add("worktree", func() error {
+			if err := gitOps.RemoveWorktree(repoPath, worktreeDir); err != nil {
+				return err
+			}
+			if branchExisted {
+				return nil
+			}
+			return gitOps.DeleteBranch(repoPath, thread.ID)
 		})
-		return
 	}
 
 	// Create session AFTER worktree is created
@@ -132,9 +423,8 @@ func handleOpencodeCommand(s *discordgo.Session, i *discordgo.InteractionCreate)
 	session := GetOrCreateSession(thread.ID, worktreeDir, repository.Path, repository.Name, i.Member.User.ID)
 	if session == nil {
 		slog.Error("failed to create session", "thread_id", thread.ID)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to create session"}[0],
-		})
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, "Failed to create session")
 		return
 	}
 	slog.Debug("session created successfully", "thread_id", thread.ID, "session_id", session.ID)
@@ -146,6 +436,14 @@ func handleOpencodeCommand(s *discordgo.Session, i *discordgo.InteractionCreate)
 	if sessionData, exists := sessionCache[thread.ID]; exists {
 		slog.Debug("found session in cache", "thread_id", thread.ID)
 		sessionData.Model = model
+		sessionData.Agent = agent
+		sessionData.BaseRef = request.BaseRef
+		sessionData.ReviewMode = request.ReviewMode
+		sessionData.AutoRespond = request.AutoRespond
+		sessionData.PlanFirst = request.PlanFirst
+		sessionData.ThreadName = threadName
+		sessionData.ChannelSession = channelSession
+		sessionData.ForkPoint = forkPoint
 
 		// Save session data without acquiring mutex again (we already hold it)
 		data, err := json.MarshalIndent(sessionData, "", "  ")
@@ -153,7 +451,7 @@ func handleOpencodeCommand(s *discordgo.Session, i *discordgo.InteractionCreate)
 			slog.Error("failed to marshal session data", "error", err)
 		} else {
 			filePath := filepath.Join(sessionsDirectory, fmt.Sprintf("%s.json", sessionData.ThreadID))
-			if err := os.WriteFile(filePath, data, 0644); err != nil {
+			if err := writeFileAtomic(filePath, data, 0644); err != nil {
 				slog.Error("failed to save session data with model", "error", err)
 			} else {
 				slog.Debug("saved session data with model", "thread_id", thread.ID)
@@ -167,419 +465,3450 @@ func handleOpencodeCommand(s *discordgo.Session, i *discordgo.InteractionCreate)
 
 	// Send initial message to the thread
 	slog.Debug("sending welcome message to thread", "thread_id", thread.ID)
-	trimmedWorktreeDir := strings.TrimPrefix(worktreeDir, currentDir)
+	trimmedWorktreeDir := strings.TrimPrefix(worktreeDir, filepath.Dir(worktreesDir))
 	welcomeMessage := fmt.Sprintf(`%s
 Session Started
 Repository: %s
 Model: %s
+Agent: %s
+Mode: %s
+Base: %s
 Worktree Path: %s
 Session ID: %s
-%s`, "```", repository.Name, fmt.Sprintf("%s/%s", model.ProviderID, model.ModelID), trimmedWorktreeDir, session.ID, "```")
+%s`, "```", repository.Name, fmt.Sprintf("%s/%s", model.ProviderID, model.ModelID), agentDisplayName(agent), sessionModeName(request.ReviewMode, request.PlanFirst), baseRefDisplayName(request.BaseRef), trimmedWorktreeDir, session.ID, "```")
 
 	SendDiscordMessage(thread.ID, welcomeMessage)
 
 	// Update the interaction response with success message AFTER welcome message
 	slog.Debug("updating interaction response", "thread_id", thread.ID)
-	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &[]string{fmt.Sprintf("codesession session created successfully! Check the thread: %s", thread.Mention())}[0],
-	})
+	respondOrFallback(s, i, fmt.Sprintf("codesession session created successfully! Check the thread: %s", thread.Mention()))
 }
 
-func handleCommitCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	threadID := i.ChannelID
-	slog.Debug("starting commit command", "thread_id", threadID)
+// handleScratchCommand starts a Q&A session in a temporary directory, without a repository or git worktree
+func handleScratchCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	slog.Debug("starting scratch command", "channel_id", i.ChannelID)
 
-	// Defer response
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-	})
-	if err != nil {
-		slog.Error("failed to defer commit interaction", "thread_id", threadID, "error", err)
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer scratch interaction", "channel_id", i.ChannelID, "error", err)
 		return
 	}
-	slog.Debug("commit interaction deferred successfully", "thread_id", threadID)
 
-	// Check if session exists
-	slog.Debug("attempting to load session", "thread_id", threadID)
-	session := lazyLoadSession(threadID)
-	if session == nil {
-		slog.Error("no session found for thread", "thread_id", threadID)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"No codesession session found for this thread. Please start a session first using `/codesession` command."}[0],
-		})
-		return
+	var modelIndex int
+	var agent string
+	var autoRespond bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "model":
+			modelIndex = int(option.IntValue())
+		case "agent":
+			agent = strings.TrimSpace(option.StringValue())
+		case "auto_respond":
+			autoRespond = option.BoolValue()
+		}
 	}
-	slog.Debug("session loaded successfully", "thread_id", threadID, "session_id", session.SessionID)
 
-	// Use the stored worktree path from session data
-	worktreePath := session.WorktreePath
-	slog.Debug("using stored worktree path", "thread_id", threadID, "worktree_path", worktreePath, "repository_path", session.RepositoryPath, "repository_name", session.RepositoryName)
-
-	// Validate worktree directory exists
-	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
-		slog.Error("worktree directory does not exist", "thread_id", threadID, "worktree_path", worktreePath)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Worktree directory not found. Please start a new session."}[0],
-		})
+	if modelIndex < 0 || modelIndex >= len(AppConfig.Models) {
+		respondOrFallback(s, i, "Invalid model selection")
 		return
 	}
-	slog.Debug("worktree directory exists", "thread_id", threadID, "worktree_path", worktreePath)
-
-	// send message to opencode to generate commit summary
-	slog.Debug("requesting AI summary for commit", "thread_id", threadID, "session_id", session.SessionID)
-	instruction := AppConfig.SummarizerInstruction
-	if instruction == "" {
-		instruction = "Generate a git commit message in conventional commit format. The first line should be in the format 'type(scope): description'. Follow with a bullet-point list of key changes made in the session. Keep the entire message concise."
-	}
-	client := Opencode()
-	if client == nil {
-		slog.Error("opencode client is nil")
-		return
-	}
-	response, err := client.Session.Prompt(context.Background(), session.SessionID, opencode.SessionPromptParams{
-		Directory: opencode.F(worktreePath),
-		Tools: opencode.F(map[string]bool{
-			"write": false,
-			"edit":  false,
-		}),
-		Parts: opencode.F([]opencode.SessionPromptParamsPartUnion{
-			&opencode.TextPartInputParam{
-				Type: opencode.F(opencode.TextPartInputTypeText),
-				Text: opencode.F(instruction),
-			},
-		}),
-		Model: opencode.F(opencode.SessionPromptParamsModel{
-			ProviderID: opencode.F(session.Model.ProviderID),
-			ModelID:    opencode.F(session.Model.ModelID),
-		}),
-	})
-	if err != nil {
-		slog.Error("failed to generate AI summary", "thread_id", threadID, "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to generate summary."}[0],
-		})
+	if autoRespond && !AppConfig.EnableAutoRespond {
+		respondOrFallback(s, i, autoRespondDisabledMessage)
 		return
 	}
-	slog.Debug("AI summary generated successfully", "thread_id", threadID, "parts_count", len(response.Parts))
+	model := AppConfig.Models[modelIndex]
 
-	// Get summary from response by looking specifically for "text" type parts
-	summary := ""
-	for i, part := range response.Parts {
-		slog.Debug("checking response part", "thread_id", threadID, "part_index", i, "part_type", part.Type, "text_length", len(part.Text))
-		if part.Type == "text" && part.Text != "" {
-			summary = part.Text
-			slog.Debug("found AI summary in text part", "thread_id", threadID, "part_index", i, "raw_summary", summary, "length", len(summary))
-			break // Use the first text-type part we find
+	var rollback rollbackSteps
+	var threadName string
+	var channelSession bool
+	thread := interactionThread(s, i.ChannelID)
+	if thread != nil {
+		if lazyLoadSession(thread.ID) != nil {
+			respondOrFallback(s, i, "This thread already has a codesession session.")
+			return
 		}
-	}
-	if summary == "" {
-		summary = "Changes made during session"
-		slog.Debug("using default summary", "thread_id", threadID, "summary", summary)
-	} else {
-		slog.Debug("final summary prepared", "thread_id", threadID, "summary", summary)
-	}
-
-	// Create a pending commit record
-	commitRecord := CommitRecord{
-		Summary:   summary,
-		Timestamp: time.Now(),
-		Status:    "pending",
-	}
-
-	// Add pending commit to session
-	sessionMutex.Lock()
-	session.Commits = append(session.Commits, commitRecord)
-	sessionMutex.Unlock()
-	slog.Debug("added pending commit record", "thread_id", threadID, "summary", summary)
-
-	// Check git status before adding
-	slog.Debug("checking git status before staging", "thread_id", threadID)
-	gitStatus, err := gitOps.GetStatus(worktreePath)
-	if err != nil {
-		slog.Error("failed to check git status", "thread_id", threadID, "error", err)
 	} else {
-		slog.Debug("git status retrieved", "thread_id", threadID, "is_clean", gitStatus.IsClean,
-			"modified_count", len(gitStatus.ModifiedFiles), "untracked_count", len(gitStatus.UntrackedFiles))
-		if gitStatus.IsClean {
-			slog.Debug("no changes detected in worktree", "thread_id", threadID)
-
-			// Update commit record with "no changes" status
-			sessionMutex.Lock()
-			if len(session.Commits) > 0 {
-				session.Commits[len(session.Commits)-1].Status = "no_changes"
-			}
-			sessionMutex.Unlock()
-
-			// Save session data after releasing mutex to avoid deadlock
-			if err := saveSessionData(session); err != nil {
-				slog.Error("failed to save session data for no changes", "thread_id", threadID, "error", err)
-			}
-
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &[]string{"No changes to commit."}[0],
-			})
+		threadName = renderThreadName(AppConfig.ThreadNameTemplate, threadNameValues{
+			Repo:   "scratch",
+			User:   interactionUsername(i),
+			Date:   time.Now(),
+			Random: generateThreadName(),
+		})
+		var refusal string
+		thread, channelSession, refusal = startSessionThread(s, i.ChannelID, threadName)
+		if refusal != "" {
+			respondOrFallback(s, i, refusal)
 			return
 		}
+		if channelSession {
+			threadName = ""
+		} else {
+			rollback.add("thread", func() error {
+				_, err := s.ChannelDelete(thread.ID)
+				return err
+			})
+		}
 	}
 
-	// Git add operation
-	slog.Debug("staging all changes", "thread_id", threadID)
-	err = gitOps.AddAll(worktreePath)
+	scratchDir, err := createScratchDir(thread.ID)
 	if err != nil {
-		slog.Error("failed to stage changes", "thread_id", threadID, "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to stage changes."}[0],
-		})
+		slog.Error("failed to create scratch directory", "thread_id", thread.ID, "error", err)
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, "Failed to create scratch directory")
 		return
 	}
-	slog.Debug("all changes staged successfully", "thread_id", threadID)
-
-	// Git commit operation
-	slog.Debug("committing changes", "thread_id", threadID, "commit_message", summary)
-	commitHash, err := gitOps.Commit(worktreePath, summary)
-	if err != nil {
-		slog.Error("failed to create commit", "thread_id", threadID, "error", err)
-
-		// Update commit record with failed status
-		sessionMutex.Lock()
-		if len(session.Commits) > 0 {
-			session.Commits[len(session.Commits)-1].Status = "failed"
-		}
-		sessionMutex.Unlock()
-
-		// Save session data after releasing mutex to avoid deadlock
-		if err := saveSessionData(session); err != nil {
-			slog.Error("failed to save session data for commit failure", "thread_id", threadID, "error", err)
-		}
+	rollback.add("scratch directory", func() error {
+		return os.RemoveAll(scratchDir)
+	})
 
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{fmt.Sprintf("Failed to commit changes. Error: %v", err)}[0],
-		})
+	// Validate the agent against the server now that there is a directory to ask about
+	if err := validateAgent(scratchDir, agent); err != nil {
+		slog.Error("invalid agent selection", "agent", agent, "error", err)
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, fmt.Sprintf("Invalid agent: %v", err))
 		return
 	}
-	slog.Debug("commit created successfully", "thread_id", threadID, "commit_hash", commitHash)
-
-	// Check current branch before push
-	currentBranch, err := gitOps.GetCurrentBranch(worktreePath)
-	if err != nil {
-		slog.Error("failed to get current branch", "thread_id", threadID, "error", err)
-		currentBranch = "main" // fallback to main branch
-	}
-	slog.Debug("current branch", "thread_id", threadID, "branch", currentBranch)
-
-	// Git push operation with specific branch
-	slog.Debug("pushing changes to remote", "thread_id", threadID, "branch", currentBranch)
-	err = gitOps.Push(worktreePath, currentBranch)
-	if err != nil {
-		slog.Error("failed to push changes", "thread_id", threadID, "error", err)
-
-		// Update commit record with failed status (commit succeeded but push failed)
-		sessionMutex.Lock()
-		if len(session.Commits) > 0 {
-			session.Commits[len(session.Commits)-1].Status = "failed"
-			session.Commits[len(session.Commits)-1].Hash = commitHash
-		}
-		sessionMutex.Unlock()
-
-		// Save session data after releasing mutex to avoid deadlock
-		if err := saveSessionData(session); err != nil {
-			slog.Error("failed to save session data for push failure", "thread_id", threadID, "error", err)
-		}
 
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{fmt.Sprintf("Failed to push changes. Error: %v.", err)}[0],
-		})
+	session := GetOrCreateSession(thread.ID, scratchDir, "", "", interactionUserID(i))
+	if session == nil {
+		slog.Error("failed to create scratch session", "thread_id", thread.ID)
+		rollback.run(thread.ID)
+		respondOrFallback(s, i, "Failed to create session")
 		return
 	}
-	slog.Debug("push completed successfully", "thread_id", threadID)
 
-	// Update commit record with success status
 	sessionMutex.Lock()
-	if len(session.Commits) > 0 {
-		session.Commits[len(session.Commits)-1].Status = "success"
-		session.Commits[len(session.Commits)-1].Hash = commitHash
-		slog.Debug("updating commit record with success status", "thread_id", threadID, "commit_hash", commitHash)
+	sessionData, exists := sessionCache[thread.ID]
+	if exists {
+		sessionData.Model = model
+		sessionData.Agent = agent
+		sessionData.Scratch = true
+		sessionData.AutoRespond = autoRespond
+		sessionData.ThreadName = threadName
+		sessionData.ChannelSession = channelSession
 	}
 	sessionMutex.Unlock()
-
-	// Save session data after releasing the mutex to avoid deadlock
-	slog.Debug("about to save session data", "thread_id", threadID)
-	if err := saveSessionData(session); err != nil {
-		slog.Error("failed to save session data after successful commit", "thread_id", threadID, "error", err)
-	} else {
-		slog.Debug("saved session data with success status", "thread_id", threadID, "commit_hash", commitHash)
+	if exists {
+		if err := saveSessionData(sessionData); err != nil {
+			slog.Error("failed to save scratch session data", "thread_id", thread.ID, "error", err)
+		}
 	}
 
-	// Send detailed success message to thread
-	slog.Debug("preparing detailed success message", "thread_id", threadID)
-	slog.Debug("sending detailed success message to thread", "thread_id", threadID)
-	detailedMessage := fmt.Sprintf("**Commit & Push Successful** (git hooks skipped)\n\n**Summary:** %s\n**Hash:** %s\n**Branch:** %s\n\n⚠️ Caution: Git hooks are skipped (if any).",
-		summary, commitHash, currentBranch)
+	welcomeMessage := fmt.Sprintf(`%s
+Scratch Session Started
+Model: %s
+Agent: %s
+Session ID: %s
+%s
+There is no repository in this session, so /commit, /diff and the other git commands are not available.`, "```", fmt.Sprintf("%s/%s", model.ProviderID, model.ModelID), agentDisplayName(agent), session.ID, "```")
+	SendDiscordMessage(thread.ID, welcomeMessage)
 
-	SendDiscordMessage(threadID, detailedMessage)
+	slog.Info("scratch session started", "thread_id", thread.ID, "scratch_dir", scratchDir)
+	respondOrFallback(s, i, fmt.Sprintf("Scratch session created! Check the thread: %s", thread.Mention()))
+}
 
-	// Update interaction response
-	slog.Debug("updating interaction response with success", "thread_id", threadID)
-	_, interactionErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &[]string{"Commit completed successfully!"}[0],
-	})
-	if interactionErr != nil {
-		slog.Error("failed to update interaction response", "thread_id", threadID, "error", interactionErr)
-	} else {
-		slog.Debug("interaction response updated successfully", "thread_id", threadID)
+// requireRepository refuses git commands in scratch sessions, answering the interaction
+func requireRepository(s *discordgo.Session, i *discordgo.InteractionCreate, session *SessionData) bool {
+	sessionMutex.RLock()
+	scratch := session.Scratch
+	sessionMutex.RUnlock()
+	if scratch {
+		respondOrFallback(s, i, scratchSessionMessage)
+		return false
 	}
+	return true
+}
 
-	slog.Debug("commit command completed successfully", "thread_id", threadID, "final_summary", summary, "commit_hash", commitHash)
+// requireWorktree checks that the session has a git worktree and that it still exists, answering the interaction when it does not
+func requireWorktree(s *discordgo.Session, i *discordgo.InteractionCreate, session *SessionData) bool {
+	if !requireRepository(s, i, session) {
+		return false
+	}
+	if _, err := os.Stat(session.WorktreePath); os.IsNotExist(err) {
+		slog.Error("worktree directory does not exist", "thread_id", session.ThreadID, "worktree_path", session.WorktreePath)
+		respondOrFallback(s, i, "Worktree directory not found. Please start a new session.")
+		return false
+	}
+	return true
 }
 
-func MessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	// Ignore messages from the bot itself
-	if m.Author.ID == s.State.User.ID {
-		return
+// sessionThreadType returns the type of thread to start for a session in a channel of the given type,
+// or a message explaining why sessions cannot be started there
+func sessionThreadType(channelType discordgo.ChannelType) (discordgo.ChannelType, string) {
+	switch channelType {
+	case discordgo.ChannelTypeGuildText:
+		return discordgo.ChannelTypeGuildPublicThread, ""
+	case discordgo.ChannelTypeGuildNews:
+		if !AppConfig.AllowAnnouncementThreads {
+			return 0, "Sessions are disabled in announcement channels. Start one in a text channel, or ask an admin to set `allow_announcement_threads = true`."
+		}
+		return discordgo.ChannelTypeGuildNewsThread, ""
+	case discordgo.ChannelTypeGuildForum, discordgo.ChannelTypeGuildMedia:
+		return 0, "Sessions cannot be started in forum channels. Start one in a text channel, or run the command inside an existing post."
+	default:
+		return 0, "Sessions can only be started in text channels or inside an existing thread."
 	}
+}
 
-	// Check if the bot is mentioned
-	isMentioned := false
-	for _, mention := range m.Mentions {
-		if mention.ID == s.State.User.ID {
-			isMentioned = true
-			break
+// sessionThreadTypeIn looks up a channel and returns the thread type for a session started in it
+func sessionThreadTypeIn(s *discordgo.Session, channelID string) (discordgo.ChannelType, string) {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+		if err != nil {
+			slog.Warn("failed to get channel info", "channel_id", channelID, "error", err)
+			// Let ThreadStart report the real problem
+			return discordgo.ChannelTypeGuildPublicThread, ""
 		}
 	}
+	return sessionThreadType(channel.Type)
+}
 
-	if !isMentioned {
-		return
+// Shown when Discord refuses to create a session thread because the bot lacks permissions
+const missingThreadPermissionMessage = "I don't have permission to create threads in this channel. Ask an admin to grant the bot **Create Public Threads** and **Send Messages in Threads** here."
+
+// isMissingPermissionError reports whether a Discord REST error means the bot lacks a permission
+func isMissingPermissionError(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
 	}
+	if restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions {
+		return true
+	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden
+}
 
-	// get the channel info to check if it's a thread
-	channel, err := s.Channel(m.ChannelID)
-	if err != nil {
-		slog.Error("failed to get channel info", "channel_id", m.ChannelID, "error", err)
-		s.ChannelMessageSend(m.ChannelID, "Failed to get channel information.")
-		return
+// startSessionThread starts the thread for a new session in channelID. When the bot may not create
+// threads there and allow_channel_sessions is set, the channel itself hosts the session instead and
+// channelSession is true. The refusal is non-empty when neither is possible.
+func startSessionThread(s *discordgo.Session, channelID, threadName string) (thread *discordgo.Channel, channelSession bool, refusal string) {
+	threadType, refusal := sessionThreadTypeIn(s, channelID)
+	if refusal != "" {
+		return nil, false, refusal
 	}
 
-	// check if message is in a thread
-	if channel.Type != discordgo.ChannelTypeGuildPublicThread && channel.Type != discordgo.ChannelTypeGuildPrivateThread {
-		s.ChannelMessageSend(m.ChannelID, "Mentioned the bot outside of a thread. Please send your message in a thread.")
-		return
+	slog.Debug("creating thread", "thread_name", threadName, "channel_id", channelID, "thread_type", threadType)
+	thread, err := s.ThreadStart(
+		channelID,
+		threadName,
+		threadType,
+		1440, // 24 hours
+	)
+	if err == nil {
+		return thread, false, ""
+	}
+	slog.Error("failed to create thread", "channel_id", channelID, "error", err)
+	if !isMissingPermissionError(err) {
+		return nil, false, "Failed to create thread"
+	}
+	if !AppConfig.AllowChannelSessions {
+		return nil, false, missingThreadPermissionMessage
 	}
 
-	threadID := m.ChannelID
+	// A channel can host a single session, like a thread
+	if lazyLoadSession(channelID) != nil {
+		return nil, false, missingThreadPermissionMessage + " This channel already hosts a session, so a new one cannot run here either."
+	}
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		slog.Error("failed to get channel info", "channel_id", channelID, "error", err)
+		return nil, false, missingThreadPermissionMessage
+	}
+	slog.Warn("cannot create threads, running the session in the channel", "channel_id", channelID)
+	return channel, true, ""
+}
 
-	// try to lazy load session for this thread
-	slog.Debug("lazy loading session", "thread_id", threadID)
-	sessionData := lazyLoadSession(threadID)
+// isChannelSession reports whether a regular channel hosts a session because threads could not be created there
+func isChannelSession(channelID string) bool {
+	if !AppConfig.AllowChannelSessions {
+		return false
+	}
+	sessionData := lazyLoadSession(channelID)
 	if sessionData == nil {
-		s.ChannelMessageSend(m.ChannelID, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
-		return
+		return false
 	}
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return sessionData.ChannelSession
+}
 
-	// spawn session listener if not already active (atomic operation)
-	spawnListenerIfNotExists(mainContext, mainWaitGroup, threadID)
-
-	// remove bot mention from the message
-	content := m.Content
-	for _, mention := range m.Mentions {
-		if mention.ID == s.State.User.ID {
-			content = strings.ReplaceAll(content, fmt.Sprintf("<@%s>", mention.ID), "")
-			content = strings.ReplaceAll(content, fmt.Sprintf("<@!%s>", mention.ID), "")
+// interactionThread returns the channel when it is a thread, or nil for regular channels
+func interactionThread(s *discordgo.Session, channelID string) *discordgo.Channel {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+		if err != nil {
+			slog.Warn("failed to get channel info", "channel_id", channelID, "error", err)
+			return nil
 		}
 	}
-	content = strings.TrimSpace(content)
-
-	if content == "" {
-		s.ChannelMessageSend(m.ChannelID, "Please provide a message to send to codesession.")
-		return
+	if !channel.IsThread() {
+		return nil
 	}
+	return channel
+}
 
-	// Check if this is a new query (session not currently streaming)
-	// If so, reset status message fields to start fresh
-	sessionMutex.Lock()
-	if !sessionData.IsStreaming {
-		// This is a new query, reset status message to start fresh
-		sessionData.LastStatusMessageID = ""
-		sessionData.StatusMessageContent = ""
-		sessionData.ToolStatusHistory = ""
-		sessionData.CurrentResponse = ""
-		sessionData.IsStreaming = true // Mark as now streaming
-		slog.Debug("starting new query, reset status message fields", "thread_id", threadID)
-	}
-	sessionMutex.Unlock()
+// rollbackSteps records undo actions for resources created by a multi-step flow
+type rollbackSteps struct {
+	steps []rollbackStep
+}
 
-	// send typing indicator
-	s.ChannelTyping(m.ChannelID)
+type rollbackStep struct {
+	name string
+	undo func() error
+}
 
-	// send message to opencode
-	response := SendMessage(threadID, content)
-	if response == nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to send message to codesession.")
-		return
+// add registers an undo action for a resource that was just created
+func (r *rollbackSteps) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// run undoes the recorded steps in reverse creation order
+func (r *rollbackSteps) run(threadID string) {
+	for idx := len(r.steps) - 1; idx >= 0; idx-- {
+		step := r.steps[idx]
+		if err := step.undo(); err != nil {
+			slog.Error("failed to roll back step", "thread_id", threadID, "step", step.name, "error", err)
+			continue
+		}
+		slog.Debug("rolled back step", "thread_id", threadID, "step", step.name)
 	}
+	r.steps = nil
 }
 
-func handleDiffCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func handleCommitCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	threadID := i.ChannelID
-	slog.Debug("starting diff command", "thread_id", threadID)
+	logger := interactionLogger(i)
+	logger.Debug("starting commit command")
 
 	// Defer response
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 	})
 	if err != nil {
-		slog.Error("failed to defer diff interaction", "thread_id", threadID, "error", err)
+		logger.Error("failed to defer commit interaction", "error", err)
 		return
 	}
-	slog.Debug("diff interaction deferred successfully", "thread_id", threadID)
+	logger.Debug("commit interaction deferred successfully")
+
+	includeUntracked := !AppConfig.CommitExcludeUntracked
+	template := AppConfig.CommitMessageTemplate
+	var confirmed, showDiff, force bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "force":
+			force = option.BoolValue()
+		case "include_untracked":
+			includeUntracked = option.BoolValue()
+		case "confirm":
+			confirmed = option.BoolValue()
+		case "show_diff":
+			showDiff = option.BoolValue()
+		case "template":
+			// Slash command options are single-line, so a literal \n stands for a line break
+			template = strings.ReplaceAll(option.StringValue(), `\n`, "\n")
+		}
+	}
+	if template != "" {
+		if err := validateCommitTemplate(template); err != nil {
+			respondOrFallback(s, i, fmt.Sprintf("Invalid template: it must contain `%s` where the generated message goes.", commitSummaryPlaceholder))
+			return
+		}
+	}
 
 	// Check if session exists
-	slog.Debug("attempting to load session", "thread_id", threadID)
+	logger.Debug("attempting to load session")
 	session := lazyLoadSession(threadID)
 	if session == nil {
-		slog.Error("no session found for thread", "thread_id", threadID)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"No codesession session found for this thread. Please start a session first using `/codesession` command."}[0],
-		})
+		logger.Error("no session found for thread")
+		respondOrFallback(s, i, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
+		return
+	}
+	logger.Debug("session loaded successfully", "session_id", session.SessionID)
+	if !requireRepository(s, i, session) {
+		return
+	}
+	sessionMutex.RLock()
+	model := session.Model
+	sessionMutex.RUnlock()
+	if message := staleModelMessage(model); message != "" {
+		logger.Warn("session model is no longer configured", "provider_id", model.ProviderID, "model_id", model.ModelID)
+		respondOrFallback(s, i, message)
 		return
 	}
-	slog.Debug("session loaded successfully", "thread_id", threadID, "session_id", session.SessionID)
 
 	// Use the stored worktree path from session data
 	worktreePath := session.WorktreePath
-	slog.Debug("using stored worktree path", "thread_id", threadID, "worktree_path", worktreePath, "repository_path", session.RepositoryPath, "repository_name", session.RepositoryName)
+	logger.Debug("using stored worktree path", "worktree_path", worktreePath, "repository_path", session.RepositoryPath, "repository_name", session.RepositoryName)
 
 	// Validate worktree directory exists
 	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
-		slog.Error("worktree directory does not exist", "thread_id", threadID, "worktree_path", worktreePath)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Worktree directory not found. Please start a new session."}[0],
-		})
+		logger.Error("worktree directory does not exist", "worktree_path", worktreePath)
+		respondOrFallback(s, i, "Worktree directory not found. Please start a new session.")
 		return
 	}
-	slog.Debug("worktree directory exists", "thread_id", threadID, "worktree_path", worktreePath)
+	logger.Debug("worktree directory exists", "worktree_path", worktreePath)
 
-	// Get diff
-	slog.Debug("generating diff", "thread_id", threadID)
-	diffOutput, err := gitOps.GetDiff(worktreePath)
+	sessionMutex.RLock()
+	reviewMode := session.ReviewMode
+	sessionMutex.RUnlock()
+	if reviewMode {
+		respondOrFallback(s, i, "This is a read-only review session, there is nothing to commit.")
+		return
+	}
+
+	// Old sessions may hold abandoned work, so require an explicit confirmation before pushing
+	if warning := commitConfirmationWarning(session, time.Now()); warning != "" && !confirmed {
+		respondOrFallback(s, i, warning)
+		return
+	}
+
+	// Refuse while a prompt is running: the active listener would render the summarizer's events into the status message
+	sessionMutex.RLock()
+	isStreaming := session.IsStreaming
+	sessionMutex.RUnlock()
+	if isStreaming {
+		respondOrFallback(s, i, "codesession is still working in this thread. Please wait for it to finish before committing.")
+		return
+	}
+
+	// One commit at a time per thread, so /amend and checkpoint commits cannot interleave with this one
+	unlock, locked := tryLockCommits(threadID)
+	if !locked {
+		respondOrFallback(s, i, commitInProgressMessage)
+		return
+	}
+	defer unlock()
+
+	// Show progress while the summary is generated
+	progressMessage, err := s.ChannelMessageSend(threadID, "⏳ Generating commit message...")
 	if err != nil {
-		slog.Error("failed to generate diff", "thread_id", threadID, "error", err)
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &[]string{"Failed to generate diff."}[0],
-		})
+		logger.Error("failed to send commit progress message", "error", err)
+	}
+	updateProgress := func(content string) {
+		if progressMessage != nil {
+			editDiscordMessage(threadID, progressMessage.ID, content)
+		}
+	}
+	s.ChannelTyping(threadID)
+
+	// send message to opencode to generate commit summary
+	logger.Debug("requesting AI summary for commit", "session_id", session.SessionID)
+	instruction := AppConfig.SummarizerInstruction
+	if instruction == "" {
+		instruction = defaultSummarizerInstruction
+	}
+	summary, err := promptSummarizer(session, instruction)
+	if err != nil && AppConfig.SummaryFallback {
+		logger.Warn("summarizer failed, using the fallback commit message", "error", err)
+		if fallback, fallbackErr := fallbackCommitSummary(worktreePath, includeUntracked); fallbackErr == nil {
+			summary, err = fallback, nil
+			sendToDiscord(threadID, "⚠️ The summarizer model is unavailable, committing with a generic message instead.")
+		} else {
+			logger.Error("failed to build the fallback commit message", "error", fallbackErr)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to generate AI summary", "error", err)
+		updateProgress("❌ Failed to generate commit message.")
+		switch {
+		case errors.Is(err, errOpencodeUnavailable):
+			respondOrFallback(s, i, "OpenCode client is not available.")
+		case errors.Is(err, context.DeadlineExceeded):
+			respondError(s, i, promptErrorMessage(err))
+		default:
+			respondError(s, i, "Failed to generate summary.")
+		}
 		return
 	}
-	slog.Debug("diff generated successfully", "thread_id", threadID, "diff_length", len(diffOutput))
+	// Models sometimes answer with only reasoning or tool calls; ask once more before falling back
+	if strings.TrimSpace(summary) == "" {
+		logger.Warn("summary response had no text, asking again")
+		updateProgress("⏳ Commit message was empty, regenerating...")
+		summary, err = promptSummarizer(session, instruction+"\n\n"+emptySummaryRetryInstruction)
+		if err != nil {
+			logger.Error("failed to regenerate empty summary", "error", err)
+			summary = ""
+		}
+	}
+	if strings.TrimSpace(summary) == "" {
+		summary = "Changes made during session"
+		logger.Debug("using default summary", "summary", summary)
+	}
+	summary = cleanCommitMessage(summary)
+
+	// Ask once more when the message must be a conventional commit and isn't
+	if AppConfig.EnforceConventionalCommits && !isConventionalCommit(summary) {
+		logger.Warn("summary is not a conventional commit, asking again", "summary", summary)
+		updateProgress("⏳ Commit message was not a conventional commit, regenerating...")
+		retry, err := promptSummarizer(session, instruction+"\n\n"+conventionalCommitRetryInstruction)
+		if err == nil {
+			retry = cleanCommitMessage(retry)
+		}
+		if err != nil || !isConventionalCommit(retry) {
+			logger.Error("failed to get a conventional commit message", "summary", retry, "error", err)
+			updateProgress("❌ The generated commit message is not a conventional commit.")
+			respondOrFallback(s, i, "The summarizer did not produce a conventional commit message (`type(scope): description`). Nothing was committed; try `/commit` again.")
+			return
+		}
+		summary = retry
+	}
+	summary = formatCommitMessage(renderCommitTemplate(template, summary), AppConfig.CommitSubjectMax, AppConfig.CommitBodyWrap)
+	logger.Debug("final summary prepared", "summary", summary)
+	updateProgress(fmt.Sprintf("📝 Commit message generated:\n```\n%s\n```", summary))
 
-	// Send diff to thread using existing message chunking
-	slog.Debug("sending diff to thread", "thread_id", threadID)
+	// Create a pending commit record
+	commitRecord := &CommitRecord{
+		Summary:   summary,
+		Timestamp: time.Now(),
+		Status:    "pending",
+	}
 
-	// Update interaction response first
-	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &[]string{"Diff generated successfully:"}[0],
-	})
+	// Add pending commit to session, keeping the pointer so later updates target this record
+	sessionMutex.Lock()
+	session.Commits = append(session.Commits, commitRecord)
+	sessionMutex.Unlock()
+	logger.Debug("added pending commit record", "summary", summary)
+
+	// Check for committable changes and the repository's safety rules, then stage
+	_, refusal, err := stageSessionChanges(session, worktreePath, includeUntracked, force)
+	if err != nil {
+		logger.Error("failed to prepare commit", "error", err)
+		updateCommitRecord(commitRecord, "failed", "")
+		if err := saveSessionData(session); err != nil {
+			logger.Error("failed to save session data for staging failure", "error", err)
+		}
+		updateProgress("❌ Failed to prepare the commit.")
+		respondError(s, i, fmt.Sprintf("Failed to prepare the commit, nothing was committed. Error: %v", err))
+		return
+	}
+	if refusal != nil {
+		logger.Debug("commit refused", "no_changes", refusal.noChanges, "include_untracked", includeUntracked)
+		status := "failed"
+		if refusal.noChanges {
+			status = "no_changes"
+		}
+		updateCommitRecord(commitRecord, status, "")
+		if err := saveSessionData(session); err != nil {
+			logger.Error("failed to save session data for refused commit", "error", err)
+		}
+		respondOrFallback(s, i, refusal.message)
+		return
+	}
+	logger.Debug("all changes staged successfully")
 
-	// Send the diff using the specialized SendDiscordDiffMessage function which handles chunking with code blocks
-	SendDiscordDiffMessage(threadID, diffOutput)
+	// Git commit operation
+	logger.Debug("committing changes", "commit_message", summary)
+	commitHash, err := gitOps.Commit(worktreePath, summary, commitTrailers(threadID, interactionUserID(i), interactionUsername(i)))
+	if err != nil {
+		logger.Error("failed to create commit", "error", err)
 
-	slog.Debug("diff command completed successfully", "thread_id", threadID)
+		// Update commit record with failed status
+		updateCommitRecord(commitRecord, "failed", "")
+
+		// Save session data after releasing mutex to avoid deadlock
+		if err := saveSessionData(session); err != nil {
+			logger.Error("failed to save session data for commit failure", "error", err)
+		}
+
+		if AppConfig.UseEmbeds {
+			sendCommitEmbed(threadID, commitRecord, worktreePath)
+		}
+
+		respondError(s, i, fmt.Sprintf("Failed to commit changes. Error: %v", err))
+		return
+	}
+	logger.Debug("commit created successfully", "commit_hash", commitHash)
+
+	// Check current branch before push
+	currentBranch, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		logger.Error("failed to get current branch", "error", err)
+		currentBranch = "main" // fallback to main branch
+	}
+	logger.Debug("current branch", "branch", currentBranch)
+
+	sessionMutex.Lock()
+	commitRecord.Branch = currentBranch
+	sessionMutex.Unlock()
+
+	// Git push operation with specific branch
+	logger.Debug("pushing changes to remote", "branch", currentBranch)
+	err = gitOps.Push(worktreePath, currentBranch)
+	if err != nil {
+		logger.Error("failed to push changes", "error", err)
+
+		// Update commit record with failed status (commit succeeded but push failed)
+		updateCommitRecord(commitRecord, "failed", commitHash)
+
+		// Save session data after releasing mutex to avoid deadlock
+		if err := saveSessionData(session); err != nil {
+			logger.Error("failed to save session data for push failure", "error", err)
+		}
+
+		if AppConfig.UseEmbeds {
+			sendCommitEmbed(threadID, commitRecord, worktreePath)
+		}
+
+		respondError(s, i, fmt.Sprintf("Failed to push changes. Error: %v.", err))
+		return
+	}
+	logger.Debug("push completed successfully")
+
+	// Pushing may have rebased the commit onto the remote branch, giving it a new hash
+	if head, err := gitOps.GetCommitHash(worktreePath); err == nil {
+		commitHash = head
+	}
+
+	// Update commit record with success status
+	logger.Debug("updating commit record with success status", "commit_hash", commitHash)
+	updateCommitRecord(commitRecord, "success", commitHash)
+
+	// Save session data after releasing the mutex to avoid deadlock
+	logger.Debug("about to save session data")
+	if err := saveSessionData(session); err != nil {
+		logger.Error("failed to save session data after successful commit", "error", err)
+	} else {
+		logger.Debug("saved session data with success status", "commit_hash", commitHash)
+	}
+
+	// Record the pushed commit in the central index
+	if err := appendCommitIndex(CommitIndexEntry{
+		Timestamp:  commitRecord.Timestamp,
+		Repository: session.RepositoryName,
+		Branch:     currentBranch,
+		Hash:       commitHash,
+		Summary:    summary,
+		UserID:     interactionUserID(i),
+		ThreadID:   threadID,
+	}); err != nil {
+		logger.Error("failed to append to commit index", "error", err)
+	}
+
+	// Send detailed success message to thread
+	logger.Debug("preparing detailed success message")
+	logger.Debug("sending detailed success message to thread")
+	if AppConfig.UseEmbeds {
+		sendCommitEmbed(threadID, commitRecord, worktreePath)
+	} else {
+		detailedMessage := fmt.Sprintf("**Commit & Push Successful** (git hooks skipped)\n\n**Summary:** %s\n**Hash:** %s\n**Branch:** %s\n\n⚠️ Caution: Git hooks are skipped (if any).",
+			summary, commitHash, currentBranch)
+
+		SendDiscordMessage(threadID, detailedMessage)
+	}
+
+	// Warn when the branch fell behind the base the session targets, since a PR would need a rebase
+	if notice := behindBaseNotice(session); notice != "" {
+		SendDiscordMessage(threadID, notice)
+	}
+
+	// Rename the thread after the first successful commit when enabled
+	if AppConfig.UpdateThreadTitle {
+		updateThreadTitleFromCommit(s, session, summary)
+	}
+
+	// Post what was committed when requested
+	if showDiff {
+		commitDiff, err := gitOps.Show(worktreePath, commitHash)
+		if err != nil {
+			logger.Error("failed to show commit", "commit_hash", commitHash, "error", err)
+			SendDiscordMessage(threadID, "Failed to show the committed diff.")
+		} else {
+			SendDiscordDiff(threadID, fmt.Sprintf("%s.diff", commitHash[:min(len(commitHash), 7)]), commitDiff)
+		}
+	}
+
+	// Update interaction response
+	logger.Debug("updating interaction response with success")
+	respondOrFallback(s, i, "Commit completed successfully!")
+
+	logger.Debug("commit command completed successfully", "final_summary", summary, "commit_hash", commitHash)
+}
+
+// commitConfirmationWarning returns a warning when the session is older than commit_confirm_after, or "" when no confirmation is needed
+func commitConfirmationWarning(session *SessionData, now time.Time) string {
+	if AppConfig.CommitConfirmAfter <= 0 {
+		return ""
+	}
+
+	sessionMutex.RLock()
+	createdAt := session.CreatedAt
+	lastActivity := session.LastActivity
+	sessionMutex.RUnlock()
+
+	age := now.Sub(createdAt)
+	if age < AppConfig.CommitConfirmAfter {
+		return ""
+	}
+
+	lastActivityText := "unknown"
+	if !lastActivity.IsZero() {
+		lastActivityText = fmt.Sprintf("%s ago", now.Sub(lastActivity).Round(time.Minute))
+	}
+	return fmt.Sprintf("⚠️ This session is %s old (last activity: %s). Run `/commit confirm:true` to commit and push anyway.",
+		age.Round(time.Minute), lastActivityText)
+}
+
+// Discord's limit for channel and thread names
+const threadNameLimit = 100
+
+// Thread names follow this template unless thread_name_template is configured
+const defaultThreadNameTemplate = "codesession: {random}"
+
+// threadNameValues fills the placeholders of a thread name template
+type threadNameValues struct {
+	Repo   string    // {repo}: repository name
+	User   string    // {user}: name of the user starting the session
+	Date   time.Time // {date}: start date as YYYY-MM-DD
+	Random string    // {random}: random adjective-noun name
+}
+
+// renderThreadName fills a thread name template, collapsing whitespace and control characters and
+// truncating to Discord's limit. An empty template or result falls back to the default template.
+func renderThreadName(template string, values threadNameValues) string {
+	if strings.TrimSpace(template) == "" {
+		template = defaultThreadNameTemplate
+	}
+	replacer := strings.NewReplacer(
+		"{repo}", values.Repo,
+		"{user}", values.User,
+		"{date}", values.Date.Format(time.DateOnly),
+		"{random}", values.Random,
+	)
+	name := strings.Join(strings.FieldsFunc(replacer.Replace(template), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	if name == "" && template != defaultThreadNameTemplate {
+		return renderThreadName(defaultThreadNameTemplate, values)
+	}
+	if runes := []rune(name); len(runes) > threadNameLimit {
+		name = strings.TrimSpace(string(runes[:threadNameLimit]))
+	}
+	return name
+}
+
+// interactionUsername returns the display name of the invoking user, falling back to the username
+func interactionUsername(i *discordgo.InteractionCreate) string {
+	if i.Member != nil {
+		if i.Member.Nick != "" {
+			return i.Member.Nick
+		}
+		if i.Member.User != nil {
+			return i.Member.User.DisplayName()
+		}
+	}
+	if i.User != nil {
+		return i.User.DisplayName()
+	}
+	return ""
+}
+
+// threadTitleFromSummary derives a thread title from the first line of a commit summary
+func threadTitleFromSummary(summary string) string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(summary), "\n")
+	title := "codesession: " + strings.TrimSpace(firstLine)
+	if runes := []rune(title); len(runes) > threadNameLimit {
+		title = strings.TrimSpace(string(runes[:threadNameLimit-3])) + "..."
+	}
+	return title
+}
+
+// updateThreadTitleFromCommit renames the thread on its first successful commit. Threads the user
+// renamed themselves (no longer carrying the generated prefix) are left alone, and only the first
+// commit triggers a rename to stay clear of Discord's channel edit rate limits.
+func updateThreadTitleFromCommit(s *discordgo.Session, session *SessionData, summary string) {
+	sessionMutex.RLock()
+	successCount := 0
+	for _, commit := range session.Commits {
+		if commit.Status == "success" {
+			successCount++
+		}
+	}
+	threadID := session.ThreadID
+	generatedName := session.ThreadName
+	sessionMutex.RUnlock()
+	if successCount != 1 {
+		return
+	}
+
+	thread, err := s.Channel(threadID)
+	if err != nil {
+		slog.Error("failed to get thread for title update", "thread_id", threadID, "error", err)
+		return
+	}
+	// Sessions saved before thread names were recorded only know the default prefix
+	renamed := !strings.HasPrefix(thread.Name, "codesession: ")
+	if generatedName != "" {
+		renamed = thread.Name != generatedName
+	}
+	if renamed {
+		slog.Debug("thread was renamed by a user, skipping title update", "thread_id", threadID, "name", thread.Name)
+		return
+	}
+
+	title := threadTitleFromSummary(summary)
+	if _, err := s.ChannelEdit(threadID, &discordgo.ChannelEdit{Name: title}); err != nil {
+		slog.Error("failed to update thread title", "thread_id", threadID, "error", err)
+		return
+	}
+	slog.Debug("updated thread title", "thread_id", threadID, "title", title)
+}
+
+// sendCommitEmbed posts the commit result as an embed, linking the commit when the remote is a known web host
+func sendCommitEmbed(threadID string, record *CommitRecord, worktreePath string) {
+	sessionMutex.RLock()
+	snapshot := *record
+	sessionMutex.RUnlock()
+
+	var filesChanged int
+	var commitURL string
+	if snapshot.Hash != "" {
+		if count, err := gitOps.CountCommitFiles(worktreePath, snapshot.Hash); err == nil {
+			filesChanged = count
+		}
+		if remoteURL, err := gitOps.GetRemoteURL(worktreePath); err == nil {
+			commitURL = remoteCommitURL(remoteURL, snapshot.Hash)
+		}
+	}
+
+	embed := buildCommitEmbed(&snapshot, filesChanged, commitURL)
+	if _, err := discord.ChannelMessageSendEmbed(threadID, embed); err != nil {
+		slog.Error("failed to send commit embed", "thread_id", threadID, "error", err)
+	}
+}
+
+func MessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	// Ignore messages from the bot itself
+	if m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	// Ignore other bots and webhooks unless explicitly allowed, to avoid loops and unintended cost
+	if m.Author.Bot && !slices.Contains(AppConfig.AllowedBotIDs, m.Author.ID) {
+		slog.Debug("ignoring message from bot", "author_id", m.Author.ID, "channel_id", m.ChannelID)
+		return
+	}
+
+	// Check if the bot is mentioned
+	isMentioned := false
+	for _, mention := range m.Mentions {
+		if mention.ID == s.State.User.ID {
+			isMentioned = true
+			break
+		}
+	}
+
+	// In auto-respond session threads every plain user message is a prompt, no mention needed
+	if !isMentioned && !isAutoRespondMessage(m) {
+		return
+	}
+
+	// get the channel info to check if it's a thread
+	channel, err := s.Channel(m.ChannelID)
+	if err != nil {
+		slog.Error("failed to get channel info", "channel_id", m.ChannelID, "error", err)
+		s.ChannelMessageSend(m.ChannelID, "Failed to get channel information.")
+		return
+	}
+
+	// check if message is in a thread, or in a channel hosting a session
+	if !channel.IsThread() && !isChannelSession(m.ChannelID) {
+		s.ChannelMessageSend(m.ChannelID, "Mentioned the bot outside of a thread. Please send your message in a thread.")
+		return
+	}
+
+	threadID := m.ChannelID
+
+	// try to lazy load session for this thread
+	slog.Debug("lazy loading session", "thread_id", threadID)
+	sessionData := lazyLoadSession(threadID)
+	if sessionData == nil {
+		s.ChannelMessageSend(m.ChannelID, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
+		return
+	}
+
+	// remove bot mention from the message
+	content := stripBotMention(s, m.Content)
+
+	attachedImages := imageAttachments(m.Attachments)
+	if content == "" && len(attachedImages) == 0 {
+		if !isMentioned {
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, "Please provide a message to send to codesession.")
+		return
+	}
+
+	sessionMutex.RLock()
+	model := sessionData.Model
+	sessionMutex.RUnlock()
+
+	// Forward screenshots to vision models, tell the user when they are dropped
+	var images []promptImage
+	if len(attachedImages) > 0 {
+		if modelSupportsVision(model) {
+			var notes []string
+			images, notes = downloadPromptImages(attachedImages)
+			if len(notes) > 0 {
+				s.ChannelMessageSend(threadID, strings.Join(notes, "\n"))
+			}
+		} else {
+			s.ChannelMessageSend(threadID, fmt.Sprintf("%s/%s is not configured with `supports_vision`, attached images are ignored.", model.ProviderID, model.ModelID))
+		}
+	}
+	if content == "" && len(images) == 0 {
+		return
+	}
+
+	trackEditablePrompt(threadID, m.ID, m.Author.ID, content, images)
+	coalescePrompt(s, sessionData, m.Author.ID, content, images)
+}
+
+// dispatchPrompt sends a user's prompt, unless it is held back for a cost confirmation
+func dispatchPrompt(s *discordgo.Session, sessionData *SessionData, authorID, content string, images []promptImage) {
+	sessionMutex.RLock()
+	model := sessionData.Model
+	sessionMutex.RUnlock()
+
+	if holdExpensivePrompt(s, sessionData.ThreadID, authorID, content, images, model) {
+		return
+	}
+
+	sendPrompt(s, sessionData, authorID, content, images)
+}
+
+// isAutoRespondMessage reports whether a message that does not mention the bot should still be
+// handled as a prompt: a plain user message in a thread whose session has auto-respond enabled
+func isAutoRespondMessage(m *discordgo.MessageCreate) bool {
+	if !AppConfig.EnableAutoRespond {
+		return false
+	}
+	if m.Type != discordgo.MessageTypeDefault && m.Type != discordgo.MessageTypeReply {
+		return false
+	}
+	// Text that looks like a slash command was most likely meant for Discord, not the model
+	if strings.HasPrefix(strings.TrimSpace(m.Content), "/") {
+		return false
+	}
+	sessionData := lazyLoadSession(m.ChannelID)
+	if sessionData == nil {
+		return false
+	}
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return sessionData.AutoRespond
+}
+
+// sendPrompt starts a new turn and sends content to opencode, or queues it while a turn is running
+func sendPrompt(s *discordgo.Session, sessionData *SessionData, authorID, content string, images []promptImage) {
+	threadID := sessionData.ThreadID
+
+	// Check if this is a new query (session not currently streaming)
+	// If so, reset status message fields to start fresh, otherwise queue the prompt
+	sessionMutex.Lock()
+	if sessionData.IsStreaming {
+		// A turn is still running; hold the prompt until the session goes idle.
+		// Enqueue under the session lock so the idle handler cannot miss it.
+		position, queued := enqueuePrompt(threadID, queuedPrompt{AuthorID: authorID, Content: content, Images: images, QueuedAt: time.Now()})
+		sessionMutex.Unlock()
+		if !queued {
+			s.ChannelMessageSend(threadID, fmt.Sprintf("The prompt queue is full (%d prompts). Wait for the current turn to finish or clear it with `/queue clear`.", maxQueuedPrompts))
+			return
+		}
+		slog.Debug("queued prompt while streaming", "thread_id", threadID, "position", position)
+		s.ChannelMessageSend(threadID, fmt.Sprintf("⏳ A prompt is still running, yours is queued at position %d.", position))
+		return
+	}
+
+	// This is a new query, reset status message to start fresh
+	sessionData.LastStatusMessageID = ""
+	sessionData.StatusMessageIDs = nil
+	sessionData.StatusMessageContents = nil
+	sessionData.StatusMessageContent = ""
+	sessionData.ToolStatusHistory = ""
+	sessionData.CurrentResponse = ""
+	sessionData.TurnHadText = false
+	sessionData.LastReasoning = ""
+	sessionData.IsStreaming = true // Mark as now streaming
+	sessionData.LastEventAt = time.Now()
+	sessionData.UpdatesPausedAt = time.Time{} // A pause only lasts for the turn it was made in
+	sessionData.CountedCostParts = nil
+	sessionData.PromptTurn++
+	turn := sessionData.PromptTurn
+	message := planPrompt(sessionData, content)
+	slog.Debug("starting new query, reset status message fields", "thread_id", threadID)
+	sessionData.LastActivity = time.Now()
+	sessionMutex.Unlock()
+	refreshPresence()
+	bindEditablePromptTurn(threadID, authorID, content, turn)
+
+	// Spawn the listener only after the turn is marked as streaming, so its connect event cannot make
+	// this prompt look like it arrived mid-turn
+	spawnListenerIfNotExists(mainContext, mainWaitGroup, threadID)
+
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data with last activity", "thread_id", threadID, "error", err)
+	}
+
+	auditPrompt(authorID, threadID, content)
+	resetAutoCommitTimer(threadID)
+	stopInactivityTimer(threadID)
+	go autoNameBranch(sessionData, content)
+
+	// send typing indicator
+	s.ChannelTyping(threadID)
+
+	// send message to opencode
+	if _, err := SendMessage(threadID, message, images); err != nil {
+		// An edit re-ran the prompt (rerun_on_edit), so this one was aborted on purpose
+		sessionMutex.RLock()
+		superseded := sessionData.PromptTurn != turn
+		sessionMutex.RUnlock()
+		if superseded {
+			slog.Debug("superseded prompt ended", "thread_id", threadID, "error", err)
+			return
+		}
+		correlationID := newCorrelationID()
+		slog.Error("prompt failed", "thread_id", threadID, "correlation_id", correlationID, "error", err)
+
+		// The turn is over, so end it like a finished one: otherwise every later prompt would be
+		// queued behind it until the watchdog gives up
+		stopActiveListener(threadID)
+		sessionMutex.Lock()
+		sessionData.IsStreaming = false
+		sessionMutex.Unlock()
+		refreshPresence()
+		if err := saveSessionData(sessionData); err != nil {
+			slog.Error("failed to save session data after failed prompt", "thread_id", threadID, "error", err)
+		}
+
+		finalizeStatusMessage(threadID, statusOutcomeFailed)
+		s.ChannelMessageSend(threadID, withCorrelationID(promptErrorMessage(err), correlationID))
+		continueAfterTurn(threadID)
+		return
+	}
+}
+
+// fallbackCommitSummary builds a commit message from the worktree status for when the summarizer
+// model is unavailable, e.g. "chore: update 3 files (+40/-12)"
+func fallbackCommitSummary(worktreePath string, includeUntracked bool) (string, error) {
+	gitStatus, err := gitOps.GetStatus(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	added, deleted, err := gitOps.UncommittedLineCounts(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	files := gitStatus.TotalCount
+	if !includeUntracked {
+		files -= gitStatus.UntrackedCount
+	}
+	return formatFallbackCommitSummary(files, added, deleted), nil
+}
+
+// formatFallbackCommitSummary renders the deterministic commit message used by fallbackCommitSummary
+func formatFallbackCommitSummary(files, added, deleted int) string {
+	noun := "files"
+	if files == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("chore: update %d %s (+%d/-%d)", files, noun, added, deleted)
+}
+
+// Asks for the commit message of /commit and /amend when summarizer_instruction is unset
+const defaultSummarizerInstruction = "Generate a git commit message in conventional commit format. The first line should be in the format 'type(scope): description'. Follow with a bullet-point list of key changes made in the session. Keep the entire message concise."
+
+// Appended to the summarizer instruction when the first answer contained no text
+const emptySummaryRetryInstruction = "Respond with ONLY the commit message as plain text: no tools, no preamble, no code fences."
+
+// Appended to the summarizer instruction when the first answer was not a conventional commit
+const conventionalCommitRetryInstruction = "Your previous answer was not a valid conventional commit message. Reply with only the commit message: the first line must be 'type(scope): description' (for example 'fix(api): handle empty response'), without code fences or markdown."
+
+func handleDiffCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting diff command", "thread_id", threadID)
+
+	// Defer response
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		slog.Error("failed to defer diff interaction", "thread_id", threadID, "error", err)
+		return
+	}
+	slog.Debug("diff interaction deferred successfully", "thread_id", threadID)
+
+	// Check if session exists
+	slog.Debug("attempting to load session", "thread_id", threadID)
+	session := lazyLoadSession(threadID)
+	if session == nil {
+		slog.Error("no session found for thread", "thread_id", threadID)
+		respondOrFallback(s, i, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
+		return
+	}
+	slog.Debug("session loaded successfully", "thread_id", threadID, "session_id", session.SessionID)
+	if !requireRepository(s, i, session) {
+		return
+	}
+
+	// Use the stored worktree path from session data
+	worktreePath := session.WorktreePath
+	slog.Debug("using stored worktree path", "thread_id", threadID, "worktree_path", worktreePath, "repository_path", session.RepositoryPath, "repository_name", session.RepositoryName)
+
+	// Validate worktree directory exists
+	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
+		slog.Error("worktree directory does not exist", "thread_id", threadID, "worktree_path", worktreePath)
+		respondOrFallback(s, i, "Worktree directory not found. Please start a new session.")
+		return
+	}
+	slog.Debug("worktree directory exists", "thread_id", threadID, "worktree_path", worktreePath)
+
+	var staged, base bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "staged":
+			staged = option.BoolValue()
+		case "base":
+			base = option.BoolValue()
+		}
+	}
+
+	// Get diff
+	slog.Debug("generating diff", "thread_id", threadID, "staged", staged, "base", base)
+	var diffOutput string
+	switch {
+	case base:
+		baseBranch, baseErr := sessionBaseBranch(session)
+		if baseErr != nil {
+			slog.Error("failed to determine base branch", "thread_id", threadID, "error", baseErr)
+			respondOrFallback(s, i, "Could not determine the base branch.")
+			return
+		}
+		diffOutput, err = gitOps.GetBranchDiff(worktreePath, baseBranch)
+	case staged:
+		diffOutput, err = gitOps.GetStagedDiff(worktreePath)
+	default:
+		diffOutput, err = gitOps.GetDiff(worktreePath)
+	}
+	if err != nil {
+		slog.Error("failed to generate diff", "thread_id", threadID, "error", err)
+		respondOrFallback(s, i, "Failed to generate diff.")
+		return
+	}
+
+	// A clean worktree doesn't mean nothing happened when the changes were already committed
+	if diffOutput == noChangesDiff {
+		if message := committedChangesHint(session); message != "" {
+			respondOrFallback(s, i, message)
+			return
+		}
+	}
+	slog.Debug("diff generated successfully", "thread_id", threadID, "diff_length", len(diffOutput))
+
+	// Send diff to thread using existing message chunking
+	slog.Debug("sending diff to thread", "thread_id", threadID)
+
+	// Update interaction response first
+	respondOrFallback(s, i, "Diff generated successfully:")
+
+	// Send the diff chunked into code blocks, or as an attachment when large
+	SendDiscordDiff(threadID, "worktree.diff", diffOutput)
+
+	slog.Debug("diff command completed successfully", "thread_id", threadID)
+}
+
+// worktreeFilePath turns a user-supplied path into one relative to the worktree root, refusing
+// paths that would leave the worktree
+func worktreeFilePath(worktreePath, path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("path is empty")
+	}
+	relPath := path
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(worktreePath, path)
+		if err != nil {
+			return "", fmt.Errorf("path %q is outside the worktree", path)
+		}
+		relPath = rel
+	}
+	relPath = filepath.Clean(relPath)
+	if !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("path %q is outside the worktree", path)
+	}
+	return relPath, nil
+}
+
+func handleFileDiffCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting filediff command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer filediff interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+	worktreePath := session.WorktreePath
+
+	var path string
+	var staged bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "path":
+			path = option.StringValue()
+		case "staged":
+			staged = option.BoolValue()
+		}
+	}
+
+	relPath, err := worktreeFilePath(worktreePath, path)
+	if err != nil {
+		respondOrFallback(s, i, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+
+	var diffOutput string
+	if staged {
+		diffOutput, err = gitOps.GetStagedDiff(worktreePath, relPath)
+	} else {
+		diffOutput, err = gitOps.GetDiff(worktreePath, relPath)
+	}
+	if err != nil {
+		slog.Error("failed to generate file diff", "thread_id", threadID, "path", relPath, "error", err)
+		respondOrFallback(s, i, "Failed to generate diff.")
+		return
+	}
+
+	// An empty diff means either an unchanged file or a path that isn't there at all
+	if diffOutput == noChangesDiff || diffOutput == noStagedChangesDiff {
+		_, statErr := os.Stat(filepath.Join(worktreePath, relPath))
+		tracked := gitOps.IsTracked(worktreePath, relPath)
+		switch {
+		case statErr != nil && !tracked:
+			respondOrFallback(s, i, fmt.Sprintf("`%s` was not found in the worktree.", relPath))
+		case !tracked:
+			respondOrFallback(s, i, fmt.Sprintf("`%s` is untracked, so it has no diff yet.", relPath))
+		case staged:
+			respondOrFallback(s, i, fmt.Sprintf("`%s` has no staged changes.", relPath))
+		default:
+			respondOrFallback(s, i, fmt.Sprintf("`%s` has no uncommitted changes.", relPath))
+		}
+		return
+	}
+
+	respondOrFallback(s, i, fmt.Sprintf("Diff of `%s`:", relPath))
+	SendDiscordDiff(threadID, filepath.Base(relPath)+".diff", diffOutput)
+}
+
+// commitTrailers returns the configured trailers tracing a commit back to its thread and requester.
+// requesterID is empty for commits nobody asked for, such as checkpoints.
+func commitTrailers(threadID, requesterID, requesterName string) []CommitTrailer {
+	var trailers []CommitTrailer
+	if AppConfig.CommitThreadTrailer {
+		trailers = append(trailers, CommitTrailer{Key: "Codesession-Thread", Value: threadID})
+	}
+	if AppConfig.CommitRequesterTrailer && requesterID != "" {
+		trailers = append(trailers, CommitTrailer{Key: "Requested-by", Value: fmt.Sprintf("%s (discord:%s)", requesterName, requesterID)})
+	}
+	return trailers
+}
+
+// sessionBaseBranch returns the branch the session targets: the one set with /setbase, otherwise
+// the remote's default branch
+func sessionBaseBranch(session *SessionData) (string, error) {
+	sessionMutex.RLock()
+	baseBranch := session.BaseBranch
+	worktreePath := session.WorktreePath
+	sessionMutex.RUnlock()
+	if baseBranch != "" {
+		return baseBranch, nil
+	}
+	return gitOps.GetBaseBranch(worktreePath)
+}
+
+// committedChangesHint explains a clean worktree whose branch is ahead of the base branch,
+// or returns "" when the branch has no commits over base
+func committedChangesHint(session *SessionData) string {
+	baseBranch, err := sessionBaseBranch(session)
+	if err != nil {
+		return ""
+	}
+	ahead, _, err := gitOps.AheadBehind(session.WorktreePath, baseBranch)
+	if err != nil || ahead == 0 {
+		return ""
+	}
+	return fmt.Sprintf("No uncommitted changes. Use `/diff base:true` to see committed changes (%d commit(s) ahead of %s).", ahead, baseBranch)
+}
+
+// sessionModeName renders whether a session may edit files and whether it plans first
+func sessionModeName(reviewMode, planFirst bool) string {
+	if reviewMode {
+		return "review (read-only)"
+	}
+	if planFirst {
+		return "edit (plan first)"
+	}
+	return "edit"
+}
+
+// baseRefDisplayName renders the ref a session started from, defaulting to the repository's current branch
+func baseRefDisplayName(baseRef string) string {
+	if baseRef == "" {
+		return "current branch"
+	}
+	return baseRef
+}
+
+// agentDisplayName renders an agent name, showing the server default when unset
+func agentDisplayName(agent string) string {
+	if agent == "" {
+		return "default"
+	}
+	return agent
+}
+
+func handleAgentCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting agent command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer agent interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	var agent string
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "name" {
+			agent = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	// Without a name, report the active agent and the available ones
+	if agent == "" {
+		sessionMutex.RLock()
+		current := session.Agent
+		sessionMutex.RUnlock()
+
+		available, err := listPrimaryAgents(session.WorktreePath)
+		if err != nil {
+			slog.Error("failed to list agents", "thread_id", threadID, "error", err)
+			respondOrFallback(s, i, fmt.Sprintf("Active agent: **%s**\nFailed to list available agents.", agentDisplayName(current)))
+			return
+		}
+		respondOrFallback(s, i, fmt.Sprintf("Active agent: **%s**\nAvailable agents: %s", agentDisplayName(current), strings.Join(available, ", ")))
+		return
+	}
+
+	if err := validateAgent(session.WorktreePath, agent); err != nil {
+		slog.Error("invalid agent selection", "thread_id", threadID, "agent", agent, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("Invalid agent: %v", err))
+		return
+	}
+
+	sessionMutex.Lock()
+	session.Agent = agent
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with agent", "thread_id", threadID, "error", err)
+	}
+
+	slog.Debug("agent switched", "thread_id", threadID, "agent", agent)
+	respondOrFallback(s, i, fmt.Sprintf("Agent switched to **%s**. It applies to the next message.", agent))
+}
+
+// staleModelMessage tells the user that a session's model was removed from the config, "" while it is still configured
+func staleModelMessage(model Model) string {
+	if findModel(model.ProviderID, model.ModelID) != nil {
+		return ""
+	}
+	return fmt.Sprintf("This session's model `%s/%s` is no longer configured. Switch to an available one with `/model`.", model.ProviderID, model.ModelID)
+}
+
+// handleModelCommand shows or switches the model of the current session
+func handleModelCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting model command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer model interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	modelIndex := -1
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "model":
+			modelIndex = int(option.IntValue())
+		}
+	}
+
+	// Without a model, report the current one
+	if modelIndex == -1 {
+		sessionMutex.RLock()
+		current := session.Model
+		sessionMutex.RUnlock()
+		content := fmt.Sprintf("Active model: **%s/%s**", current.ProviderID, current.ModelID)
+		if message := staleModelMessage(current); message != "" {
+			content += "\n" + message
+		}
+		respondOrFallback(s, i, content)
+		return
+	}
+	if modelIndex < 0 || modelIndex >= len(AppConfig.Models) {
+		respondOrFallback(s, i, "Invalid model selection")
+		return
+	}
+
+	model := AppConfig.Models[modelIndex]
+	sessionMutex.Lock()
+	previous := session.Model
+	session.Model = model
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with model", "thread_id", threadID, "error", err)
+	}
+
+	slog.Info("model switched", "thread_id", threadID, "from", fmt.Sprintf("%s/%s", previous.ProviderID, previous.ModelID), "to", fmt.Sprintf("%s/%s", model.ProviderID, model.ModelID))
+	respondOrFallback(s, i, fmt.Sprintf("Model switched to **%s/%s**. It applies to the next message.", model.ProviderID, model.ModelID))
+}
+
+func handleStatusCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting status command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer status interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	sessionMutex.RLock()
+	repositoryName := session.RepositoryName
+	if session.Scratch {
+		repositoryName = "(scratch, no repository)"
+	}
+	status := fmt.Sprintf(`%s
+Repository: %s
+Model: %s
+Agent: %s
+Mode: %s
+Active: %t
+Streaming: %t
+Updates Paused: %t
+Commits: %d
+Cost: $%.2f
+Tokens: %d in / %d out (%d steps)
+Created At: %s
+%s`, "```", repositoryName, fmt.Sprintf("%s/%s", session.Model.ProviderID, session.Model.ModelID),
+		agentDisplayName(session.Agent), sessionModeName(session.ReviewMode, session.PlanFirst), session.Active, session.IsStreaming, !session.UpdatesPausedAt.IsZero(), len(session.Commits),
+		session.TotalCost, session.TotalInputTokens, session.TotalOutputTokens, session.StepCount, session.CreatedAt.Format(time.RFC3339), "```")
+	sessionMutex.RUnlock()
+
+	respondOrFallback(s, i, status)
+}
+
+func handleGitConfigCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting gitconfig command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer gitconfig interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireWorktree(s, i, session) {
+		return
+	}
+
+	var key, value string
+	var hasValue bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "key":
+			key = strings.TrimSpace(option.StringValue())
+		case "value":
+			value = option.StringValue()
+			hasValue = true
+		}
+	}
+
+	// Without a key, show the current values of every allowed key
+	if key == "" {
+		var lines []string
+		for _, allowedKey := range allowedGitConfigKeys {
+			current, err := gitOps.GetConfig(session.WorktreePath, allowedKey)
+			if err != nil {
+				slog.Error("failed to read git config", "thread_id", threadID, "key", allowedKey, "error", err)
+				current = "(error)"
+			} else if current == "" {
+				current = "(unset)"
+			}
+			lines = append(lines, fmt.Sprintf("%s = %s", allowedKey, current))
+		}
+		respondOrFallback(s, i, fmt.Sprintf("```\n%s\n```", strings.Join(lines, "\n")))
+		return
+	}
+
+	if !isAllowedGitConfigKey(key) {
+		respondOrFallback(s, i, fmt.Sprintf("Git config key `%s` is not allowed. Allowed keys: %s", key, strings.Join(allowedGitConfigKeys, ", ")))
+		return
+	}
+
+	if !hasValue {
+		current, err := gitOps.GetConfig(session.WorktreePath, key)
+		if err != nil {
+			slog.Error("failed to read git config", "thread_id", threadID, "key", key, "error", err)
+			respondOrFallback(s, i, "Failed to read git config.")
+			return
+		}
+		if current == "" {
+			current = "(unset)"
+		}
+		respondOrFallback(s, i, fmt.Sprintf("`%s = %s`", key, current))
+		return
+	}
+
+	sessionMutex.RLock()
+	repository := findRepository(session.RepositoryName)
+	sessionMutex.RUnlock()
+	enableExtension := repository != nil && repository.AllowWorktreeConfig
+	if err := gitOps.SetConfig(session.WorktreePath, key, value, enableExtension); errors.Is(err, errWorktreeConfigDisabled) {
+		respondOrFallback(s, i, "This repository does not use per-worktree git config, and enabling it would change the repository's shared config. Set `allow_worktree_config = true` for the repository to allow it.")
+		return
+	} else if err != nil {
+		slog.Error("failed to set git config", "thread_id", threadID, "key", key, "error", err)
+		respondOrFallback(s, i, "Failed to set git config.")
+		return
+	}
+	slog.Debug("git config updated", "thread_id", threadID, "key", key)
+	respondOrFallback(s, i, fmt.Sprintf("Set `%s = %s` for this worktree.", key, value))
+}
+
+func handleContextCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting context command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer context interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireRepository(s, i, session) {
+		return
+	}
+
+	branch, err := gitOps.GetCurrentBranch(session.WorktreePath)
+	if err != nil {
+		slog.Error("failed to get current branch", "thread_id", threadID, "error", err)
+		branch = "(unknown)"
+	}
+
+	sessionMutex.RLock()
+	contextMessage := fmt.Sprintf(`%s
+Repository: %s
+Repository Path: %s
+Worktree Path: %s
+Branch: %s
+Session ID: %s
+%s`, "```", session.RepositoryName, session.RepositoryPath, session.WorktreePath, branch, session.SessionID, "```")
+	sessionMutex.RUnlock()
+
+	respondOrFallback(s, i, contextMessage)
+}
+
+// handleRemoteCommand shows where the session branch pushes to: the remotes with their credentials
+// redacted, the current branch and its upstream
+func handleRemoteCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	logger := interactionLogger(i)
+	if err := deferInteraction(s, i, true); err != nil {
+		logger.Error("failed to defer remote interaction", "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireWorktree(s, i, session) {
+		return
+	}
+
+	remotes, err := gitOps.ListRemotes(session.WorktreePath)
+	if err != nil {
+		logger.Error("failed to list remotes", "error", err)
+		respondError(s, i, "Failed to list the git remotes.")
+		return
+	}
+	tracking, err := gitOps.GetTrackingInfo(session.WorktreePath)
+	if err != nil {
+		logger.Error("failed to get tracking info", "error", err)
+		respondError(s, i, "Failed to get the branch tracking info.")
+		return
+	}
+
+	respondOrFallback(s, i, formatRemoteInfo(remotes, tracking))
+}
+
+// formatRemoteInfo renders the /remote report
+func formatRemoteInfo(remotes string, tracking *TrackingInfo) string {
+	if remotes == "" {
+		remotes = "(no remotes configured)"
+	}
+	branch := tracking.Branch
+	if branch == "" {
+		branch = "(detached HEAD)"
+	}
+
+	var upstream string
+	switch {
+	case tracking.Upstream == "":
+		upstream = "none, the first push from /commit sets it"
+	case tracking.Gone:
+		upstream = fmt.Sprintf("%s (gone from the remote)", tracking.Upstream)
+	default:
+		upstream = fmt.Sprintf("%s (ahead %d, behind %d)", tracking.Upstream, tracking.Ahead, tracking.Behind)
+	}
+
+	return fmt.Sprintf("**Remotes**\n```\n%s\n```\nBranch: `%s`\nUpstream: %s", remotes, branch, upstream)
+}
+
+func handleLastCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	userID := interactionUserID(i)
+	slog.Debug("starting last command", "user_id", userID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer last interaction", "user_id", userID, "error", err)
+		return
+	}
+
+	sessions, err := listStoredSessions()
+	if err != nil {
+		slog.Error("failed to list sessions", "error", err)
+		respondOrFallback(s, i, "Failed to list sessions.")
+		return
+	}
+
+	latest := mostRecentSessionForUser(sessions, userID)
+	if latest == nil {
+		respondOrFallback(s, i, "You have no previous codesession sessions. Start one with `/codesession`.")
+		return
+	}
+
+	// Load the session into the cache so the thread is ready for the next mention
+	session := lazyLoadSession(latest.ThreadID)
+	if session == nil {
+		respondOrFallback(s, i, "Failed to load your last session.")
+		return
+	}
+
+	// Reopen the thread if Discord auto-archived it
+	archived := false
+	if session.ChannelSession {
+		slog.Debug("session runs in a channel, nothing to unarchive", "thread_id", session.ThreadID)
+	} else if _, err := s.ChannelEdit(session.ThreadID, &discordgo.ChannelEdit{Archived: &archived}); err != nil {
+		slog.Warn("failed to unarchive thread", "thread_id", session.ThreadID, "error", err)
+	}
+
+	respondOrFallback(s, i, fmt.Sprintf("Your last session (%s) is in <#%s>. Mention the bot there to continue.", session.RepositoryName, session.ThreadID))
+}
+
+// handleListCommand shows the configured repositories and models so users can pick before starting a session
+func handleListCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: formatConfigListing(AppConfig.Repositories, AppConfig.Models),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		slog.Error("failed to respond to list command", "error", err)
+	}
+}
+
+// formatConfigListing lists repositories by name and models as provider/model; repository paths stay private
+func formatConfigListing(repositories []Repository, models []Model) string {
+	var b strings.Builder
+	b.WriteString("**Repositories**\n")
+	if len(repositories) == 0 {
+		b.WriteString("None configured\n")
+	}
+	for _, repository := range repositories {
+		fmt.Fprintf(&b, "- `%s`\n", repository.Name)
+	}
+
+	b.WriteString("\n**Models**\n")
+	if len(models) == 0 {
+		b.WriteString("None configured\n")
+	}
+	for _, model := range models {
+		line := fmt.Sprintf("- `%s/%s`", model.ProviderID, model.ModelID)
+		if model.SupportsVision {
+			line += " (vision)"
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func handleAutoCommitCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting autocommit command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer autocommit interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	var intervalText string
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "interval" {
+			intervalText = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	if intervalText == "" {
+		interval := autoCommitInterval(session)
+		if interval <= 0 {
+			respondOrFallback(s, i, "Auto-commit is disabled for this session.")
+		} else {
+			respondOrFallback(s, i, fmt.Sprintf("Auto-commit checkpoints after %s of inactivity.", interval))
+		}
+		return
+	}
+
+	var interval time.Duration
+	if intervalText != "off" {
+		parsed, err := time.ParseDuration(intervalText)
+		if err != nil || parsed < time.Minute {
+			respondOrFallback(s, i, "Invalid interval. Use a duration of at least 1m (e.g. `30m`, `1h`) or `off`.")
+			return
+		}
+		interval = parsed
+	}
+
+	sessionMutex.Lock()
+	session.AutoCommitInterval = &interval
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with auto-commit interval", "thread_id", threadID, "error", err)
+	}
+	resetAutoCommitTimer(threadID)
+
+	if interval <= 0 {
+		respondOrFallback(s, i, "Auto-commit disabled for this session.")
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("Auto-commit enabled: checkpoint after %s of inactivity.", interval))
+}
+
+func handleKeepCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting keep command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer keep interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	keep := true
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "enabled":
+			keep = option.BoolValue()
+		}
+	}
+
+	sessionMutex.Lock()
+	session.KeepThread = keep
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with keep flag", "thread_id", threadID, "error", err)
+	}
+
+	if keep {
+		respondOrFallback(s, i, "This thread stays open after each completed task.")
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("This thread follows the server setting again (`on_complete = \"%s\"`).", AppConfig.OnComplete))
+}
+
+// unpushedCommit returns the latest commit record on branch whose commit exists but was not pushed,
+// or nil when the most recent commit on branch was pushed or there is none. Commits made on the
+// other branches of a forked session are not pushed with branch, so they are left out.
+func unpushedCommit(session *SessionData, branch string) *CommitRecord {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	for idx := len(session.Commits) - 1; idx >= 0; idx-- {
+		record := session.Commits[idx]
+		if record.Hash == "" || record.Branch != branch {
+			continue
+		}
+		if record.Status == "committed" || record.Status == "failed" {
+			return record
+		}
+		return nil
+	}
+	return nil
+}
+
+func handleRetryPushCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	logger := interactionLogger(i)
+	logger.Debug("starting retry push command")
+
+	if err := deferInteraction(s, i, false); err != nil {
+		logger.Error("failed to defer retry push interaction", "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+
+	worktreePath := session.WorktreePath
+	branch, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		logger.Error("failed to get current branch", "error", err)
+		respondError(s, i, "Failed to determine the current branch.")
+		return
+	}
+
+	record := unpushedCommit(session, branch)
+	if record == nil {
+		respondOrFallback(s, i, fmt.Sprintf("Nothing to push: the latest commit on `%s` was already pushed.", branch))
+		return
+	}
+
+	if err := gitOps.Push(worktreePath, branch); err != nil {
+		logger.Error("retry push failed", "branch", branch, "error", err)
+		respondError(s, i, fmt.Sprintf("Push failed again. Error: %v", err))
+		return
+	}
+
+	// The push covers every earlier unpushed commit on the branch too
+	head, headErr := gitOps.GetCommitHash(worktreePath)
+	sessionMutex.Lock()
+	for _, commit := range session.Commits {
+		if commit.Branch == branch && commit.Hash != "" && (commit.Status == "committed" || commit.Status == "failed") {
+			commit.Status = "success"
+		}
+	}
+	// Pushing may have rebased the latest commit onto the remote branch, giving it a new hash
+	if headErr == nil {
+		record.Hash = head
+	}
+	summary, _, _ := strings.Cut(record.Summary, "\n")
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		logger.Error("failed to save session data after retry push", "error", err)
+	}
+
+	logger.Info("retry push succeeded", "branch", branch)
+	respondOrFallback(s, i, fmt.Sprintf("Pushed `%s` to `%s`.", summary, branch))
+}
+
+// latestCommit returns the session's most recent commit record that produced a commit
+func latestCommit(session *SessionData) *CommitRecord {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	for idx := len(session.Commits) - 1; idx >= 0; idx-- {
+		if record := session.Commits[idx]; record.Hash != "" {
+			return record
+		}
+	}
+	return nil
+}
+
+// amendRefusal explains why the latest session commit cannot be amended, "" when it can.
+// A pushed commit is only amended with force, which then force-pushes it.
+func amendRefusal(record *CommitRecord, head string, pushed, force bool) string {
+	switch {
+	case record == nil:
+		return "This session has not made a commit yet."
+	case record.Hash != head:
+		return "The session's last commit is no longer the branch head, so it cannot be amended."
+	case pushed && !force:
+		return "The last commit was already pushed. Use `force:true` to amend it and force-push the branch."
+	}
+	return ""
+}
+
+// handleAmendCommand rewrites the message of the session's last commit, with the given message or
+// a newly generated one
+func handleAmendCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	logger := interactionLogger(i)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		logger.Error("failed to defer amend interaction", "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireWorktree(s, i, session) {
+		return
+	}
+
+	var message string
+	var force bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "message":
+			message = strings.ReplaceAll(option.StringValue(), `\n`, "\n")
+		case "force":
+			force = option.BoolValue()
+		}
+	}
+
+	sessionMutex.RLock()
+	isStreaming := session.IsStreaming
+	sessionMutex.RUnlock()
+	if isStreaming {
+		respondOrFallback(s, i, "codesession is still working in this thread. Please wait for it to finish before amending.")
+		return
+	}
+	unlock, locked := tryLockCommits(threadID)
+	if !locked {
+		respondOrFallback(s, i, commitInProgressMessage)
+		return
+	}
+	defer unlock()
+
+	worktreePath := session.WorktreePath
+	record := latestCommit(session)
+	head, err := gitOps.GetCommitHash(worktreePath)
+	if err != nil {
+		logger.Error("failed to get head commit", "error", err)
+		respondError(s, i, "Failed to read the current commit.")
+		return
+	}
+	pushed := false
+	if record != nil {
+		sessionMutex.RLock()
+		pushed = record.Status == "success"
+		sessionMutex.RUnlock()
+		if !pushed {
+			// Amending a pushed commit without force-pushing would leave local and remote diverged
+			if pushed, err = gitOps.IsCommitPushed(worktreePath, record.Hash); err != nil {
+				logger.Error("failed to check whether the commit was pushed", "error", err)
+				respondError(s, i, "Failed to check whether the last commit was pushed, so it was not amended.")
+				return
+			}
+		}
+	}
+	if refusal := amendRefusal(record, head, pushed, force); refusal != "" {
+		respondOrFallback(s, i, refusal)
+		return
+	}
+
+	sessionMutex.RLock()
+	previous := record.Summary
+	sessionMutex.RUnlock()
+	if message == "" {
+		instruction := AppConfig.SummarizerInstruction
+		if instruction == "" {
+			instruction = defaultSummarizerInstruction
+		}
+		instruction += "\n\nThis replaces the message of the last commit, which was:\n" + previous + "\n\nDescribe the changes of that commit more accurately."
+		s.ChannelTyping(threadID)
+		message, err = promptSummarizer(session, instruction)
+		if err != nil || strings.TrimSpace(message) == "" {
+			logger.Error("failed to generate amended commit message", "error", err)
+			respondError(s, i, "Failed to generate a new commit message. Pass one with `message` instead.")
+			return
+		}
+		message = formatCommitMessage(renderCommitTemplate(AppConfig.CommitMessageTemplate, cleanCommitMessage(message)), AppConfig.CommitSubjectMax, AppConfig.CommitBodyWrap)
+	}
+
+	hash, err := gitOps.Amend(worktreePath, message, commitTrailers(threadID, interactionUserID(i), interactionUsername(i)))
+	if err != nil {
+		logger.Error("failed to amend commit", "error", err)
+		respondError(s, i, fmt.Sprintf("Failed to amend the commit. Error: %v", err))
+		return
+	}
+
+	status := "committed"
+	result := "The amended commit is not pushed yet; `/retrypush` pushes it."
+	if pushed {
+		branch, err := gitOps.GetCurrentBranch(worktreePath)
+		if err == nil {
+			err = gitOps.ForcePush(worktreePath, branch)
+		}
+		if err != nil {
+			logger.Error("failed to force push amended commit", "error", err)
+			result = fmt.Sprintf("Force-pushing the amended commit failed: %v", err)
+		} else {
+			status = "success"
+			result = fmt.Sprintf("Force-pushed to `%s`.", branch)
+		}
+	}
+
+	sessionMutex.Lock()
+	record.Hash = hash
+	record.Summary = message
+	record.Status = status
+	record.Timestamp = time.Now()
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		logger.Error("failed to save session data after amend", "error", err)
+	}
+
+	logger.Info("amended commit", "commit_hash", hash, "pushed", pushed)
+	respondOrFallback(s, i, fmt.Sprintf("✏️ Amended commit `%s`:\n```\n%s\n```\n%s", hash[:min(len(hash), 7)], message, result))
+}
+
+func handleTransferCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting transfer command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer transfer interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	var newOwner *discordgo.User
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "user":
+			newOwner = option.UserValue(s)
+		}
+	}
+	if newOwner == nil {
+		respondOrFallback(s, i, "Please select the user to transfer the session to.")
+		return
+	}
+	if newOwner.Bot {
+		respondOrFallback(s, i, "Sessions cannot be transferred to a bot.")
+		return
+	}
+
+	callerID := interactionUserID(i)
+	sessionMutex.Lock()
+	previousOwner := session.UserID
+	if !canTransferSession(previousOwner, callerID, isAdmin(i)) {
+		sessionMutex.Unlock()
+		respondOrFallback(s, i, "Only the session owner or an admin can transfer this session.")
+		return
+	}
+	session.UserID = newOwner.ID
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data after transfer", "thread_id", threadID, "error", err)
+	}
+
+	slog.Info("session ownership transferred", "thread_id", threadID, "from", previousOwner, "to", newOwner.ID, "by", callerID)
+	if previousOwner == "" {
+		respondOrFallback(s, i, fmt.Sprintf("<@%s> now owns this session.", newOwner.ID))
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("Session ownership transferred from <@%s> to <@%s>.", previousOwner, newOwner.ID))
+}
+
+// canTransferSession reports whether the caller may hand a session on: its owner always can, and
+// an admin can transfer any session, including one without a recorded owner
+func canTransferSession(ownerID, callerID string, admin bool) bool {
+	if admin {
+		return true
+	}
+	return ownerID != "" && ownerID == callerID
+}
+
+// Reply when auto-respond is requested but not enabled in the config
+const autoRespondDisabledMessage = "Auto-respond is disabled. An admin can enable it with `enable_auto_respond = true` (requires the Message Content intent)."
+
+func handleAutoRespondCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting autorespond command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer autorespond interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !AppConfig.EnableAutoRespond {
+		respondOrFallback(s, i, autoRespondDisabledMessage)
+		return
+	}
+
+	var enabled *bool
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "enabled" {
+			value := option.BoolValue()
+			enabled = &value
+		}
+	}
+
+	if enabled == nil {
+		sessionMutex.RLock()
+		current := session.AutoRespond
+		sessionMutex.RUnlock()
+		if current {
+			respondOrFallback(s, i, "Auto-respond is on: every message in this thread is sent to the model.")
+		} else {
+			respondOrFallback(s, i, "Auto-respond is off: mention the bot to send a message to the model.")
+		}
+		return
+	}
+
+	sessionMutex.Lock()
+	session.AutoRespond = *enabled
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with auto-respond", "thread_id", threadID, "error", err)
+	}
+
+	if *enabled {
+		respondOrFallback(s, i, "Auto-respond enabled: every message in this thread is now sent to the model, no mention needed.")
+		return
+	}
+	respondOrFallback(s, i, "Auto-respond disabled: mention the bot to send a message to the model.")
+}
+
+// behindBaseNotice returns a warning when the session branch is behind the base set with /setbase,
+// or "" when no base is set or the branch is up to date
+func behindBaseNotice(session *SessionData) string {
+	sessionMutex.RLock()
+	baseBranch := session.BaseBranch
+	sessionMutex.RUnlock()
+	if baseBranch == "" {
+		return ""
+	}
+	// Compare against the remote's current state, not the last fetch
+	if remoteBranch, ok := strings.CutPrefix(baseBranch, "origin/"); ok {
+		if err := gitOps.FetchBranch(session.WorktreePath, remoteBranch); err != nil {
+			slog.Warn("failed to fetch base branch", "base_branch", baseBranch, "error", err)
+		}
+	}
+	_, behind, err := gitOps.AheadBehind(session.WorktreePath, baseBranch)
+	if err != nil || behind == 0 {
+		return ""
+	}
+	return fmt.Sprintf("⚠️ The session branch is %d commit(s) behind its base `%s`. Rebase before opening a pull request.", behind, baseBranch)
+}
+
+func handleSetBaseCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting setbase command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer setbase interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+	worktreePath := session.WorktreePath
+
+	var branch string
+	var reset bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "branch":
+			branch = strings.TrimSpace(option.StringValue())
+		case "reset":
+			reset = option.BoolValue()
+		}
+	}
+
+	// Without a branch, report the current base
+	if branch == "" && !reset {
+		sessionMutex.RLock()
+		current := session.BaseBranch
+		sessionMutex.RUnlock()
+		if current != "" {
+			respondOrFallback(s, i, fmt.Sprintf("Base branch: `%s` (set for this session).", current))
+			return
+		}
+		base, err := gitOps.GetBaseBranch(worktreePath)
+		if err != nil {
+			respondOrFallback(s, i, "No base branch set, and the remote's default branch could not be determined.")
+			return
+		}
+		respondOrFallback(s, i, fmt.Sprintf("Base branch: `%s` (remote default).", base))
+		return
+	}
+
+	if !reset {
+		// Only branches are accepted, not tags or revisions such as HEAD~3; plain names are
+		// accepted for branches that only exist on the remote
+		switch {
+		case gitOps.BranchExists(worktreePath, branch):
+		case gitOps.RemoteBranchExists(worktreePath, branch):
+		case gitOps.RemoteBranchExists(worktreePath, "origin/"+branch):
+			branch = "origin/" + branch
+		default:
+			respondOrFallback(s, i, fmt.Sprintf("Branch `%s` was not found.", branch))
+			return
+		}
+	} else {
+		branch = ""
+	}
+
+	sessionMutex.Lock()
+	session.BaseBranch = branch
+	sessionMutex.Unlock()
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data with base branch", "thread_id", threadID, "error", err)
+	}
+
+	slog.Info("session base branch set", "thread_id", threadID, "base_branch", branch)
+	if branch == "" {
+		respondOrFallback(s, i, "Base branch reset to the remote's default branch.")
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("Base branch set to `%s`. `/compare`, `/prdescription` and `/diff base:true` now use it.", branch))
+}
+
+func handleCompareCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting compare command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer compare interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+	worktreePath := session.WorktreePath
+
+	var target string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "target":
+			target = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	if target == "" {
+		base, err := sessionBaseBranch(session)
+		if err != nil {
+			slog.Error("failed to determine base branch", "thread_id", threadID, "error", err)
+			respondOrFallback(s, i, "Could not determine the base branch. Please pass a `target` branch.")
+			return
+		}
+		target = base
+	}
+	if !gitOps.RefExists(worktreePath, target) {
+		respondOrFallback(s, i, fmt.Sprintf("Branch `%s` was not found.", target))
+		return
+	}
+
+	ahead, behind, err := gitOps.AheadBehind(worktreePath, target)
+	if err != nil {
+		slog.Error("failed to compare branches", "thread_id", threadID, "target", target, "error", err)
+		respondOrFallback(s, i, "Failed to compare branches.")
+		return
+	}
+	stat, err := gitOps.DiffStat(worktreePath, target)
+	if err != nil {
+		slog.Error("failed to get diff stat", "thread_id", threadID, "target", target, "error", err)
+		respondOrFallback(s, i, "Failed to compare branches.")
+		return
+	}
+
+	branch, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		branch = "HEAD"
+	}
+
+	summary := fmt.Sprintf("**%s** vs **%s**: %d commit(s) ahead, %d commit(s) behind.", branch, target, ahead, behind)
+	if stat == "" {
+		respondOrFallback(s, i, summary+"\nNo file changes over the target branch.")
+		return
+	}
+	content := summary + "\n```\n" + stat + "\n```"
+	if len(content) > messageLimit {
+		respondOrFallback(s, i, summary)
+		SendDiscordDiff(threadID, "compare.stat", stat)
+		return
+	}
+	respondOrFallback(s, i, content)
+}
+
+// Custom ID prefixes for the /codesession start confirmation buttons
+const (
+	sessionStartConfirmID = "session_start:confirm"
+	sessionStartCancelID  = "session_start:cancel"
+)
+
+// askSessionStartConfirmation replaces the deferred /codesession response with a summary of
+// the session about to be started and confirm/cancel buttons
+func askSessionStartConfirmation(s *discordgo.Session, i *discordgo.InteractionCreate, request sessionStartRequest) {
+	repository := request.repository()
+	model := request.model()
+	agent := request.Agent
+
+	confirmID := encodeSessionStartConfirmID(request)
+	if len(confirmID) > maxCustomIDLength {
+		respondOrFallback(s, i, "The agent and ref names are too long to confirm. Please use shorter names.")
+		return
+	}
+
+	// Catch problems before anyone confirms. The thread, whose ID names the branch and worktree, does
+	// not exist yet, so the interaction ID (also a snowflake) stands in for it.
+	worktreesDir, err := ensureWorktreeDir()
+	if err != nil {
+		slog.Error("failed to ensure worktrees directory", "error", err)
+		respondOrFallback(s, i, "Failed to create worktrees directory")
+		return
+	}
+	if _, err := gitOps.PlanWorktree(repository.Path, filepath.Join(worktreesDir, i.ID), i.ID, request.BaseRef, repository.SparsePaths); err != nil {
+		slog.Error("session worktree cannot be created", "repository", repository.Name, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("A worktree for %s cannot be created: %v", repository.Name, err))
+		return
+	}
+
+	baseState := "(unknown)"
+	if request.BaseRef != "" {
+		baseState = request.BaseRef
+	} else if branch, err := gitOps.GetCurrentBranch(repository.Path); err == nil {
+		baseState = branch
+		if hash, err := gitOps.GetCommitHash(repository.Path); err == nil && len(hash) >= 7 {
+			baseState = fmt.Sprintf("%s @ %s", branch, hash[:7])
+		}
+	}
+
+	content := fmt.Sprintf(`Start a new session?
+%s
+Repository: %s
+Base: %s
+Model: %s/%s
+Agent: %s
+Mode: %s
+%s`, "```", repository.Name, baseState, model.ProviderID, model.ModelID, agentDisplayName(agent), sessionModeName(request.ReviewMode, request.PlanFirst), "```")
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Start session",
+					Style:    discordgo.SuccessButton,
+					CustomID: confirmID,
+				},
+				discordgo.Button{
+					Label:    "Cancel",
+					Style:    discordgo.SecondaryButton,
+					CustomID: sessionStartCancelID,
+				},
+			},
+		},
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+	}); err != nil {
+		slog.Error("failed to send session start confirmation", "error", err)
+	}
+}
+
+// Discord rejects component custom IDs longer than this
+const maxCustomIDLength = 100
+
+// encodeSessionStartConfirmID packs a start request into a confirm button custom ID.
+// Refs cannot contain ":", so the agent goes last and may contain anything.
+func encodeSessionStartConfirmID(request sessionStartRequest) string {
+	return fmt.Sprintf("%s:%d:%d:%t:%t:%t:%s:%s", sessionStartConfirmID, request.RepositoryIndex, request.ModelIndex, request.ReviewMode, request.AutoRespond, request.PlanFirst, request.BaseRef, request.Agent)
+}
+
+// parseSessionStartConfirmID unpacks a start request from a confirm button custom ID
+func parseSessionStartConfirmID(customID string) (sessionStartRequest, error) {
+	var request sessionStartRequest
+	fields := strings.SplitN(strings.TrimPrefix(customID, sessionStartConfirmID+":"), ":", 7)
+	if len(fields) != 7 {
+		return request, fmt.Errorf("malformed custom id %q", customID)
+	}
+	repositoryIndex, err := strconv.Atoi(fields[0])
+	if err != nil || repositoryIndex < 0 || repositoryIndex >= len(AppConfig.Repositories) {
+		return request, fmt.Errorf("invalid repository index in %q", customID)
+	}
+	modelIndex, err := strconv.Atoi(fields[1])
+	if err != nil || modelIndex < 0 || modelIndex >= len(AppConfig.Models) {
+		return request, fmt.Errorf("invalid model index in %q", customID)
+	}
+	reviewMode, err := strconv.ParseBool(fields[2])
+	if err != nil {
+		return request, fmt.Errorf("invalid review flag in %q", customID)
+	}
+	autoRespond, err := strconv.ParseBool(fields[3])
+	if err != nil {
+		return request, fmt.Errorf("invalid auto-respond flag in %q", customID)
+	}
+	planFirst, err := strconv.ParseBool(fields[4])
+	if err != nil {
+		return request, fmt.Errorf("invalid plan flag in %q", customID)
+	}
+	request.RepositoryIndex = repositoryIndex
+	request.ModelIndex = modelIndex
+	request.ReviewMode = reviewMode
+	request.AutoRespond = autoRespond
+	request.PlanFirst = planFirst
+	request.BaseRef = fields[5]
+	request.Agent = fields[6]
+	return request, nil
+}
+
+// handleComponentInteraction handles button clicks on bot messages
+func handleComponentInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	slog.Debug("component interaction", "channel_id", i.ChannelID, "custom_id", customID)
+
+	switch {
+	case customID == sessionStartCancelID:
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "Session start cancelled.",
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+		if err != nil {
+			slog.Error("failed to respond to cancel button", "error", err)
+		}
+	case strings.HasPrefix(customID, sessionStartConfirmID+":"):
+		request, err := parseSessionStartConfirmID(customID)
+		if err != nil {
+			slog.Error("invalid session start confirmation", "error", err)
+			return
+		}
+		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "⏳ Starting session...",
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+		if err != nil {
+			slog.Error("failed to respond to confirm button", "error", err)
+			return
+		}
+		startSession(s, i, request)
+	case strings.HasPrefix(customID, promptCostConfirmID+":"):
+		handlePromptCostButton(s, i, strings.TrimPrefix(customID, promptCostConfirmID+":"), true)
+	case strings.HasPrefix(customID, promptCostCancelID+":"):
+		handlePromptCostButton(s, i, strings.TrimPrefix(customID, promptCostCancelID+":"), false)
+	case customID == cleanupRepoCancelID:
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "Repository cleanup cancelled.",
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+		if err != nil {
+			slog.Error("failed to respond to cleanup cancel button", "error", err)
+		}
+	case strings.HasPrefix(customID, cleanupRepoConfirmID+":"):
+		handleCleanupRepoConfirm(s, i, strings.TrimPrefix(customID, cleanupRepoConfirmID+":"))
+	case customID == continueThreadID:
+		handleContinueThreadButton(s, i)
+	}
+}
+
+// handlePromptCostButton sends or drops the held prompt with the given nonce. Only the prompt's
+// author may decide; other users get an ephemeral refusal and the prompt stays pending.
+func handlePromptCostButton(s *discordgo.Session, i *discordgo.InteractionCreate, nonce string, confirmed bool) {
+	prompt, found, owned := claimPendingPrompt(nonce, interactionUserID(i))
+	if found && !owned {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Only the author of this prompt can send or cancel it.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			slog.Error("failed to refuse prompt cost button", "thread_id", i.ChannelID, "error", err)
+		}
+		return
+	}
+
+	content := "Prompt cancelled."
+	switch {
+	case !found:
+		content = "This prompt is no longer pending."
+	case confirmed:
+		content = "Sending prompt..."
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		slog.Error("failed to respond to prompt cost button", "thread_id", i.ChannelID, "error", err)
+	}
+	if !found || !confirmed {
+		return
+	}
+
+	sessionData := lazyLoadSession(prompt.threadID)
+	if sessionData == nil {
+		s.ChannelMessageSend(prompt.threadID, "No codesession session found for this thread. Please start a session first using `/codesession` command.")
+		return
+	}
+	sendPrompt(s, sessionData, prompt.authorID, prompt.content, prompt.images)
+}
+
+func handlePauseCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting pause command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer pause interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	if session := loadThreadSession(s, i); session == nil {
+		return
+	}
+
+	if err := pauseUpdates(threadID); err != nil {
+		respondOrFallback(s, i, fmt.Sprintf("Cannot pause: %v.", err))
+		return
+	}
+	respondOrFallback(s, i, "⏸️ Live updates paused. The task keeps running; use `/unpause` to catch up.")
+}
+
+func handleUnpauseCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	logger := interactionLogger(i)
+	logger.Debug("starting unpause command")
+
+	if err := deferInteraction(s, i, false); err != nil {
+		logger.Error("failed to defer unpause interaction", "error", err)
+		return
+	}
+
+	if session := loadThreadSession(s, i); session == nil {
+		return
+	}
+
+	err := resumeUpdates(threadID)
+	switch {
+	case errors.Is(err, errUpdatesRunning):
+		respondOrFallback(s, i, "Live updates are not paused.")
+	case err != nil:
+		logger.Error("failed to resume live updates", "error", err)
+		respondError(s, i, "Failed to catch up with the session. Live updates are still paused, try again.")
+	default:
+		respondOrFallback(s, i, "▶️ Live updates resumed.")
+	}
+}
+
+func handleExportMarkdownCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	logger := interactionLogger(i)
+	logger.Debug("starting exportmd command")
+
+	if err := deferInteraction(s, i, false); err != nil {
+		logger.Error("failed to defer exportmd interaction", "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	messages, err := fetchTranscript(session)
+	if err != nil {
+		logger.Error("failed to fetch session messages", "error", err)
+		respondError(s, i, "Failed to load the session history from OpenCode.")
+		return
+	}
+
+	// Always attach the transcript: even short sessions quickly exceed a message
+	transcript := renderTranscriptMarkdown(session, messages)
+	content := fmt.Sprintf("Transcript of %d message(s).", len(messages))
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+		Files: []*discordgo.File{
+			{
+				Name:        fmt.Sprintf("codesession-%s.md", threadID),
+				ContentType: "text/markdown",
+				Reader:      strings.NewReader(transcript),
+			},
+		},
+	})
+	if err != nil {
+		logger.Error("failed to upload transcript", "error", err)
+		respondError(s, i, "Failed to upload the transcript.")
+		return
+	}
+	logger.Info("exported session transcript", "messages", len(messages), "bytes", len(transcript))
+}
+
+func handleModelInfoCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting modelinfo command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer modelinfo interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	sessionMutex.RLock()
+	model := session.Model
+	worktreePath := session.WorktreePath
+	sessionMutex.RUnlock()
+
+	availability := checkModelAvailability(worktreePath, model)
+	if availability.Err != nil {
+		slog.Warn("model availability check failed", "thread_id", threadID, "provider_id", model.ProviderID, "model_id", model.ModelID, "error", availability.Err)
+	}
+	respondOrFallback(s, i, formatModelInfo(model, availability))
+}
+
+func handleEstimateCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting estimate command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer estimate interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	var prompt string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "prompt":
+			prompt = option.StringValue()
+		}
+	}
+
+	sessionMutex.RLock()
+	model := session.Model
+	sessionMutex.RUnlock()
+
+	pricing, ok := pricingFor(model)
+	if !ok {
+		respondOrFallback(s, i, fmt.Sprintf("No pricing configured for %s/%s. Add it under `[pricing]` in the config.", model.ProviderID, model.ModelID))
+		return
+	}
+	respondOrFallback(s, i, formatCostEstimate(prompt, model, pricing))
+}
+
+func handleLogsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting logs command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer logs interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	if loadThreadSession(s, i) == nil {
+		return
+	}
+
+	lines := sessionLogLines(threadID)
+	if len(lines) == 0 {
+		respondOrFallback(s, i, "No logs captured for this session yet.")
+		return
+	}
+
+	respondOrFallback(s, i, fmt.Sprintf("Last %d log line(s) for this session:", len(lines)))
+	// Account for code block wrapper length (```\n and \n```)
+	for _, chunk := range splitMessage(strings.Join(lines, "\n"), messageLimit-8) {
+		if _, err := s.ChannelMessageSend(threadID, "```\n"+chunk+"\n```"); err != nil {
+			slog.Error("failed to send logs chunk", "error", err)
+			break
+		}
+	}
+}
+
+func handleCompareModelsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting compare models command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer compare models interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	if !AppConfig.EnableModelComparison {
+		respondOrFallback(s, i, "Model comparison is disabled. An admin can enable it with `enable_model_comparison = true`.")
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+
+	var prompt string
+	modelIndexes := []int{-1, -1}
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "prompt":
+			prompt = strings.TrimSpace(option.StringValue())
+		case "model_a":
+			modelIndexes[0] = int(option.IntValue())
+		case "model_b":
+			modelIndexes[1] = int(option.IntValue())
+		}
+	}
+	if prompt == "" {
+		respondOrFallback(s, i, "Please provide a prompt.")
+		return
+	}
+
+	var models []Model
+	for _, modelIndex := range modelIndexes {
+		if modelIndex < 0 || modelIndex >= len(AppConfig.Models) {
+			respondOrFallback(s, i, "Invalid model selection")
+			return
+		}
+		models = append(models, AppConfig.Models[modelIndex])
+	}
+
+	// Comparison sessions share the worktree's event stream. Their idle events are ignored by the
+	// thread's listener, but their parts would still mix into a running prompt's status.
+	sessionMutex.RLock()
+	isStreaming := session.IsStreaming
+	worktreePath := session.WorktreePath
+	sessionMutex.RUnlock()
+	if isStreaming {
+		respondOrFallback(s, i, "A prompt is still running in this session. Please wait for it to finish.")
+		return
+	}
+
+	respondOrFallback(s, i, fmt.Sprintf("⏳ Asking %d models the same prompt (each run is billed separately)...", len(models)))
+	s.ChannelTyping(threadID)
+
+	for _, result := range compareModels(worktreePath, models, prompt) {
+		label := fmt.Sprintf("**%s/%s**", result.Model.ProviderID, result.Model.ModelID)
+		if result.Err != nil {
+			slog.Error("model comparison failed", "thread_id", threadID, "provider_id", result.Model.ProviderID, "model_id", result.Model.ModelID, "error", result.Err)
+			SendDiscordMessage(threadID, fmt.Sprintf("%s\n%s", label, promptErrorMessage(result.Err)))
+			continue
+		}
+		text := removeExcessiveNewLine(result.Text)
+		if text == "" {
+			text = "(no text response)"
+		}
+		SendDiscordMessage(threadID, fmt.Sprintf("%s\n%s", label, text))
+	}
+}
+
+func handlePRDescriptionCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting pr description command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer pr description interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+	worktreePath := session.WorktreePath
+
+	var target string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "target":
+			target = strings.TrimSpace(option.StringValue())
+		}
+	}
+	if target == "" {
+		base, err := sessionBaseBranch(session)
+		if err != nil {
+			respondOrFallback(s, i, "Could not determine the base branch. Please pass a `target` branch.")
+			return
+		}
+		target = base
+	}
+	if !gitOps.RefExists(worktreePath, target) {
+		respondOrFallback(s, i, fmt.Sprintf("Branch `%s` was not found.", target))
+		return
+	}
+
+	// Refuse while a prompt is running: the active listener would render the summarizer's events into the status message
+	sessionMutex.RLock()
+	isStreaming := session.IsStreaming
+	var summaries []string
+	for _, commit := range session.Commits {
+		if commit.Status == "success" {
+			summaries = append(summaries, commit.Summary)
+		}
+	}
+	sessionMutex.RUnlock()
+	if isStreaming {
+		respondOrFallback(s, i, "codesession is still working in this thread. Please wait for it to finish.")
+		return
+	}
+
+	commitLog, err := gitOps.CommitLog(worktreePath, target)
+	if err != nil {
+		slog.Error("failed to get commit log", "thread_id", threadID, "error", err)
+		respondOrFallback(s, i, "Failed to read the branch history.")
+		return
+	}
+	if commitLog == "" {
+		respondOrFallback(s, i, fmt.Sprintf("This branch has no commits over `%s` yet. Run `/commit` first.", target))
+		return
+	}
+	diffStat, err := gitOps.DiffStat(worktreePath, target)
+	if err != nil {
+		slog.Error("failed to get diff stat", "thread_id", threadID, "error", err)
+		respondOrFallback(s, i, "Failed to read the branch history.")
+		return
+	}
+
+	s.ChannelTyping(threadID)
+	description, err := promptSummarizer(session, buildPRDescriptionPrompt(AppConfig.PRDescriptionInstruction, target, summaries, commitLog, diffStat))
+	if err != nil {
+		slog.Error("failed to generate pr description", "thread_id", threadID, "error", err)
+		respondOrFallback(s, i, promptErrorMessage(err))
+		return
+	}
+	title, body := splitPRDescription(description)
+	if title == "" {
+		respondOrFallback(s, i, "The model returned an empty description.")
+		return
+	}
+
+	respondOrFallback(s, i, fmt.Sprintf("PR description against `%s`:", target))
+	SendDiscordMessage(threadID, fmt.Sprintf("**%s**\n%s", title, body))
+}
+
+// Default window and listing cap for /shipped
+const (
+	defaultShippedDays = 7
+	maxShippedListed   = 20
+)
+
+// handleLogLevelCommand shows or changes the bot's log level without a restart
+func handleLogLevelCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer loglevel interaction", "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+
+	var levelStr string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "level":
+			levelStr = strings.ToLower(strings.TrimSpace(option.StringValue()))
+		}
+	}
+
+	previous := logLevel.Level()
+	if levelStr == "" {
+		respondOrFallback(s, i, fmt.Sprintf("Log level is `%s`.", logLevelName(previous)))
+		return
+	}
+	level, ok := parseLogLevel(levelStr)
+	if !ok {
+		respondOrFallback(s, i, fmt.Sprintf("Unknown log level `%s`. Use debug, info, warn or error.", levelStr))
+		return
+	}
+
+	logLevel.Set(level)
+	slog.Warn("log level changed at runtime", "from", logLevelName(previous), "to", logLevelName(level), "user_id", interactionUserID(i))
+	respondOrFallback(s, i, fmt.Sprintf("Log level changed from `%s` to `%s` until the next restart.", logLevelName(previous), logLevelName(level)))
+}
+
+func handleReloadSessionCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting reloadsession command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer reloadsession interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+
+	session, err := reloadSession(threadID)
+	if err != nil {
+		slog.Error("failed to reload session", "thread_id", threadID, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("Failed to reload the session: %v", err))
+		return
+	}
+
+	sessionMutex.RLock()
+	reloaded := fmt.Sprintf(`Session reloaded from disk.
+%s
+Repository: %s
+Model: %s
+Agent: %s
+Mode: %s
+Base: %s
+Worktree Path: %s
+Session ID: %s
+Owner: %s
+Auto Respond: %t
+Commits: %d
+%s`, "```", session.RepositoryName, fmt.Sprintf("%s/%s", session.Model.ProviderID, session.Model.ModelID),
+		agentDisplayName(session.Agent), sessionModeName(session.ReviewMode, session.PlanFirst), baseRefDisplayName(session.BaseRef),
+		session.WorktreePath, session.SessionID, session.UserID, session.AutoRespond, len(session.Commits), "```")
+	sessionMutex.RUnlock()
+
+	respondOrFallback(s, i, reloaded)
+}
+
+// Custom ID prefixes for the /cleanuprepo confirmation buttons; the confirm ID ends with the repository name
+const (
+	cleanupRepoConfirmID = "cleanup_repo:confirm"
+	cleanupRepoCancelID  = "cleanup_repo:cancel"
+)
+
+// Failures listed in the /cleanuprepo summary; the rest only go to the logs
+const maxCleanupFailuresShown = 5
+
+func handleCleanupRepoCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	slog.Debug("starting cleanuprepo command", "channel_id", i.ChannelID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer cleanuprepo interaction", "channel_id", i.ChannelID, "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+
+	var repositoryName string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "repository":
+			repositoryName = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	// The repository may already be gone from the config, so match on the name stored in sessions
+	sessions, err := repositorySessions(repositoryName)
+	if err != nil {
+		slog.Error("failed to list sessions for cleanup", "repository", repositoryName, "error", err)
+		respondOrFallback(s, i, "Failed to list sessions.")
+		return
+	}
+	if len(sessions) == 0 {
+		respondOrFallback(s, i, fmt.Sprintf("No sessions found for repository `%s`.", repositoryName))
+		return
+	}
+
+	confirmID := cleanupRepoConfirmID + ":" + repositoryName
+	if len(confirmID) > maxCustomIDLength {
+		respondOrFallback(s, i, "The repository name is too long to confirm.")
+		return
+	}
+
+	streaming := 0
+	sessionMutex.RLock()
+	for _, sessionData := range sessions {
+		if sessionData.IsStreaming {
+			streaming++
+		}
+	}
+	sessionMutex.RUnlock()
+
+	content := fmt.Sprintf("Remove all %d session(s) of `%s`, including their worktrees and unpushed changes?", len(sessions), repositoryName)
+	if streaming > 0 {
+		content += fmt.Sprintf("\n⚠️ %d of them are running a task right now.", streaming)
+	}
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Remove sessions",
+					Style:    discordgo.DangerButton,
+					CustomID: confirmID,
+				},
+				discordgo.Button{
+					Label:    "Cancel",
+					Style:    discordgo.SecondaryButton,
+					CustomID: cleanupRepoCancelID,
+				},
+			},
+		},
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+	}); err != nil {
+		slog.Error("failed to send cleanup confirmation", "repository", repositoryName, "error", err)
+	}
+}
+
+// handleCleanupRepoConfirm removes a repository's sessions once an admin confirmed /cleanuprepo
+func handleCleanupRepoConfirm(s *discordgo.Session, i *discordgo.InteractionCreate, repositoryName string) {
+	if !isAdmin(i) {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "This command is restricted to bot admins.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			slog.Error("failed to refuse cleanup confirmation", "error", err)
+		}
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    fmt.Sprintf("⏳ Removing sessions of `%s`...", repositoryName),
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		slog.Error("failed to respond to cleanup confirm button", "error", err)
+		return
+	}
+
+	logger := interactionLogger(i)
+	summary, err := cleanupRepositorySessions(repositoryName)
+	if err != nil {
+		logger.Error("repository cleanup failed", "repository", repositoryName, "error", err)
+		respondError(s, i, "Repository cleanup failed.")
+		return
+	}
+
+	content := fmt.Sprintf("Removed %d session(s) and %d worktree(s) of `%s`.", summary.Sessions, summary.Worktrees, repositoryName)
+	if len(summary.Failures) > 0 {
+		failures := summary.Failures
+		if len(failures) > maxCleanupFailuresShown {
+			failures = failures[:maxCleanupFailuresShown]
+		}
+		content += fmt.Sprintf("\n%d problem(s), see the logs for all of them:\n```\n%s\n```", len(summary.Failures), strings.Join(failures, "\n"))
+	}
+	respondOrFallback(s, i, content)
+}
+
+func handleShippedCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	slog.Debug("starting shipped command", "channel_id", i.ChannelID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer shipped interaction", "channel_id", i.ChannelID, "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+	if !AppConfig.CommitIndex {
+		respondOrFallback(s, i, "The commit index is disabled. An admin can enable it with `commit_index = true`.")
+		return
+	}
+
+	days := defaultShippedDays
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "days":
+			days = int(option.IntValue())
+		}
+	}
+	if days <= 0 {
+		respondOrFallback(s, i, "`days` must be a positive number.")
+		return
+	}
+
+	entries, err := readCommitIndex(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		slog.Error("failed to read commit index", "error", err)
+		respondOrFallback(s, i, "Failed to read the commit index.")
+		return
+	}
+	if len(entries) == 0 {
+		respondOrFallback(s, i, fmt.Sprintf("No commits pushed in the last %d day(s).", days))
+		return
+	}
+
+	perRepository := make(map[string]int)
+	for _, entry := range entries {
+		perRepository[entry.Repository]++
+	}
+	repositories := make([]string, 0, len(perRepository))
+	for name := range perRepository {
+		repositories = append(repositories, name)
+	}
+	sort.Strings(repositories)
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "**Shipped in the last %d day(s):** %d commit(s)\n", days, len(entries))
+	for _, name := range repositories {
+		fmt.Fprintf(&message, "- %s: %d\n", name, perRepository[name])
+	}
+
+	message.WriteString("\n**Most recent:**\n")
+	for idx := len(entries) - 1; idx >= 0 && idx >= len(entries)-maxShippedListed; idx-- {
+		entry := entries[idx]
+		line := fmt.Sprintf("- `%s` %s/%s: %s", entry.Hash[:min(len(entry.Hash), 7)], entry.Repository, entry.Branch, strings.SplitN(entry.Summary, "\n", 2)[0])
+		if entry.UserID != "" {
+			line += fmt.Sprintf(" (<@%s>)", entry.UserID)
+		}
+		message.WriteString(line + "\n")
+	}
+	if len(entries) > maxShippedListed {
+		fmt.Fprintf(&message, "...and %d more\n", len(entries)-maxShippedListed)
+	}
+
+	content := message.String()
+	content = truncateText(content, messageLimit, "...")
+	respondOrFallback(s, i, content)
+}
+
+// Default window of /costreport
+const defaultCostReportDays = 30
+
+// Session dumps longer than this are attached as a file instead of a code block
+const maxInlineSessionDump = 1800
+
+func handleDumpSessionCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	logger := interactionLogger(i)
+	logger.Debug("starting dumpsession command")
+
+	if err := deferInteraction(s, i, true); err != nil {
+		logger.Error("failed to defer dumpsession interaction", "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+
+	threadID := i.ChannelID
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "thread":
+			threadID = option.ChannelValue(nil).ID
+		}
+	}
+
+	dump, err := dumpSessionFile(threadID)
+	if errors.Is(err, os.ErrNotExist) {
+		respondOrFallback(s, i, fmt.Sprintf("No session file found for <#%s>.", threadID))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to dump session file", "dumped_thread_id", threadID, "error", err)
+		respondError(s, i, "Failed to read the session file.")
+		return
+	}
+
+	if len(dump) <= maxInlineSessionDump {
+		respondOrFallback(s, i, fmt.Sprintf("```json\n%s\n```", dump))
+		return
+	}
+	content := fmt.Sprintf("Session file of <#%s>:", threadID)
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+		Files: []*discordgo.File{
+			{
+				Name:        fmt.Sprintf("%s.json", threadID),
+				ContentType: "application/json",
+				Reader:      strings.NewReader(dump),
+			},
+		},
+	})
+	if err != nil {
+		logger.Error("failed to upload session dump", "dumped_thread_id", threadID, "error", err)
+	}
+}
+
+func handleCostReportCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	slog.Debug("starting costreport command", "channel_id", i.ChannelID)
+
+	if err := deferInteraction(s, i, true); err != nil {
+		slog.Error("failed to defer costreport interaction", "channel_id", i.ChannelID, "error", err)
+		return
+	}
+
+	if !requireAdmin(s, i) {
+		return
+	}
+	if !AppConfig.UsageIndex {
+		respondOrFallback(s, i, "The usage index is disabled. An admin can enable it with `usage_index = true`.")
+		return
+	}
+
+	days := defaultCostReportDays
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "days":
+			days = int(option.IntValue())
+		}
+	}
+	if days <= 0 {
+		respondOrFallback(s, i, "`days` must be a positive number.")
+		return
+	}
+
+	entries, err := readUsageIndex(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		slog.Error("failed to read usage index", "error", err)
+		respondOrFallback(s, i, "Failed to read the usage index.")
+		return
+	}
+	if len(entries) == 0 {
+		respondOrFallback(s, i, fmt.Sprintf("No model usage recorded in the last %d day(s).", days))
+		return
+	}
+
+	content := formatCostReport(buildCostReport(entries), days)
+	content = truncateText(content, messageLimit, "...")
+	respondOrFallback(s, i, content)
+}
+
+func handleForkCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting fork command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer fork interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+
+	var name string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "name":
+			name = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	sessionMutex.RLock()
+	isStreaming := session.IsStreaming
+	sessionMutex.RUnlock()
+	if isStreaming {
+		respondOrFallback(s, i, "A prompt is still running in this session. Please wait for it to finish.")
+		return
+	}
+
+	sub, err := forkWorktree(session, name)
+	if err != nil {
+		slog.Error("failed to fork worktree", "thread_id", threadID, "name", name, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("Failed to fork worktree: %v", err))
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("Forked worktree **%s** on branch `%s` from the last commit and switched to it. Uncommitted changes stay in the previous worktree; use `/worktree` to switch back.", name, sub.Branch))
+}
+
+func handleWorktreeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting worktree command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer worktree interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil || !requireRepository(s, i, session) {
+		return
+	}
+
+	var name string
+	var remove bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "name":
+			name = strings.TrimSpace(option.StringValue())
+		case "remove":
+			remove = option.BoolValue()
+		}
+	}
+
+	// Without a name, list the thread's worktrees
+	if name == "" {
+		sessionMutex.RLock()
+		current := session.CurrentWorktree
+		names := make([]string, 0, len(session.Worktrees))
+		lines := make(map[string]string, len(session.Worktrees))
+		for worktreeName, sub := range session.Worktrees {
+			names = append(names, worktreeName)
+			lines[worktreeName] = fmt.Sprintf("- **%s**: `%s`", worktreeName, sub.Branch)
+		}
+		sessionMutex.RUnlock()
+
+		if len(names) == 0 {
+			respondOrFallback(s, i, "This session has a single worktree. Use `/fork` to create another one.")
+			return
+		}
+		sort.Strings(names)
+		var message strings.Builder
+		message.WriteString("Worktrees:\n")
+		for _, worktreeName := range names {
+			message.WriteString(lines[worktreeName])
+			if worktreeName == current {
+				message.WriteString(" (current)")
+			}
+			message.WriteString("\n")
+		}
+		respondOrFallback(s, i, message.String())
+		return
+	}
+
+	if remove {
+		if err := removeSubWorktree(session, name); err != nil {
+			slog.Error("failed to remove worktree", "thread_id", threadID, "name", name, "error", err)
+			respondOrFallback(s, i, fmt.Sprintf("Failed to remove worktree: %v", err))
+			return
+		}
+		respondOrFallback(s, i, fmt.Sprintf("Removed worktree **%s** and its branch.", name))
+		return
+	}
+
+	if err := switchWorktree(session, name); err != nil {
+		slog.Error("failed to switch worktree", "thread_id", threadID, "name", name, "error", err)
+		respondOrFallback(s, i, fmt.Sprintf("Failed to switch worktree: %v", err))
+		return
+	}
+	respondOrFallback(s, i, fmt.Sprintf("Switched to worktree **%s**. Prompts and git commands now use it.", name))
+}
+
+// Queued prompts are shown truncated to this many characters in /queue
+const queuedPromptPreviewLength = 100
+
+func handleQueueCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting queue command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer queue interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	var clearQueue bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "clear":
+			clearQueue = option.BoolValue()
+		}
+	}
+
+	if clearQueue {
+		sessionMutex.RLock()
+		ownerID := session.UserID
+		sessionMutex.RUnlock()
+		if ownerID != "" && ownerID != interactionUserID(i) && !isAdmin(i) {
+			respondOrFallback(s, i, "Only the session owner can clear the queue.")
+			return
+		}
+		dropped := clearPromptQueue(threadID)
+		slog.Info("cleared prompt queue", "thread_id", threadID, "dropped", dropped, "user_id", interactionUserID(i))
+		respondOrFallback(s, i, fmt.Sprintf("Cleared %d queued prompt(s).", dropped))
+		return
+	}
+
+	queue := queuedPrompts(threadID)
+	if len(queue) == 0 {
+		respondOrFallback(s, i, "No prompts are queued.")
+		return
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%d queued prompt(s):\n", len(queue))
+	for idx, prompt := range queue {
+		preview := strings.Join(strings.Fields(prompt.Content), " ")
+		if len([]rune(preview)) > queuedPromptPreviewLength {
+			preview = string([]rune(preview)[:queuedPromptPreviewLength]) + "..."
+		}
+		fmt.Fprintf(&message, "%d. <@%s>, %s ago: %s\n", idx+1, prompt.AuthorID, time.Since(prompt.QueuedAt).Round(time.Second), preview)
+	}
+	respondOrFallback(s, i, message.String())
 }
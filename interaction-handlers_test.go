@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testInteraction builds a slash command interaction invoked by userID in channelID
+func testInteraction(channelID, userID string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "interaction-" + channelID,
+		AppID:     "app",
+		Token:     "token-" + channelID,
+		Type:      discordgo.InteractionApplicationCommand,
+		ChannelID: channelID,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID, Username: userID}},
+	}}
+}
+
+func TestStartSessionRollsBackWorktree(t *testing.T) {
+	repo := newTestRepo(t)
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	fake.respond = func(w http.ResponseWriter, r *http.Request, body string) bool {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/channels/rollback-thread") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"rollback-thread","type":11,"name":"rollback"}`))
+			return true
+		}
+		return false
+	}
+	var worktreeCreated atomic.Bool
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := os.Stat(r.URL.Query().Get("directory"))
+		worktreeCreated.Store(err == nil)
+		http.Error(w, "session creation failed", http.StatusInternalServerError)
+	}))
+	pull := false
+	useTestConfig(t, func(config *Config) {
+		config.Repositories = []Repository{{Name: "repo", Path: repo, PullBeforeWorktree: &pull}}
+		config.Models = []Model{{ProviderID: "provider", ModelID: "model"}}
+	})
+
+	startSession(discord, testInteraction("rollback-thread", "user"), sessionStartRequest{})
+
+	if !worktreeCreated.Load() {
+		t.Fatal("worktree did not exist when the session was requested")
+	}
+	if _, err := os.Stat(filepath.Join(worktreesDirectory, "rollback-thread")); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists after failed session creation (stat error %v)", err)
+	}
+	if branches := runGit(t, repo, "branch", "--list", "rollback-thread"); branches != "" {
+		t.Errorf("session branch still exists: %q", branches)
+	}
+	if worktrees := runGit(t, repo, "worktree", "list"); strings.Contains(worktrees, "rollback-thread") {
+		t.Errorf("worktree still registered:\n%s", worktrees)
+	}
+	edits := fake.calls(http.MethodPatch, "/webhooks/app/token-rollback-thread/messages/@original")
+	if len(edits) != 1 || !strings.Contains(edits[0].Body, "Failed to create session") {
+		t.Errorf("interaction edits = %v, want the session failure reply", edits)
+	}
+}
+
+func TestMessageHandlerIgnoresBots(t *testing.T) {
+	tests := []struct {
+		name        string
+		author      discordgo.User
+		allowedBots []string
+		wantHandled bool
+	}{
+		{name: "user mention", author: discordgo.User{ID: "user"}, wantHandled: true},
+		{name: "bot mention", author: discordgo.User{ID: "other-bot", Bot: true}},
+		{name: "allow-listed bot mention", author: discordgo.User{ID: "other-bot", Bot: true}, allowedBots: []string{"other-bot"}, wantHandled: true},
+		{name: "own message", author: discordgo.User{ID: "bot-user", Bot: true}, allowedBots: []string{"bot-user"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDiscord(t)
+			useTestConfig(t, func(config *Config) { config.AllowedBotIDs = tt.allowedBots })
+
+			author := tt.author
+			MessageHandler(discord, &discordgo.MessageCreate{Message: &discordgo.Message{
+				ChannelID: "mention-channel",
+				Content:   "<@bot-user> hello",
+				Author:    &author,
+				Mentions:  []*discordgo.User{discord.State.User},
+			}})
+
+			// A handled mention looks up the channel; an ignored one makes no calls at all
+			handled := len(fake.calls(http.MethodGet, "/channels/mention-channel")) > 0
+			if handled != tt.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestIsExpiredInteractionError(t *testing.T) {
+	restError := func(status, code int) error {
+		return &discordgo.RESTError{
+			Response: &http.Response{StatusCode: status},
+			Message:  &discordgo.APIErrorMessage{Code: code},
+		}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unknown webhook", err: restError(http.StatusNotFound, discordgo.ErrCodeUnknownWebhook), want: true},
+		{name: "invalid token", err: restError(http.StatusBadRequest, discordgo.ErrCodeInvalidWebhookTokenProvided), want: true},
+		{name: "unknown interaction", err: restError(http.StatusNotFound, discordgo.ErrCodeUnknownInteraction), want: true},
+		{name: "unauthorized", err: restError(http.StatusUnauthorized, 0), want: true},
+		{name: "wrapped", err: fmt.Errorf("edit: %w", restError(http.StatusNotFound, discordgo.ErrCodeUnknownWebhook)), want: true},
+		{name: "missing permissions", err: restError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions), want: false},
+		{name: "not a REST error", err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isExpiredInteractionError(tt.err); got != tt.want {
+				t.Errorf("isExpiredInteractionError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEphemeralInteractions(t *testing.T) {
+	markEphemeralInteraction("ephemeral")
+	if !isEphemeralInteraction("ephemeral") {
+		t.Error("interaction deferred as ephemeral is not remembered")
+	}
+	if isEphemeralInteraction("public") {
+		t.Error("interaction never deferred as ephemeral is reported as ephemeral")
+	}
+}
+
+func TestRespondOrFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		ephemeral bool
+		wantSend  string // channel the reply falls back to
+	}{
+		{name: "public", wantSend: "/channels/fallback-channel/messages"},
+		{name: "ephemeral", ephemeral: true, wantSend: "/channels/dm-channel/messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDiscord(t)
+			fake.respond = func(w http.ResponseWriter, r *http.Request, body string) bool {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/webhooks/"):
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintf(w, `{"code":%d,"message":"Unknown Webhook"}`, discordgo.ErrCodeUnknownWebhook)
+					return true
+				case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/users/@me/channels"):
+					w.Write([]byte(`{"id":"dm-channel","type":1}`))
+					return true
+				}
+				return false
+			}
+
+			i := testInteraction("fallback-channel", "user-"+tt.name)
+			i.ID = "fallback-" + tt.name
+			if tt.ephemeral {
+				markEphemeralInteraction(i.ID)
+			}
+			respondOrFallback(discord, i, "late reply")
+
+			sends := fake.calls(http.MethodPost, "/messages")
+			if len(sends) != 1 || sends[0].Path != tt.wantSend || !strings.Contains(sends[0].Body, "late reply") {
+				t.Fatalf("sends = %v, want one reply to %s", sends, tt.wantSend)
+			}
+			if opened := fake.calls(http.MethodPost, "/users/@me/channels"); (len(opened) == 1) != tt.ephemeral {
+				t.Errorf("direct message channels opened = %d, ephemeral %v", len(opened), tt.ephemeral)
+			}
+		})
+	}
+}
+
+func TestThreadTitleFromSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary string
+		want    string
+	}{
+		{name: "first line", summary: "  feat: add login\n\nLonger body  ", want: "codesession: feat: add login"},
+		{name: "at limit", summary: strings.Repeat("a", threadNameLimit-13), want: "codesession: " + strings.Repeat("a", threadNameLimit-13)},
+		{name: "over limit", summary: strings.Repeat("a", threadNameLimit), want: "codesession: " + strings.Repeat("a", threadNameLimit-16) + "..."},
+		// Discord counts characters, so a multi-byte summary that fits is kept whole
+		{name: "multi-byte at limit", summary: strings.Repeat("é", threadNameLimit-13), want: "codesession: " + strings.Repeat("é", threadNameLimit-13)},
+		{name: "multi-byte over limit", summary: strings.Repeat("日", threadNameLimit), want: "codesession: " + strings.Repeat("日", threadNameLimit-16) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := threadTitleFromSummary(tt.summary)
+			if got != tt.want {
+				t.Errorf("threadTitleFromSummary() = %q, want %q", got, tt.want)
+			}
+			if count := utf8.RuneCountInString(got); count > threadNameLimit {
+				t.Errorf("title has %d characters, over the %d limit", count, threadNameLimit)
+			}
+		})
+	}
+}
+
+func TestSessionStartConfirmID(t *testing.T) {
+	useTestConfig(t, func(config *Config) {
+		config.Repositories = make([]Repository, 2)
+		config.Models = make([]Model, 3)
+	})
+	request := sessionStartRequest{RepositoryIndex: 1, ModelIndex: 2, Agent: "build:fast", BaseRef: "v1.2", ReviewMode: true, PlanFirst: true}
+	got, err := parseSessionStartConfirmID(encodeSessionStartConfirmID(request))
+	if err != nil || got != request {
+		t.Errorf("round trip = %+v, %v, want %+v", got, err, request)
+	}
+	for _, customID := range []string{
+		sessionStartConfirmID + ":0:0:false",
+		sessionStartConfirmID + ":5:0:false:false:false::",
+		sessionStartConfirmID + ":0:9:false:false:false::",
+		sessionStartConfirmID + ":0:0:maybe:false:false::",
+	} {
+		if _, err := parseSessionStartConfirmID(customID); err == nil {
+			t.Errorf("parseSessionStartConfirmID(%q) accepted a malformed ID", customID)
+		}
+	}
+}
+
+func TestSessionStartConfirmation(t *testing.T) {
+	tests := []struct {
+		name         string
+		confirm      bool
+		wantCallback string
+	}{
+		{name: "cancel", wantCallback: "Session start cancelled."},
+		{name: "confirm", confirm: true, wantCallback: "Starting session..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			useTestDataDirs(t)
+			fake := useFakeDiscord(t)
+			fake.respond = func(w http.ResponseWriter, r *http.Request, body string) bool {
+				if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/channels/confirm-thread") {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(`{"id":"confirm-thread","type":11,"name":"confirm"}`))
+					return true
+				}
+				return false
+			}
+			var sessionsCreated atomic.Int32
+			useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sessionsCreated.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"id":"ses_confirm","directory":%q,"projectID":"p","title":"t","version":"1","time":{"created":0,"updated":0}}`, r.URL.Query().Get("directory"))
+			}))
+			t.Cleanup(func() {
+				sessionMutex.Lock()
+				delete(sessionCache, "confirm-thread")
+				sessionMutex.Unlock()
+			})
+			pull := false
+			useTestConfig(t, func(config *Config) {
+				config.Repositories = []Repository{{Name: "repo", Path: repo, PullBeforeWorktree: &pull}}
+				config.Models = []Model{{ProviderID: "provider", ModelID: "model"}}
+			})
+
+			customID := sessionStartCancelID
+			if tt.confirm {
+				customID = encodeSessionStartConfirmID(sessionStartRequest{Agent: "plan"})
+			}
+			i := testInteraction("confirm-thread", "user")
+			i.Type = discordgo.InteractionMessageComponent
+			i.Data = discordgo.MessageComponentInteractionData{CustomID: customID}
+			handleComponentInteraction(discord, i)
+
+			callbacks := fake.calls(http.MethodPost, "/interactions/"+i.ID+"/"+i.Token+"/callback")
+			if len(callbacks) != 1 || !strings.Contains(callbacks[0].Body, tt.wantCallback) {
+				t.Fatalf("callbacks = %v, want %q", callbacks, tt.wantCallback)
+			}
+
+			_, statErr := os.Stat(filepath.Join(worktreesDirectory, "confirm-thread"))
+			started := sessionsCreated.Load() == 1 && statErr == nil && lazyLoadSession("confirm-thread") != nil
+			if started != tt.confirm {
+				t.Errorf("session started = %v, want %v", started, tt.confirm)
+			}
+			if tt.confirm {
+				welcome := fake.calls(http.MethodPost, "/channels/confirm-thread/messages")
+				if len(welcome) == 0 || !strings.Contains(welcome[0].Body, "Session Started") || !strings.Contains(welcome[0].Body, "Agent: plan") {
+					t.Errorf("welcome messages = %v, want the started session's summary", welcome)
+				}
+			}
+		})
+	}
+}
+
+func TestCanTransferSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		owner  string
+		caller string
+		admin  bool
+		want   bool
+	}{
+		{name: "owner", owner: "1", caller: "1", want: true},
+		{name: "other user", owner: "1", caller: "2", want: false},
+		{name: "admin", owner: "1", caller: "2", admin: true, want: true},
+		{name: "no owner", owner: "", caller: "2", want: false},
+		{name: "no owner as admin", owner: "", caller: "2", admin: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canTransferSession(tt.owner, tt.caller, tt.admin); got != tt.want {
+				t.Errorf("canTransferSession(%q, %q, %v) = %v, want %v", tt.owner, tt.caller, tt.admin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnpushedCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		commits  []*CommitRecord
+		branch   string
+		wantHash string
+	}{
+		{name: "none", commits: nil, branch: "main"},
+		{
+			name:     "latest failed",
+			commits:  []*CommitRecord{{Hash: "a", Status: "success", Branch: "main"}, {Hash: "b", Status: "failed", Branch: "main"}},
+			branch:   "main",
+			wantHash: "b",
+		},
+		{
+			name:    "latest pushed",
+			commits: []*CommitRecord{{Hash: "a", Status: "committed", Branch: "main"}, {Hash: "b", Status: "success", Branch: "main"}},
+			branch:  "main",
+		},
+		{
+			name:     "skips records without a commit",
+			commits:  []*CommitRecord{{Hash: "a", Status: "committed", Branch: "main"}, {Status: "failed", Branch: "main"}},
+			branch:   "main",
+			wantHash: "a",
+		},
+		{
+			name:     "other branch ignored",
+			commits:  []*CommitRecord{{Hash: "a", Status: "committed", Branch: "main"}, {Hash: "b", Status: "committed", Branch: "fork"}},
+			branch:   "main",
+			wantHash: "a",
+		},
+		{
+			name:    "only other branch unpushed",
+			commits: []*CommitRecord{{Hash: "a", Status: "success", Branch: "main"}, {Hash: "b", Status: "failed", Branch: "fork"}},
+			branch:  "main",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unpushedCommit(&SessionData{Commits: tt.commits}, tt.branch)
+			var gotHash string
+			if got != nil {
+				gotHash = got.Hash
+			}
+			if gotHash != tt.wantHash {
+				t.Errorf("unpushedCommit() = %q, want %q", gotHash, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestStaleModelMessage(t *testing.T) {
+	previous := AppConfig.Models
+	AppConfig.Models = []Model{{ProviderID: "anthropic", ModelID: "claude-sonnet"}}
+	t.Cleanup(func() { AppConfig.Models = previous })
+
+	if got := staleModelMessage(Model{ProviderID: "anthropic", ModelID: "claude-sonnet"}); got != "" {
+		t.Errorf("staleModelMessage() for a configured model = %q, want empty", got)
+	}
+	if got := staleModelMessage(Model{ProviderID: "openai", ModelID: "removed"}); got == "" {
+		t.Error("staleModelMessage() for a removed model is empty")
+	}
+}
+
+func TestAmendRefusal(t *testing.T) {
+	record := &CommitRecord{Hash: "abc123", Summary: "feat: x"}
+	tests := []struct {
+		name   string
+		record *CommitRecord
+		head   string
+		pushed bool
+		force  bool
+		want   string
+	}{
+		{name: "no commit", record: nil, head: "abc123", want: "This session has not made a commit yet."},
+		{name: "not head", record: record, head: "def456", want: "The session's last commit is no longer the branch head, so it cannot be amended."},
+		{name: "pushed", record: record, head: "abc123", pushed: true, want: "The last commit was already pushed. Use `force:true` to amend it and force-push the branch."},
+		{name: "pushed with force", record: record, head: "abc123", pushed: true, force: true, want: ""},
+		{name: "local head", record: record, head: "abc123", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := amendRefusal(tt.record, tt.head, tt.pushed, tt.force); got != tt.want {
+				t.Errorf("amendRefusal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
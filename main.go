@@ -5,27 +5,41 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 )
 
-func setLogLevel(levelStr string) {
-	var level slog.Level
+// Minimum level of the default logger. Handlers read it on every record, so /loglevel can change it
+// at runtime without replacing the handler other goroutines are logging through.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel maps a log_level name to its slog level
+func parseLogLevel(levelStr string) (slog.Level, bool) {
 	switch levelStr {
 	case "debug":
-		level = slog.LevelDebug
+		return slog.LevelDebug, true
 	case "info":
-		level = slog.LevelInfo
+		return slog.LevelInfo, true
 	case "warn":
-		level = slog.LevelWarn
+		return slog.LevelWarn, true
 	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo // default to info
+		return slog.LevelError, true
 	}
+	return slog.LevelInfo, false
+}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
-	slog.SetDefault(slog.New(handler))
+// logLevelName renders a level the way log_level spells it
+func logLevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+func setLogLevel(levelStr string) {
+	level, _ := parseLogLevel(levelStr) // default to info
+	logLevel.Set(level)
+
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(newSessionLogHandler(handler)))
 }
 
 func main() {
@@ -44,13 +58,20 @@ func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	// fatal errors from background components trigger the same graceful shutdown as a signal
+	fatal := make(chan error, 1)
+
 	wg.Add(2)
-	go RunOpencodeServer(ctx, &wg)
+	go RunOpencodeServer(ctx, &wg, fatal)
 	go RunDiscordBot(ctx, &wg)
 
-	// receive signal
-	sig := <-sigs
-	slog.Info("received signal", "signal", sig)
+	// receive signal or fatal error
+	select {
+	case sig := <-sigs:
+		slog.Info("received signal", "signal", sig)
+	case err := <-fatal:
+		slog.Error("shutting down after fatal error", "error", err)
+	}
 	cancel()
 
 	wg.Wait()
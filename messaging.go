@@ -2,77 +2,564 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sst/opencode-sdk-go"
 )
 
+// staleModelError is returned by SendMessage when the session's model was removed from the config
+type staleModelError struct {
+	message string
+}
+
+func (e *staleModelError) Error() string {
+	return e.message
+}
+
 // send message to session
-func SendMessage(threadID string, message string) *opencode.SessionPromptResponse {
+func SendMessage(threadID string, message string, images []promptImage) (*opencode.SessionPromptResponse, error) {
 	sessionMutex.RLock()
 	sessionData, exists := sessionCache[threadID]
 	sessionMutex.RUnlock()
 	if !exists {
-		return nil
+		return nil, fmt.Errorf("session not found for thread %s", threadID)
 	}
 
 	// Use the session's stored worktree path and existing session
+	sessionMutex.RLock()
 	model := sessionData.Model
 	session := sessionData.Session
 	worktreePath := sessionData.WorktreePath
+	agent := sessionData.Agent
+	reviewMode := sessionData.ReviewMode
+	planning := isPlanning(sessionData)
+	contextSent := sessionData.ContextSent
+	repositoryName := sessionData.RepositoryName
+	scratch := sessionData.Scratch
+	sessionMutex.RUnlock()
 
 	if session == nil {
 		slog.Error("session object is nil for thread", "thread_id", threadID)
-		return nil
+		return nil, fmt.Errorf("session object is nil for thread %s", threadID)
+	}
+
+	// A prompt to a model removed from the config would only fail with an opaque provider error
+	if message := staleModelMessage(model); message != "" {
+		slog.Warn("session model is no longer configured", "thread_id", threadID, "provider_id", model.ProviderID, "model_id", model.ModelID)
+		return nil, &staleModelError{message: message}
 	}
 
 	slog.Debug("sending message to session", "thread_id", threadID, "session_id", session.ID, "message", message, "worktree_path", worktreePath)
 
+	// Scratch directories live in the temp dir, which the system may have cleaned up since
+	if scratch {
+		if err := ensureScratchDir(worktreePath); err != nil {
+			slog.Error("failed to recreate scratch directory", "thread_id", threadID, "worktree_path", worktreePath, "error", err)
+			return nil, err
+		}
+	}
+
 	// Validate that the worktree path exists and is accessible
 	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
 		slog.Error("worktree path does not exist", "thread_id", threadID, "worktree_path", worktreePath)
-		return nil
+		return nil, fmt.Errorf("worktree path %s does not exist", worktreePath)
 	}
 
 	// Get absolute path to ensure OpenCode SDK gets the correct directory
 	absWorktreePath, err := filepath.Abs(worktreePath)
 	if err != nil {
 		slog.Error("failed to get absolute path for worktree", "thread_id", threadID, "worktree_path", worktreePath, "error", err)
-		return nil
+		return nil, err
 	}
 	slog.Debug("using absolute worktree path", "thread_id", threadID, "abs_worktree_path", absWorktreePath)
 
 	client := Opencode()
 	if client == nil {
 		slog.Error("opencode client is nil", "thread_id", threadID)
-		return nil
+		return nil, fmt.Errorf("opencode client is nil")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
 
 	// Enhanced message - add worktree boundary instruction for defense-in-depth
-	enhancedMessage := message + "\n\nImportant: Stay within the current worktree directory for all file operations."
+	repository := findRepository(repositoryName)
+	var extraDirs []string
+	if repository != nil {
+		extraDirs = repository.ExtraContextDirs
+	}
+	enhancedMessage := message + "\n\n" + worktreeBoundary(extraDirs)
 
-	response, err := client.Session.Prompt(ctx, session.ID, opencode.SessionPromptParams{
-		Directory: opencode.F(absWorktreePath),
+	// The first prompt of a session carries the repository's context files
+	repositoryContext := ""
+	if repository != nil && !contextSent && len(repository.ContextFiles) > 0 {
+		repositoryContext = capContextTokens(loadRepositoryContext(absWorktreePath, repository.ContextFiles), AppConfig.ContextTokenBudget)
+		if repositoryContext != "" {
+			enhancedMessage = repositoryContext + "\n" + enhancedMessage
+		}
+	}
+
+	params := buildPromptParams(absWorktreePath, model, agent, enhancedMessage, images)
+	if tools := promptTools(repository, reviewMode || planning); tools != nil {
+		params.Tools = opencode.F(tools)
+	}
+	timeout := promptTimeoutFor(model)
+	reestablished := false
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		response, err := client.Session.Prompt(ctx, session.ID, params)
+		cancel()
+		if err == nil {
+			err = assistantMessageError(response)
+		}
+		if err == nil {
+			if repositoryContext != "" {
+				sessionMutex.Lock()
+				sessionData.ContextSent = true
+				sessionMutex.Unlock()
+				if err := saveSessionData(sessionData); err != nil {
+					slog.Error("failed to save session data after sending context", "thread_id", threadID, "error", err)
+				}
+			}
+			return response, nil
+		}
+
+		slog.Error("failed to send message", "thread_id", threadID, "session_id", session.ID, "attempt", attempt, "error", err)
+		if !reestablished && isSessionNotFoundError(err) {
+			reestablished = true
+			if err := reestablishSession(sessionData); err != nil {
+				return nil, err
+			}
+			sessionMutex.RLock()
+			session = sessionData.Session
+			sessionMutex.RUnlock()
+			// The listener of this turn follows the old session; a fresh one follows the new session
+			stopActiveListener(threadID)
+			spawnListenerIfNotExists(mainContext, mainWaitGroup, threadID)
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Only the request was cancelled; stop the server too, or the next prompt would overlap with this one
+			if abortErr := abortSession(session.ID, absWorktreePath); abortErr != nil {
+				slog.Error("failed to abort timed out prompt", "thread_id", threadID, "session_id", session.ID, "error", abortErr)
+			}
+			sessionMutex.Lock()
+			sessionData.IsStreaming = false
+			sessionMutex.Unlock()
+			SetSessionActive(threadID, false)
+			return nil, fmt.Errorf("prompt timed out after %s: %w", timeout, err)
+		}
+		if classifyPromptError(err) != promptErrorRateLimit || attempt >= maxRateLimitRetries {
+			return nil, err
+		}
+
+		// Back off exponentially on provider rate limits
+		delay := rateLimitBaseDelay << attempt
+		sendToDiscord(threadID, fmt.Sprintf("Provider rate limit hit, retrying in %s...", delay))
+		time.Sleep(delay)
+	}
+}
+
+// isSessionNotFoundError reports whether OpenCode rejected a prompt because the session does not
+// exist on the server, e.g. after the server was restarted with a fresh storage
+func isSessionNotFoundError(err error) bool {
+	var apiErr *opencode.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	text := strings.ToLower(apiErr.JSON.RawJSON())
+	return strings.Contains(text, "notfounderror") || strings.Contains(text, "session not found")
+}
+
+// reestablishSession replaces a session that no longer exists on the OpenCode server with a new one
+// for the same worktree, stores its ID and tells the thread that earlier context is gone
+func reestablishSession(sessionData *SessionData) error {
+	client := Opencode()
+	if client == nil {
+		return errOpencodeUnavailable
+	}
+
+	sessionMutex.RLock()
+	threadID := sessionData.ThreadID
+	worktreePath := sessionData.WorktreePath
+	oldSessionID := sessionData.SessionID
+	sessionMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	session, err := client.Session.New(ctx, opencode.SessionNewParams{
+		Directory: opencode.F(worktreePath),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to re-establish session: %w", err)
+	}
+
+	sessionMutex.Lock()
+	sessionData.SessionID = session.ID
+	sessionData.Session = session
+	// The new session has not seen the repository context yet
+	sessionData.ContextSent = false
+	if sub, exists := sessionData.Worktrees[sessionData.CurrentWorktree]; exists {
+		sub.SessionID = session.ID
+	}
+	sessionMutex.Unlock()
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after re-establishing session", "thread_id", threadID, "error", err)
+	}
+
+	slog.Warn("re-established missing opencode session", "thread_id", threadID, "old_session_id", oldSessionID, "session_id", session.ID)
+	sendToDiscord(threadID, "♻️ The OpenCode session no longer existed on the server (it was probably restarted), so a new one was started for this worktree and the request retried. The model does not remember the earlier conversation.")
+	return nil
+}
+
+// errOpencodeUnavailable is returned when the OpenCode client has not been initialized
+var errOpencodeUnavailable = errors.New("opencode client is nil")
+
+// promptSummarizer asks the session's model to answer an instruction with file-modifying tools
+// disabled and returns the first text part of the response ("" when there is none)
+func promptSummarizer(session *SessionData, instruction string) (string, error) {
+	client := Opencode()
+	if client == nil {
+		return "", errOpencodeUnavailable
+	}
+
+	sessionMutex.RLock()
+	sessionID := session.SessionID
+	worktreePath := session.WorktreePath
+	model := session.Model
+	threadID := session.ThreadID
+	sessionMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), promptTimeoutFor(model))
+	defer cancel()
+	params := opencode.SessionPromptParams{
+		Directory: opencode.F(worktreePath),
+		Tools: opencode.F(map[string]bool{
+			"write": false,
+			"edit":  false,
+		}),
 		Parts: opencode.F([]opencode.SessionPromptParamsPartUnion{
 			&opencode.TextPartInputParam{
 				Type: opencode.F(opencode.TextPartInputTypeText),
-				Text: opencode.F(enhancedMessage),
+				Text: opencode.F(instruction),
 			},
 		}),
 		Model: opencode.F(opencode.SessionPromptParamsModel{
 			ProviderID: opencode.F(model.ProviderID),
 			ModelID:    opencode.F(model.ModelID),
 		}),
-	})
+	}
+	response, err := client.Session.Prompt(ctx, sessionID, params)
+	if err != nil && isSessionNotFoundError(err) {
+		slog.Warn("session not found on the server, re-establishing it for the summarizer", "thread_id", threadID, "session_id", sessionID)
+		if reestablishErr := reestablishSession(session); reestablishErr != nil {
+			return "", reestablishErr
+		}
+		sessionMutex.RLock()
+		sessionID = session.SessionID
+		sessionMutex.RUnlock()
+		response, err = client.Session.Prompt(ctx, sessionID, params)
+	}
 	if err != nil {
-		slog.Error("failed to send message", "thread_id", threadID, "session_id", session.ID, "error", err)
+		return "", err
+	}
+	slog.Debug("AI summary generated successfully", "thread_id", threadID, "parts_count", len(response.Parts))
+
+	// Get summary from response by looking specifically for "text" type parts
+	for i, part := range response.Parts {
+		slog.Debug("checking response part", "thread_id", threadID, "part_index", i, "part_type", part.Type, "text_length", len(part.Text))
+		if part.Type == "text" && part.Text != "" {
+			slog.Debug("found AI summary in text part", "thread_id", threadID, "part_index", i, "raw_summary", part.Text, "length", len(part.Text))
+			return part.Text, nil // Use the first text-type part we find
+		}
+	}
+	return "", nil
+}
+
+// Commit subjects longer than this are cut at a word boundary
+const maxCommitSubjectLength = 72
+
+// conventionalCommitPattern matches a conventional commit subject such as "feat(api)!: add endpoint"
+var conventionalCommitPattern = regexp.MustCompile(`^[a-z]+(\([^()]+\))?!?: \S`)
+
+// Placeholder in commit message templates replaced by the generated summary
+const commitSummaryPlaceholder = "{summary}"
+
+// validateCommitTemplate checks that a commit message template has a place for the summary
+func validateCommitTemplate(template string) error {
+	if !strings.Contains(template, commitSummaryPlaceholder) {
+		return fmt.Errorf("commit message template %q must contain %s", template, commitSummaryPlaceholder)
+	}
+	return nil
+}
+
+// renderCommitTemplate places the summary into a commit message template; an empty template
+// leaves the summary unchanged
+func renderCommitTemplate(template, summary string) string {
+	if template == "" {
+		return summary
+	}
+	return strings.TrimSpace(strings.ReplaceAll(template, commitSummaryPlaceholder, summary))
+}
+
+// cleanCommitMessage turns summarizer output into a git-friendly message: surrounding code fences and
+// markdown decoration are removed, the first line becomes a subject of at most maxCommitSubjectLength
+// characters (left to formatCommitMessage when commit_subject_max is set), and the remaining
+// paragraphs are kept as the body
+func cleanCommitMessage(raw string) string {
+	text := strings.TrimSpace(raw)
+	if strings.HasPrefix(text, "```") {
+		// Drop the opening fence (and its language tag) and the closing fence
+		_, inner, _ := strings.Cut(text, "\n")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(inner), "```"))
+	}
+
+	subject, body, _ := strings.Cut(text, "\n")
+	subject = strings.TrimSpace(strings.TrimLeft(subject, "# "))
+	subject = strings.Trim(subject, "`*")
+	subject = strings.TrimSpace(subject)
+	if runes := []rune(subject); AppConfig.CommitSubjectMax <= 0 && len(runes) > maxCommitSubjectLength {
+		cut := string(runes[:maxCommitSubjectLength])
+		if idx := strings.LastIndex(cut, " "); idx > maxCommitSubjectLength/2 {
+			cut = cut[:idx]
+		}
+		subject = strings.TrimSpace(cut)
+	}
+
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return subject
+	}
+	return subject + "\n\n" + body
+}
+
+// formatCommitMessage applies commit_subject_max and commit_body_wrap to a finished commit message:
+// a longer subject is cut and ends with an ellipsis, longer body lines are wrapped at word boundaries
+func formatCommitMessage(message string, subjectMax, bodyWrap int) string {
+	subject, body, hasBody := strings.Cut(message, "\n")
+	if runes := []rune(subject); subjectMax > 0 && len(runes) > subjectMax {
+		subject = strings.TrimSpace(string(runes[:max(subjectMax-1, 0)])) + "…"
+	}
+	if !hasBody {
+		return subject
+	}
+	if bodyWrap > 0 {
+		var lines []string
+		for _, line := range strings.Split(body, "\n") {
+			lines = append(lines, wrapCommitLine(line, bodyWrap)...)
+		}
+		body = strings.Join(lines, "\n")
+	}
+	return subject + "\n" + body
+}
+
+// listItemPattern matches the marker of a markdown list item, e.g. "- ", "* " or "2. "
+var listItemPattern = regexp.MustCompile(`^(\s*)([-*]|\d+\.) `)
+
+// wrapCommitLine breaks a body line into lines of at most width characters. Continuation lines of
+// list items are indented under the item's text; words longer than width (URLs, paths) stay whole.
+func wrapCommitLine(line string, width int) []string {
+	if utf8.RuneCountInString(line) <= width {
+		return []string{line}
+	}
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	continuation := indent
+	if marker := listItemPattern.FindString(line); marker != "" {
+		continuation = strings.Repeat(" ", utf8.RuneCountInString(marker))
+	}
+
+	var lines []string
+	current := indent
+	for _, word := range strings.Fields(line) {
+		switch {
+		case strings.TrimSpace(current) == "":
+			current += word
+		case utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = continuation + word
+		}
+	}
+	return append(lines, current)
+}
+
+// isConventionalCommit reports whether the subject line of message follows the conventional commit format
+func isConventionalCommit(message string) bool {
+	subject, _, _ := strings.Cut(message, "\n")
+	return conventionalCommitPattern.MatchString(subject)
+}
+
+// Default instruction for /prdescription when pr_description_instruction is not configured
+const defaultPRDescriptionInstruction = "Write a pull request title and description for this branch. The first line is the title, without any prefix. Follow with a blank line and a markdown body summarizing what changed and why, based on the commits and file summary below. Keep it concise."
+
+// buildPRDescriptionPrompt combines the PR instruction with the branch's commits so the model
+// summarizes the whole branch rather than a single commit
+func buildPRDescriptionPrompt(instruction, target string, commitSummaries []string, commitLog, diffStat string) string {
+	if instruction == "" {
+		instruction = defaultPRDescriptionInstruction
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(instruction)
+	fmt.Fprintf(&prompt, "\n\nTarget branch: %s\n", target)
+	if len(commitSummaries) > 0 {
+		prompt.WriteString("\nCommit messages from this session:\n")
+		for _, summary := range commitSummaries {
+			fmt.Fprintf(&prompt, "---\n%s\n", strings.TrimSpace(summary))
+		}
+	}
+
+	// The commit log and file summary of a long-lived branch can be large, so they share the context budget
+	var branchContext strings.Builder
+	fmt.Fprintf(&branchContext, "\nCommits on this branch:\n%s\n", commitLog)
+	if diffStat != "" {
+		fmt.Fprintf(&branchContext, "\nFiles changed:\n%s\n", diffStat)
+	}
+	prompt.WriteString(capContextTokens(branchContext.String(), AppConfig.ContextTokenBudget))
+	return prompt.String()
+}
+
+// splitPRDescription splits generated text into a title (first non-empty line) and body
+func splitPRDescription(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	title, body, _ := strings.Cut(text, "\n")
+	title = strings.TrimSpace(strings.TrimLeft(title, "# "))
+	return title, strings.TrimSpace(body)
+}
+
+// Prompt error classes surfaced to users
+const (
+	promptErrorRateLimit = "rate_limit"
+	promptErrorQuota     = "quota"
+	promptErrorAuth      = "auth"
+	promptErrorTimeout   = "timeout"
+	promptErrorUnknown   = "unknown"
+)
+
+// Rate-limited prompts are retried with exponential backoff starting at rateLimitBaseDelay
+const (
+	maxRateLimitRetries = 3
+	rateLimitBaseDelay  = 5 * time.Second
+)
+
+// assistantMessageError converts a provider error reported inside a successful prompt response into an error
+func assistantMessageError(response *opencode.SessionPromptResponse) error {
+	if response == nil || response.Info.Error.Name == "" {
 		return nil
 	}
+	return fmt.Errorf("%s: %s", response.Info.Error.Name, response.Info.Error.JSON.RawJSON())
+}
+
+// classifyPromptError inspects a prompt error for rate-limit, quota and auth signals
+func classifyPromptError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return promptErrorTimeout
+	}
+
+	status := 0
+	text := strings.ToLower(err.Error())
+	var apiErr *opencode.Error
+	if errors.As(err, &apiErr) {
+		status = apiErr.StatusCode
+		text = strings.ToLower(apiErr.JSON.RawJSON()) + " " + text
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests || strings.Contains(text, "rate limit") || strings.Contains(text, "rate_limit") || strings.Contains(text, "too many requests"):
+		return promptErrorRateLimit
+	case status == http.StatusPaymentRequired || strings.Contains(text, "quota") || strings.Contains(text, "insufficient") || strings.Contains(text, "billing"):
+		return promptErrorQuota
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || strings.Contains(text, "providerautherror") || strings.Contains(text, "api key") || strings.Contains(text, "unauthorized"):
+		return promptErrorAuth
+	}
+	return promptErrorUnknown
+}
+
+// promptErrorMessage returns an actionable message for users when a prompt fails
+func promptErrorMessage(err error) string {
+	var stale *staleModelError
+	if errors.As(err, &stale) {
+		return stale.message
+	}
+	switch classifyPromptError(err) {
+	case promptErrorRateLimit:
+		return "The model provider is rate limiting requests. Please wait a bit and try again."
+	case promptErrorQuota:
+		return "The model provider reports the quota or credit is exhausted. Please check the provider account or switch models."
+	case promptErrorAuth:
+		return "The model provider rejected the credentials (API key invalid or missing). Please ask an admin to run `opencode auth login`."
+	case promptErrorTimeout:
+		return "The model did not respond in time and the request was cancelled. Try again, or ask an admin to raise `prompt_timeout`."
+	}
+	return "Failed to send message to codesession."
+}
+
+// readOnlyTools disables the tools that can modify the worktree
+func readOnlyTools() map[string]bool {
+	return map[string]bool{
+		"write": false,
+		"edit":  false,
+		"patch": false,
+		"bash":  false,
+	}
+}
+
+// promptTools resolves the tools map sent with a prompt: the repository's tools_preset, with the
+// file-modifying tools disabled on top of it for read-only prompts. nil leaves the server defaults.
+func promptTools(repository *Repository, readOnly bool) map[string]bool {
+	var tools map[string]bool
+	if repository != nil && repository.ToolsPreset != "" {
+		tools = make(map[string]bool)
+		for tool, enabled := range AppConfig.ToolPresets[repository.ToolsPreset] {
+			tools[tool] = enabled
+		}
+	}
+	if readOnly {
+		if tools == nil {
+			tools = make(map[string]bool)
+		}
+		for tool, enabled := range readOnlyTools() {
+			tools[tool] = enabled
+		}
+	}
+	return tools
+}
 
-	return response
+// buildPromptParams constructs the prompt parameters for a session message.
+// The agent is only sent when set so the server default applies otherwise.
+func buildPromptParams(worktreePath string, model Model, agent string, message string, images []promptImage) opencode.SessionPromptParams {
+	parts := []opencode.SessionPromptParamsPartUnion{
+		&opencode.TextPartInputParam{
+			Type: opencode.F(opencode.TextPartInputTypeText),
+			Text: opencode.F(message),
+		},
+	}
+	for _, image := range images {
+		parts = append(parts, imagePart(image))
+	}
+	params := opencode.SessionPromptParams{
+		Directory: opencode.F(worktreePath),
+		Parts:     opencode.F(parts),
+		Model: opencode.F(opencode.SessionPromptParamsModel{
+			ProviderID: opencode.F(model.ProviderID),
+			ModelID:    opencode.F(model.ModelID),
+		}),
+	}
+	if agent != "" {
+		params.Agent = opencode.F(agent)
+	}
+	return params
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+)
+
+func TestPromptErrorMessageStaleModel(t *testing.T) {
+	stale := &staleModelError{message: "model removed"}
+	if got := promptErrorMessage(fmt.Errorf("send failed: %w", stale)); got != "model removed" {
+		t.Errorf("promptErrorMessage() = %q, want the stale model message", got)
+	}
+}
+
+func TestPromptTools(t *testing.T) {
+	previous := AppConfig.ToolPresets
+	AppConfig.ToolPresets = map[string]ToolPreset{
+		"docs": {"bash": false, "webfetch": true},
+	}
+	t.Cleanup(func() { AppConfig.ToolPresets = previous })
+
+	tests := []struct {
+		name       string
+		repository *Repository
+		readOnly   bool
+		want       map[string]bool
+	}{
+		{name: "no repository", repository: nil, want: nil},
+		{name: "no preset", repository: &Repository{Name: "app"}, want: nil},
+		{name: "preset", repository: &Repository{Name: "docs", ToolsPreset: "docs"}, want: map[string]bool{"bash": false, "webfetch": true}},
+		{
+			name: "read-only without preset", repository: &Repository{Name: "app"}, readOnly: true,
+			want: map[string]bool{"write": false, "edit": false, "patch": false, "bash": false},
+		},
+		{
+			name: "read-only on top of preset", repository: &Repository{Name: "docs", ToolsPreset: "docs"}, readOnly: true,
+			want: map[string]bool{"write": false, "edit": false, "patch": false, "bash": false, "webfetch": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := promptTools(tt.repository, tt.readOnly)
+			if (got == nil) != (tt.want == nil) || !maps.Equal(got, tt.want) {
+				t.Errorf("promptTools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Read-only prompts must not change the configured preset
+	if AppConfig.ToolPresets["docs"]["write"] {
+		t.Error("promptTools modified the configured preset")
+	}
+}
+
+func TestIsSessionNotFoundError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/session/missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"name":"NotFoundError"}`)
+		case "/session/named":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"name":"NotFoundError","data":{"message":"Session not found"}}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"name":"UnknownError"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := opencode.NewClient(option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+
+	sessionError := func(id string) error {
+		_, err := client.Session.Get(context.Background(), id, opencode.SessionGetParams{})
+		if err == nil {
+			t.Fatalf("expected an error for session %s", id)
+		}
+		return err
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "not found status", err: sessionError("missing"), want: true},
+		{name: "not found body", err: sessionError("named"), want: true},
+		{name: "wrapped", err: fmt.Errorf("prompt failed: %w", sessionError("missing")), want: true},
+		{name: "server error", err: sessionError("other"), want: false},
+		{name: "not an API error", err: errors.New("session not found"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSessionNotFoundError(tt.err); got != tt.want {
+				t.Errorf("isSessionNotFoundError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMessageReestablishesMissingSession(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	model := Model{ProviderID: "provider", ModelID: "model"}
+	useTestConfig(t, func(config *Config) { config.Models = []Model{model} })
+
+	var promptsMutex sync.Mutex
+	var prompted []string
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/event":
+			// The respawned listener follows the new session until the test ends
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case r.Method == http.MethodPost && r.URL.Path == "/session":
+			fmt.Fprintf(w, `{"id":"ses_new","directory":%q,"projectID":"p","title":"t","version":"1","time":{"created":0,"updated":0}}`, r.URL.Query().Get("directory"))
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/message"):
+			sessionID := strings.Split(r.URL.Path, "/")[2]
+			promptsMutex.Lock()
+			prompted = append(prompted, sessionID)
+			promptsMutex.Unlock()
+			if sessionID != "ses_new" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"name":"NotFoundError","data":{"message":"Session not found"}}`)
+				return
+			}
+			fmt.Fprint(w, `{"info":{"id":"msg","role":"assistant","sessionID":"ses_new"},"parts":[{"id":"part","type":"text","text":"retried","messageID":"msg","sessionID":"ses_new"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	// The retry respawns the thread's listener under the bot's main context
+	ctx, cancel := context.WithCancel(context.Background())
+	var listeners sync.WaitGroup
+	previousContext, previousWaitGroup := mainContext, mainWaitGroup
+	mainContext, mainWaitGroup = ctx, &listeners
+	t.Cleanup(func() {
+		stopActiveListener("reestablish-thread")
+		cancel()
+		listeners.Wait()
+		mainContext, mainWaitGroup = previousContext, previousWaitGroup
+	})
+
+	sessionData := &SessionData{
+		ThreadID:     "reestablish-thread",
+		SessionID:    "ses_old",
+		Session:      &opencode.Session{ID: "ses_old"},
+		WorktreePath: t.TempDir(),
+		Model:        model,
+		ContextSent:  true,
+	}
+	useTestSession(t, sessionData)
+
+	response, err := SendMessage("reestablish-thread", "hello", nil)
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if len(response.Parts) != 1 || response.Parts[0].Text != "retried" {
+		t.Errorf("response parts = %+v, want the retried answer", response.Parts)
+	}
+	promptsMutex.Lock()
+	if strings.Join(prompted, ",") != "ses_old,ses_new" {
+		t.Errorf("prompted sessions %v, want the missing one then its replacement", prompted)
+	}
+	promptsMutex.Unlock()
+
+	sessionMutex.RLock()
+	sessionID, contextSent := sessionData.SessionID, sessionData.ContextSent
+	sessionMutex.RUnlock()
+	if sessionID != "ses_new" || contextSent {
+		t.Errorf("session = %s with context sent %v, want ses_new awaiting its context", sessionID, contextSent)
+	}
+	listenersMutex.RLock()
+	_, listening := activeListeners["reestablish-thread"]
+	listenersMutex.RUnlock()
+	if !listening {
+		t.Error("no listener follows the new session")
+	}
+	notices := fake.calls(http.MethodPost, "/channels/reestablish-thread/messages")
+	if len(notices) != 1 || !strings.Contains(notices[0].Body, "no longer existed on the server") {
+		t.Errorf("notices = %v, want one about the new session", notices)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// modelComparisonResult is the answer of one model to a comparison prompt
+type modelComparisonResult struct {
+	Model Model
+	Text  string
+	Err   error
+}
+
+// compareModels sends the same prompt to each model in parallel, each in its own throwaway
+// OpenCode session with file-modifying tools disabled so the runs cannot interfere with each
+// other or the worktree. Results are returned in the order of models.
+func compareModels(worktreePath string, models []Model, prompt string) []modelComparisonResult {
+	results := make([]modelComparisonResult, len(models))
+	var wg sync.WaitGroup
+	for idx, model := range models {
+		wg.Add(1)
+		go func(idx int, model Model) {
+			defer wg.Done()
+			text, err := promptThrowawaySession(worktreePath, model, prompt)
+			results[idx] = modelComparisonResult{Model: model, Text: text, Err: err}
+		}(idx, model)
+	}
+	wg.Wait()
+	return results
+}
+
+// promptThrowawaySession runs a read-only prompt in a temporary session and deletes it afterwards
+func promptThrowawaySession(worktreePath string, model Model, prompt string) (string, error) {
+	client := Opencode()
+	if client == nil {
+		return "", fmt.Errorf("opencode client is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), promptTimeoutFor(model))
+	defer cancel()
+
+	session, err := client.Session.New(ctx, opencode.SessionNewParams{
+		Directory: opencode.F(worktreePath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create comparison session: %w", err)
+	}
+	defer func() {
+		if _, err := client.Session.Delete(context.Background(), session.ID, opencode.SessionDeleteParams{
+			Directory: opencode.F(worktreePath),
+		}); err != nil {
+			slog.Warn("failed to delete comparison session", "session_id", session.ID, "error", err)
+		}
+	}()
+
+	params := buildPromptParams(worktreePath, model, "", prompt, nil)
+	params.Tools = opencode.F(readOnlyTools())
+	response, err := client.Session.Prompt(ctx, session.ID, params)
+	if err == nil {
+		err = assistantMessageError(response)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var texts []string
+	for _, part := range response.Parts {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompareModelsParallel(t *testing.T) {
+	models := []Model{{ProviderID: "provider", ModelID: "model-a"}, {ProviderID: "provider", ModelID: "model-b"}}
+
+	// Each prompt is held until both have arrived, so a sequential dispatch fails
+	var arrived sync.WaitGroup
+	arrived.Add(len(models))
+	var created, deleted atomic.Int32
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/session":
+			id := created.Add(1)
+			fmt.Fprintf(w, `{"id":"ses_%d","directory":"/worktree","projectID":"p","title":"t","version":"1","time":{"created":0,"updated":0}}`, id)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/message"):
+			var body struct {
+				Model struct {
+					ModelID string `json:"modelID"`
+				} `json:"model"`
+				Tools map[string]bool `json:"tools"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			arrived.Done()
+			waited := make(chan struct{})
+			go func() { arrived.Wait(); close(waited) }()
+			select {
+			case <-waited:
+			case <-time.After(5 * time.Second):
+				http.Error(w, "prompts were not sent in parallel", http.StatusInternalServerError)
+				return
+			}
+			if enabled, set := body.Tools["write"]; !set || enabled {
+				http.Error(w, "comparison prompt may modify files", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"info":{"id":"msg","role":"assistant","sessionID":"s"},"parts":[{"id":"part","type":"text","text":"answer from %s","messageID":"msg","sessionID":"s"}]}`, body.Model.ModelID)
+		case r.Method == http.MethodDelete:
+			deleted.Add(1)
+			w.Write([]byte("true"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	results := compareModels("/worktree", models, "which is better?")
+	if len(results) != len(models) {
+		t.Fatalf("got %d results, want %d", len(results), len(models))
+	}
+	for idx, result := range results {
+		if result.Err != nil {
+			t.Fatalf("model %s: %v", result.Model.ModelID, result.Err)
+		}
+		if result.Model != models[idx] || result.Text != "answer from "+models[idx].ModelID {
+			t.Errorf("result %d = %s %q, want the answer of %s", idx, result.Model.ModelID, result.Text, models[idx].ModelID)
+		}
+	}
+	if created.Load() != 2 || deleted.Load() != 2 {
+		t.Errorf("created %d and deleted %d sessions, want 2 throwaway sessions", created.Load(), deleted.Load())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// The availability check only lists providers, so it should answer quickly
+const modelCheckTimeout = 10 * time.Second
+
+// modelAvailability is the result of checking a model against the OpenCode server's provider list
+type modelAvailability struct {
+	Listed       bool          // The provider exposes the model
+	ProviderName string        // Display name of the provider, when known
+	ModelName    string        // Display name of the model, when known
+	Context      int           // Context window in tokens, 0 when unknown
+	Latency      time.Duration // Round trip of the provider list request
+	Err          error         // Set when the check itself failed
+}
+
+// checkModelAvailability asks the OpenCode server whether model is offered by its provider and
+// measures how long the server takes to answer. No prompt is sent, so the check costs nothing.
+func checkModelAvailability(worktreePath string, model Model) modelAvailability {
+	client := Opencode()
+	if client == nil {
+		return modelAvailability{Err: fmt.Errorf("opencode client is nil")}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), modelCheckTimeout)
+	defer cancel()
+
+	started := time.Now()
+	response, err := client.App.Providers(ctx, opencode.AppProvidersParams{
+		Directory: opencode.F(worktreePath),
+	})
+	latency := time.Since(started)
+	if err != nil {
+		return modelAvailability{Latency: latency, Err: err}
+	}
+
+	availability := modelAvailability{Latency: latency}
+	for _, provider := range response.Providers {
+		if provider.ID != model.ProviderID {
+			continue
+		}
+		availability.ProviderName = provider.Name
+		if info, ok := provider.Models[model.ModelID]; ok {
+			availability.Listed = true
+			availability.ModelName = info.Name
+			availability.Context = int(info.Limit.Context)
+		}
+	}
+	return availability
+}
+
+// formatModelInfo describes the session model and the outcome of its availability check
+func formatModelInfo(model Model, availability modelAvailability) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Model: `%s/%s`", model.ProviderID, model.ModelID)
+	if availability.ModelName != "" {
+		fmt.Fprintf(&builder, " (%s", availability.ModelName)
+		if availability.ProviderName != "" {
+			fmt.Fprintf(&builder, " via %s", availability.ProviderName)
+		}
+		builder.WriteString(")")
+	}
+	builder.WriteString("\n")
+	if availability.Context > 0 {
+		fmt.Fprintf(&builder, "Context window: %d tokens\n", availability.Context)
+	}
+
+	latency := availability.Latency.Round(time.Millisecond)
+	switch {
+	case availability.Err != nil:
+		fmt.Fprintf(&builder, "Availability: ⚠️ unknown, the check failed after %s: %v", latency, availability.Err)
+	case availability.Listed:
+		fmt.Fprintf(&builder, "Availability: ✅ offered by the OpenCode server (answered in %s)", latency)
+	case availability.ProviderName != "":
+		fmt.Fprintf(&builder, "Availability: ❌ %s does not offer this model (answered in %s)", availability.ProviderName, latency)
+	default:
+		fmt.Fprintf(&builder, "Availability: ❌ provider `%s` is not configured on the OpenCode server (answered in %s)", model.ProviderID, latency)
+	}
+	return builder.String()
+}
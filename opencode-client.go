@@ -1 +1,51 @@
 package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// listPrimaryAgents returns the names of the agents the server reports as selectable for prompts
+func listPrimaryAgents(directory string) ([]string, error) {
+	client := Opencode()
+	if client == nil {
+		return nil, fmt.Errorf("opencode client is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	agents, err := client.Agent.List(ctx, opencode.AgentListParams{
+		Directory: opencode.F(directory),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	var names []string
+	for _, agent := range *agents {
+		if agent.Mode == opencode.AgentModePrimary || agent.Mode == opencode.AgentModeAll {
+			names = append(names, agent.Name)
+		}
+	}
+	return names, nil
+}
+
+// validateAgent checks that the agent is one the server reports; an empty agent means the server default
+func validateAgent(directory, agent string) error {
+	if agent == "" {
+		return nil
+	}
+	names, err := listPrimaryAgents(directory)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(names, agent) {
+		return fmt.Errorf("unknown agent %q, available agents: %v", agent, names)
+	}
+	return nil
+}
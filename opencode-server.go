@@ -2,26 +2,66 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
 )
 
-func RunOpencodeServer(ctx context.Context, wg *sync.WaitGroup) {
+// RunOpencodeServer starts the opencode server and stops it when ctx is canceled.
+// Startup failures are reported on fatal so main can shut down gracefully.
+func RunOpencodeServer(ctx context.Context, wg *sync.WaitGroup, fatal chan<- error) {
 	defer wg.Done()
 
+	// A leftover server from a previous run would make `opencode serve` fail on the port
+	if inUse, err := portInUse(AppConfig.OpencodePort); err != nil {
+		slog.Warn("failed to check opencode port", "port", AppConfig.OpencodePort, "error", err)
+	} else if inUse {
+		if !AppConfig.ReuseExistingServer {
+			err := portInUseError(AppConfig.OpencodePort)
+			slog.Error("failed to start opencode server", "error", err)
+			fatal <- err
+			return
+		}
+		if err := probeOpencodeServer(); err != nil {
+			err = fmt.Errorf("port %d is in use but does not answer as an opencode server: %w", AppConfig.OpencodePort, err)
+			slog.Error("failed to reuse opencode server", "error", err)
+			fatal <- err
+			return
+		}
+		// The server isn't ours, so it is left running on shutdown
+		slog.Info("reusing opencode server already listening", "port", AppConfig.OpencodePort)
+		<-ctx.Done()
+		return
+	}
+
 	// run opencode server
 	port := strconv.Itoa(AppConfig.OpencodePort)
 
-	cmd := exec.Command("opencode", "serve", "-p", port)
+	cmd, err := buildOpencodeCommand(port)
+	if err != nil {
+		slog.Error("invalid opencode server configuration", "error", err)
+		fatal <- err
+		return
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
+		err = describeOpencodeStartError(err)
 		slog.Error("failed to start opencode server", "error", err)
-		os.Exit(1)
+		fatal <- err
+		return
 	}
 	// initialize opencode client
 	Opencode()
@@ -36,3 +76,74 @@ func RunOpencodeServer(ctx context.Context, wg *sync.WaitGroup) {
 	cmd.Wait() // wait for the process to exit
 	slog.Info("opencode server stopped")
 }
+
+// portInUse reports whether something already listens on the local TCP port
+func portInUse(port int) (bool, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return true, nil
+		}
+		return false, err
+	}
+	listener.Close()
+	return false, nil
+}
+
+// portInUseError describes an occupied opencode port, naming the process holding it when lsof can tell
+func portInUseError(port int) error {
+	hint := "stop the process holding it, change opencode_port, or set reuse_existing_server = true to connect to it"
+	output, err := exec.Command("lsof", "-t", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN").Output()
+	if pids := strings.Fields(string(output)); err == nil && len(pids) > 0 {
+		return fmt.Errorf("opencode_port %d is already in use by PID %s: %s", port, strings.Join(pids, ", "), hint)
+	}
+	return fmt.Errorf("opencode_port %d is already in use: %s", port, hint)
+}
+
+// probeOpencodeServer checks that the server on the configured port speaks the opencode API
+func probeOpencodeServer() error {
+	client := Opencode()
+	if client == nil {
+		return errOpencodeUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.Path.Get(ctx, opencode.PathGetParams{})
+	return err
+}
+
+// buildOpencodeCommand constructs the `opencode serve` command with the configured extra args and environment
+func buildOpencodeCommand(port string) (*exec.Cmd, error) {
+	for _, arg := range AppConfig.OpencodeArgs {
+		if arg == "-p" || arg == "--port" || strings.HasPrefix(arg, "--port=") || strings.HasPrefix(arg, "-p=") {
+			return nil, fmt.Errorf("opencode_args must not set the port (%q); use opencode_port instead", arg)
+		}
+	}
+
+	args := append([]string{"serve", "-p", port}, AppConfig.OpencodeArgs...)
+	cmd := exec.Command(opencodeBinary(), args...)
+
+	if len(AppConfig.OpencodeEnv) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range AppConfig.OpencodeEnv {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return cmd, nil
+}
+
+// opencodeBinary returns the configured opencode binary, defaulting to the one in PATH
+func opencodeBinary() string {
+	if AppConfig.OpencodePath != "" {
+		return AppConfig.OpencodePath
+	}
+	return "opencode"
+}
+
+// describeOpencodeStartError turns a missing-binary error into an actionable message
+func describeOpencodeStartError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("opencode binary %q not found: install opencode (https://opencode.ai) or set opencode_path in config.toml: %w", opencodeBinary(), err)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDescribeOpencodeStartError(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "missing absolute path", path: filepath.Join(t.TempDir(), "opencode")},
+		{name: "not in PATH", path: "opencode-binary-that-does-not-exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t, func(config *Config) { config.OpencodePath = tt.path })
+
+			startErr := exec.Command(opencodeBinary(), "serve").Start()
+			if startErr == nil {
+				t.Fatal("starting a bogus binary succeeded")
+			}
+			err := describeOpencodeStartError(startErr)
+			if !strings.Contains(err.Error(), "opencode binary \""+tt.path+"\" not found") || !strings.Contains(err.Error(), "opencode_path") {
+				t.Errorf("describeOpencodeStartError() = %q, want a not-found hint naming the path", err)
+			}
+			if !errors.Is(err, exec.ErrNotFound) && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("describeOpencodeStartError() = %v, want it to wrap the start error", err)
+			}
+		})
+	}
+
+	other := errors.New("permission denied")
+	if got := describeOpencodeStartError(other); got != other {
+		t.Errorf("describeOpencodeStartError(other) = %v, want it unchanged", got)
+	}
+}
+
+func TestBuildOpencodeCommand(t *testing.T) {
+	useTestConfig(t, func(config *Config) {
+		config.OpencodePath = "/opt/opencode/bin/opencode"
+		config.OpencodeArgs = []string{"--log-level", "DEBUG"}
+		config.OpencodeEnv = map[string]string{"OPENCODE_CONFIG": "/etc/opencode.json"}
+	})
+
+	cmd, err := buildOpencodeCommand("4096")
+	if err != nil {
+		t.Fatalf("buildOpencodeCommand() error = %v", err)
+	}
+	if cmd.Path != "/opt/opencode/bin/opencode" {
+		t.Errorf("path = %q, want the configured opencode_path", cmd.Path)
+	}
+	wantArgs := []string{"/opt/opencode/bin/opencode", "serve", "-p", "4096", "--log-level", "DEBUG"}
+	if !slices.Equal(cmd.Args, wantArgs) {
+		t.Errorf("args = %q, want %q", cmd.Args, wantArgs)
+	}
+	if !slices.Contains(cmd.Env, "OPENCODE_CONFIG=/etc/opencode.json") {
+		t.Errorf("env is missing the configured variable")
+	}
+	if len(cmd.Env) <= len(AppConfig.OpencodeEnv) {
+		t.Errorf("env has %d entries, want the inherited environment as well", len(cmd.Env))
+	}
+
+	// Without extra env the child simply inherits the bot's environment
+	AppConfig.OpencodeEnv = nil
+	if cmd, err := buildOpencodeCommand("4096"); err != nil || cmd.Env != nil {
+		t.Errorf("buildOpencodeCommand() without env = %v, %v, want inherited environment", cmd.Env, err)
+	}
+
+	for _, arg := range []string{"-p", "--port", "--port=5000", "-p=5000"} {
+		AppConfig.OpencodeArgs = []string{arg}
+		if _, err := buildOpencodeCommand("4096"); err == nil {
+			t.Errorf("buildOpencodeCommand() accepted port override %q", arg)
+		}
+	}
+}
+
+func TestPortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, portText, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portText)
+
+	if inUse, err := portInUse(port); err != nil || !inUse {
+		t.Errorf("portInUse() on a listening port = %v, %v, want true", inUse, err)
+	}
+	message := portInUseError(port).Error()
+	if !strings.Contains(message, fmt.Sprintf("opencode_port %d is already in use", port)) || !strings.Contains(message, "reuse_existing_server") {
+		t.Errorf("portInUseError() = %q, want the port and how to resolve it", message)
+	}
+	// When lsof can tell, the holder named is this test process
+	if strings.Contains(message, "in use by PID") && !strings.Contains(message, strconv.Itoa(os.Getpid())) {
+		t.Errorf("portInUseError() = %q, want it to name PID %d", message, os.Getpid())
+	}
+
+	listener.Close()
+	if inUse, err := portInUse(port); err != nil || inUse {
+		t.Errorf("portInUse() on a released port = %v, %v, want false", inUse, err)
+	}
+}
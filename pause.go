@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	errNoRunningTurn  = errors.New("no task is running in this session")
+	errAlreadyPaused  = errors.New("live updates are already paused")
+	errUpdatesRunning = errors.New("live updates are not paused")
+)
+
+// Serializes catching up, so /unpause and a turn ending while paused never replay the same parts twice
+var catchUpMutex sync.Mutex
+
+// pauseUpdates stops posting the turn's parts to the thread while the turn keeps running on the
+// OpenCode server. The listener keeps following the session, so the turn's end is still noticed and
+// prompts sent in the meantime are queued as usual.
+func pauseUpdates(threadID string) error {
+	sessionMutex.Lock()
+	sessionData, exists := sessionCache[threadID]
+	switch {
+	case !exists || !sessionData.IsStreaming:
+		sessionMutex.Unlock()
+		return errNoRunningTurn
+	case !sessionData.UpdatesPausedAt.IsZero():
+		sessionMutex.Unlock()
+		return errAlreadyPaused
+	}
+	sessionData.UpdatesPausedAt = time.Now()
+	sessionMutex.Unlock()
+
+	flushStatus(threadID)
+	slog.Info("paused live updates", "thread_id", threadID)
+	return nil
+}
+
+// updatesPaused reports whether /pause holds back a thread's live updates
+func updatesPaused(threadID string) bool {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	sessionData, exists := sessionCache[threadID]
+	return exists && !sessionData.UpdatesPausedAt.IsZero()
+}
+
+// resumeUpdates catches up on the parts that finished while updates were paused and switches the
+// listener back to posting live updates. It runs on /unpause and when the turn ends while paused;
+// the turn itself is only finished by the listener's idle event.
+func resumeUpdates(threadID string) error {
+	catchUpMutex.Lock()
+	defer catchUpMutex.Unlock()
+
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	var pausedAt time.Time
+	if exists {
+		pausedAt = sessionData.UpdatesPausedAt
+	}
+	sessionMutex.RUnlock()
+	if !exists || pausedAt.IsZero() {
+		return errUpdatesRunning
+	}
+
+	messages, err := fetchTranscript(sessionData)
+	if err != nil {
+		return err
+	}
+
+	sessionMutex.Lock()
+	sessionData.UpdatesPausedAt = time.Time{}
+	sessionData.LastEventAt = time.Now()
+	sessionMutex.Unlock()
+
+	// Replay what finished after the pause; earlier parts were already shown
+	replayed := 0
+	for _, message := range messages {
+		if message.Role != "assistant" || (!message.Completed.IsZero() && message.Completed.Before(pausedAt)) {
+			continue
+		}
+		for idx := range message.Parts {
+			part := &message.Parts[idx]
+			if partEndedBefore(part, pausedAt) {
+				continue
+			}
+			handleMessagePart(threadID, part)
+			replayed++
+		}
+	}
+	slog.Info("resumed live updates", "thread_id", threadID, "replayed_parts", replayed)
+	return nil
+}
+
+// clearUpdatesPause drops a pause without catching up, for a turn that ended when its transcript
+// could not be fetched
+func clearUpdatesPause(threadID string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		sessionData.UpdatesPausedAt = time.Time{}
+	}
+}
+
+// partEndedBefore reports whether a part finished before t; parts still in progress never have
+func partEndedBefore(part *MessagePart, t time.Time) bool {
+	timeRange := part.Time
+	if part.Type == PartTypeTool && part.State != nil {
+		timeRange = part.State.Time
+	}
+	if timeRange == nil || timeRange.End == nil {
+		return false
+	}
+	return time.UnixMilli(*timeRange.End).Before(t)
+}
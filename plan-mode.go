@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Plan-first sessions draft a plan with file-modifying tools disabled and only execute it after /approve
+const (
+	planStatusPending  = "pending"  // A plan was posted and awaits /approve or /reject
+	planStatusApproved = "approved" // The plan was approved; prompts run with tools enabled
+)
+
+// Instructions wrapped around prompts of a plan-first session
+const (
+	planDraftInstruction    = "Do not change any files yet. Write a concise, numbered plan for the request below: which files you would change and how, and any questions you need answered first. The plan will be reviewed before you execute it.\n\nRequest:\n"
+	planRevisionInstruction = "Do not change any files yet. Revise your plan based on the feedback below and post the complete updated plan.\n\nFeedback:\n"
+	planExecuteInstruction  = "Your plan is approved. Carry it out now for the original request:\n\n"
+)
+
+// Posted when a plan-drafting turn ends
+const planReadyMessage = "📝 Plan ready. Use `/approve` to execute it or `/reject` to discard it, or reply with feedback to revise it."
+
+// isPlanning reports whether a session's prompts still draft a plan. Callers hold sessionMutex.
+func isPlanning(sessionData *SessionData) bool {
+	return sessionData.PlanFirst && sessionData.PlanStatus != planStatusApproved
+}
+
+// planPrompt wraps a prompt of a session that is drafting a plan and remembers the request the plan
+// is for; while a plan awaits approval, prompts are feedback on it. Other prompts are returned unchanged.
+// Callers hold sessionMutex for writing.
+func planPrompt(sessionData *SessionData, content string) string {
+	if !isPlanning(sessionData) {
+		return content
+	}
+	if sessionData.PlanStatus == planStatusPending {
+		return planRevisionInstruction + content
+	}
+	sessionData.PlanRequest = content
+	return planDraftInstruction + content
+}
+
+// markPlanReady moves a session whose turn drafted a plan to awaiting approval and tells the thread
+func markPlanReady(threadID string) {
+	sessionMutex.Lock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists || !isPlanning(sessionData) || sessionData.PlanRequest == "" {
+		sessionMutex.Unlock()
+		return
+	}
+	sessionData.PlanStatus = planStatusPending
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after plan", "thread_id", threadID, "error", err)
+	}
+	sendToDiscord(threadID, planReadyMessage)
+}
+
+// resolvePendingPlan checks that the caller may decide on the session's pending plan and returns
+// the request the plan was drafted for; the refusal is non-empty when there is nothing to decide
+func resolvePendingPlan(i *discordgo.InteractionCreate, session *SessionData, status string) (string, string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	if !session.PlanFirst {
+		return "", "This session was not started with `plan`."
+	}
+	if session.UserID != "" && session.UserID != interactionUserID(i) && !isAdmin(i) {
+		return "", "Only the session owner or an admin can approve or reject the plan."
+	}
+	if session.PlanStatus != planStatusPending {
+		return "", "There is no plan waiting for approval."
+	}
+	if session.IsStreaming {
+		return "", "The model is still revising the plan. Wait for it to finish."
+	}
+	request := session.PlanRequest
+	session.PlanStatus = status
+	session.PlanRequest = ""
+	return request, ""
+}
+
+// handleApproveCommand executes the pending plan of a plan-first session with tools enabled
+func handleApproveCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting approve command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer approve interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	request, refusal := resolvePendingPlan(i, session, planStatusApproved)
+	if refusal != "" {
+		respondOrFallback(s, i, refusal)
+		return
+	}
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data after plan approval", "thread_id", threadID, "error", err)
+	}
+
+	userID := interactionUserID(i)
+	slog.Info("plan approved", "thread_id", threadID, "user_id", userID)
+	respondOrFallback(s, i, fmt.Sprintf("✅ Plan approved by <@%s>, executing it now.", userID))
+	sendPrompt(s, session, userID, planExecuteInstruction+request, nil)
+}
+
+// handleRejectCommand discards the pending plan so the next prompt drafts a new one
+func handleRejectCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting reject command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer reject interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+
+	if _, refusal := resolvePendingPlan(i, session, ""); refusal != "" {
+		respondOrFallback(s, i, refusal)
+		return
+	}
+	if err := saveSessionData(session); err != nil {
+		slog.Error("failed to save session data after plan rejection", "thread_id", threadID, "error", err)
+	}
+
+	slog.Info("plan rejected", "thread_id", threadID, "user_id", interactionUserID(i))
+	respondOrFallback(s, i, "🗑️ Plan discarded. Send a new request to draft another plan.")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Minimum time between presence updates; Discord rate limits gateway status changes
+const presenceMinInterval = 20 * time.Second
+
+// Buffered so a refresh requested while an update is pending is coalesced into it
+var presenceRefresh = make(chan struct{}, 1)
+
+// presenceText describes how many sessions currently have a task running
+func presenceText(working int) string {
+	switch working {
+	case 0:
+		return "for new sessions"
+	case 1:
+		return "1 session"
+	default:
+		return fmt.Sprintf("%d sessions", working)
+	}
+}
+
+// workingSessionCount returns the number of sessions with a running turn
+func workingSessionCount() int {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	count := 0
+	for _, sessionData := range sessionCache {
+		if sessionData.IsStreaming {
+			count++
+		}
+	}
+	return count
+}
+
+// refreshPresence asks for the bot presence to be updated; it never blocks
+func refreshPresence() {
+	if !AppConfig.ShowPresence {
+		return
+	}
+	select {
+	case presenceRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// runPresenceUpdater keeps the bot's "Watching ..." activity in line with the number of working
+// sessions, updating at most once per presenceMinInterval and only when the text changes
+func runPresenceUpdater(ctx context.Context, s *discordgo.Session) {
+	if !AppConfig.ShowPresence {
+		return
+	}
+	refreshPresence()
+
+	var current string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-presenceRefresh:
+		}
+
+		if text := presenceText(workingSessionCount()); text != current {
+			err := s.UpdateStatusComplex(discordgo.UpdateStatusData{
+				Status: string(discordgo.StatusOnline),
+				Activities: []*discordgo.Activity{{
+					Name: text,
+					Type: discordgo.ActivityTypeWatching,
+				}},
+			})
+			if err != nil {
+				slog.Error("failed to update presence", "error", err)
+			} else {
+				current = text
+				slog.Debug("updated presence", "text", text)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(presenceMinInterval):
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// coalescedPrompt collects one user's messages in a thread until the coalesce window passes quietly
+type coalescedPrompt struct {
+	sessionData *SessionData
+	authorID    string
+	contents    []string
+	images      []promptImage
+	timer       *time.Timer
+}
+
+// Prompts being collected, keyed by thread and author
+var coalescedPrompts = make(map[string]*coalescedPrompt)
+var coalescedPromptsMutex sync.Mutex
+
+// coalescePrompt holds a message for message_coalesce_window and merges it with further messages from
+// the same user in the same thread, so a paste Discord split into several messages becomes one prompt.
+// Every new message restarts the window. Without a window the prompt is dispatched right away.
+func coalescePrompt(s *discordgo.Session, sessionData *SessionData, authorID, content string, images []promptImage) {
+	window := AppConfig.MessageCoalesceWindow
+	if window <= 0 {
+		dispatchPrompt(s, sessionData, authorID, content, images)
+		return
+	}
+
+	key := sessionData.ThreadID + "/" + authorID
+	coalescedPromptsMutex.Lock()
+	defer coalescedPromptsMutex.Unlock()
+
+	pending, exists := coalescedPrompts[key]
+	if !exists {
+		pending = &coalescedPrompt{sessionData: sessionData, authorID: authorID}
+		coalescedPrompts[key] = pending
+		pending.timer = time.AfterFunc(window, func() {
+			flushCoalescedPrompt(s, key)
+		})
+	} else {
+		pending.timer.Reset(window)
+		slog.Debug("coalescing message into pending prompt", "thread_id", sessionData.ThreadID, "author_id", authorID, "messages", len(pending.contents)+1)
+	}
+	if content != "" {
+		pending.contents = append(pending.contents, content)
+	}
+	pending.images = append(pending.images, images...)
+}
+
+// flushCoalescedPrompt dispatches the messages collected under key as a single prompt
+func flushCoalescedPrompt(s *discordgo.Session, key string) {
+	coalescedPromptsMutex.Lock()
+	pending, exists := coalescedPrompts[key]
+	delete(coalescedPrompts, key)
+	coalescedPromptsMutex.Unlock()
+	if !exists {
+		return
+	}
+
+	dispatchPrompt(s, pending.sessionData, pending.authorID, strings.Join(pending.contents, "\n"), pending.images)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sst/opencode-sdk-go"
+)
+
+// How long after it was sent an edited prompt message is still re-run
+const rerunEditWindow = 2 * time.Minute
+
+// editablePrompt is the latest prompt message of a thread, kept for rerun_on_edit
+type editablePrompt struct {
+	messageID string
+	authorID  string
+	content   string
+	images    []promptImage
+	sentAt    time.Time
+	turn      uint64 // PromptTurn that runs this prompt; 0 while it is coalesced, queued or held
+}
+
+// Latest prompt message per thread, guarded by editablePromptsMutex
+var editablePrompts = make(map[string]*editablePrompt)
+var editablePromptsMutex sync.Mutex
+
+// trackEditablePrompt remembers a thread's latest prompt message so an edit to it can re-run it.
+// The prompt is tied to a turn only once bindEditablePromptTurn sees it start.
+func trackEditablePrompt(threadID, messageID, authorID, content string, images []promptImage) {
+	if !AppConfig.RerunOnEdit {
+		return
+	}
+	editablePromptsMutex.Lock()
+	defer editablePromptsMutex.Unlock()
+	editablePrompts[threadID] = &editablePrompt{
+		messageID: messageID,
+		authorID:  authorID,
+		content:   content,
+		images:    images,
+		sentAt:    time.Now(),
+	}
+}
+
+// bindEditablePromptTurn ties the tracked prompt to the turn sendPrompt started for it. Content that
+// differs from the tracked message, e.g. several coalesced messages, leaves it unbound.
+func bindEditablePromptTurn(threadID, authorID, content string, turn uint64) {
+	if !AppConfig.RerunOnEdit {
+		return
+	}
+	editablePromptsMutex.Lock()
+	defer editablePromptsMutex.Unlock()
+	if tracked, exists := editablePrompts[threadID]; exists && tracked.turn == 0 && tracked.authorID == authorID && tracked.content == content {
+		tracked.turn = turn
+	}
+}
+
+// editedPrompt returns the tracked prompt an edit applies to and its new content. ok is false when
+// the edit is to another message, came too late, or did not change the text (e.g. an embed unfurl).
+func editedPrompt(threadID, messageID, content string, now time.Time) (prompt editablePrompt, ok bool) {
+	editablePromptsMutex.Lock()
+	defer editablePromptsMutex.Unlock()
+
+	tracked, exists := editablePrompts[threadID]
+	if !exists || tracked.messageID != messageID {
+		return editablePrompt{}, false
+	}
+	if now.Sub(tracked.sentAt) > rerunEditWindow || content == "" || content == tracked.content {
+		return editablePrompt{}, false
+	}
+	tracked.content = content
+	prompt = *tracked
+	// The re-run starts a new turn, which binds the prompt again
+	tracked.turn = 0
+	return prompt, true
+}
+
+// turnHasOutput reports whether the running turn already showed something in the thread. The caller
+// must hold sessionMutex.
+func turnHasOutput(sessionData *SessionData) bool {
+	return sessionData.TurnHadText || sessionData.CurrentResponse != "" || sessionData.ToolStatusHistory != ""
+}
+
+// MessageEditHandler re-runs a prompt whose message was edited shortly after it was sent, as long
+// as the model has not produced any output for it yet (rerun_on_edit)
+func MessageEditHandler(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if !AppConfig.RerunOnEdit || m.Message == nil {
+		return
+	}
+	if m.Author != nil && m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	threadID := m.ChannelID
+	prompt, ok := editedPrompt(threadID, m.ID, stripBotMention(s, m.Content), time.Now())
+	if !ok {
+		return
+	}
+	if m.Author != nil && m.Author.ID != prompt.authorID {
+		return
+	}
+
+	sessionData := lazyLoadSession(threadID)
+	if sessionData == nil {
+		return
+	}
+
+	// Only the running turn of this very prompt can be re-run; a held, queued or finished one is left alone
+	sessionMutex.Lock()
+	if prompt.turn == 0 || prompt.turn != sessionData.PromptTurn || !sessionData.IsStreaming || turnHasOutput(sessionData) || !sessionData.UpdatesPausedAt.IsZero() || len(queuedPrompts(threadID)) > 0 {
+		sessionMutex.Unlock()
+		slog.Debug("prompt edited too late to re-run", "thread_id", threadID, "message_id", m.ID)
+		return
+	}
+	// Bump the turn first, so the aborted prompt's error is not reported
+	sessionData.PromptTurn++
+	sessionID := sessionData.SessionID
+	worktreePath := sessionData.WorktreePath
+	sessionMutex.Unlock()
+
+	if err := abortSession(sessionID, worktreePath); err != nil {
+		slog.Error("failed to abort prompt for re-run", "thread_id", threadID, "session_id", sessionID, "error", err)
+		s.ChannelMessageSend(threadID, "Failed to cancel the running prompt, so your edit was not applied. Send it as a new message instead.")
+		return
+	}
+
+	// End the aborted turn like the watchdog does, without waiting for its idle event
+	stopActiveListener(threadID)
+	sessionMutex.Lock()
+	sessionData.IsStreaming = false
+	sessionMutex.Unlock()
+	finalizeStatusMessage(threadID, statusOutcomeInterrupted)
+	refreshPresence()
+
+	slog.Info("re-running edited prompt", "thread_id", threadID, "message_id", m.ID)
+	s.ChannelMessageSend(threadID, "✏️ Prompt edited, re-running it with the new text.")
+	dispatchPrompt(s, sessionData, prompt.authorID, prompt.content, prompt.images)
+}
+
+// abortSession stops the prompt an OpenCode session is working on
+func abortSession(sessionID, worktreePath string) error {
+	client := Opencode()
+	if client == nil {
+		return errOpencodeUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := client.Session.Abort(ctx, sessionID, opencode.SessionAbortParams{
+		Directory: opencode.F(worktreePath),
+	})
+	return err
+}
+
+// stripBotMention removes the bot's mentions from a message and trims it
+func stripBotMention(s *discordgo.Session, content string) string {
+	botID := s.State.User.ID
+	content = strings.ReplaceAll(content, fmt.Sprintf("<@%s>", botID), "")
+	content = strings.ReplaceAll(content, fmt.Sprintf("<@!%s>", botID), "")
+	return strings.TrimSpace(content)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Prompts beyond this many per thread are rejected instead of queued
+const maxQueuedPrompts = 10
+
+// queuedPrompt is a prompt received while the session was still working on a turn
+type queuedPrompt struct {
+	AuthorID string
+	Content  string
+	Images   []promptImage
+	QueuedAt time.Time
+}
+
+// Pending prompts per thread, sent one at a time as the session goes idle
+var promptQueues = make(map[string][]queuedPrompt)
+var promptQueuesMutex sync.Mutex
+
+// enqueuePrompt appends a prompt to the thread's queue and returns its 1-based position,
+// or false when the queue is full
+func enqueuePrompt(threadID string, prompt queuedPrompt) (int, bool) {
+	promptQueuesMutex.Lock()
+	defer promptQueuesMutex.Unlock()
+
+	if len(promptQueues[threadID]) >= maxQueuedPrompts {
+		return 0, false
+	}
+	promptQueues[threadID] = append(promptQueues[threadID], prompt)
+	return len(promptQueues[threadID]), true
+}
+
+// dequeuePrompt removes and returns the oldest queued prompt of a thread
+func dequeuePrompt(threadID string) (queuedPrompt, bool) {
+	promptQueuesMutex.Lock()
+	defer promptQueuesMutex.Unlock()
+
+	queue := promptQueues[threadID]
+	if len(queue) == 0 {
+		return queuedPrompt{}, false
+	}
+	if len(queue) == 1 {
+		delete(promptQueues, threadID)
+	} else {
+		promptQueues[threadID] = queue[1:]
+	}
+	return queue[0], true
+}
+
+// queuedPrompts returns a copy of the thread's queue, oldest first
+func queuedPrompts(threadID string) []queuedPrompt {
+	promptQueuesMutex.Lock()
+	defer promptQueuesMutex.Unlock()
+
+	return append([]queuedPrompt(nil), promptQueues[threadID]...)
+}
+
+// clearPromptQueue drops every queued prompt of a thread and returns how many were dropped
+func clearPromptQueue(threadID string) int {
+	promptQueuesMutex.Lock()
+	defer promptQueuesMutex.Unlock()
+
+	dropped := len(promptQueues[threadID])
+	delete(promptQueues, threadID)
+	return dropped
+}
+
+// dispatchQueuedPrompt sends the oldest queued prompt of a thread after its session went idle
+func dispatchQueuedPrompt(threadID string) {
+	prompt, ok := dequeuePrompt(threadID)
+	if !ok {
+		return
+	}
+
+	sessionData := lazyLoadSession(threadID)
+	if sessionData == nil {
+		slog.Warn("session gone, dropping queued prompts", "thread_id", threadID, "dropped", clearPromptQueue(threadID)+1)
+		return
+	}
+
+	slog.Debug("sending queued prompt", "thread_id", threadID, "author_id", prompt.AuthorID, "queued_for", time.Since(prompt.QueuedAt))
+	sendPrompt(discord, sessionData, prompt.AuthorID, prompt.Content, prompt.Images)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Caps on the repository documentation injected into a session's first prompt
+const (
+	maxContextFileBytes  = 16 << 10 // per file
+	maxContextTotalBytes = 48 << 10 // across all files
+)
+
+// First line of the context preamble sent with a session's first prompt
+const repositoryContextHeader = "Follow the conventions in these repository documents for all work in this session:"
+
+// Appended to every prompt to keep file operations inside the session's worktree
+const worktreeBoundaryInstruction = "Important: Stay within the current worktree directory for all file operations."
+
+// worktreeBoundary returns the per-prompt instruction scoping file access. Extra context directories
+// are listed as readable, while all changes must still be made in the worktree. Directories that
+// are not absolute paths to an existing directory are left out.
+func worktreeBoundary(extraDirs []string) string {
+	var readable []string
+	for _, dir := range extraDirs {
+		if !filepath.IsAbs(dir) {
+			slog.Warn("ignoring extra context directory that is not an absolute path", "dir", dir)
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			slog.Warn("ignoring missing extra context directory", "dir", dir, "error", err)
+			continue
+		}
+		readable = append(readable, "- "+filepath.Clean(dir))
+	}
+	if len(readable) == 0 {
+		return worktreeBoundaryInstruction
+	}
+	return "Important: Make all file changes within the current worktree directory. " +
+		"These additional directories are available as read-only reference; read them when useful but never create, edit or delete files in them:\n" +
+		strings.Join(readable, "\n")
+}
+
+// capContextTokens cuts injected context down to about budget tokens, ending at a line break and
+// noting the truncation for the model. A budget of 0 or less leaves the text unchanged.
+func capContextTokens(text string, budget int) string {
+	if budget <= 0 || estimateTokens(text) <= budget {
+		return text
+	}
+	runes := []rune(text)
+	kept := string(runes[:min(len(runes), budget*charsPerToken)])
+	if cut := strings.LastIndexByte(kept, '\n'); cut > 0 {
+		kept = kept[:cut]
+	}
+	slog.Debug("truncated injected context to token budget", "budget", budget, "estimated_tokens", estimateTokens(text))
+	return fmt.Sprintf("%s\n(context truncated to about %d tokens)\n", kept, budget)
+}
+
+// loadRepositoryContext reads the configured context files from a worktree and formats them
+// as a preamble for the first prompt. Missing or unreadable files are skipped.
+func loadRepositoryContext(worktreePath string, files []string) string {
+	var preamble strings.Builder
+	total := 0
+	for _, file := range files {
+		if !filepath.IsLocal(file) {
+			slog.Warn("ignoring context file outside the repository", "file", file)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(worktreePath, file))
+		if err != nil {
+			slog.Debug("skipping context file", "file", file, "error", err)
+			continue
+		}
+
+		content := string(data)
+		truncated := false
+		if len(content) > maxContextFileBytes {
+			content = truncateText(content, maxContextFileBytes, "")
+			truncated = true
+		}
+		if total+len(content) > maxContextTotalBytes {
+			slog.Warn("context files exceed the size limit, skipping the rest", "file", file, "limit", maxContextTotalBytes)
+			break
+		}
+		total += len(content)
+
+		fmt.Fprintf(&preamble, "<file path=%q>\n%s\n</file>\n", file, strings.TrimSpace(content))
+		if truncated {
+			fmt.Fprintf(&preamble, "(%s was truncated to %d bytes)\n", file, maxContextFileBytes)
+		}
+	}
+	if preamble.Len() == 0 {
+		return ""
+	}
+	return repositoryContextHeader + "\n" + preamble.String()
+}
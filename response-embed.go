@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's embed limits besides the description
+const (
+	maxEmbedTotal      = 6000
+	maxEmbedFields     = 25
+	maxEmbedFieldName  = 256
+	maxEmbedFieldValue = 1024
+)
+
+// Zero-width space standing in for empty field names and values, which Discord rejects
+const emptyEmbedText = "\u200b"
+
+// markdownHeadingPattern matches a markdown heading line and captures its text
+var markdownHeadingPattern = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+
+// responseSection is the text under one markdown heading of a response
+type responseSection struct {
+	heading string
+	body    string
+}
+
+// splitResponseSections splits a response on its markdown headings, ignoring heading-like lines
+// inside code blocks. It returns the text before the first heading, the sections and whether the
+// response contains a code block.
+func splitResponseSections(response string) (string, []responseSection, bool) {
+	var preamble []string
+	var sections []responseSection
+	var body []string
+	inCode, hasCode := false, false
+
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(body, "\n"))
+		if len(sections) == 0 {
+			preamble = body
+		} else {
+			sections[len(sections)-1].body = text
+		}
+		body = nil
+	}
+
+	for _, line := range strings.Split(response, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			hasCode = true
+		}
+		if !inCode {
+			if match := markdownHeadingPattern.FindStringSubmatch(line); match != nil {
+				flush()
+				sections = append(sections, responseSection{heading: strings.TrimSpace(match[1])})
+				continue
+			}
+		}
+		body = append(body, line)
+	}
+	flush()
+	return strings.TrimSpace(strings.Join(preamble, "\n")), sections, hasCode
+}
+
+// buildResponseEmbed renders a structured response, one with markdown headings or code blocks, as
+// an embed: the text before the first heading becomes the description and each heading a field.
+// It returns nil when the response has no structure or does not fit Discord's embed limits, so the
+// caller falls back to plain messages.
+func buildResponseEmbed(response string) *discordgo.MessageEmbed {
+	preamble, sections, hasCode := splitResponseSections(response)
+	if len(sections) == 0 && !hasCode {
+		return nil
+	}
+	if len(preamble) > maxEmbedDescription {
+		return nil
+	}
+
+	embed := &discordgo.MessageEmbed{Description: preamble}
+	total := len(preamble)
+	for _, section := range sections {
+		if len(section.heading) > maxEmbedFieldName {
+			return nil
+		}
+		values := []string{emptyEmbedText}
+		if section.body != "" {
+			// Splitting a code block across fields would break its fences
+			if len(section.body) > maxEmbedFieldValue && strings.Contains(section.body, "```") {
+				return nil
+			}
+			values = splitMessage(section.body, maxEmbedFieldValue)
+		}
+		for idx, value := range values {
+			name := section.heading
+			if idx > 0 {
+				name = emptyEmbedText
+			}
+			if strings.TrimSpace(value) == "" {
+				value = emptyEmbedText
+			}
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: name, Value: value})
+			total += len(name) + len(value)
+		}
+	}
+	if len(embed.Fields) > maxEmbedFields || total > maxEmbedTotal {
+		return nil
+	}
+	return embed
+}
+
+// sendModelResponse posts a model response as a structured embed when response_as_embed is set
+// and the response fits one, otherwise as plain message chunks
+func sendModelResponse(threadID, response string) {
+	if AppConfig.ResponseAsEmbed && discord != nil {
+		if embed := buildResponseEmbed(response); embed != nil {
+			_, err := discord.ChannelMessageSendEmbed(threadID, embed)
+			if err == nil {
+				slog.Debug("sent response embed to discord", "thread_id", threadID, "fields", len(embed.Fields))
+				return
+			}
+			slog.Warn("failed to send response embed, sending plain messages", "thread_id", threadID, "error", err)
+		}
+	}
+	SendDiscordMessage(threadID, response)
+}
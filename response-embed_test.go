@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildResponseEmbed(t *testing.T) {
+	type field struct{ name, value string }
+	tests := []struct {
+		name            string
+		response        string
+		wantNil         bool
+		wantDescription string
+		wantFields      []field
+	}{
+		{name: "plain text", response: "Just a short answer.", wantNil: true},
+		{
+			name:            "headings",
+			response:        "Intro\n\n## Changes\n- one\n- two\n## Tests\nall pass",
+			wantDescription: "Intro",
+			wantFields:      []field{{"Changes", "- one\n- two"}, {"Tests", "all pass"}},
+		},
+		{
+			name:       "empty section",
+			response:   "# Summary\n# Details\ntext",
+			wantFields: []field{{"Summary", emptyEmbedText}, {"Details", "text"}},
+		},
+		{
+			name:            "heading inside code block",
+			response:        "Example:\n```\n# not a heading\n```",
+			wantDescription: "Example:\n```\n# not a heading\n```",
+		},
+		{
+			name:       "long section continues in unnamed fields",
+			response:   "## Log\n" + strings.Repeat("a", maxEmbedFieldValue) + "\n" + "tail",
+			wantFields: []field{{"Log", strings.Repeat("a", maxEmbedFieldValue)}, {emptyEmbedText, "tail"}},
+		},
+		{name: "long code block", response: "## Code\n```\n" + strings.Repeat("x\n", maxEmbedFieldValue) + "```", wantNil: true},
+		{name: "long heading", response: "## " + strings.Repeat("h", maxEmbedFieldName+1) + "\nbody", wantNil: true},
+		{name: "too many fields", response: strings.Repeat("## h\nbody\n", maxEmbedFields+1), wantNil: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embed := buildResponseEmbed(tt.response)
+			if tt.wantNil {
+				if embed != nil {
+					t.Fatalf("buildResponseEmbed() = %+v, want nil", embed)
+				}
+				return
+			}
+			if embed == nil {
+				t.Fatal("buildResponseEmbed() = nil, want an embed")
+			}
+			if embed.Description != tt.wantDescription {
+				t.Errorf("description = %q, want %q", embed.Description, tt.wantDescription)
+			}
+			if len(embed.Fields) != len(tt.wantFields) {
+				t.Fatalf("got %d fields, want %d", len(embed.Fields), len(tt.wantFields))
+			}
+			for idx, want := range tt.wantFields {
+				if got := embed.Fields[idx]; got.Name != want.name || got.Value != want.value {
+					t.Errorf("field %d = (%q, %q), want (%q, %q)", idx, got.Name, got.Value, want.name, want.value)
+				}
+			}
+		})
+	}
+}
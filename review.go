@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reviewSection is one diff of the /review output
+type reviewSection struct {
+	Title    string
+	Filename string // Attachment name when the diff is too long for messages
+	Diff     string // Empty when the section has no changes
+	Err      error
+}
+
+// buildReviewSections computes the staged, unstaged and committed-vs-base diffs of a worktree.
+// A failing section carries its error so the others are still shown.
+func buildReviewSections(worktreePath, baseBranch string, baseErr error) []reviewSection {
+	staged := reviewSection{Title: "Staged changes", Filename: "staged.diff"}
+	staged.Diff, staged.Err = gitOps.GetStagedDiff(worktreePath)
+	if staged.Diff == noStagedChangesDiff {
+		staged.Diff = ""
+	}
+
+	unstaged := reviewSection{Title: "Unstaged changes", Filename: "unstaged.diff"}
+	unstaged.Diff, unstaged.Err = gitOps.GetDiff(worktreePath)
+	if unstaged.Diff == noChangesDiff {
+		unstaged.Diff = ""
+	}
+
+	committed := reviewSection{Title: "Committed changes", Filename: "committed.diff", Err: baseErr}
+	if baseErr == nil {
+		committed.Title = fmt.Sprintf("Committed changes over %s", baseBranch)
+		committed.Diff, committed.Err = gitOps.GetBranchDiff(worktreePath, baseBranch)
+		if committed.Diff == noBranchChangesDiff(baseBranch) {
+			committed.Diff = ""
+		}
+	}
+
+	return []reviewSection{staged, unstaged, committed}
+}
+
+// handleReviewCommand posts the staged, unstaged and committed-vs-base diffs of the session in one go
+func handleReviewCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	slog.Debug("starting review command", "thread_id", threadID)
+
+	if err := deferInteraction(s, i, false); err != nil {
+		slog.Error("failed to defer review interaction", "thread_id", threadID, "error", err)
+		return
+	}
+
+	session := loadThreadSession(s, i)
+	if session == nil {
+		return
+	}
+	if !requireWorktree(s, i, session) {
+		return
+	}
+
+	baseBranch, baseErr := sessionBaseBranch(session)
+	sections := buildReviewSections(session.WorktreePath, baseBranch, baseErr)
+
+	respondOrFallback(s, i, "Review of this session's changes:")
+	for _, section := range sections {
+		switch {
+		case section.Err != nil:
+			slog.Error("failed to compute review section", "thread_id", threadID, "section", section.Title, "error", section.Err)
+			sendToDiscord(threadID, fmt.Sprintf("**%s**\nFailed to compute this diff.", section.Title))
+		case section.Diff == "":
+			sendToDiscord(threadID, fmt.Sprintf("**%s**\nNo changes.", section.Title))
+		default:
+			sendToDiscord(threadID, fmt.Sprintf("**%s**", section.Title))
+			SendDiscordDiff(threadID, section.Filename, section.Diff)
+		}
+	}
+
+	slog.Debug("review command completed successfully", "thread_id", threadID)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the temporary directories that back scratch sessions
+const scratchDirPrefix = "codesession-scratch-"
+
+// Answer to git commands run in a scratch session
+const scratchSessionMessage = "This is a scratch session without a git repository, so git commands are not available. Start a `/codesession` to work on a repository."
+
+// createScratchDir creates the throwaway working directory of a scratch session
+func createScratchDir(threadID string) (string, error) {
+	dir, err := os.MkdirTemp("", scratchDirPrefix+threadID+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	return dir, nil
+}
+
+// ensureScratchDir recreates a scratch session's directory when the system cleaned up its temp dir
+func ensureScratchDir(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+// isScratchDir reports whether path names a scratch session directory
+func isScratchDir(path string) bool {
+	return strings.HasPrefix(filepath.Base(filepath.Clean(path)), scratchDirPrefix)
+}
+
+// removeScratchDir deletes a scratch session's temporary directory. Anything not named like a scratch
+// directory is refused, so a corrupted session file cannot make it delete an arbitrary tree.
+func removeScratchDir(path string) error {
+	if path == "" || !isScratchDir(path) {
+		return fmt.Errorf("refusing to remove %q: not a scratch directory", path)
+	}
+	return os.RemoveAll(path)
+}
+
+// orphanScratchDirs lists the scratch directories in tempDir that no stored session refers to
+func orphanScratchDirs(tempDir string, owned map[string]bool) ([]worktreeUsage, error) {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []worktreeUsage
+	for _, entry := range entries {
+		path := filepath.Join(tempDir, entry.Name())
+		if !entry.IsDir() || !isScratchDir(path) || owned[path] {
+			continue
+		}
+		orphans = append(orphans, worktreeUsage{Name: entry.Name(), Path: path, Size: dirSize(path)})
+	}
+	return orphans, nil
+}
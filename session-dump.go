@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Keys whose values are replaced in /dumpsession output. Matching is by suffix, so fields added
+// later (e.g. "push_token", "accessToken") are covered, while counters such as "total_input_tokens"
+// stay readable.
+var redactedSessionKeys = []string{"token", "secret", "password", "credential", "credentials", "api_key", "apikey"}
+
+// Placeholder for redacted values
+const redactedValue = "[redacted]"
+
+// dumpSessionFile reads a thread's stored session file and returns it as indented JSON with secret fields redacted
+func dumpSessionFile(threadID string) (string, error) {
+	filePath, err := sessionFilePath(threadID)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	var stored any
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return "", fmt.Errorf("session file is not valid JSON: %w", err)
+	}
+	redacted, err := json.MarshalIndent(redactSecrets(stored), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(redacted), nil
+}
+
+// redactSecrets replaces the values of secret-looking keys anywhere in decoded JSON
+func redactSecrets(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, nested := range typed {
+			if isSecretKey(key) {
+				typed[key] = redactedValue
+				continue
+			}
+			typed[key] = redactSecrets(nested)
+		}
+	case []any:
+		for idx, nested := range typed {
+			typed[idx] = redactSecrets(nested)
+		}
+	}
+	return value
+}
+
+// isSecretKey reports whether a JSON key names a secret
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range redactedSessionKeys {
+		if strings.HasSuffix(key, secret) {
+			return true
+		}
+	}
+	return false
+}
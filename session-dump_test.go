@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "token", want: true},
+		{key: "access_token", want: true},
+		{key: "GitHubToken", want: true},
+		{key: "api_key", want: true},
+		{key: "openaiApiKey", want: true},
+		{key: "db_password", want: true},
+		{key: "credentials", want: true},
+		{key: "total_input_tokens", want: false},
+		{key: "token_count", want: false},
+		{key: "secret_santa_enabled", want: false},
+		{key: "thread_id", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := isSecretKey(tt.key); got != tt.want {
+				t.Errorf("isSecretKey(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "flat", input: `{"thread_id":"1","api_key":"sk-1"}`, want: `{"thread_id":"1","api_key":"[redacted]"}`},
+		{name: "nested", input: `{"model":{"auth":{"access_token":"t"}}}`, want: `{"model":{"auth":{"access_token":"[redacted]"}}}`},
+		{name: "array", input: `{"items":[{"password":"p"},{"name":"n"}]}`, want: `{"items":[{"password":"[redacted]"},{"name":"n"}]}`},
+		{name: "token counters kept", input: `{"total_input_tokens":10,"total_output_tokens":5}`, want: `{"total_input_tokens":10,"total_output_tokens":5}`},
+		{name: "whole secret object", input: `{"credentials":{"user":"u"}}`, want: `{"credentials":"[redacted]"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input, want any
+			if err := json.Unmarshal([]byte(tt.input), &input); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if got := redactSecrets(input); !reflect.DeepEqual(got, want) {
+				t.Errorf("redactSecrets(%s) = %v, want %v", tt.input, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Runtime state changes in bursts while a turn streams, so it is written at most once per interval
+const sessionFlushInterval = 5 * time.Second
+
+// Pending checkpoint writes per thread
+var sessionFlushTimers = make(map[string]*time.Timer)
+var sessionFlushMutex sync.Mutex
+
+// scheduleSessionFlush saves a thread's session within sessionFlushInterval. Changes made before the
+// write are included in it, so a streaming turn costs one write per interval instead of one per event.
+func scheduleSessionFlush(threadID string) {
+	sessionFlushMutex.Lock()
+	defer sessionFlushMutex.Unlock()
+
+	if _, pending := sessionFlushTimers[threadID]; pending {
+		return
+	}
+	sessionFlushTimers[threadID] = time.AfterFunc(sessionFlushInterval, func() {
+		flushSession(threadID)
+	})
+}
+
+// flushSession saves a thread's session right away, replacing a scheduled checkpoint
+func flushSession(threadID string) {
+	sessionFlushMutex.Lock()
+	if timer, pending := sessionFlushTimers[threadID]; pending {
+		timer.Stop()
+		delete(sessionFlushTimers, threadID)
+	}
+	sessionFlushMutex.Unlock()
+
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	sessionMutex.RUnlock()
+	if !exists {
+		return
+	}
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to checkpoint session data", "thread_id", threadID, "error", err)
+		return
+	}
+	slog.Debug("checkpointed session data", "thread_id", threadID)
+}
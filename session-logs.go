@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Maximum number of log lines kept per thread; older lines are dropped first
+const sessionLogLimit = 200
+
+var (
+	sessionLogs      = make(map[string][]string)
+	sessionLogsMutex sync.Mutex
+)
+
+// sessionLogHandler forwards records to the wrapped handler and additionally keeps the
+// ones tagged with a thread_id in a per-thread buffer that /logs can dump
+type sessionLogHandler struct {
+	inner    slog.Handler
+	threadID string // thread_id bound via With, if any
+}
+
+func newSessionLogHandler(inner slog.Handler) *sessionLogHandler {
+	return &sessionLogHandler{inner: inner}
+}
+
+func (h *sessionLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sessionLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	threadID := h.threadID
+	var attrs []string
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "thread_id" {
+			threadID = attr.Value.String()
+		} else {
+			attrs = append(attrs, attr.String())
+		}
+		return true
+	})
+	if threadID != "" {
+		line := fmt.Sprintf("%s %s %s", record.Time.Format("15:04:05"), record.Level, record.Message)
+		if len(attrs) > 0 {
+			line += " " + strings.Join(attrs, " ")
+		}
+		captureSessionLog(threadID, line)
+	}
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *sessionLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	threadID := h.threadID
+	for _, attr := range attrs {
+		if attr.Key == "thread_id" {
+			threadID = attr.Value.String()
+		}
+	}
+	return &sessionLogHandler{inner: h.inner.WithAttrs(attrs), threadID: threadID}
+}
+
+func (h *sessionLogHandler) WithGroup(name string) slog.Handler {
+	return &sessionLogHandler{inner: h.inner.WithGroup(name), threadID: h.threadID}
+}
+
+// captureSessionLog appends a line to the thread's log buffer, dropping the oldest beyond the limit
+func captureSessionLog(threadID, line string) {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+
+	lines := append(sessionLogs[threadID], line)
+	if len(lines) > sessionLogLimit {
+		lines = lines[len(lines)-sessionLogLimit:]
+	}
+	sessionLogs[threadID] = lines
+}
+
+// sessionLogLines returns a copy of the captured log lines for a thread
+func sessionLogLines(threadID string) []string {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	return append([]string(nil), sessionLogs[threadID]...)
+}
+
+// clearSessionLogs drops the captured log lines for a thread
+func clearSessionLogs(threadID string) {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	delete(sessionLogs, threadID)
+}
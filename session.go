@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/sst/opencode-sdk-go"
@@ -23,14 +24,13 @@ func lazyLoadSession(threadID string) *SessionData {
 	}
 
 	// Ensure sessions directory exists
-	sessionDir, err := ensureSessionDir()
+	filePath, err := sessionFilePath(threadID)
 	if err != nil {
 		slog.Error("failed to ensure sessions directory", "error", err)
 		return nil
 	}
 	
 	// Try to load from file
-	filePath := filepath.Join(sessionDir, fmt.Sprintf("%s.json", threadID))
 	data, err := os.ReadFile(filePath)
 	slog.Debug("lazy loading session from file", "thread_id", threadID, "file_path", filePath, "error", err)
 	if err != nil {
@@ -62,6 +62,49 @@ func lazyLoadSession(threadID string) *SessionData {
 	return &sessionData
 }
 
+// reloadSession evicts a thread's session from the cache and loads it again from its file, picking up
+// external edits. Sessions with a running turn are refused because their runtime state would be lost.
+// When the file cannot be loaded the cached session is kept.
+func reloadSession(threadID string) (*SessionData, error) {
+	sessionMutex.Lock()
+	previous, cached := sessionCache[threadID]
+	if cached && previous.IsStreaming {
+		sessionMutex.Unlock()
+		return nil, fmt.Errorf("the model is still working in this thread")
+	}
+	delete(sessionCache, threadID)
+	sessionMutex.Unlock()
+
+	reloaded := lazyLoadSession(threadID)
+	if reloaded == nil {
+		if cached {
+			sessionMutex.Lock()
+			if _, exists := sessionCache[threadID]; !exists {
+				sessionCache[threadID] = previous
+			}
+			sessionMutex.Unlock()
+		}
+		return nil, fmt.Errorf("session file is missing or invalid, see the bot logs")
+	}
+
+	if cached {
+		sessionMutex.Lock()
+		reloaded.Active = previous.Active
+		sessionMutex.Unlock()
+	}
+	slog.Info("reloaded session from disk", "thread_id", threadID, "session_id", reloaded.SessionID)
+	return reloaded, nil
+}
+
+// sessionFilePath returns the path of a thread's session file in the .sessions directory
+func sessionFilePath(threadID string) (string, error) {
+	sessionDir, err := ensureSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(sessionDir, fmt.Sprintf("%s.json", threadID)), nil
+}
+
 // save session data to .sessions directory
 func saveSessionData(sessionData *SessionData) error {
 	sessionMutex.Lock()
@@ -72,12 +115,38 @@ func saveSessionData(sessionData *SessionData) error {
 		return err
 	}
 
-	sessionDir, err := ensureSessionDir()
+	filePath, err := sessionFilePath(sessionData.ThreadID)
 	if err != nil {
 		return err
 	}
-	filePath := filepath.Join(sessionDir, fmt.Sprintf("%s.json", sessionData.ThreadID))
-	return os.WriteFile(filePath, data, 0644)
+	return writeFileAtomic(filePath, data, 0644)
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it over path,
+// so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set temp file mode: %w", err)
+	}
+	return os.Rename(tmpPath, path)
 }
 
 // get or create session for thread
@@ -127,10 +196,21 @@ func GetOrCreateSession(threadID, worktreePath, repositoryPath, repositoryName,
 		RepositoryPath: repositoryPath,
 		RepositoryName: repositoryName,
 		CreatedAt:      time.Now(),
-		Commits:        make([]CommitRecord, 0),
+		Commits:        make([]*CommitRecord, 0),
 		UserID:         userID,
 	}
 	sessionMutex.Lock()
+	if existing, exists := sessionCache[threadID]; exists {
+		// Another request for the same thread won the race; keep its session and drop ours
+		sessionMutex.Unlock()
+		slog.Warn("session created concurrently for thread, discarding duplicate", "thread_id", threadID, "session_id", session.ID)
+		if _, err := client.Session.Delete(context.Background(), session.ID, opencode.SessionDeleteParams{
+			Directory: opencode.F(absWorktreePath),
+		}); err != nil {
+			slog.Error("failed to delete duplicate session", "thread_id", threadID, "session_id", session.ID, "error", err)
+		}
+		return existing.Session
+	}
 	sessionCache[threadID] = sessionData
 	sessionMutex.Unlock()
 
@@ -144,18 +224,22 @@ func GetOrCreateSession(threadID, worktreePath, repositoryPath, repositoryName,
 
 // cleanup session (remove from cache and file)
 func CleanupSession(threadID string) error {
-	// Stop any active listener first
+	// Stop any active listener and timers first
 	stopActiveListener(threadID)
+	stopAutoCommitTimer(threadID)
+	stopInactivityTimer(threadID)
+	clearSessionLogs(threadID)
+	clearPromptQueue(threadID)
 
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
 	delete(sessionCache, threadID)
-	sessionDir, err := ensureSessionDir()
+	refreshPresence()
+	filePath, err := sessionFilePath(threadID)
 	if err != nil {
 		return err
 	}
-	filePath := filepath.Join(sessionDir, fmt.Sprintf("%s.json", threadID))
 	// remove only if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return nil
@@ -212,3 +296,81 @@ func IsSessionActiveBySessionID(sessionID string) bool {
 	}
 	return false
 }
+
+// updateCommitRecord sets the status (and hash, when known) of a commit record under the session lock
+func updateCommitRecord(record *CommitRecord, status, hash string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	record.Status = status
+	if hash != "" {
+		record.Hash = hash
+	}
+}
+
+// listStoredSessions returns every known session, preferring the cached copy over the file on disk.
+// Cached sessions are shared, so callers read their fields under sessionMutex.
+func listStoredSessions() ([]*SessionData, error) {
+	sessionDir, err := ensureSessionDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	sessions := make([]*SessionData, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		threadID := strings.TrimSuffix(entry.Name(), ".json")
+		if cached, exists := sessionCache[threadID]; exists {
+			sessions = append(sessions, cached)
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(sessionDir, entry.Name()))
+		if err != nil {
+			slog.Warn("failed to read session file", "file", entry.Name(), "error", err)
+			continue
+		}
+		var sessionData SessionData
+		if err := json.Unmarshal(data, &sessionData); err != nil {
+			slog.Warn("failed to unmarshal session file", "file", entry.Name(), "error", err)
+			continue
+		}
+		sessions = append(sessions, &sessionData)
+	}
+	return sessions, nil
+}
+
+// lastActiveAt returns when the session was last used, falling back to its creation time
+func (s *SessionData) lastActiveAt() time.Time {
+	if s.LastActivity.After(s.CreatedAt) {
+		return s.LastActivity
+	}
+	return s.CreatedAt
+}
+
+// mostRecentSessionForUser picks the most recently active session owned by the user, or nil.
+// The sessions may be cached ones, so their fields are read under the session lock.
+func mostRecentSessionForUser(sessions []*SessionData, userID string) *SessionData {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	var latest *SessionData
+	for _, sessionData := range sessions {
+		if sessionData.UserID != userID {
+			continue
+		}
+		if latest == nil || sessionData.lastActiveAt().After(latest.lastActiveAt()) {
+			latest = sessionData
+		}
+	}
+	return latest
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+)
+
+// useTestDataDirs points the sessions and worktrees directories at a temporary directory
+func useTestDataDirs(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	sessionsDir := filepath.Join(base, ".sessions")
+	worktreesDir := filepath.Join(base, ".worktrees")
+	for _, dir := range []string{sessionsDir, worktreesDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDirsMutex.Lock()
+	previousSessions, previousWorktrees := sessionsDirectory, worktreesDirectory
+	sessionsDirectory, worktreesDirectory = sessionsDir, worktreesDir
+	dataDirsMutex.Unlock()
+	t.Cleanup(func() {
+		dataDirsMutex.Lock()
+		sessionsDirectory, worktreesDirectory = previousSessions, previousWorktrees
+		dataDirsMutex.Unlock()
+	})
+	return base
+}
+
+// useFakeOpencode points the shared OpenCode client at an httptest server for the test's duration
+func useFakeOpencode(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	opencodeOnce.Do(func() {})
+	previous := opencodeClient
+	opencodeClient = opencode.NewClient(option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	t.Cleanup(func() { opencodeClient = previous })
+	return server
+}
+
+// Run with -race: distinct threads starting sessions at the same time must each get their own
+// session, cached and saved exactly once
+func TestGetOrCreateSessionConcurrent(t *testing.T) {
+	var created atomic.Int32
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/session" {
+			http.NotFound(w, r)
+			return
+		}
+		id := created.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"ses_%d","directory":%q,"projectID":"p","title":"t","version":"1","time":{"created":0,"updated":0}}`, id, r.URL.Query().Get("directory"))
+	}))
+	base := useTestDataDirs(t)
+
+	const threads = 16
+	sessionIDs := make([]string, threads)
+	var wg sync.WaitGroup
+	for idx := range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			threadID := fmt.Sprintf("race-thread-%d", idx)
+			session := GetOrCreateSession(threadID, filepath.Join(base, threadID), "/repo", "repo", "user")
+			if session != nil {
+				sessionIDs[idx] = session.ID
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for idx, id := range sessionIDs {
+		threadID := fmt.Sprintf("race-thread-%d", idx)
+		t.Cleanup(func() {
+			sessionMutex.Lock()
+			delete(sessionCache, threadID)
+			sessionMutex.Unlock()
+		})
+		if id == "" {
+			t.Errorf("thread %s got no session", threadID)
+			continue
+		}
+		if seen[id] {
+			t.Errorf("session %s was handed to more than one thread", id)
+		}
+		seen[id] = true
+
+		sessionMutex.RLock()
+		cached := sessionCache[threadID]
+		sessionMutex.RUnlock()
+		if cached == nil || cached.SessionID != id {
+			t.Errorf("thread %s cached %+v, want session %s", threadID, cached, id)
+		}
+		data, err := os.ReadFile(filepath.Join(sessionsDirectory, threadID+".json"))
+		if err != nil {
+			t.Errorf("session file for %s: %v", threadID, err)
+			continue
+		}
+		var stored SessionData
+		if err := json.Unmarshal(data, &stored); err != nil || stored.SessionID != id {
+			t.Errorf("session file for %s holds %q (%v), want %s", threadID, stored.SessionID, err, id)
+		}
+	}
+	if got := created.Load(); got != threads {
+		t.Errorf("created %d sessions on the server, want %d", got, threads)
+	}
+}
+
+// Run with -race: commits recorded and updated concurrently, with saves in between, must each keep
+// their own status and hash
+func TestUpdateCommitRecordConcurrent(t *testing.T) {
+	useTestDataDirs(t)
+	sessionData := &SessionData{ThreadID: "commit-race", Commits: make([]*CommitRecord, 0)}
+
+	const commits = 32
+	records := make([]*CommitRecord, commits)
+	var wg sync.WaitGroup
+	for idx := range commits {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record := &CommitRecord{Summary: fmt.Sprintf("commit %d", idx), Status: "pending"}
+			sessionMutex.Lock()
+			sessionData.Commits = append(sessionData.Commits, record)
+			sessionMutex.Unlock()
+			records[idx] = record
+
+			status := "success"
+			if idx%2 == 1 {
+				status = "failed"
+			}
+			updateCommitRecord(record, status, fmt.Sprintf("hash-%d", idx))
+			if err := saveSessionData(sessionData); err != nil {
+				t.Errorf("saveSessionData() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(sessionData.Commits) != commits {
+		t.Fatalf("recorded %d commits, want %d", len(sessionData.Commits), commits)
+	}
+	for idx, record := range records {
+		wantStatus := "success"
+		if idx%2 == 1 {
+			wantStatus = "failed"
+		}
+		if record.Status != wantStatus || record.Hash != fmt.Sprintf("hash-%d", idx) {
+			t.Errorf("record %d = (%q, %q), want (%q, hash-%d)", idx, record.Status, record.Hash, wantStatus, idx)
+		}
+	}
+
+	// The last save holds every record with its final state
+	if err := saveSessionData(sessionData); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(sessionsDirectory, "commit-race.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored SessionData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string]string, len(stored.Commits))
+	for _, record := range stored.Commits {
+		hashes[record.Summary] = record.Hash
+	}
+	for idx := range commits {
+		if got := hashes[fmt.Sprintf("commit %d", idx)]; got != fmt.Sprintf("hash-%d", idx) {
+			t.Errorf("stored commit %d has hash %q, want hash-%d", idx, got, idx)
+		}
+	}
+}
+
+func TestMostRecentSessionForUser(t *testing.T) {
+	now := time.Now()
+	sessions := []*SessionData{
+		{ThreadID: "old", UserID: "alice", CreatedAt: now.Add(-48 * time.Hour)},
+		{ThreadID: "active", UserID: "alice", CreatedAt: now.Add(-72 * time.Hour), LastActivity: now.Add(-time.Hour)},
+		{ThreadID: "new", UserID: "alice", CreatedAt: now.Add(-2 * time.Hour)},
+		{ThreadID: "other", UserID: "bob", CreatedAt: now},
+	}
+	tests := []struct {
+		user string
+		want string
+	}{
+		{user: "alice", want: "active"},
+		{user: "bob", want: "other"},
+		{user: "carol", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			var got string
+			if latest := mostRecentSessionForUser(sessions, tt.user); latest != nil {
+				got = latest.ThreadID
+			}
+			if got != tt.want {
+				t.Errorf("mostRecentSessionForUser(%q) = %q, want %q", tt.user, got, tt.want)
+			}
+		})
+	}
+}
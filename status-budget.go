@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// statusBudget holds the character budgets of a turn's status messages. Budgets of 0 or less
+// leave their part uncapped.
+type statusBudget struct {
+	total     int    // status_max_chars: tool history and response together
+	tools     int    // status_tool_chars: tool history alone
+	response  int    // status_response_chars: response alone
+	trimFirst string // status_trim_first: the part that gives up space when both exceed total
+}
+
+// configuredStatusBudget returns the status budgets set in the config
+func configuredStatusBudget() statusBudget {
+	return statusBudget{
+		total:     AppConfig.StatusMaxChars,
+		tools:     AppConfig.StatusToolChars,
+		response:  AppConfig.StatusResponseChars,
+		trimFirst: AppConfig.StatusTrimFirst,
+	}
+}
+
+// allocateStatusBudget fits the tool history and the current response of a status message into
+// their budgets, counted in characters. Each part is held to its own budget, and when both don't
+// fit into the total the part named by trimFirst gives up space first. Tool history loses its
+// oldest lines, a response its beginning.
+func allocateStatusBudget(tools, response string, budget statusBudget) (string, string) {
+	toolsLimit, responseLimit := partLimit(budget.tools), partLimit(budget.response)
+	trimmedTools, trimmedResponse := trimStatusTools(tools, toolsLimit), trimStatusResponse(response, responseLimit)
+	if budget.total <= 0 || statusLength(trimmedTools, trimmedResponse) <= budget.total {
+		return trimmedTools, trimmedResponse
+	}
+
+	// Both parts are trimmed again from the original text so the tool note counts every dropped line
+	if budget.trimFirst == StatusTrimResponse {
+		trimmedTools = trimStatusTools(tools, min(toolsLimit, budget.total))
+		return trimmedTools, trimStatusResponse(response, min(responseLimit, remainingBudget(budget.total, trimmedTools)))
+	}
+	trimmedResponse = trimStatusResponse(response, min(responseLimit, budget.total))
+	return trimStatusTools(tools, min(toolsLimit, remainingBudget(budget.total, trimmedResponse))), trimmedResponse
+}
+
+// partLimit turns a per-part budget into a character limit, leaving budgets of 0 or less uncapped
+func partLimit(budget int) int {
+	if budget <= 0 {
+		return math.MaxInt
+	}
+	return budget
+}
+
+// remainingBudget returns the characters left of total for one part once the other part and the
+// newline between them are placed
+func remainingBudget(total int, other string) int {
+	if other == "" {
+		return total
+	}
+	return total - utf8.RuneCountInString(other) - 1
+}
+
+// statusLength counts the characters of both status parts, including the newline between them
+func statusLength(tools, response string) int {
+	length := utf8.RuneCountInString(tools) + utf8.RuneCountInString(response)
+	if tools != "" && response != "" {
+		length++
+	}
+	return length
+}
+
+// trimStatusTools keeps the most recent tool status lines that fit into limit characters, noting
+// how many earlier lines were dropped
+func trimStatusTools(history string, limit int) string {
+	if utf8.RuneCountInString(history) <= limit {
+		return history
+	}
+	lines := strings.Split(strings.TrimSuffix(history, "\n"), "\n")
+	for dropped := 1; dropped <= len(lines); dropped++ {
+		kept := strings.Join(lines[dropped:], "\n")
+		note := fmt.Sprintf("|>> … %d earlier lines trimmed", dropped)
+		if kept != "" {
+			note += "\n" + kept
+		}
+		if utf8.RuneCountInString(note) <= limit {
+			return note
+		}
+	}
+	return ""
+}
+
+// trimStatusResponse keeps the end of a response that fits into limit characters behind an ellipsis
+func trimStatusResponse(response string, limit int) string {
+	if utf8.RuneCountInString(response) <= limit {
+		return response
+	}
+	const marker = "…"
+	if limit <= 1 {
+		return ""
+	}
+	runes := []rune(response)
+	return marker + string(runes[len(runes)-(limit-1):])
+}
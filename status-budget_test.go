@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAllocateStatusBudget(t *testing.T) {
+	tools := "|>> read internal/server/handlers.go\n|>> edit internal/server/handlers.go\n|>> bash go test ./..."
+	tests := []struct {
+		name         string
+		tools        string
+		response     string
+		budget       statusBudget
+		wantTools    string
+		wantResponse string
+	}{
+		{name: "no budget", tools: tools, response: "done", wantTools: tools, wantResponse: "done"},
+		{name: "fits", tools: tools, response: "done", budget: statusBudget{total: 101}, wantTools: tools, wantResponse: "done"},
+		{
+			name: "trim tools first", tools: tools, response: "done", budget: statusBudget{total: 60, trimFirst: StatusTrimTools},
+			wantTools: "|>> … 2 earlier lines trimmed\n|>> bash go test ./...", wantResponse: "done",
+		},
+		{
+			name: "trim response first", tools: "|>> read", response: "abcdefghij", budget: statusBudget{total: 15, trimFirst: StatusTrimResponse},
+			wantTools: "|>> read", wantResponse: "…fghij",
+		},
+		{name: "response only", response: "abcdefghij", budget: statusBudget{total: 7, trimFirst: StatusTrimTools}, wantResponse: "…efghij"},
+		{
+			name: "per-part caps", tools: tools, response: "abcdefghij", budget: statusBudget{tools: 55, response: 5},
+			wantTools: "|>> … 2 earlier lines trimmed\n|>> bash go test ./...", wantResponse: "…ghij",
+		},
+		{
+			name: "part caps before total", tools: tools, response: "abcdefghij", budget: statusBudget{total: 60, tools: 60, trimFirst: StatusTrimTools},
+			wantTools: "|>> … 3 earlier lines trimmed", wantResponse: "abcdefghij",
+		},
+		{
+			name: "counts characters", response: "héllo wörld ✓", budget: statusBudget{total: 13, response: 13},
+			wantResponse: "héllo wörld ✓",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTools, gotResponse := allocateStatusBudget(tt.tools, tt.response, tt.budget)
+			if gotTools != tt.wantTools || gotResponse != tt.wantResponse {
+				t.Errorf("allocateStatusBudget() = (%q, %q), want (%q, %q)", gotTools, gotResponse, tt.wantTools, tt.wantResponse)
+			}
+			if tt.budget.total > 0 {
+				total := utf8.RuneCountInString(gotTools) + utf8.RuneCountInString(gotResponse)
+				if gotTools != "" && gotResponse != "" {
+					total++
+				}
+				if total > tt.budget.total {
+					t.Errorf("allocateStatusBudget() used %d characters, budget %d", total, tt.budget.total)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimStatusTools(t *testing.T) {
+	history := "|>> read internal/server/handlers.go\n|>> edit internal/server/handlers.go\n|>> bash go test ./..."
+	tests := []struct {
+		name    string
+		history string
+		limit   int
+		want    string
+	}{
+		{name: "fits", history: history, limit: 96, want: history},
+		{name: "drops oldest", history: history, limit: 91, want: "|>> … 1 earlier lines trimmed\n|>> edit internal/server/handlers.go\n|>> bash go test ./..."},
+		{name: "only the note", history: history, limit: 40, want: "|>> … 3 earlier lines trimmed"},
+		{name: "note counted in characters", history: history, limit: 29, want: "|>> … 3 earlier lines trimmed"},
+		{name: "nothing fits", history: history, limit: 10, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimStatusTools(tt.history, tt.limit); got != tt.want {
+				t.Errorf("trimStatusTools(%q, %d) = %q, want %q", tt.history, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimStatusResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		limit    int
+		want     string
+	}{
+		{name: "fits", response: "hello", limit: 5, want: "hello"},
+		{name: "keeps the end", response: "hello world", limit: 8, want: "…o world"},
+		{name: "limit at marker", response: "hello world", limit: 1, want: ""},
+		{name: "multi-byte characters", response: "aaé€", limit: 3, want: "…é€"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimStatusResponse(tt.response, tt.limit)
+			if got != tt.want {
+				t.Errorf("trimStatusResponse(%q, %d) = %q, want %q", tt.response, tt.limit, got, tt.want)
+			}
+			if utf8.RuneCountInString(got) > tt.limit || !utf8.ValidString(got) || (got != "" && !strings.HasSuffix(tt.response, strings.TrimPrefix(got, "…"))) {
+				t.Errorf("trimStatusResponse(%q, %d) = %q is not a valid tail", tt.response, tt.limit, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Status message edits are coalesced to at most one per interval per thread unless status_flush_interval says otherwise
+const defaultStatusFlushInterval = 750 * time.Millisecond
+
+// statusFlusher tracks the pending edit of one thread's status messages
+type statusFlusher struct {
+	timer     *time.Timer
+	lastFlush time.Time
+}
+
+var statusFlushers = make(map[string]*statusFlusher)
+var statusFlushMutex sync.Mutex
+
+// statusFlushInterval returns the minimum time between status message edits of a thread, 0 when coalescing is off
+func statusFlushInterval() time.Duration {
+	if AppConfig.StatusFlushInterval < 0 {
+		return 0
+	}
+	if AppConfig.StatusFlushInterval == 0 {
+		return defaultStatusFlushInterval
+	}
+	return AppConfig.StatusFlushInterval
+}
+
+// scheduleStatusFlush marks a thread's status as changed. The latest content is rendered once the
+// flush interval since the previous edit has passed, so bursts of updates cost a single edit.
+// The caller holds sessionMutex.
+func scheduleStatusFlush(threadID string, sessionData *SessionData) {
+	interval := statusFlushInterval()
+	if interval <= 0 {
+		rebuildStatusMessage(threadID, sessionData)
+		return
+	}
+
+	statusFlushMutex.Lock()
+	defer statusFlushMutex.Unlock()
+
+	flusher, exists := statusFlushers[threadID]
+	if !exists {
+		flusher = &statusFlusher{}
+		statusFlushers[threadID] = flusher
+	}
+	if flusher.timer != nil {
+		return // a flush is already pending and will pick up this change
+	}
+	delay := max(interval-time.Since(flusher.lastFlush), 0)
+	flusher.timer = time.AfterFunc(delay, func() {
+		flushStatus(threadID)
+	})
+}
+
+// flushStatus renders the latest status content of a thread if a flush is pending
+func flushStatus(threadID string) {
+	statusFlushMutex.Lock()
+	flusher, exists := statusFlushers[threadID]
+	if !exists || flusher.timer == nil {
+		statusFlushMutex.Unlock()
+		return
+	}
+	flusher.timer.Stop()
+	flusher.timer = nil
+	flusher.lastFlush = time.Now()
+	statusFlushMutex.Unlock()
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists {
+		slog.Debug("session gone before status flush", "thread_id", threadID)
+		return
+	}
+	rebuildStatusMessage(threadID, sessionData)
+}
+
+// cancelStatusFlush drops a pending flush, e.g. when the status messages are being deleted
+func cancelStatusFlush(threadID string) {
+	statusFlushMutex.Lock()
+	defer statusFlushMutex.Unlock()
+
+	if flusher, exists := statusFlushers[threadID]; exists {
+		if flusher.timer != nil {
+			flusher.timer.Stop()
+		}
+		delete(statusFlushers, threadID)
+	}
+}
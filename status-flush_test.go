@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusFlushBoundsEdits(t *testing.T) {
+	fake := useFakeDiscord(t)
+	const interval = 50 * time.Millisecond
+	useTestConfig(t, func(config *Config) { config.StatusFlushInterval = interval })
+	useTestDataDirs(t)
+	useTestSession(t, &SessionData{ThreadID: "flush-thread"})
+	t.Cleanup(func() { cancelStatusFlush("flush-thread") })
+
+	const updates = 200
+	start := time.Now()
+	for idx := range updates {
+		updateTextResponse("flush-thread", fmt.Sprintf("Response:\nchunk %d", idx))
+		time.Sleep(time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	time.Sleep(2 * interval)
+
+	writes := append(fake.calls(http.MethodPost, "/channels/flush-thread/messages"), fake.calls(http.MethodPatch, "/channels/flush-thread/messages/")...)
+	// One edit per interval, plus the leading edit and the trailing flush
+	maxWrites := int(elapsed/interval) + 2
+	if len(writes) == 0 || len(writes) > maxWrites {
+		t.Fatalf("%d updates over %v caused %d message writes, want between 1 and %d", updates, elapsed, len(writes), maxWrites)
+	}
+
+	// The trailing flush renders the latest content
+	final := false
+	for _, write := range writes {
+		final = final || strings.Contains(write.Body, fmt.Sprintf("chunk %d", updates-1))
+	}
+	if !final {
+		t.Error("no write carries the final update")
+	}
+}
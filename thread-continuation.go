@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Custom ID of the button that moves a session into a continuation thread
+const continueThreadID = "continue_thread"
+
+// Discord's maximum thread name length
+const maxThreadNameLength = 100
+
+// continuationSuffixPattern matches the " (part N)" suffix of a continuation thread's name
+var continuationSuffixPattern = regexp.MustCompile(` \(part \d+\)$`)
+
+// offerThreadContinuation posts a button to continue the session in a new thread once the thread
+// has more messages than thread_message_limit or is older than thread_max_age. It is offered once
+// per thread.
+func offerThreadContinuation(threadID string) {
+	if AppConfig.ThreadMessageLimit <= 0 && AppConfig.ThreadMaxAge <= 0 {
+		return
+	}
+
+	sessionMutex.RLock()
+	sessionData, exists := sessionCache[threadID]
+	skip := !exists || sessionData.ChannelSession || sessionData.ContinuationOffered
+	sessionMutex.RUnlock()
+	if skip {
+		return
+	}
+
+	thread, err := discord.Channel(threadID)
+	if err != nil {
+		slog.Error("failed to get thread info for continuation check", "thread_id", threadID, "error", err)
+		return
+	}
+	reason := threadContinuationReason(thread.MessageCount, threadAge(threadID, time.Now()))
+	if reason == "" {
+		return
+	}
+
+	_, err = discord.ChannelMessageSendComplex(threadID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("📚 This thread %s. Continue the session (same worktree, branch and conversation) in a new thread?", reason),
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Continue in a new thread",
+						Style:    discordgo.PrimaryButton,
+						CustomID: continueThreadID,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("failed to offer thread continuation", "thread_id", threadID, "error", err)
+		return
+	}
+
+	sessionMutex.Lock()
+	sessionData.ContinuationOffered = true
+	sessionMutex.Unlock()
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after offering continuation", "thread_id", threadID, "error", err)
+	}
+}
+
+// threadContinuationReason explains which limit a thread has reached, "" when none
+func threadContinuationReason(messageCount int, age time.Duration) string {
+	if AppConfig.ThreadMessageLimit > 0 && messageCount >= AppConfig.ThreadMessageLimit {
+		return fmt.Sprintf("has %d messages", messageCount)
+	}
+	if AppConfig.ThreadMaxAge > 0 && age >= AppConfig.ThreadMaxAge {
+		return fmt.Sprintf("is %s old", age.Round(time.Hour))
+	}
+	return ""
+}
+
+// threadAge derives a thread's age from the creation time encoded in its snowflake ID
+func threadAge(threadID string, now time.Time) time.Duration {
+	createdAt, err := discordgo.SnowflakeTimestamp(threadID)
+	if err != nil {
+		return 0
+	}
+	return now.Sub(createdAt)
+}
+
+// continuationThreadName names the next thread of a session: "name (part N)"
+func continuationThreadName(name string, part int) string {
+	suffix := fmt.Sprintf(" (part %d)", part)
+	base := []rune(continuationSuffixPattern.ReplaceAllString(name, ""))
+	if limit := maxThreadNameLength - len(suffix); len(base) > limit {
+		base = base[:limit]
+	}
+	return string(base) + suffix
+}
+
+// handleContinueThreadButton starts a continuation thread next to the current one and moves the
+// session into it. Only the session owner or an admin may do so, and only between turns.
+func handleContinueThreadButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	threadID := i.ChannelID
+	logger := interactionLogger(i)
+
+	respond := func(content string) {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			logger.Error("failed to respond to continue thread button", "error", err)
+		}
+	}
+
+	sessionData := lazyLoadSession(threadID)
+	if sessionData == nil {
+		respond("No codesession session found for this thread.")
+		return
+	}
+	sessionMutex.RLock()
+	owner := sessionData.UserID
+	streaming := sessionData.IsStreaming
+	previousThreads := len(sessionData.PreviousThreadIDs)
+	sessionMutex.RUnlock()
+
+	if owner != "" && owner != interactionUserID(i) && !isAdmin(i) {
+		respond("Only the session owner or an admin can move this session to a new thread.")
+		return
+	}
+	if streaming || len(queuedPrompts(threadID)) > 0 {
+		respond("The model is still working. Try again once the current prompt and the queue have finished.")
+		return
+	}
+
+	thread, err := s.Channel(threadID)
+	if err != nil || thread.ParentID == "" {
+		logger.Error("failed to get thread info for continuation", "error", err)
+		respond("Failed to get information about this thread.")
+		return
+	}
+	threadType, refusal := sessionThreadTypeIn(s, thread.ParentID)
+	if refusal != "" {
+		respond(refusal)
+		return
+	}
+	newThread, err := s.ThreadStart(thread.ParentID, continuationThreadName(thread.Name, previousThreads+2), threadType, 1440)
+	if err != nil {
+		logger.Error("failed to create continuation thread", "parent_id", thread.ParentID, "error", err)
+		if isMissingPermissionError(err) {
+			respond(missingThreadPermissionMessage)
+		} else {
+			respond("Failed to create the continuation thread.")
+		}
+		return
+	}
+
+	if err := moveSessionToThread(sessionData, newThread.ID); err != nil {
+		logger.Error("failed to move session to continuation thread", "new_thread_id", newThread.ID, "error", err)
+		respond("Failed to move the session to the new thread.")
+		s.ChannelMessageSend(newThread.ID, "Failed to move the session here, keep using the previous thread.")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    fmt.Sprintf("📚 The session continues in <#%s>.", newThread.ID),
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		logger.Error("failed to update continuation offer", "error", err)
+	}
+
+	sessionMutex.RLock()
+	intro := fmt.Sprintf("📚 Continuing the session from <#%s>.\nWorktree: `%s`", threadID, sessionData.WorktreePath)
+	if owner != "" {
+		intro += fmt.Sprintf("\nOwner: <@%s>", owner)
+	}
+	sessionMutex.RUnlock()
+	s.ChannelMessageSend(newThread.ID, intro)
+	logger.Info("session continued in new thread", "new_thread_id", newThread.ID)
+}
+
+// moveSessionToThread rebinds an idle session, with its worktree and OpenCode session, to another
+// thread: the cache entry and session file move to the new thread ID
+func moveSessionToThread(sessionData *SessionData, newThreadID string) error {
+	sessionMutex.RLock()
+	oldThreadID := sessionData.ThreadID
+	sessionMutex.RUnlock()
+
+	oldFilePath, err := sessionFilePath(oldThreadID)
+	if err != nil {
+		return err
+	}
+
+	stopActiveListener(oldThreadID)
+	stopAutoCommitTimer(oldThreadID)
+	stopInactivityTimer(oldThreadID)
+
+	sessionMutex.Lock()
+	if _, exists := sessionCache[newThreadID]; exists {
+		sessionMutex.Unlock()
+		return fmt.Errorf("thread %s already has a session", newThreadID)
+	}
+	delete(sessionCache, oldThreadID)
+	sessionData.ThreadID = newThreadID
+	sessionData.PreviousThreadIDs = append(sessionData.PreviousThreadIDs, oldThreadID)
+	sessionData.ContinuationOffered = false
+	sessionData.LastStatusMessageID = ""
+	sessionData.StatusMessageIDs = nil
+	sessionCache[newThreadID] = sessionData
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(sessionData); err != nil {
+		// Put the session back so the old thread keeps working
+		sessionMutex.Lock()
+		delete(sessionCache, newThreadID)
+		sessionData.ThreadID = oldThreadID
+		sessionData.PreviousThreadIDs = sessionData.PreviousThreadIDs[:len(sessionData.PreviousThreadIDs)-1]
+		sessionCache[oldThreadID] = sessionData
+		sessionMutex.Unlock()
+		resetAutoCommitTimer(oldThreadID)
+		return err
+	}
+	if err := os.Remove(oldFilePath); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to remove session file of previous thread", "thread_id", oldThreadID, "error", err)
+	}
+	clearSessionLogs(oldThreadID)
+	resetAutoCommitTimer(newThreadID)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// toolStatusLine formats the status line for a completed tool, adding a progress hint when
+// tool_progress is enabled and the tool's input or metadata carries countable items
+func toolStatusLine(part *MessagePart) string {
+	if AppConfig.ToolProgress {
+		if progress := toolProgress(part); progress != "" {
+			return fmt.Sprintf("|>> tool: %s (%s)", part.Tool, progress)
+		}
+	}
+	return fmt.Sprintf("|>> tool: %s", part.Tool)
+}
+
+// toolProgress extracts a short progress summary from the known input and metadata keys of
+// OpenCode's tools. It is best effort: unknown tools or unexpected shapes return "".
+func toolProgress(part *MessagePart) string {
+	if part.State == nil {
+		return ""
+	}
+	input := part.State.Input
+	metadata := part.State.Metadata
+
+	switch part.Tool {
+	case "todowrite":
+		todos, ok := input["todos"].([]any)
+		if !ok || len(todos) == 0 {
+			return ""
+		}
+		done := 0
+		for _, todo := range todos {
+			if fields, ok := todo.(map[string]any); ok && stringField(fields, "status") == "completed" {
+				done++
+			}
+		}
+		return fmt.Sprintf("%d/%d todos done", done, len(todos))
+	case "multiedit":
+		if edits, ok := input["edits"].([]any); ok && len(edits) > 0 {
+			return fmt.Sprintf("%d edits", len(edits))
+		}
+	case "glob", "list":
+		if count, ok := metadata["count"].(float64); ok {
+			return fmt.Sprintf("%d files", int(count))
+		}
+	case "grep":
+		if matches, ok := metadata["matches"].(float64); ok {
+			return fmt.Sprintf("%d matches", int(matches))
+		}
+	case "task":
+		// Subagent tasks report the tool calls they made
+		summary, ok := metadata["summary"].([]any)
+		if !ok || len(summary) == 0 {
+			return ""
+		}
+		done := 0
+		for _, entry := range summary {
+			fields, ok := entry.(map[string]any)
+			if !ok {
+				continue
+			}
+			if state, ok := fields["state"].(map[string]any); ok && stringField(state, "status") == ToolStatusCompleted {
+				done++
+			}
+		}
+		return fmt.Sprintf("%d/%d steps", done, len(summary))
+	}
+	return ""
+}
@@ -85,28 +85,80 @@ type CommitRecord struct {
 	Summary   string    `json:"summary"`
 	Timestamp time.Time `json:"timestamp"`
 	Status    string    `json:"status"` // "success", "failed", "pending"
+	Branch    string    `json:"branch,omitempty"`
+}
+
+// SubWorktree is one named worktree of a forked session, each with its own branch and OpenCode session
+type SubWorktree struct {
+	Path      string `json:"path"`
+	Branch    string `json:"branch"`
+	SessionID string `json:"session_id"`
 }
 
 // SessionData holds all information about an OpenCode session
 type SessionData struct {
-	ThreadID       string         `json:"thread_id"`
-	SessionID      string         `json:"session_id"`
-	Model          Model          `json:"model"`
-	WorktreePath   string         `json:"worktree_path"`
-	RepositoryPath string         `json:"repository_path"`
-	RepositoryName string         `json:"repository_name"`
-	CreatedAt      time.Time      `json:"created_at"`
-	Commits        []CommitRecord `json:"commits"`
+	ThreadID       string    `json:"thread_id"`
+	SessionID      string    `json:"session_id"`
+	Model          Model     `json:"model"`
+	Agent          string    `json:"agent,omitempty"`
+	BaseRef        string    `json:"base_ref,omitempty"`     // Commit, tag or branch the session branch was created from
+	Scratch        bool      `json:"scratch,omitempty"`      // Q&A session in a temporary directory without git; git commands are refused
+	BaseBranch     string    `json:"base_branch,omitempty"`  // Branch targeted by compare, PR description and base diffs; empty means the remote default
+	ReviewMode     bool      `json:"review_mode,omitempty"`  // Read-only session: prompts cannot modify files
+	PlanFirst      bool      `json:"plan_first,omitempty"`   // Prompts draft a plan that must be approved before it is executed
+	PlanStatus     string    `json:"plan_status,omitempty"`  // Progress of a plan-first session: "", pending or approved
+	PlanRequest    string    `json:"plan_request,omitempty"` // Request the current plan was drafted for
+	ContextSent    bool      `json:"context_sent,omitempty"` // Repository context_files were included in a prompt
+	KeepThread     bool      `json:"keep_thread,omitempty"`  // Exempt from on_complete archiving/locking
+	AutoRespond    bool      `json:"auto_respond,omitempty"` // Every message in the thread is a prompt, no mention needed
+	WorktreePath   string    `json:"worktree_path"`
+	RepositoryPath string    `json:"repository_path"`
+	RepositoryName string    `json:"repository_name"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastActivity   time.Time `json:"last_activity,omitempty"`
+	UserID         string    `json:"user_id,omitempty"`     // User who started (owns) the session
+	ThreadName     string    `json:"thread_name,omitempty"` // Name the bot gave the thread; empty when bound to an existing thread
+	TotalCost      float64   `json:"total_cost,omitempty"`  // Cumulative model cost in USD reported by OpenCode
+	// Per-session checkpoint interval overriding auto_commit_interval; 0 disables
+	AutoCommitInterval *time.Duration  `json:"auto_commit_interval,omitempty"`
+	Commits            []*CommitRecord `json:"commits"`
+	// auto_branch_name already ran for the session's first prompt
+	BranchNamed bool `json:"branch_named,omitempty"`
+	// Runs in a regular channel because the bot could not create threads there
+	ChannelSession bool `json:"channel_session,omitempty"`
+	// Commit the session branch started from, recorded at creation for /drift
+	ForkPoint string `json:"fork_point,omitempty"`
+	// Threads the session ran in before it was continued in its current one, oldest first
+	PreviousThreadIDs []string `json:"previous_thread_ids,omitempty"`
+	// The thread outgrew thread_message_limit or thread_max_age and a continuation was offered
+	ContinuationOffered bool `json:"continuation_offered,omitempty"`
+	// Set when the bot shut down while a prompt was running, cleared once the thread is told on startup
+	WasStreaming bool `json:"was_streaming,omitempty"`
+	// Named worktrees of the thread once it has been forked, including "main"; nil until the first /fork
+	Worktrees       map[string]*SubWorktree `json:"worktrees,omitempty"`
+	CurrentWorktree string                  `json:"current_worktree,omitempty"`
+
+	// Runtime state checkpointed by scheduleSessionFlush so a restart resumes accurately
+	TotalInputTokens    int             `json:"total_input_tokens,omitempty"`     // Cumulative input tokens reported by OpenCode
+	TotalOutputTokens   int             `json:"total_output_tokens,omitempty"`    // Cumulative output tokens reported by OpenCode
+	StepCount           int             `json:"step_count,omitempty"`             // Model steps finished in the session
+	LastStatusMessageID string          `json:"last_status_message_id,omitempty"` // Last status message of the current turn
+	StatusMessageIDs    []string        `json:"status_message_ids,omitempty"`     // IDs of every status message in the current turn
+	CountedCostParts    map[string]bool `json:"counted_cost_parts,omitempty"`     // Step-finish parts of the current turn already added to TotalCost
 
 	// Non-serialized runtime fields
-	Session              *opencode.Session `json:"-"` // Don't serialize the session object
-	Active               bool              `json:"-"` // Don't serialize the active state
-	IsStreaming          bool              `json:"-"` // Don't serialize the SSE streaming state
-	LastStatusMessageID  string            `json:"-"` // Don't serialize the last status message ID
-	StatusMessageContent string            `json:"-"` // Don't serialize the current status message content
-	ToolStatusHistory    string            `json:"-"` // Don't serialize the tool/thinking status history
-	CurrentResponse      string            `json:"-"` // Don't serialize the current text response
-	UserID               string            `json:"-"` // Don't serialize the user ID who started the session
+	Session               *opencode.Session `json:"-"` // Don't serialize the session object
+	Active                bool              `json:"-"` // Don't serialize the active state
+	IsStreaming           bool              `json:"-"` // Don't serialize the SSE streaming state
+	StatusMessageContents []string          `json:"-"` // Don't serialize the rendered content of each status message
+	StatusMessageContent  string            `json:"-"` // Don't serialize the current status message content
+	ToolStatusHistory     string            `json:"-"` // Don't serialize the tool/thinking status history
+	CurrentResponse       string            `json:"-"` // Don't serialize the current text response
+	TurnHadText           bool              `json:"-"` // Don't serialize whether the current turn produced a text part
+	LastReasoning         string            `json:"-"` // Don't serialize the latest reasoning text of the current turn
+	LastEventAt           time.Time         `json:"-"` // Don't serialize when the listener last received an event
+	UpdatesPausedAt       time.Time         `json:"-"` // Don't serialize when /pause stopped the listener; zero when not paused
+	PromptTurn            uint64            `json:"-"` // Don't serialize the counter of started turns, bumped when an edit supersedes one
 }
 
 // Global variables for session management
@@ -114,5 +166,12 @@ var sessionCache = make(map[string]*SessionData, 100) // Pre-allocate for typica
 var sessionMutex sync.RWMutex
 
 // Active event listeners management
-var activeListeners = make(map[string]context.CancelFunc, 100) // Pre-allocate for typical load
+var activeListeners = make(map[string]*activeListener, 100) // Pre-allocate for typical load
 var listenersMutex sync.RWMutex
+var listenerGeneration uint64 // Incremented for every spawned listener, guarded by listenersMutex
+
+// activeListener is a running event listener; generation tells a superseded listener apart from its replacement
+type activeListener struct {
+	cancel     context.CancelFunc
+	generation uint64
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UsageEntry is the cost of one model step in the central usage index
+type UsageEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	ThreadID     string    `json:"thread_id"`
+	UserID       string    `json:"user_id,omitempty"`
+	Repository   string    `json:"repository"`
+	Model        string    `json:"model"`
+	Cost         float64   `json:"cost"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+}
+
+// usageIndexPath returns the location of the append-only usage index next to the data directories
+func usageIndexPath() (string, error) {
+	sessionDir, err := ensureSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(sessionDir), "usage-index.jsonl"), nil
+}
+
+// readUsageIndex returns the usage recorded at or after since, oldest first
+func readUsageIndex(since time.Time) ([]UsageEntry, error) {
+	indexPath, err := usageIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	return readIndexEntries(indexPath, since, func(entry UsageEntry) time.Time {
+		return entry.Timestamp
+	})
+}
+
+// recordStepUsage adds the cost reported by a step-finish part to the session total and, when
+// usage_index is enabled, to the usage index. Each part is counted once even if it is updated again.
+func recordStepUsage(threadID string, part *MessagePart) {
+	if part.Cost == nil {
+		return
+	}
+
+	sessionMutex.Lock()
+	sessionData, exists := sessionCache[threadID]
+	if !exists {
+		sessionMutex.Unlock()
+		return
+	}
+	if sessionData.CountedCostParts == nil {
+		sessionData.CountedCostParts = make(map[string]bool)
+	}
+	if sessionData.CountedCostParts[part.ID] {
+		sessionMutex.Unlock()
+		return
+	}
+	sessionData.CountedCostParts[part.ID] = true
+	sessionData.TotalCost += *part.Cost
+	sessionData.StepCount++
+	if part.Tokens != nil {
+		sessionData.TotalInputTokens += part.Tokens.Input
+		sessionData.TotalOutputTokens += part.Tokens.Output
+	}
+	entry := UsageEntry{
+		Timestamp:  time.Now(),
+		ThreadID:   threadID,
+		UserID:     sessionData.UserID,
+		Repository: sessionData.RepositoryName,
+		Model:      sessionData.Model.ProviderID + "/" + sessionData.Model.ModelID,
+		Cost:       *part.Cost,
+	}
+	sessionMutex.Unlock()
+
+	if part.Tokens != nil {
+		entry.InputTokens = part.Tokens.Input
+		entry.OutputTokens = part.Tokens.Output
+	}
+	scheduleSessionFlush(threadID)
+
+	if !AppConfig.UsageIndex {
+		return
+	}
+	indexPath, err := usageIndexPath()
+	if err == nil {
+		err = appendIndexEntry(indexPath, entry)
+	}
+	if err != nil {
+		slog.Error("failed to append to usage index", "thread_id", threadID, "error", err)
+	}
+}
+
+// costShare is the total cost attributed to one user, repository or model
+type costShare struct {
+	Key  string
+	Cost float64
+}
+
+// CostReport aggregates usage entries over a time window
+type CostReport struct {
+	Total        float64
+	Steps        int
+	ByUser       []costShare
+	ByRepository []costShare
+	ByModel      []costShare
+}
+
+// buildCostReport sums entries in total and per user, repository and model, most expensive first
+func buildCostReport(entries []UsageEntry) CostReport {
+	byUser := make(map[string]float64)
+	byRepository := make(map[string]float64)
+	byModel := make(map[string]float64)
+	report := CostReport{Steps: len(entries)}
+	for _, entry := range entries {
+		report.Total += entry.Cost
+		byUser[entry.UserID] += entry.Cost
+		byRepository[entry.Repository] += entry.Cost
+		byModel[entry.Model] += entry.Cost
+	}
+	report.ByUser = sortedCostShares(byUser)
+	report.ByRepository = sortedCostShares(byRepository)
+	report.ByModel = sortedCostShares(byModel)
+	return report
+}
+
+// sortedCostShares orders costs descending, breaking ties by key
+func sortedCostShares(costs map[string]float64) []costShare {
+	shares := make([]costShare, 0, len(costs))
+	for key, cost := range costs {
+		shares = append(shares, costShare{Key: key, Cost: cost})
+	}
+	sort.Slice(shares, func(a, b int) bool {
+		if shares[a].Cost != shares[b].Cost {
+			return shares[a].Cost > shares[b].Cost
+		}
+		return shares[a].Key < shares[b].Key
+	})
+	return shares
+}
+
+// Rows listed per section of a cost report before the rest are summarized
+const maxCostReportRows = 10
+
+// formatCostReport renders a report for the last days; users are shown as mentions
+func formatCostReport(report CostReport, days int) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "**Cost in the last %d day(s):** $%.2f over %d model step(s)\n", days, report.Total, report.Steps)
+
+	section := func(title string, shares []costShare, label func(string) string) {
+		fmt.Fprintf(&message, "\n**%s:**\n", title)
+		for idx, share := range shares {
+			if idx == maxCostReportRows {
+				fmt.Fprintf(&message, "...and %d more\n", len(shares)-maxCostReportRows)
+				break
+			}
+			percent := 0.0
+			if report.Total > 0 {
+				percent = share.Cost / report.Total * 100
+			}
+			fmt.Fprintf(&message, "%d. %s: $%.2f (%.0f%%)\n", idx+1, label(share.Key), share.Cost, percent)
+		}
+	}
+	section("By user", report.ByUser, func(userID string) string {
+		if userID == "" {
+			return "unknown"
+		}
+		return fmt.Sprintf("<@%s>", userID)
+	})
+	section("By repository", report.ByRepository, func(name string) string { return name })
+	section("By model", report.ByModel, func(model string) string { return "`" + model + "`" })
+	return message.String()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 var reCollapseNewlines = regexp.MustCompile(`\n+`)
@@ -35,3 +36,43 @@ func appendToContentHistory(existing, newContent string) string {
 	}
 	return existing + "\n" + newContent
 }
+
+// splitMessage splits text into chunks of at most limit bytes, preferring to break at newlines and
+// never inside a multi-byte character
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	remaining := text
+	for len(remaining) > 0 {
+		if len(remaining) <= limit {
+			chunks = append(chunks, remaining)
+			break
+		}
+		split := strings.LastIndex(remaining[:limit], "\n")
+		if split <= 0 {
+			split = limit
+			for split > 0 && !utf8.RuneStart(remaining[split]) {
+				split--
+			}
+			if split == 0 {
+				// A single character wider than limit is kept whole
+				_, split = utf8.DecodeRuneInString(remaining)
+			}
+		}
+		chunks = append(chunks, remaining[:split])
+		remaining = strings.TrimPrefix(remaining[split:], "\n")
+	}
+	return chunks
+}
+
+// truncateText cuts text to at most limit bytes on a character boundary, ending it with suffix when
+// anything was cut, so multi-byte characters are never split
+func truncateText(text string, limit int, suffix string) string {
+	if len(text) <= limit {
+		return text
+	}
+	end := max(limit-len(suffix), 0)
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end] + suffix
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		limit  int
+		suffix string
+		want   string
+	}{
+		{name: "fits", text: "hello", limit: 5, suffix: "...", want: "hello"},
+		{name: "ascii", text: "hello world", limit: 8, suffix: "...", want: "hello..."},
+		{name: "no suffix", text: "hello world", limit: 5, suffix: "", want: "hello"},
+		{name: "multi-byte boundary", text: "héllo", limit: 2, suffix: "", want: "h"},
+		{name: "emoji", text: "ab😀cd", limit: 5, suffix: "", want: "ab"},
+		{name: "suffix longer than limit", text: "hello", limit: 2, suffix: "...", want: "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateText(tt.text, tt.limit, tt.suffix)
+			if got != tt.want {
+				t.Errorf("truncateText(%q, %d, %q) = %q, want %q", tt.text, tt.limit, tt.suffix, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateText(%q, %d, %q) = %q is not valid UTF-8", tt.text, tt.limit, tt.suffix, got)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{name: "fits", text: "short", limit: 10, want: []string{"short"}},
+		{name: "empty", text: "", limit: 10, want: nil},
+		{name: "newline", text: "line one\nline two", limit: 10, want: []string{"line one", "line two"}},
+		{name: "no newline", text: "abcdefghij", limit: 4, want: []string{"abcd", "efgh", "ij"}},
+		{name: "multi-byte", text: "aé€b", limit: 3, want: []string{"aé", "€", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Fatalf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			for _, chunk := range got {
+				if len(chunk) > tt.limit || !utf8.ValidString(chunk) {
+					t.Errorf("chunk %q exceeds %d bytes or is not valid UTF-8", chunk, tt.limit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A turn without any event for this long is considered dead unless stuck_session_timeout says otherwise
+const defaultStuckSessionTimeout = 15 * time.Minute
+
+// Upper bound on how often the watchdog scans sessions
+const maxWatchdogInterval = 30 * time.Second
+
+// stuckSessionTimeout returns how long a streaming session may go without events, 0 when the watchdog is off
+func stuckSessionTimeout() time.Duration {
+	if AppConfig.StuckSessionTimeout < 0 {
+		return 0
+	}
+	if AppConfig.StuckSessionTimeout == 0 {
+		return defaultStuckSessionTimeout
+	}
+	return AppConfig.StuckSessionTimeout
+}
+
+// touchLastEvent records that the thread's listener just received an event
+func touchLastEvent(threadID string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		sessionData.LastEventAt = time.Now()
+	}
+}
+
+// runStuckSessionWatchdog periodically recovers sessions that are streaming but have gone silent,
+// e.g. because the backend hung, so the thread isn't left with a "working..." message forever
+func runStuckSessionWatchdog(ctx context.Context) {
+	timeout := stuckSessionTimeout()
+	if timeout <= 0 {
+		slog.Info("stuck session watchdog disabled")
+		return
+	}
+	ticker := time.NewTicker(min(timeout/4, maxWatchdogInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, threadID := range stuckSessions(now, timeout) {
+				recoverStuckSession(threadID, timeout)
+			}
+		}
+	}
+}
+
+// stuckSessions returns the threads whose turn has been running without events for longer than timeout
+func stuckSessions(now time.Time, timeout time.Duration) []string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	var threadIDs []string
+	for threadID, sessionData := range sessionCache {
+		if sessionData.IsStreaming && !sessionData.LastEventAt.IsZero() && now.Sub(sessionData.LastEventAt) > timeout {
+			threadIDs = append(threadIDs, threadID)
+		}
+	}
+	return threadIDs
+}
+
+// recoverStuckSession ends a turn that stopped producing events: the listener is stopped, the prompt
+// aborted on the server, the status message finalized as failed and the user warned. Queued prompts
+// then run as after a normal turn.
+func recoverStuckSession(threadID string, timeout time.Duration) {
+	slog.Warn("session stuck without events, ending turn", "thread_id", threadID, "timeout", timeout)
+
+	stopActiveListener(threadID)
+	var sessionID, worktreePath string
+	sessionMutex.Lock()
+	if sessionData, exists := sessionCache[threadID]; exists {
+		sessionData.IsStreaming = false
+		sessionID = sessionData.SessionID
+		worktreePath = sessionData.WorktreePath
+	}
+	sessionMutex.Unlock()
+	refreshPresence()
+
+	// The server may still be working on the prompt; stop it so the next one doesn't overlap with it
+	if sessionID != "" {
+		if err := abortSession(sessionID, worktreePath); err != nil {
+			slog.Error("failed to abort stuck prompt", "thread_id", threadID, "session_id", sessionID, "error", err)
+		}
+	}
+
+	finalizeStatusMessage(threadID, statusOutcomeFailed)
+	sendToDiscord(threadID, fmt.Sprintf("⚠️ No progress from the model for %s, so the task was assumed dead and stopped. Send a message to try again.", timeout.Round(time.Second)))
+	SetSessionActive(threadID, false)
+	resetAutoCommitTimer(threadID)
+
+	if len(queuedPrompts(threadID)) > 0 {
+		go dispatchQueuedPrompt(threadID)
+	}
+}
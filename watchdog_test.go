@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchdogRecoversSilentSession(t *testing.T) {
+	useTestDataDirs(t)
+	fake := useFakeDiscord(t)
+	var abortedMutex sync.Mutex
+	var aborted []string
+	useFakeOpencode(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/abort") {
+			abortedMutex.Lock()
+			aborted = append(aborted, r.URL.Path)
+			abortedMutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("true"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	const timeout = 100 * time.Millisecond
+	useTestConfig(t, func(config *Config) { config.StuckSessionTimeout = timeout })
+
+	now := time.Now()
+	silent := &SessionData{
+		ThreadID:              "silent-thread",
+		SessionID:             "ses_silent",
+		WorktreePath:          "/worktree",
+		IsStreaming:           true,
+		Active:                true,
+		LastEventAt:           now,
+		StatusMessageIDs:      []string{"status-1"},
+		StatusMessageContents: []string{statusHeaderWorking + "\n🔧 read main.go"},
+	}
+	busy := &SessionData{ThreadID: "busy-thread", SessionID: "ses_busy", IsStreaming: true, Active: true, LastEventAt: now}
+	useTestSession(t, silent)
+	useTestSession(t, busy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runStuckSessionWatchdog(ctx)
+		close(done)
+	}()
+	// The busy session keeps receiving events while the silent one gets none
+	for deadline := time.Now().Add(4 * timeout); time.Now().Before(deadline); {
+		touchLastEvent("busy-thread")
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	sessionMutex.RLock()
+	silentStreaming, silentActive, busyStreaming := silent.IsStreaming, silent.Active, busy.IsStreaming
+	sessionMutex.RUnlock()
+	if silentStreaming || silentActive {
+		t.Error("silent session is still streaming or active")
+	}
+	if !busyStreaming {
+		t.Error("session that kept receiving events was ended")
+	}
+
+	abortedMutex.Lock()
+	defer abortedMutex.Unlock()
+	if len(aborted) != 1 || aborted[0] != "/session/ses_silent/abort" {
+		t.Errorf("aborted %v, want only the silent session's prompt", aborted)
+	}
+	edits := fake.calls(http.MethodPatch, "/channels/silent-thread/messages/status-1")
+	if len(edits) != 1 || !strings.Contains(edits[0].Body, statusOutcomeFailed) {
+		t.Errorf("status edits = %v, want the failed outcome", edits)
+	}
+	warnings := fake.calls(http.MethodPost, "/channels/silent-thread/messages")
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Body, "No progress from the model") {
+		t.Errorf("warnings = %v, want one stuck session notice", warnings)
+	}
+	if calls := fake.calls(http.MethodPost, "/channels/busy-thread/"); len(calls) != 0 {
+		t.Errorf("busy thread received %v", calls)
+	}
+}
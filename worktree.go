@@ -1,27 +1,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/sst/opencode-sdk-go"
 )
 
 func CleanupWorktree(threadID string) error {
 	// Prefer repoPath from session; otherwise infer from known worktree layout
 	sessionData := lazyLoadSession(threadID)
 	var worktreePath, repoPath string
+	if sessionData != nil && sessionData.Scratch {
+		// Scratch sessions have no repository, only a temporary directory
+		slog.Debug("removing scratch directory", "thread_id", threadID, "path", sessionData.WorktreePath)
+		return removeScratchDir(sessionData.WorktreePath)
+	}
 	if sessionData != nil && sessionData.RepositoryPath != "" {
 		repoPath = sessionData.RepositoryPath
 		worktreePath = sessionData.WorktreePath
 	} else {
-		// try known layout under configured repositories
-		for _, repo := range AppConfig.Repositories {
-			candidate := filepath.Join(repo.Path, ".worktrees", threadID)
-			if _, err := os.Stat(candidate); err == nil {
-				repoPath = repo.Path
+		// try the known worktree layout and resolve the owning repository from git
+		worktreesDir, err := ensureWorktreeDir()
+		if err != nil {
+			return err
+		}
+		candidate := filepath.Join(worktreesDir, threadID)
+		if _, err := os.Stat(candidate); err == nil {
+			if root, err := gitOps.GetRepositoryRoot(candidate); err == nil {
+				repoPath = root
 				worktreePath = candidate
-				break
 			}
 		}
 		if repoPath == "" {
@@ -31,3 +44,243 @@ func CleanupWorktree(threadID string) error {
 	slog.Debug("removing worktree", "thread_id", threadID, "repo_path", repoPath, "worktree_path", worktreePath)
 	return gitOps.RemoveWorktree(repoPath, worktreePath)
 }
+
+// Name under which the session's original worktree is listed once the thread has been forked
+const mainWorktreeName = "main"
+
+// Sub-worktree names become part of branch and directory names
+var subWorktreeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// forkWorktree creates a named worktree branched from the current worktree's HEAD with its own
+// OpenCode session, then makes it the thread's current worktree. Uncommitted changes are not carried over.
+func forkWorktree(sessionData *SessionData, name string) (*SubWorktree, error) {
+	if !subWorktreeNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid name %q: use lowercase letters, digits and dashes", name)
+	}
+
+	sessionMutex.RLock()
+	threadID := sessionData.ThreadID
+	worktreePath := sessionData.WorktreePath
+	repoPath := sessionData.RepositoryPath
+	repoName := sessionData.RepositoryName
+	sessionID := sessionData.SessionID
+	_, exists := sessionData.Worktrees[name]
+	sessionMutex.RUnlock()
+	if exists || name == mainWorktreeName {
+		return nil, fmt.Errorf("a worktree named %q already exists", name)
+	}
+
+	head, err := gitOps.GetCommitHash(worktreePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve current commit: %w", err)
+	}
+	currentBranch, err := gitOps.GetCurrentBranch(worktreePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve current branch: %w", err)
+	}
+
+	worktreesDir, err := ensureWorktreeDir()
+	if err != nil {
+		return nil, err
+	}
+	branch := fmt.Sprintf("%s-%s", threadID, name)
+	path := filepath.Join(worktreesDir, branch)
+
+	var sparsePaths []string
+	if repository := findRepository(repoName); repository != nil {
+		sparsePaths = repository.SparsePaths
+	}
+	// The fork starts from a known commit, so pulling the reference repository gains nothing
+	if err := gitOps.CreateWorktree(repoPath, path, branch, head, sparsePaths, false); err != nil {
+		return nil, err
+	}
+
+	client := Opencode()
+	if client == nil {
+		removeForkedWorktree(repoPath, path, branch)
+		return nil, errOpencodeUnavailable
+	}
+	session, err := client.Session.New(context.Background(), opencode.SessionNewParams{
+		Directory: opencode.F(path),
+	})
+	if err != nil {
+		removeForkedWorktree(repoPath, path, branch)
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	sub := &SubWorktree{Path: path, Branch: branch, SessionID: session.ID}
+	sessionMutex.Lock()
+	if sessionData.Worktrees == nil {
+		// The first fork registers the original worktree so it can be switched back to
+		sessionData.Worktrees = map[string]*SubWorktree{
+			mainWorktreeName: {Path: worktreePath, Branch: currentBranch, SessionID: sessionID},
+		}
+		sessionData.CurrentWorktree = mainWorktreeName
+	}
+	sessionData.Worktrees[name] = sub
+	sessionMutex.Unlock()
+
+	slog.Info("forked worktree", "thread_id", threadID, "name", name, "branch", branch, "base", head)
+	if err := switchWorktree(sessionData, name); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// switchWorktree makes the named worktree current, pointing prompts and git commands at it
+func switchWorktree(sessionData *SessionData, name string) error {
+	sessionMutex.Lock()
+	sub, exists := sessionData.Worktrees[name]
+	if !exists {
+		sessionMutex.Unlock()
+		return fmt.Errorf("no worktree named %q", name)
+	}
+	if sessionData.IsStreaming {
+		sessionMutex.Unlock()
+		return fmt.Errorf("a prompt is still running, wait for it to finish before switching")
+	}
+	sessionData.WorktreePath = sub.Path
+	sessionData.SessionID = sub.SessionID
+	sessionData.Session = &opencode.Session{ID: sub.SessionID}
+	sessionData.CurrentWorktree = name
+	threadID := sessionData.ThreadID
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after switching worktree", "thread_id", threadID, "error", err)
+	}
+
+	// Events are streamed per directory; the next message spawns a listener for the new worktree
+	stopActiveListener(threadID)
+
+	slog.Info("switched worktree", "thread_id", threadID, "name", name, "worktree_path", sub.Path)
+	return nil
+}
+
+// removeSubWorktree deletes a forked worktree, its branch and its OpenCode session.
+// The original worktree and the current one cannot be removed.
+func removeSubWorktree(sessionData *SessionData, name string) error {
+	sessionMutex.RLock()
+	sub, exists := sessionData.Worktrees[name]
+	current := sessionData.CurrentWorktree
+	repoPath := sessionData.RepositoryPath
+	threadID := sessionData.ThreadID
+	sessionMutex.RUnlock()
+
+	switch {
+	case !exists:
+		return fmt.Errorf("no worktree named %q", name)
+	case name == mainWorktreeName:
+		return fmt.Errorf("the %q worktree cannot be removed", mainWorktreeName)
+	case name == current:
+		return fmt.Errorf("switch to another worktree before removing %q", name)
+	}
+
+	if err := gitOps.RemoveWorktree(repoPath, sub.Path); err != nil {
+		return err
+	}
+	if err := gitOps.DeleteBranch(repoPath, sub.Branch); err != nil {
+		slog.Warn("failed to delete forked branch", "thread_id", threadID, "branch", sub.Branch, "error", err)
+	}
+	if client := Opencode(); client != nil {
+		if _, err := client.Session.Delete(context.Background(), sub.SessionID, opencode.SessionDeleteParams{
+			Directory: opencode.F(sub.Path),
+		}); err != nil {
+			slog.Warn("failed to delete forked session", "thread_id", threadID, "session_id", sub.SessionID, "error", err)
+		}
+	}
+
+	sessionMutex.Lock()
+	delete(sessionData.Worktrees, name)
+	sessionMutex.Unlock()
+
+	if err := saveSessionData(sessionData); err != nil {
+		slog.Error("failed to save session data after removing worktree", "thread_id", threadID, "error", err)
+	}
+	slog.Info("removed forked worktree", "thread_id", threadID, "name", name)
+	return nil
+}
+
+// removeForkedWorktree rolls back a fork whose session could not be created
+func removeForkedWorktree(repoPath, path, branch string) {
+	if err := gitOps.RemoveWorktree(repoPath, path); err != nil {
+		slog.Warn("failed to roll back forked worktree", "worktree_path", path, "error", err)
+		return
+	}
+	if err := gitOps.DeleteBranch(repoPath, branch); err != nil {
+		slog.Warn("failed to roll back forked branch", "branch", branch, "error", err)
+	}
+}
+
+// repoCleanupSummary reports what cleanupRepositorySessions removed
+type repoCleanupSummary struct {
+	Sessions  int
+	Worktrees int
+	Failures  []string
+}
+
+// repositorySessions returns the stored sessions that belong to a repository
+func repositorySessions(repositoryName string) ([]*SessionData, error) {
+	sessions, err := listStoredSessions()
+	if err != nil {
+		return nil, err
+	}
+	var matching []*SessionData
+	sessionMutex.RLock()
+	for _, sessionData := range sessions {
+		if sessionData.RepositoryName == repositoryName {
+			matching = append(matching, sessionData)
+		}
+	}
+	sessionMutex.RUnlock()
+	return matching, nil
+}
+
+// cleanupRepositorySessions tears down every session of a repository: listeners and timers are stopped,
+// all of its worktrees removed and the session files deleted. Failures are collected, not fatal.
+func cleanupRepositorySessions(repositoryName string) (repoCleanupSummary, error) {
+	var summary repoCleanupSummary
+	sessions, err := repositorySessions(repositoryName)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, sessionData := range sessions {
+		sessionMutex.RLock()
+		threadID := sessionData.ThreadID
+		repoPath := sessionData.RepositoryPath
+		paths := []string{sessionData.WorktreePath}
+		for _, sub := range sessionData.Worktrees {
+			if !slices.Contains(paths, sub.Path) {
+				paths = append(paths, sub.Path)
+			}
+		}
+		sessionMutex.RUnlock()
+
+		// Stop the listener before the worktree disappears under it
+		stopActiveListener(threadID)
+		for _, path := range paths {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+			if err := gitOps.RemoveWorktree(repoPath, path); err != nil {
+				slog.Warn("failed to remove worktree during repository cleanup", "thread_id", threadID, "worktree_path", path, "error", err)
+				summary.Failures = append(summary.Failures, fmt.Sprintf("%s: %v", path, err))
+				continue
+			}
+			summary.Worktrees++
+		}
+		if err := CleanupSession(threadID); err != nil {
+			slog.Warn("failed to remove session during repository cleanup", "thread_id", threadID, "error", err)
+			summary.Failures = append(summary.Failures, fmt.Sprintf("session %s: %v", threadID, err))
+			continue
+		}
+		summary.Sessions++
+	}
+
+	slog.Info("cleaned up repository sessions", "repository", repositoryName, "sessions", summary.Sessions, "worktrees", summary.Worktrees, "failures", len(summary.Failures))
+	return summary, nil
+}